@@ -2,6 +2,8 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
 	"go.sia.tech/core/consensus"
@@ -199,16 +201,51 @@ type ElementSpentResponse struct {
 // /mining/getblocktemplate.
 type MiningGetBlockTemplateRequest struct {
 	LongPollID string `json:"longpollid,omitempty"`
+
+	// Block proposal from BIP 0023. If Mode is "proposal", Data must contain
+	// the hex-encoded block to validate.
+	Mode string `json:"mode,omitempty"`
+	Data string `json:"data,omitempty"`
+
+	// Compact omits the data of transactions the node took from its pool,
+	// leaving only their IDs. Clients assemble the block from their own copy
+	// of the pool.
+	Compact bool `json:"compact,omitempty"`
+
+	// MaxAge overrides the server's max template age for this long polling
+	// request. The request returns a refreshed template once the current one
+	// is older than MaxAge. It is clamped to the server's ceiling. Zero uses
+	// the server's max template age.
+	MaxAge time.Duration `json:"maxage,omitempty"`
 }
 
 // MiningGetBlockTemplateResponse is the response type for
 // /mining/getblocktemplate.
 type MiningGetBlockTemplateResponse struct {
+	// Commitment is the commitment of the block header. For v1 blocks, it is
+	// the merkle root of the miner payout and transactions. For v2 blocks, it
+	// is the merkle root of StateLeaf followed by the leaf hashes of the v1
+	// and v2 transactions in the order they appear in Transactions. This
+	// matches the commitment the node checks when the block is submitted.
 	Commitment        types.Hash256                       `json:"commitment"`
 	Transactions      []MiningGetBlockTemplateResponseTxn `json:"transactions"`
 	MinerPayout       []MiningGetBlockTemplateResponseTxn `json:"minerpayout"`
 	PreviousBlockHash string                              `json:"previousblockhash"`
 
+	// Parent is the chain index the template builds on. The parent's state is
+	// the basis for the commitment.
+	Parent types.ChainIndex `json:"parent"`
+	// StateLeaf is the first leaf of the v2 commitment tree. It commits to the
+	// parent state and the miner payout address. Only set for v2 blocks.
+	StateLeaf *types.Hash256 `json:"stateleaf,omitempty"`
+	// PayoutAddress is the address the template's miner payout is sent to.
+	PayoutAddress string `json:"payoutaddress"`
+	// FeeBreakdown summarizes the fees paid by the template's transactions.
+	FeeBreakdown MiningFeeBreakdown `json:"feebreakdown"`
+	// CoinbaseFlags is the marker stored in the arbitrary data of the
+	// template's first transaction. Empty if no marker is added.
+	CoinbaseFlags string `json:"coinbaseflags,omitempty"`
+
 	// Optional long polling from BIP 0022.
 	LongPollID string `json:"longpollid"`
 
@@ -222,7 +259,62 @@ type MiningGetBlockTemplateResponse struct {
 	// Block proposal from BIP 0023.
 	Version uint32 `json:"version"`
 	Bits    string `json:"bits"`
-}
+
+	// block and target are used for the binary encoding of the template.
+	block  types.Block
+	target types.BlockID
+}
+
+// MiningBlockTemplate is the binary representation of a block template. It is
+// returned by /mining/getblocktemplate when the request's Accept header is
+// application/octet-stream.
+type MiningBlockTemplate struct {
+	// Block is the unsolved block. Its nonce is zero and its timestamp is the
+	// template's curtime.
+	Block      types.Block
+	Parent     types.ChainIndex
+	Commitment types.Hash256
+	StateLeaf  *types.Hash256
+	LongPollID string
+	Target     types.BlockID
+	Height     uint64
+}
+
+// MiningFeeCategory is the number of transactions in a category and the total
+// fees they pay.
+type MiningFeeCategory struct {
+	Transactions int            `json:"transactions"`
+	Fees         types.Currency `json:"fees"`
+}
+
+// MiningFeeBreakdown summarizes the fees of a block template's transactions by
+// category. FileContracts contains the v1 and v2 transactions that create,
+// revise or resolve file contracts, so it overlaps with V1 and V2.
+type MiningFeeBreakdown struct {
+	V1            MiningFeeCategory `json:"v1"`
+	V2            MiningFeeCategory `json:"v2"`
+	FileContracts MiningFeeCategory `json:"fileContracts"`
+}
+
+// Reject reasons returned by /mining/getblocktemplate in proposal mode.
+const (
+	ProposalRejectDuplicate    = "duplicate"
+	ProposalRejectBadPrevBlock = "bad-prevblk"
+	ProposalRejectTimeTooNew   = "time-too-new"
+	ProposalRejectInconclusive = "inconclusive"
+)
+
+// Reject reasons returned by /mining/submitblock.
+const (
+	// SubmitRejectDuplicate is returned if the node already has the block
+	// or the same block was submitted shortly before. If the server accepts
+	// known blocks, it is returned as the result of a successful request
+	// for blocks the node has.
+	SubmitRejectDuplicate = "duplicate"
+	// SubmitRejectNotSynced is returned if the node isn't synced and
+	// unsynced submissions are rejected.
+	SubmitRejectNotSynced = "node-not-synced"
+)
 
 // MiningGetBlockTemplateResponseTxn is a transaction in a block template.
 type MiningGetBlockTemplateResponseTxn struct {
@@ -237,10 +329,416 @@ type MiningGetBlockTemplateResponseTxn struct {
 
 // MiningSubmitBlockRequest is the request type for /mining/submitblock.
 type MiningSubmitBlockRequest struct {
-	// should contain only the hex-encoded block
+	// Params contains the hex-encoded block. Additional params are ignored.
 	Params []string `json:"params"`
 }
 
+// UnmarshalJSON implements json.Unmarshaler. Like bitcoind, only the first
+// param, the hex-encoded block, is used. Clients commonly send further params,
+// e.g. the BIP 22 parameters object or the target, which are ignored
+// regardless of their type.
+func (r *MiningSubmitBlockRequest) UnmarshalJSON(b []byte) error {
+	var req struct {
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(b, &req); err != nil {
+		return err
+	} else if len(req.Params) == 0 {
+		r.Params = nil
+		return nil
+	}
+	var block string
+	if err := json.Unmarshal(req.Params[0], &block); err != nil {
+		return errors.New("block must be a hex-encoded string")
+	}
+	r.Params = []string{block}
+	return nil
+}
+
+// MiningGetBlockRequest is the request type for /mining/getblock. Exactly one
+// of Height or ID must be set.
+type MiningGetBlockRequest struct {
+	Height *uint64        `json:"height,omitempty"`
+	ID     *types.BlockID `json:"id,omitempty"`
+}
+
+// MiningDifficultyRequest is the request type for /mining/difficulty. If Count
+// is zero, the default of 100 blocks is returned.
+type MiningDifficultyRequest struct {
+	Count uint64 `json:"count"`
+}
+
+// MiningDifficultyEntry is the difficulty a block of the best chain was mined
+// at. Entries are returned by /mining/difficulty, newest first.
+type MiningDifficultyEntry struct {
+	Height     uint64         `json:"height"`
+	ID         types.BlockID  `json:"id"`
+	Timestamp  time.Time      `json:"timestamp"`
+	Difficulty consensus.Work `json:"difficulty"`
+}
+
+// MiningGetBlockResponse is the response type for /mining/getblock.
+type MiningGetBlockResponse struct {
+	ID    types.BlockID `json:"id"`
+	Block types.Block   `json:"block"`
+	// hex-encoded block using the same encoding as /mining/submitblock
+	Data string `json:"data"`
+}
+
+// MiningBlockStatusRequest is the request type for /mining/blockstatus.
+type MiningBlockStatusRequest struct {
+	ID types.BlockID `json:"id"`
+}
+
+// MiningBlockStatusResponse is the response type for /mining/blockstatus.
+type MiningBlockStatusResponse struct {
+	// Known is true if the node has the block and its state. The remaining
+	// fields are only set if it does.
+	Known  bool   `json:"known"`
+	Height uint64 `json:"height"`
+	// MainChain is true if the block is part of the best chain. Known blocks
+	// that aren't are orphaned, e.g. because they were reorged out.
+	MainChain bool `json:"mainChain"`
+	Orphaned  bool `json:"orphaned"`
+	// Confirmations is the number of blocks of the best chain from the block
+	// to the tip, including the block itself. Zero for orphaned blocks.
+	Confirmations uint64 `json:"confirmations"`
+}
+
+// MiningExcludeTransactionRequest is the request type for
+// /mining/excludetxn.
+type MiningExcludeTransactionRequest struct {
+	ID types.TransactionID `json:"id"`
+}
+
+// MiningIncludeTransactionRequest is the request type for
+// /mining/includetxn.
+type MiningIncludeTransactionRequest struct {
+	ID types.TransactionID `json:"id"`
+}
+
+// MiningPinTransactionRequest is the request type for /mining/pintxn. Exactly
+// one of ID, Transaction and V2Transaction must be set. ID pins a transaction
+// that is already in the pool. Transaction and V2Transaction are added to the
+// pool before being pinned. Basis is the chain index V2Transaction is valid
+// at.
+type MiningPinTransactionRequest struct {
+	ID            *types.TransactionID `json:"id,omitempty"`
+	Transaction   *types.Transaction   `json:"transaction,omitempty"`
+	V2Transaction *types.V2Transaction `json:"v2transaction,omitempty"`
+	Basis         types.ChainIndex     `json:"basis"`
+}
+
+// MiningPinTransactionResponse is the response type for /mining/pintxn.
+type MiningPinTransactionResponse struct {
+	ID types.TransactionID `json:"id"`
+}
+
+// MiningUnpinTransactionRequest is the request type for /mining/unpintxn.
+type MiningUnpinTransactionRequest struct {
+	ID types.TransactionID `json:"id"`
+}
+
+// MiningFeeHistogramResponse is the response type for /mining/feehistogram.
+type MiningFeeHistogramResponse struct {
+	RecommendedFee types.Currency             `json:"recommendedFee"`
+	MaxBlockWeight uint64                     `json:"maxBlockWeight"`
+	Buckets        []MiningFeeHistogramBucket `json:"buckets"`
+}
+
+// A MiningFeeHistogramBucket contains the cumulative number and weight of pool
+// transactions paying at least MinFeeRate per unit of weight. Buckets are
+// ordered by decreasing fee rate.
+type MiningFeeHistogramBucket struct {
+	MinFeeRate   types.Currency `json:"minFeeRate"`
+	Transactions int            `json:"transactions"`
+	Weight       uint64         `json:"weight"`
+}
+
+// MiningConfirmTargetRequest is the request type for /mining/confirmtarget.
+type MiningConfirmTargetRequest struct {
+	// FeeRate is the fee per unit of weight the transaction pays.
+	FeeRate types.Currency `json:"feeRate"`
+}
+
+// MiningConfirmTargetResponse is the response type for /mining/confirmtarget.
+type MiningConfirmTargetResponse struct {
+	FeeRate        types.Currency `json:"feeRate"`
+	RecommendedFee types.Currency `json:"recommendedFee"`
+	MaxBlockWeight uint64         `json:"maxBlockWeight"`
+	// TransactionsAhead and WeightAhead are the number and weight of pool
+	// transactions paying a higher fee rate, which are selected first.
+	TransactionsAhead int    `json:"transactionsAhead"`
+	WeightAhead       uint64 `json:"weightAhead"`
+	// Blocks is the estimated number of blocks until a transaction paying
+	// FeeRate is confirmed, assuming no transactions paying more are added
+	// to the pool in the meantime.
+	Blocks uint64 `json:"blocks"`
+}
+
+// MiningWaitForTipRequest is the request type for /mining/waitfortip.
+type MiningWaitForTipRequest struct {
+	// Tip is the tip known to the client. The request returns once the
+	// node's tip differs from it or after a timeout.
+	Tip types.ChainIndex `json:"tip"`
+}
+
+// MiningUpdatesRequest is the request type for /mining/updates.
+type MiningUpdatesRequest struct {
+	// Index is the last index the client has processed. The request returns
+	// once the node's tip differs from it or after a timeout.
+	Index types.ChainIndex `json:"index"`
+	// Limit is the maximum number of updates to return. Defaults to 10 if
+	// zero.
+	Limit int `json:"limit,omitempty"`
+}
+
+// MiningGetWorkRequest is the request type for the legacy /mining/getwork
+// endpoint. If Params is empty, new work is returned. Otherwise Params should
+// contain the hex-encoded solved block header.
+type MiningGetWorkRequest struct {
+	Params []string `json:"params"`
+}
+
+// MiningGetWorkResponse is the response type for /mining/getwork when
+// requesting new work.
+type MiningGetWorkResponse struct {
+	// hex-encoded 80-byte block header consisting of the parent ID, nonce,
+	// timestamp and commitment
+	Data   string `json:"data"`
+	Target string `json:"target"`
+}
+
+// MiningAllocateRangeRequest is the request type for /mining/allocaterange.
+type MiningAllocateRangeRequest struct {
+	LongPollID string `json:"longpollid"`
+}
+
+// MiningAllocateRangeResponse is the response type for /mining/allocaterange.
+// The worker owns the nonces in [Start, Start+Size) for the template.
+type MiningAllocateRangeResponse struct {
+	Start uint64 `json:"start"`
+	Size  uint64 `json:"size"`
+}
+
+// MiningWillIncludeRequest is the request type for /mining/willinclude.
+type MiningWillIncludeRequest struct {
+	ID types.TransactionID `json:"id"`
+}
+
+// MiningWillIncludeResponse is the response type for /mining/willinclude.
+type MiningWillIncludeResponse struct {
+	// Included is true if the transaction would be included in the next
+	// block template. The remaining fields are only set if it is.
+	Included bool `json:"included"`
+	// Position is the index of the transaction in the template's
+	// transactions.
+	Position int `json:"position"`
+	// FeeRank is the 1-based rank of the transaction's fee rate among the
+	// template's transactions. Transactions paying the same fee rate share a
+	// rank.
+	FeeRank int            `json:"feeRank"`
+	FeeRate types.Currency `json:"feeRate"`
+	// Transactions is the total number of transactions in the template.
+	Transactions int `json:"transactions"`
+}
+
+// MiningPayoutAddressResponse is the response type for
+// /mining/payoutaddress.
+type MiningPayoutAddressResponse struct {
+	Address types.Address `json:"address"`
+}
+
+// MiningHardforkHeights are the activation heights of the network's
+// hardforks.
+type MiningHardforkHeights struct {
+	DevAddr      uint64 `json:"devAddr"`
+	Tax          uint64 `json:"tax"`
+	StorageProof uint64 `json:"storageProof"`
+	Oak          uint64 `json:"oak"`
+	ASIC         uint64 `json:"asic"`
+	Foundation   uint64 `json:"foundation"`
+	V2Allow      uint64 `json:"v2Allow"`
+	V2Require    uint64 `json:"v2Require"`
+	V2FinalCut   uint64 `json:"v2FinalCut"`
+}
+
+// MiningInfoResponse is the response type for /mining/info. All fields are
+// taken from the same snapshot of the chain, so the template's parent matches
+// the tip the other fields describe.
+type MiningInfoResponse struct {
+	Template MiningGetBlockTemplateResponse `json:"template"`
+
+	Network  string                `json:"network"`
+	Hardfork MiningHardforkHeights `json:"hardfork"`
+
+	// Target is the hex-encoded proof-of-work target, Bits its compact
+	// encoding and Difficulty the corresponding difficulty.
+	Target     string         `json:"target"`
+	Bits       string         `json:"bits"`
+	Difficulty consensus.Work `json:"difficulty"`
+	// TotalWork is the cumulative work of the chain up to the template's
+	// parent.
+	TotalWork consensus.Work `json:"totalWork"`
+
+	PayoutAddress  types.Address  `json:"payoutAddress"`
+	RecommendedFee types.Currency `json:"recommendedFee"`
+
+	// Synced is true if the node is connected to peers and its tip is
+	// recent.
+	Synced bool `json:"synced"`
+	Peers  int  `json:"peers"`
+}
+
+// MiningChainWorkResponse is the response type for /mining/chainwork.
+// Comparing the total work of several nodes shows which of them is on the
+// best chain, e.g. during a network partition.
+type MiningChainWorkResponse struct {
+	Tip       types.ChainIndex `json:"tip"`
+	TotalWork consensus.Work   `json:"totalWork"`
+}
+
+// MiningBlockIntervalsRequest is the request type for /mining/blockintervals.
+// If Count is zero, the intervals of the last 100 blocks are returned.
+type MiningBlockIntervalsRequest struct {
+	Count int `json:"count"`
+}
+
+// A MiningBlockInterval is the time between a block and its parent, computed
+// from their timestamps. Block timestamps aren't strictly increasing, so it
+// can be negative.
+type MiningBlockInterval struct {
+	Index     types.ChainIndex `json:"index"`
+	Timestamp time.Time        `json:"timestamp"`
+	Interval  time.Duration    `json:"interval"`
+}
+
+// MiningBlockIntervalsResponse is the response type for
+// /mining/blockintervals. Intervals are ordered by height, oldest first.
+type MiningBlockIntervalsResponse struct {
+	TargetInterval  time.Duration         `json:"targetInterval"`
+	AverageInterval time.Duration         `json:"averageInterval"`
+	Intervals       []MiningBlockInterval `json:"intervals"`
+}
+
+// MiningNetworkHardforks contains the activation heights of a network's
+// hardforks.
+type MiningNetworkHardforks struct {
+	DevAddr      uint64 `json:"devAddr"`
+	Tax          uint64 `json:"tax"`
+	StorageProof uint64 `json:"storageProof"`
+	Oak          uint64 `json:"oak"`
+	ASIC         uint64 `json:"asic"`
+	Foundation   uint64 `json:"foundation"`
+	V2Allow      uint64 `json:"v2Allow"`
+	V2Require    uint64 `json:"v2Require"`
+	V2FinalCut   uint64 `json:"v2FinalCut"`
+}
+
+// MiningNetworkResponse is the response type for /mining/network. Clients can
+// compare it with the network they expect before submitting work.
+type MiningNetworkResponse struct {
+	Name      string                 `json:"name"`
+	GenesisID types.BlockID          `json:"genesisID"`
+	Hardforks MiningNetworkHardforks `json:"hardforks"`
+}
+
+// MiningStatsResponse is the response type for /mining/stats.
+type MiningStatsResponse struct {
+	StartTime time.Time     `json:"startTime"`
+	Uptime    time.Duration `json:"uptime"`
+
+	TemplatesServed      uint64  `json:"templatesServed"`
+	TemplateCacheHits    uint64  `json:"templateCacheHits"`
+	TemplateCacheMisses  uint64  `json:"templateCacheMisses"`
+	TemplateCacheHitRate float64 `json:"templateCacheHitRate"`
+	// TemplatesRefreshed is the number of cache misses that reused the
+	// transactions of the previous template because only its timestamp was
+	// outdated.
+	TemplatesRefreshed uint64 `json:"templatesRefreshed"`
+
+	BlocksSubmitted uint64 `json:"blocksSubmitted"`
+	BlocksAccepted  uint64 `json:"blocksAccepted"`
+	BlocksRejected  uint64 `json:"blocksRejected"`
+	// BlocksDuplicate is the number of submitted blocks that were rejected
+	// because the same block was submitted shortly before.
+	BlocksDuplicate uint64 `json:"blocksDuplicate"`
+
+	// OrphanedBlocks is the number of blocks that were reverted from the best
+	// chain by a reorg. SubmittedBlocksOrphaned is the subset of those that
+	// were submitted through the API.
+	OrphanedBlocks          uint64 `json:"orphanedBlocks"`
+	SubmittedBlocksOrphaned uint64 `json:"submittedBlocksOrphaned"`
+
+	// IndexLag is the number of blocks the wallet indexer lags behind the
+	// chain tip. Only set if the server monitors the indexer.
+	IndexLag *uint64 `json:"indexLag,omitempty"`
+}
+
+// Statuses of the CPU miner controlled by /mining/pause and /mining/resume.
+const (
+	MinerStatusRunning = "running"
+	MinerStatusPaused  = "paused"
+)
+
+// MiningMinerStatusResponse is the response type for /mining/minerstatus,
+// /mining/pause and /mining/resume.
+type MiningMinerStatusResponse struct {
+	Status string `json:"status"`
+}
+
+// JSON-RPC 2.0 error codes returned by /mining/rpc.
+const (
+	JSONRPCParseError     = -32700
+	JSONRPCInvalidRequest = -32600
+	JSONRPCMethodNotFound = -32601
+	JSONRPCInvalidParams  = -32602
+	JSONRPCInternalError  = -32603
+)
+
+// JSONRPCRequest is a JSON-RPC 2.0 request sent to /mining/rpc. Requests
+// without an ID are notifications and don't receive a response.
+type JSONRPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// JSONRPCResponse is a JSON-RPC 2.0 response returned by /mining/rpc. Exactly
+// one of Result and Error is set.
+type JSONRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *JSONRPCError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// A JSONRPCError is the error object of a JSON-RPC 2.0 response.
+type JSONRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error implements error.
+func (e *JSONRPCError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// DebugBenchTemplateRequest is the request type for /debug/benchtemplate.
+type DebugBenchTemplateRequest struct {
+	Duration time.Duration `json:"duration"`
+}
+
+// DebugBenchTemplateResponse is the response type for /debug/benchtemplate.
+type DebugBenchTemplateResponse struct {
+	Templates          int           `json:"templates"`
+	Elapsed            time.Duration `json:"elapsed"`
+	TemplatesPerSecond float64       `json:"templatesPerSecond"`
+	AvgTransactions    float64       `json:"avgTransactions"`
+	PoolTransactions   int           `json:"poolTransactions"`
+}
+
 // An AddSigningKeyRequest is a request to add an ed25519 signing key to the
 // key store.
 type AddSigningKeyRequest struct {
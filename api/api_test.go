@@ -1,27 +1,46 @@
 package api_test
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"go.sia.tech/core/blake2b"
 	"go.sia.tech/core/consensus"
 	"go.sia.tech/core/types"
 	"go.sia.tech/coreutils"
+	"go.sia.tech/coreutils/chain"
 	"go.sia.tech/minerd/api"
 	"go.sia.tech/minerd/internal/testutil"
 	walletdAPI "go.sia.tech/walletd/v2/api"
 	"go.sia.tech/walletd/v2/wallet"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
+	"lukechampine.com/frand"
 )
 
 func startMinerServer(tb testing.TB, cn *testutil.ConsensusNode, log *zap.Logger, opts ...api.ServerOption) *api.Client {
 	tb.Helper()
+	return startMinerServerWithWallet(tb, cn, log, nil, opts...)
+}
+
+// startMinerServerWithWallet is like startMinerServer but creates the wallet
+// manager with the given options.
+func startMinerServerWithWallet(tb testing.TB, cn *testutil.ConsensusNode, log *zap.Logger, walletOpts []wallet.Option, opts ...api.ServerOption) *api.Client {
+	tb.Helper()
 
 	l, err := net.Listen("tcp", ":0")
 	if err != nil {
@@ -29,7 +48,7 @@ func startMinerServer(tb testing.TB, cn *testutil.ConsensusNode, log *zap.Logger
 	}
 	tb.Cleanup(func() { l.Close() })
 
-	wm, err := wallet.NewManager(cn.Chain, cn.Store)
+	wm, err := wallet.NewManager(cn.Chain, cn.Store, walletOpts...)
 	if err != nil {
 		tb.Fatal(err)
 	}
@@ -222,6 +241,30 @@ func TestMineGetBlockTemplate(t *testing.T) {
 				t.Fatal(err)
 			}
 			v2BlockData.Commitment = cs.Commitment(minerPayout.Address, txns, v2Txns)
+
+			// the commitment must be reproducible from the state leaf
+			if resp.StateLeaf == nil {
+				t.Fatal("expected state leaf for v2 template")
+			} else if *resp.StateLeaf != cs.MerkleLeafHash(minerPayout.Address) {
+				t.Fatal("state leaf mismatch")
+			}
+			var acc blake2b.Accumulator
+			acc.AddLeaf(*resp.StateLeaf)
+			for _, txn := range txns {
+				acc.AddLeaf(txn.MerkleLeafHash())
+			}
+			for _, txn := range v2Txns {
+				acc.AddLeaf(txn.MerkleLeafHash())
+			}
+			if acc.Root() != resp.Commitment {
+				t.Fatal("commitment doesn't match state leaf and transactions")
+			}
+		} else if resp.StateLeaf != nil {
+			t.Fatal("expected no state leaf for v1 template")
+		}
+
+		if tip := cn.Chain.Tip(); resp.Parent != tip {
+			t.Fatalf("expected parent %v, got %v", tip, resp.Parent)
 		}
 
 		// construct block
@@ -337,7 +380,7 @@ func TestMineGetBlockTemplateMaxAge(t *testing.T) {
 
 	network, genesisBlock := testutil.V1Network()
 	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
-	c := startMinerServer(t, cn, log, api.WithMaxTemplateAge(time.Second))
+	c := startMinerServer(t, cn, log, api.WithMaxTemplateAge(time.Second), api.WithLongPollJitter(0))
 
 	// get block template
 	resp, err := c.MiningGetBlockTemplate(context.Background(), "")
@@ -356,3 +399,2872 @@ func TestMineGetBlockTemplateMaxAge(t *testing.T) {
 		t.Fatalf("expected MiningGetBlockTemplate to return after ~1s, got %v", time.Since(start))
 	}
 }
+
+func TestMineGetBlockTemplateRequestMaxAge(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log, api.WithMaxTemplateAge(time.Minute), api.WithLongPollJitter(0))
+
+	resp, err := c.MiningGetBlockTemplate(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a long poll using the server's max age keeps waiting
+	defaultDone := make(chan error, 1)
+	go func() {
+		_, err := c.MiningGetBlockTemplate(context.Background(), resp.LongPollID)
+		defaultDone <- err
+	}()
+
+	// a long poll with a shorter max age returns a refreshed template
+	start := time.Now()
+	refreshed, err := c.MiningGetBlockTemplateMaxAge(context.Background(), resp.LongPollID, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	} else if elapsed := time.Since(start); elapsed < 500*time.Millisecond || elapsed > 3*time.Second {
+		t.Fatalf("expected long poll to return after ~2s, got %v", elapsed)
+	} else if refreshed.LongPollID == resp.LongPollID {
+		t.Fatal("expected a refreshed template")
+	}
+
+	select {
+	case err := <-defaultDone:
+		t.Fatalf("expected long poll without max age to keep waiting, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the shared template isn't evicted, other clients are still served it
+	if current, err := c.MiningGetBlockTemplate(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	} else if current.LongPollID != resp.LongPollID {
+		t.Fatal("expected the shared template to survive a short max age request")
+	}
+
+	// the next long poll with the refreshed template waits for the copy to
+	// expire instead of returning the older shared template
+	start = time.Now()
+	if again, err := c.MiningGetBlockTemplateMaxAge(context.Background(), refreshed.LongPollID, 2*time.Second); err != nil {
+		t.Fatal(err)
+	} else if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Fatalf("expected long poll to wait for the refreshed template to expire, returned after %v", elapsed)
+	} else if again.LongPollID == refreshed.LongPollID || again.Timestamp <= refreshed.Timestamp {
+		t.Fatal("expected a newer refreshed template")
+	}
+
+	select {
+	case err := <-defaultDone:
+		t.Fatalf("expected long poll without max age to keep waiting, got %v", err)
+	default:
+	}
+
+	if _, err := c.MiningGetBlockTemplateMaxAge(context.Background(), "", -time.Second); err == nil {
+		t.Fatal("expected negative max age to be rejected")
+	}
+}
+
+func TestMineGetBlockTemplatePayoutAddressFunc(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+
+	var calls int
+	addrs := []types.Address{frand.Entropy256(), frand.Entropy256()}
+	c := startMinerServer(t, cn, log, api.WithPayoutAddressFunc(func() (types.Address, error) {
+		addr := addrs[calls%len(addrs)]
+		calls++
+		return addr, nil
+	}))
+
+	for i := range addrs {
+		resp, err := c.MiningGetBlockTemplate(context.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rawMinerPayout, err := hex.DecodeString(resp.MinerPayout[0].Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var minerPayout types.SiacoinOutput
+		dec := types.NewBufDecoder(rawMinerPayout)
+		(*types.V1SiacoinOutput)(&minerPayout).DecodeFrom(dec)
+		if err := dec.Err(); err != nil {
+			t.Fatal(err)
+		} else if minerPayout.Address != addrs[i] {
+			t.Fatalf("expected payout address %v, got %v", addrs[i], minerPayout.Address)
+		}
+
+		// mine a block to invalidate the template
+		cn.MineBlocks(t, types.VoidAddress, 1)
+	}
+}
+
+func TestMineProposeBlock(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	test := func(t *testing.T, n *consensus.Network, genesisBlock types.Block) {
+		cn := testutil.NewConsensusNode(t, n, genesisBlock, log)
+		c := startMinerServer(t, cn, log)
+		cn.MineBlocks(t, types.VoidAddress, 5)
+
+		cs := cn.Chain.TipState()
+		b := types.Block{
+			ParentID:     cs.Index.ID,
+			Timestamp:    types.CurrentTimestamp(),
+			MinerPayouts: []types.SiacoinOutput{{Address: frand.Entropy256(), Value: cs.BlockReward()}},
+		}
+		if cs.Index.Height >= n.HardforkV2.AllowHeight {
+			b.V2 = &types.V2BlockData{
+				Height:     cs.Index.Height + 1,
+				Commitment: cs.Commitment(b.MinerPayouts[0].Address, nil, nil),
+			}
+		}
+
+		// a valid block without proof of work should be accepted
+		if reason, err := c.MiningProposeBlock(context.Background(), b); err != nil {
+			t.Fatal(err)
+		} else if reason != "" {
+			t.Fatalf("expected proposal to be accepted, got %q", reason)
+		}
+
+		// a block with the wrong parent should be rejected
+		bad := b
+		bad.ParentID = frand.Entropy256()
+		if reason, err := c.MiningProposeBlock(context.Background(), bad); err != nil {
+			t.Fatal(err)
+		} else if reason != api.ProposalRejectBadPrevBlock {
+			t.Fatalf("expected %q, got %q", api.ProposalRejectBadPrevBlock, reason)
+		}
+
+		// a block with an invalid payout should be rejected
+		bad = b
+		bad.MinerPayouts = []types.SiacoinOutput{{Address: b.MinerPayouts[0].Address, Value: cs.BlockReward().Mul64(2)}}
+		if reason, err := c.MiningProposeBlock(context.Background(), bad); err != nil {
+			t.Fatal(err)
+		} else if reason == "" {
+			t.Fatal("expected proposal with invalid payout to be rejected")
+		}
+
+		// a known block should be reported as a duplicate
+		if !coreutils.FindBlockNonce(cs, &b, 10*time.Second) {
+			t.Fatal("failed to find nonce")
+		} else if err := c.MiningSubmitBlock(context.Background(), b); err != nil {
+			t.Fatal(err)
+		} else if reason, err := c.MiningProposeBlock(context.Background(), b); err != nil {
+			t.Fatal(err)
+		} else if reason != api.ProposalRejectDuplicate {
+			t.Fatalf("expected %q, got %q", api.ProposalRejectDuplicate, reason)
+		}
+	}
+
+	t.Run("v1", func(t *testing.T) {
+		network, genesisBlock := testutil.V1Network()
+		test(t, network, genesisBlock)
+	})
+
+	t.Run("v2", func(t *testing.T) {
+		network, genesisBlock := testutil.V2Network()
+		test(t, network, genesisBlock)
+	})
+}
+
+func TestMineGetBlockTemplateWithoutCache(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log, api.WithoutTemplateCache())
+
+	resp, err := c.MiningGetBlockTemplate(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// long polling with the previous id should return a fresh template
+	// immediately
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp2, err := c.MiningGetBlockTemplate(ctx, resp.LongPollID)
+	if err != nil {
+		t.Fatal(err)
+	} else if resp2.LongPollID == resp.LongPollID {
+		t.Fatal("expected a fresh template")
+	}
+}
+
+func TestMineGetBlockTemplatePinnedTimestamp(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log, api.WithoutTemplateCache(), api.WithPinnedTimestamp(time.Minute))
+
+	expected := cn.Chain.TipState().PrevTimestamps[0].Add(time.Minute)
+	for range 2 {
+		resp, err := c.MiningGetBlockTemplate(context.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		} else if resp.Timestamp != int32(expected.Unix()) {
+			t.Fatalf("expected timestamp %v, got %v", expected.Unix(), resp.Timestamp)
+		}
+	}
+}
+
+func TestMineGetBlock(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V2Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+	cn.MineBlocks(t, types.VoidAddress, int(network.HardforkV2.AllowHeight)+5)
+
+	for _, height := range []uint64{0, network.HardforkV2.AllowHeight + 5} {
+		index, ok := cn.Chain.BestIndex(height)
+		if !ok {
+			t.Fatal("missing index for height", height)
+		}
+
+		b, err := c.MiningGetBlockByHeight(context.Background(), height)
+		if err != nil {
+			t.Fatal(err)
+		} else if b.ID() != index.ID {
+			t.Fatalf("expected block %v, got %v", index.ID, b.ID())
+		}
+
+		b, err = c.MiningGetBlockByID(context.Background(), index.ID)
+		if err != nil {
+			t.Fatal(err)
+		} else if b.ID() != index.ID {
+			t.Fatalf("expected block %v, got %v", index.ID, b.ID())
+		}
+	}
+
+	if _, err := c.MiningGetBlockByHeight(context.Background(), cn.Chain.Tip().Height+1); err == nil {
+		t.Fatal("expected error for height beyond tip")
+	} else if _, err := c.MiningGetBlockByID(context.Background(), types.BlockID(frand.Entropy256())); err == nil {
+		t.Fatal("expected error for unknown block")
+	}
+}
+
+func TestDebugBenchTemplate(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+
+	// the endpoint is only available in debug mode
+	c := startMinerServer(t, cn, log)
+	if _, err := c.DebugBenchTemplate(context.Background(), 100*time.Millisecond); err == nil {
+		t.Fatal("expected error without debug mode")
+	}
+
+	c = startMinerServer(t, cn, log, api.WithDebug())
+	resp, err := c.DebugBenchTemplate(context.Background(), 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.Templates == 0 {
+		t.Fatal("expected templates to be generated")
+	} else if resp.Elapsed < 100*time.Millisecond {
+		t.Fatalf("expected benchmark to run for at least 100ms, ran for %v", resp.Elapsed)
+	}
+}
+
+func TestMineFeeHistogram(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V2Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+
+	w, err := c.AddWallet(walletdAPI.WalletUpdateRequest{Name: "fees"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := types.GeneratePrivateKey()
+	uc := types.StandardUnlockConditions(key.PublicKey())
+	err = c.Wallet(w.ID).AddAddress(wallet.Address{
+		Address: uc.UnlockHash(),
+		SpendPolicy: &types.SpendPolicy{
+			Type: types.PolicyTypeUnlockConditions(uc),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn.MineBlocks(t, uc.UnlockHash(), int(network.MaturityDelay)+int(network.HardforkV2.AllowHeight))
+
+	resp, err := c.Wallet(w.ID).ConstructV2([]types.SiacoinOutput{
+		{Address: uc.UnlockHash(), Value: types.Siacoins(100)},
+	}, nil, uc.UnlockHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn := resp.Transaction
+	sigHash := cn.Chain.TipState().InputSigHash(txn)
+	for i := range txn.SiacoinInputs {
+		txn.SiacoinInputs[i].SatisfiedPolicy.Signatures = []types.Signature{key.SignHash(sigHash)}
+	}
+	if _, err := c.TxpoolBroadcast(resp.Basis, nil, []types.V2Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+
+	histogram, err := c.MiningFeeHistogram(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if len(histogram.Buckets) == 0 {
+		t.Fatal("expected buckets")
+	}
+	for i := 1; i < len(histogram.Buckets); i++ {
+		prev, cur := histogram.Buckets[i-1], histogram.Buckets[i]
+		if cur.MinFeeRate.Cmp(prev.MinFeeRate) > 0 {
+			t.Fatal("expected buckets to be ordered by decreasing fee rate")
+		} else if cur.Transactions < prev.Transactions || cur.Weight < prev.Weight {
+			t.Fatal("expected buckets to be cumulative")
+		}
+	}
+	last := histogram.Buckets[len(histogram.Buckets)-1]
+	if last.Transactions != 1 {
+		t.Fatalf("expected 1 transaction in the last bucket, got %d", last.Transactions)
+	} else if last.Weight != cn.Chain.TipState().V2TransactionWeight(txn) {
+		t.Fatalf("expected weight %d, got %d", cn.Chain.TipState().V2TransactionWeight(txn), last.Weight)
+	}
+
+	// the confirmation target is based on the same pool transactions
+	weight := cn.Chain.TipState().V2TransactionWeight(txn)
+	rate := txn.MinerFee.Div64(weight)
+	target, err := c.MiningConfirmTarget(context.Background(), rate.Sub(types.NewCurrency64(1)))
+	if err != nil {
+		t.Fatal(err)
+	} else if target.TransactionsAhead != 1 || target.WeightAhead != weight {
+		t.Fatalf("expected 1 transaction with weight %d ahead, got %d with weight %d", weight, target.TransactionsAhead, target.WeightAhead)
+	} else if target.Blocks != 1 {
+		t.Fatalf("expected confirmation in 1 block, got %d", target.Blocks)
+	} else if target.MaxBlockWeight != histogram.MaxBlockWeight {
+		t.Fatalf("expected max block weight %d, got %d", histogram.MaxBlockWeight, target.MaxBlockWeight)
+	}
+	target, err = c.MiningConfirmTarget(context.Background(), rate)
+	if err != nil {
+		t.Fatal(err)
+	} else if target.TransactionsAhead != 0 || target.WeightAhead != 0 || target.Blocks != 1 {
+		t.Fatalf("expected no transactions ahead, got %+v", target)
+	}
+}
+
+func TestMineSubmitBlockAndWait(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log, api.WithSubmitBlockWaitTimeout(100*time.Millisecond))
+
+	childBlock := func(cs consensus.State) types.Block {
+		b := types.Block{
+			ParentID:     cs.Index.ID,
+			Timestamp:    types.CurrentTimestamp(),
+			MinerPayouts: []types.SiacoinOutput{{Address: frand.Entropy256(), Value: cs.BlockReward()}},
+		}
+		if !coreutils.FindBlockNonce(cs, &b, 10*time.Second) {
+			t.Fatal("failed to find nonce")
+		}
+		return b
+	}
+
+	parent := cn.Chain.TipState()
+	b := childBlock(parent)
+	if err := c.MiningSubmitBlockAndWait(context.Background(), b); err != nil {
+		t.Fatal(err)
+	} else if cn.Chain.Tip().ID != b.ID() {
+		t.Fatalf("expected tip to be %v, got %v", b.ID(), cn.Chain.Tip().ID)
+	}
+
+	// a sibling of the tip is added but doesn't become the tip, so waiting
+	// should time out
+	sibling := childBlock(parent)
+	if err := c.MiningSubmitBlockAndWait(context.Background(), sibling); err == nil {
+		t.Fatal("expected waiting for a sibling block to time out")
+	}
+}
+
+func TestMineGetBlockTemplateForceVersion(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	test := func(t *testing.T, n *consensus.Network, genesisBlock types.Block, version uint32) {
+		cn := testutil.NewConsensusNode(t, n, genesisBlock, log)
+		c := startMinerServer(t, cn, log, api.WithForceBlockVersion(version))
+
+		resp, err := c.MiningGetBlockTemplate(context.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		} else if resp.Version != version {
+			t.Fatalf("expected version %d, got %d", version, resp.Version)
+		}
+	}
+
+	t.Run("v1", func(t *testing.T) {
+		network, genesisBlock := testutil.V2Network()
+		test(t, network, genesisBlock, 1)
+	})
+
+	t.Run("v2", func(t *testing.T) {
+		network, genesisBlock := testutil.V1Network()
+		test(t, network, genesisBlock, 2)
+	})
+}
+
+func TestMineGetBlockTemplateMinHeight(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log, api.WithMinMiningHeight(5), api.WithoutTemplateCache())
+
+	if _, err := c.MiningGetBlockTemplate(context.Background(), ""); err == nil || !strings.Contains(err.Error(), "mining is disabled below height 5") {
+		t.Fatalf("expected template to be refused, got %v", err)
+	}
+
+	cn.MineBlocks(t, types.VoidAddress, 4)
+	if resp, err := c.MiningGetBlockTemplate(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	} else if resp.Height != 5 {
+		t.Fatalf("expected template at height 5, got %d", resp.Height)
+	}
+}
+
+func TestClientUnixSocket(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+
+	path := filepath.Join(t.TempDir(), "minerd.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	minerAPI := api.NewServer(cn.Chain, cn.Syncer, types.VoidAddress, api.WithLogger(log))
+	server := &http.Server{Handler: http.StripPrefix("/mining", minerAPI)}
+	defer server.Close()
+	go server.Serve(l)
+
+	c := api.NewClient(api.UnixSocketURL(path), "")
+	b, err := c.MiningGetBlockByHeight(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	} else if b.ID() != genesisBlock.ID() {
+		t.Fatalf("expected genesis block %v, got %v", genesisBlock.ID(), b.ID())
+	}
+}
+
+func TestMiningStats(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+
+	for range 2 {
+		if _, err := c.MiningGetBlockTemplate(context.Background(), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// submit a valid block and an invalid one
+	cs := cn.Chain.TipState()
+	b := types.Block{
+		ParentID:     cs.Index.ID,
+		Timestamp:    types.CurrentTimestamp(),
+		MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+	}
+	if !coreutils.FindBlockNonce(cs, &b, 10*time.Second) {
+		t.Fatal("failed to find nonce")
+	} else if err := c.MiningSubmitBlock(context.Background(), b); err != nil {
+		t.Fatal(err)
+	}
+	b.ParentID = b.ID()
+	b.MinerPayouts[0].Value = b.MinerPayouts[0].Value.Mul64(2)
+	if err := c.MiningSubmitBlock(context.Background(), b); err == nil {
+		t.Fatal("expected invalid block to be rejected")
+	}
+
+	stats, err := c.MiningStats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if stats.TemplatesServed != 2 {
+		t.Fatalf("expected 2 templates served, got %d", stats.TemplatesServed)
+	} else if stats.TemplateCacheHits != 1 || stats.TemplateCacheMisses != 1 || stats.TemplateCacheHitRate != 0.5 {
+		t.Fatalf("expected 1 cache hit and 1 miss, got %d hits, %d misses and a hit rate of %v", stats.TemplateCacheHits, stats.TemplateCacheMisses, stats.TemplateCacheHitRate)
+	} else if stats.BlocksSubmitted != 2 || stats.BlocksAccepted != 1 || stats.BlocksRejected != 1 {
+		t.Fatalf("expected 2 submitted, 1 accepted and 1 rejected block, got %d, %d and %d", stats.BlocksSubmitted, stats.BlocksAccepted, stats.BlocksRejected)
+	} else if stats.Uptime <= 0 {
+		t.Fatal("expected positive uptime")
+	} else if stats.IndexLag != nil {
+		t.Fatalf("expected no index lag without monitoring, got %d", *stats.IndexLag)
+	}
+}
+
+func TestMiningStatsIndexLag(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	indexed := cn.Chain.Tip()
+	c := startMinerServer(t, cn, log, api.WithIndexedTipFunc(func() (types.ChainIndex, error) { return indexed, nil }))
+	cn.MineBlocks(t, types.VoidAddress, 5)
+
+	stats, err := c.MiningStats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if stats.IndexLag == nil || *stats.IndexLag != 5 {
+		t.Fatalf("expected an index lag of 5 blocks, got %v", stats.IndexLag)
+	}
+}
+
+func TestMineGetBlockTemplateConsistentSnapshot(t *testing.T) {
+	log := zaptest.NewLogger(t, zaptest.Level(zap.WarnLevel))
+
+	network, genesisBlock := testutil.V2Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log, api.WithoutTemplateCache())
+
+	w, err := c.AddWallet(walletdAPI.WalletUpdateRequest{Name: "hammer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := types.GeneratePrivateKey()
+	uc := types.StandardUnlockConditions(key.PublicKey())
+	addr := uc.UnlockHash()
+	err = c.Wallet(w.ID).AddAddress(wallet.Address{
+		Address: addr,
+		SpendPolicy: &types.SpendPolicy{
+			Type: types.PolicyTypeUnlockConditions(uc),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn.MineBlocks(t, addr, int(network.MaturityDelay)+int(network.HardforkV2.AllowHeight)+20)
+
+	// continuously add transactions to the pool and mine blocks to clear it
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ctx.Err() == nil; i++ {
+			if i%10 == 9 {
+				if b, ok := coreutils.MineBlock(cn.Chain, addr, time.Second); ok {
+					cn.Chain.AddBlocks([]types.Block{b})
+				}
+				continue
+			}
+
+			resp, err := c.Wallet(w.ID).ConstructV2([]types.SiacoinOutput{
+				{Address: addr, Value: types.Siacoins(1)},
+			}, nil, addr)
+			if err != nil {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			txn := resp.Transaction
+			sigHash := cn.Chain.TipState().InputSigHash(txn)
+			for i := range txn.SiacoinInputs {
+				txn.SiacoinInputs[i].SatisfiedPolicy.Signatures = []types.Signature{key.SignHash(sigHash)}
+			}
+			c.TxpoolBroadcast(resp.Basis, nil, []types.V2Transaction{txn})
+		}
+	}()
+
+	for range 100 {
+		resp, err := c.MiningGetBlockTemplate(context.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		spent := make(map[types.SiacoinOutputID]bool)
+		for _, templateTxn := range resp.Transactions {
+			if templateTxn.TxType != "2" {
+				t.Fatalf("unexpected transaction type %q", templateTxn.TxType)
+			}
+			rawTxn, err := hex.DecodeString(templateTxn.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var txn types.V2Transaction
+			dec := types.NewBufDecoder(rawTxn)
+			txn.DecodeFrom(dec)
+			if err := dec.Err(); err != nil {
+				t.Fatal(err)
+			}
+			for _, sci := range txn.SiacoinInputs {
+				if spent[sci.Parent.ID] {
+					t.Fatalf("template spends %v twice", sci.Parent.ID)
+				}
+				spent[sci.Parent.ID] = true
+			}
+		}
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestMineAllowedCIDRs(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+
+	allowed := startMinerServer(t, cn, log, api.WithAllowedCIDRs([]netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}))
+	if _, err := allowed.MiningGetBlockTemplate(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	denied := startMinerServer(t, cn, log, api.WithAllowedCIDRs([]netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}))
+	if _, err := denied.MiningGetBlockTemplate(context.Background(), ""); err == nil || !strings.Contains(err.Error(), "forbidden") {
+		t.Fatalf("expected forbidden error, got %v", err)
+	}
+}
+
+func TestMineGetBlockTemplateStartupGracePeriod(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+
+	const gracePeriod = 200 * time.Millisecond
+	c := startMinerServer(t, cn, log, api.WithStartupGracePeriod(gracePeriod))
+
+	// the node has no peers so templates shouldn't be served until the grace
+	// period has passed
+	if _, err := c.MiningGetBlockTemplate(context.Background(), ""); err == nil || !strings.Contains(err.Error(), "warming up") {
+		t.Fatalf("expected warming up error, got %v", err)
+	}
+	time.Sleep(gracePeriod)
+	if _, err := c.MiningGetBlockTemplate(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMinePayoutAddress(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+
+	var calls int
+	addrs := []types.Address{frand.Entropy256(), frand.Entropy256()}
+	c := startMinerServer(t, cn, log, api.WithPayoutAddressFunc(func() (types.Address, error) {
+		addr := addrs[calls%len(addrs)]
+		calls++
+		return addr, nil
+	}))
+
+	// without a cached template, the address of the next template is returned
+	resp, err := c.MiningPayoutAddress(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.Address != addrs[0] {
+		t.Fatalf("expected payout address %v, got %v", addrs[0], resp.Address)
+	}
+
+	// once a template is cached, its address is returned
+	template, err := c.MiningGetBlockTemplate(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	} else if template.PayoutAddress != addrs[1].String() {
+		t.Fatalf("expected template payout address %v, got %v", addrs[1], template.PayoutAddress)
+	}
+	resp, err = c.MiningPayoutAddress(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.Address != addrs[1] {
+		t.Fatalf("expected payout address %v, got %v", addrs[1], resp.Address)
+	}
+}
+
+func TestMineSubmitBlockOutlineUnknownTransactions(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V2Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+
+	w, err := c.AddWallet(walletdAPI.WalletUpdateRequest{Name: "outline"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := types.GeneratePrivateKey()
+	uc := types.StandardUnlockConditions(key.PublicKey())
+	err = c.Wallet(w.ID).AddAddress(wallet.Address{
+		Address: uc.UnlockHash(),
+		SpendPolicy: &types.SpendPolicy{
+			Type: types.PolicyTypeUnlockConditions(uc),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn.MineBlocks(t, uc.UnlockHash(), int(network.MaturityDelay)+int(network.HardforkV2.AllowHeight))
+
+	// construct a transaction but never add it to the node's pool
+	resp, err := c.Wallet(w.ID).ConstructV2([]types.SiacoinOutput{
+		{Address: uc.UnlockHash(), Value: types.Siacoins(100)},
+	}, nil, uc.UnlockHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn := resp.Transaction
+	cs := cn.Chain.TipState()
+	sigHash := cs.InputSigHash(txn)
+	for i := range txn.SiacoinInputs {
+		txn.SiacoinInputs[i].SatisfiedPolicy.Signatures = []types.Signature{key.SignHash(sigHash)}
+	}
+	if len(cn.Chain.V2PoolTransactions()) != 0 {
+		t.Fatal("expected empty pool")
+	}
+
+	b := types.Block{
+		ParentID:     cs.Index.ID,
+		Timestamp:    types.CurrentTimestamp(),
+		MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward().Add(txn.MinerFee)}},
+		V2: &types.V2BlockData{
+			Height:       cs.Index.Height + 1,
+			Transactions: []types.V2Transaction{txn},
+		},
+	}
+	b.V2.Commitment = cs.Commitment(types.VoidAddress, nil, b.V2.Transactions)
+	if !coreutils.FindBlockNonce(cs, &b, 10*time.Second) {
+		t.Fatal("failed to find nonce")
+	} else if err := c.MiningSubmitBlock(context.Background(), b); err != nil {
+		t.Fatal(err)
+	}
+
+	// the outline must contain the full transaction so peers can reconstruct
+	// the block without having seen it
+	outlines := cn.Syncer.BlockOutlines()
+	if len(outlines) != 1 {
+		t.Fatalf("expected 1 block outline, got %d", len(outlines))
+	}
+	bo := outlines[0]
+	if missing := bo.Missing(); len(missing) != 0 {
+		t.Fatalf("expected no missing transactions, got %v", missing)
+	}
+	outlined, missing := bo.Complete(cs, nil, nil)
+	if len(missing) != 0 {
+		t.Fatalf("expected no missing transactions, got %v", missing)
+	} else if outlined.ID() != b.ID() {
+		t.Fatalf("expected block %v, got %v", b.ID(), outlined.ID())
+	}
+}
+
+func TestMineSlowTemplateWarning(t *testing.T) {
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, zaptest.NewLogger(t))
+
+	core, logs := observer.New(zap.WarnLevel)
+	c := startMinerServer(t, cn, zap.New(core), api.WithSlowTemplateThreshold(time.Nanosecond))
+	if _, err := c.MiningGetBlockTemplate(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := logs.FilterMessage("slow block template generation").All()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 warning, got %d", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	for _, key := range []string{"elapsed", "transactions", "size"} {
+		if _, ok := fields[key]; !ok {
+			t.Fatalf("expected field %q to be logged", key)
+		}
+	}
+}
+
+func TestMineWillInclude(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V2Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+
+	w, err := c.AddWallet(walletdAPI.WalletUpdateRequest{Name: "willinclude"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := types.GeneratePrivateKey()
+	uc := types.StandardUnlockConditions(key.PublicKey())
+	err = c.Wallet(w.ID).AddAddress(wallet.Address{
+		Address: uc.UnlockHash(),
+		SpendPolicy: &types.SpendPolicy{
+			Type: types.PolicyTypeUnlockConditions(uc),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn.MineBlocks(t, uc.UnlockHash(), int(network.MaturityDelay)+int(network.HardforkV2.AllowHeight))
+
+	resp, err := c.Wallet(w.ID).ConstructV2([]types.SiacoinOutput{
+		{Address: uc.UnlockHash(), Value: types.Siacoins(100)},
+	}, nil, uc.UnlockHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn := resp.Transaction
+	sigHash := cn.Chain.TipState().InputSigHash(txn)
+	for i := range txn.SiacoinInputs {
+		txn.SiacoinInputs[i].SatisfiedPolicy.Signatures = []types.Signature{key.SignHash(sigHash)}
+	}
+	if _, err := c.TxpoolBroadcast(resp.Basis, nil, []types.V2Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+
+	inclusion, err := c.MiningWillInclude(context.Background(), txn.ID())
+	if err != nil {
+		t.Fatal(err)
+	} else if !inclusion.Included || inclusion.Position != 0 || inclusion.FeeRank != 1 || inclusion.Transactions != 1 {
+		t.Fatalf("expected transaction to be included first, got %+v", inclusion)
+	}
+
+	// the answer should match the template contents
+	template, err := c.MiningGetBlockTemplate(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	} else if template.Transactions[inclusion.Position].TxID != txn.ID().String() {
+		t.Fatal("expected transaction at the reported position")
+	}
+
+	// excluded transactions and unknown transactions are not included
+	if err := c.MiningExcludeTransaction(context.Background(), txn.ID()); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []types.TransactionID{txn.ID(), frand.Entropy256()} {
+		if inclusion, err := c.MiningWillInclude(context.Background(), id); err != nil {
+			t.Fatal(err)
+		} else if inclusion.Included {
+			t.Fatalf("expected transaction %v not to be included", id)
+		}
+	}
+}
+
+func TestMineAllocateRange(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log, api.WithNonceRangeSize(1<<63))
+
+	template, err := c.MiningGetBlockTemplate(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the nonce space is split into two ranges
+	r1, err := c.MiningAllocateRange(context.Background(), template.LongPollID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := c.MiningAllocateRange(context.Background(), template.LongPollID)
+	if err != nil {
+		t.Fatal(err)
+	} else if r1.Start != 0 || r1.Size != 1<<63 || r2.Start != 1<<63 || r2.Size != 1<<63 {
+		t.Fatalf("unexpected ranges %+v and %+v", r1, r2)
+	} else if _, err := c.MiningAllocateRange(context.Background(), template.LongPollID); err == nil {
+		t.Fatal("expected nonce space to be exhausted")
+	}
+
+	// allocations expire with the template
+	cn.MineBlocks(t, types.VoidAddress, 1)
+	if _, err := c.MiningAllocateRange(context.Background(), template.LongPollID); err == nil {
+		t.Fatal("expected allocation for expired template to fail")
+	}
+	template, err = c.MiningGetBlockTemplate(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	} else if r, err := c.MiningAllocateRange(context.Background(), template.LongPollID); err != nil {
+		t.Fatal(err)
+	} else if r.Start != 0 {
+		t.Fatalf("expected allocations to restart at 0, got %d", r.Start)
+	}
+}
+
+func TestMineGetWork(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+
+	work, err := c.MiningGetWork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawHeader, err := hex.DecodeString(work.Data)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(rawHeader) != 80 {
+		t.Fatalf("expected 80 byte header, got %d", len(rawHeader))
+	}
+	var h types.BlockHeader
+	dec := types.NewBufDecoder(rawHeader)
+	h.DecodeFrom(dec)
+	if err := dec.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	cs := cn.Chain.TipState()
+	if h.ParentID != cs.Index.ID {
+		t.Fatal("expected header to build on the tip")
+	} else if work.Target != cs.PoWTarget().String() {
+		t.Fatalf("expected target %v, got %v", cs.PoWTarget(), work.Target)
+	}
+
+	// solve the header
+	for h.ID().CmpWork(cs.PoWTarget()) < 0 {
+		h.Nonce += cs.NonceFactor()
+	}
+	if accepted, err := c.MiningSubmitWork(context.Background(), h); err != nil {
+		t.Fatal(err)
+	} else if !accepted {
+		t.Fatal("expected block to be accepted")
+	} else if cn.Chain.Tip().ID != h.ID() {
+		t.Fatal("expected block to be the new tip")
+	}
+
+	// the work is stale now
+	if _, err := c.MiningSubmitWork(context.Background(), h); err == nil {
+		t.Fatal("expected stale work to be rejected")
+	}
+}
+
+func TestMineGetWorkV2(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V2Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+	cn.MineBlocks(t, types.VoidAddress, int(network.HardforkV2.AllowHeight))
+
+	if _, err := c.MiningGetWork(context.Background()); err == nil || !strings.Contains(err.Error(), "only supports v1") {
+		t.Fatalf("expected v1 only error, got %v", err)
+	}
+}
+
+func TestMineGetBlockTemplateReorgDebounce(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+
+	const window = time.Second
+	c := startMinerServer(t, cn, log, api.WithReorgDebounce(window))
+
+	assertParent := func(want types.ChainIndex) {
+		t.Helper()
+		template, err := c.MiningGetBlockTemplate(context.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		} else if template.Parent != want {
+			t.Fatalf("expected template to build on %v, got %v", want, template.Parent)
+		}
+	}
+	assertParent(cn.Chain.Tip())
+
+	// the first reorg invalidates the template immediately
+	cn.MineBlocks(t, types.VoidAddress, 1)
+	first := cn.Chain.Tip()
+	assertParent(first)
+
+	// further reorgs within the window are coalesced
+	cn.MineBlocks(t, types.VoidAddress, 2)
+	assertParent(first)
+
+	// once the window expires, the template builds on the settled tip
+	time.Sleep(window + 100*time.Millisecond)
+	assertParent(cn.Chain.Tip())
+}
+
+func TestMiningStatsOrphanedBlocks(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+
+	// build a competing chain on a separate chain manager
+	store, tipState, err := chain.NewDBStore(chain.NewMemDB(), network, genesisBlock, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fork := chain.NewManager(store, tipState)
+	var forkBlocks []types.Block
+	for range 2 {
+		b, ok := coreutils.MineBlock(fork, frand.Entropy256(), 10*time.Second)
+		if !ok {
+			t.Fatal("failed to mine block")
+		} else if err := fork.AddBlocks([]types.Block{b}); err != nil {
+			t.Fatal(err)
+		}
+		forkBlocks = append(forkBlocks, b)
+	}
+
+	// submit a block through the API, then reorg it out
+	cs := cn.Chain.TipState()
+	b := types.Block{
+		ParentID:     cs.Index.ID,
+		Timestamp:    types.CurrentTimestamp(),
+		MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+	}
+	if !coreutils.FindBlockNonce(cs, &b, 10*time.Second) {
+		t.Fatal("failed to find nonce")
+	} else if err := c.MiningSubmitBlock(context.Background(), b); err != nil {
+		t.Fatal(err)
+	} else if err := cn.Chain.AddBlocks(forkBlocks); err != nil {
+		t.Fatal(err)
+	} else if cn.Chain.Tip() != fork.Tip() {
+		t.Fatal("expected reorg to the competing chain")
+	}
+
+	stats, err := c.MiningStats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if stats.OrphanedBlocks != 1 || stats.SubmittedBlocksOrphaned != 1 {
+		t.Fatalf("expected 1 orphaned block submitted through the API, got %d orphaned and %d submitted orphaned", stats.OrphanedBlocks, stats.SubmittedBlocksOrphaned)
+	}
+}
+
+func TestMiningBlockStatus(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+
+	// build a competing chain on a separate chain manager
+	store, tipState, err := chain.NewDBStore(chain.NewMemDB(), network, genesisBlock, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fork := chain.NewManager(store, tipState)
+	var forkBlocks []types.Block
+	for range 2 {
+		b, ok := coreutils.MineBlock(fork, frand.Entropy256(), 10*time.Second)
+		if !ok {
+			t.Fatal("failed to mine block")
+		} else if err := fork.AddBlocks([]types.Block{b}); err != nil {
+			t.Fatal(err)
+		}
+		forkBlocks = append(forkBlocks, b)
+	}
+
+	assertStatus := func(id types.BlockID, expected api.MiningBlockStatusResponse) {
+		t.Helper()
+		status, err := c.MiningBlockStatus(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		} else if status != expected {
+			t.Fatalf("expected status %+v, got %+v", expected, status)
+		}
+	}
+
+	// blocks the node hasn't seen are unknown
+	assertStatus(forkBlocks[0].ID(), api.MiningBlockStatusResponse{})
+
+	cs := cn.Chain.TipState()
+	b := types.Block{
+		ParentID:     cs.Index.ID,
+		Timestamp:    types.CurrentTimestamp(),
+		MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+	}
+	if !coreutils.FindBlockNonce(cs, &b, 10*time.Second) {
+		t.Fatal("failed to find nonce")
+	} else if err := c.MiningSubmitBlock(context.Background(), b); err != nil {
+		t.Fatal(err)
+	}
+	assertStatus(b.ID(), api.MiningBlockStatusResponse{Known: true, Height: 1, MainChain: true, Confirmations: 1})
+	assertStatus(genesisBlock.ID(), api.MiningBlockStatusResponse{Known: true, Height: 0, MainChain: true, Confirmations: 2})
+
+	// reorg the submitted block out
+	if err := cn.Chain.AddBlocks(forkBlocks); err != nil {
+		t.Fatal(err)
+	} else if cn.Chain.Tip() != fork.Tip() {
+		t.Fatal("expected reorg to the competing chain")
+	}
+	assertStatus(b.ID(), api.MiningBlockStatusResponse{Known: true, Height: 1, Orphaned: true})
+	assertStatus(forkBlocks[0].ID(), api.MiningBlockStatusResponse{Known: true, Height: 1, MainChain: true, Confirmations: 2})
+}
+
+func TestMineGetBlockTemplateMedianTimestamp(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+
+	// mine blocks with timestamps in the future so that the current time is
+	// before the median timestamp
+	future := types.CurrentTimestamp().Add(time.Hour)
+	for range 11 {
+		cs := cn.Chain.TipState()
+		b := types.Block{
+			ParentID:     cs.Index.ID,
+			Timestamp:    future,
+			MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+		}
+		if !coreutils.FindBlockNonce(cs, &b, 10*time.Second) {
+			t.Fatal("failed to find nonce")
+		} else if err := cn.Chain.AddBlocks([]types.Block{b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	template, err := c.MiningGetBlockTemplate(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	} else if ts := time.Unix(int64(template.Timestamp), 0); !ts.After(future) {
+		t.Fatalf("expected template timestamp after %v, got %v", future, ts)
+	}
+
+	// a block built from the template should be accepted
+	cs := cn.Chain.TipState()
+	b := types.Block{
+		ParentID:     cs.Index.ID,
+		Timestamp:    time.Unix(int64(template.Timestamp), 0),
+		MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+	}
+	if !coreutils.FindBlockNonce(cs, &b, 10*time.Second) {
+		t.Fatal("failed to find nonce")
+	} else if err := c.MiningSubmitBlock(context.Background(), b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientSubscribeTips(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tips := c.SubscribeTips(ctx)
+
+	next := func() types.ChainIndex {
+		t.Helper()
+		select {
+		case tip := <-tips:
+			return tip
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for tip")
+			return types.ChainIndex{}
+		}
+	}
+
+	// the current tip is sent first
+	if tip := next(); tip != cn.Chain.Tip() {
+		t.Fatalf("expected tip %v, got %v", cn.Chain.Tip(), tip)
+	}
+	for range 3 {
+		cn.MineBlocks(t, types.VoidAddress, 1)
+		if tip := next(); tip != cn.Chain.Tip() {
+			t.Fatalf("expected tip %v, got %v", cn.Chain.Tip(), tip)
+		}
+	}
+
+	// the channel is closed once the context is canceled
+	cancel()
+	select {
+	case _, ok := <-tips:
+		if ok {
+			t.Fatal("expected channel to be closed")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for channel to close")
+	}
+}
+
+func TestMineIndexModeNone(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V2Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServerWithWallet(t, cn, log, []wallet.Option{wallet.WithIndexMode(wallet.IndexModeNone)})
+
+	// mining doesn't depend on the wallet index, so blocks can be mined
+	// across the v2 hardfork without the store ever syncing
+	for range network.HardforkV2.AllowHeight + 3 {
+		template, err := c.MiningGetBlockTemplate(context.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		} else if template.Parent != cn.Chain.Tip() {
+			t.Fatalf("expected template to build on %v, got %v", cn.Chain.Tip(), template.Parent)
+		}
+
+		cs, err := c.ConsensusTipState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := types.Block{
+			ParentID:     cs.Index.ID,
+			Timestamp:    types.CurrentTimestamp(),
+			MinerPayouts: []types.SiacoinOutput{{Address: frand.Entropy256(), Value: cs.BlockReward()}},
+		}
+		if template.Version == 2 {
+			b.V2 = &types.V2BlockData{Height: cs.Index.Height + 1}
+			b.V2.Commitment = cs.Commitment(b.MinerPayouts[0].Address, nil, nil)
+		}
+		if !coreutils.FindBlockNonce(cs, &b, 10*time.Second) {
+			t.Fatal("failed to find nonce")
+		} else if err := c.MiningSubmitBlock(context.Background(), b); err != nil {
+			t.Fatal(err)
+		} else if cn.Chain.Tip().ID != b.ID() {
+			t.Fatal("expected block to be the new tip")
+		}
+	}
+	if cs := cn.Chain.TipState(); cs.Index.Height < network.HardforkV2.AllowHeight {
+		t.Fatal("expected v2 blocks to be mined")
+	}
+}
+
+func TestMiningInfo(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V2Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+
+	info, err := c.MiningInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := cn.Chain.TipState()
+	switch {
+	case info.Template.Parent != cs.Index:
+		t.Fatalf("expected template to build on %v, got %v", cs.Index, info.Template.Parent)
+	case info.Network != network.Name:
+		t.Fatalf("expected network %q, got %q", network.Name, info.Network)
+	case info.Hardfork.V2Allow != network.HardforkV2.AllowHeight || info.Hardfork.V2Require != network.HardforkV2.RequireHeight:
+		t.Fatalf("unexpected hardfork heights %+v", info.Hardfork)
+	case info.Target != cs.PoWTarget().String() || info.Target != info.Template.Target:
+		t.Fatalf("expected target %v, got %v", cs.PoWTarget(), info.Target)
+	case info.Bits != info.Template.Bits:
+		t.Fatalf("expected bits %v, got %v", info.Template.Bits, info.Bits)
+	case info.Difficulty != cs.Difficulty:
+		t.Fatalf("expected difficulty %v, got %v", cs.Difficulty, info.Difficulty)
+	case info.PayoutAddress.String() != info.Template.PayoutAddress:
+		t.Fatalf("expected payout address %v, got %v", info.Template.PayoutAddress, info.PayoutAddress)
+	case !info.RecommendedFee.Equals(cn.Chain.RecommendedFee()):
+		t.Fatalf("expected recommended fee %v, got %v", cn.Chain.RecommendedFee(), info.RecommendedFee)
+	case info.Synced || info.Peers != 0:
+		t.Fatal("expected node without peers not to be synced")
+	}
+
+	// the template is shared with getblocktemplate
+	template, err := c.MiningGetBlockTemplate(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	} else if template.LongPollID != info.Template.LongPollID {
+		t.Fatal("expected the cached template to be returned")
+	}
+}
+
+func TestMiningUpdates(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+	cn.MineBlocks(t, types.VoidAddress, 5)
+
+	// updates since an old index are returned immediately
+	genesis, _ := cn.Chain.BestIndex(0)
+	reverted, applied, err := c.MiningUpdates(context.Background(), genesis, 3)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(reverted) != 0 || len(applied) != 3 {
+		t.Fatalf("expected 3 applied updates, got %d reverted and %d applied", len(reverted), len(applied))
+	} else if applied[0].State.Index.Height != 1 || applied[0].State.Network == nil {
+		t.Fatalf("unexpected first update %v", applied[0].State.Index)
+	}
+
+	// at the tip, the request waits for the next block
+	tip := cn.Chain.Tip()
+	appliedCh := make(chan []chain.ApplyUpdate, 1)
+	go func() {
+		_, applied, err := c.MiningUpdates(context.Background(), tip, 10)
+		if err != nil {
+			t.Error(err)
+		}
+		appliedCh <- applied
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cn.MineBlocks(t, types.VoidAddress, 1)
+	select {
+	case applied := <-appliedCh:
+		if len(applied) != 1 || applied[0].State.Index != cn.Chain.Tip() {
+			t.Fatalf("expected the new tip to be applied, got %d updates", len(applied))
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for updates")
+	}
+
+	// unknown indices require a resync
+	_, _, err = c.MiningUpdates(context.Background(), types.ChainIndex{Height: 3, ID: frand.Entropy256()}, 10)
+	if err == nil || !strings.Contains(err.Error(), "resync") {
+		t.Fatalf("expected resync error, got %v", err)
+	}
+
+	// so do known blocks paired with the wrong height
+	_, _, err = c.MiningUpdates(context.Background(), types.ChainIndex{Height: genesis.Height + 1, ID: genesis.ID}, 10)
+	if err == nil || !strings.Contains(err.Error(), "resync") {
+		t.Fatalf("expected resync error, got %v", err)
+	}
+}
+
+func TestMineSubmitBlockDifficultyBounds(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	// raise the difficulty slightly so there is room below it
+	network.InitialTarget = types.BlockID{0x3f}
+	for i := 1; i < len(network.InitialTarget); i++ {
+		network.InitialTarget[i] = 0xff
+	}
+	work := func(s string) (w consensus.Work) {
+		if err := w.UnmarshalText([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	childBlock := func(cs consensus.State) types.Block {
+		b := types.Block{
+			ParentID:     cs.Index.ID,
+			Timestamp:    types.CurrentTimestamp(),
+			MinerPayouts: []types.SiacoinOutput{{Address: frand.Entropy256(), Value: cs.BlockReward()}},
+		}
+		if !coreutils.FindBlockNonce(cs, &b, 10*time.Second) {
+			t.Fatal("failed to find nonce")
+		}
+		return b
+	}
+
+	tests := []struct {
+		name     string
+		min, max consensus.Work
+		err      string
+	}{
+		{"default", consensus.Work{}, consensus.Work{}, ""},
+		{"below minimum", work("1000000000000000000000000"), consensus.Work{}, "below the minimum"},
+		{"above maximum", consensus.Work{}, work("2"), "above the maximum"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+			c := startMinerServer(t, cn, log, api.WithDifficultyBounds(test.min, test.max))
+
+			b := childBlock(cn.Chain.TipState())
+			err := c.MiningSubmitBlock(context.Background(), b)
+			if test.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				} else if cn.Chain.Tip().ID != b.ID() {
+					t.Fatal("expected block to be added")
+				}
+			} else if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error %q, got %v", test.err, err)
+			} else if cn.Chain.Tip().ID == b.ID() {
+				t.Fatal("expected block not to be added")
+			}
+		})
+	}
+}
+
+func TestMineGetBlockTemplateBinary(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V2Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+
+	// check a v1 and a v2 template
+	for _, v2 := range []bool{false, true} {
+		if v2 {
+			cn.MineBlocks(t, types.VoidAddress, int(network.HardforkV2.AllowHeight))
+		}
+
+		template, err := c.MiningGetBlockTemplate(context.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		binary, err := c.MiningGetBlockTemplateBinary(context.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case binary.LongPollID != template.LongPollID:
+			t.Fatal("expected the cached template to be returned")
+		case binary.Parent != template.Parent:
+			t.Fatalf("expected parent %v, got %v", template.Parent, binary.Parent)
+		case binary.Commitment != template.Commitment || binary.Block.Header().Commitment != template.Commitment:
+			t.Fatalf("expected commitment %v, got %v", template.Commitment, binary.Commitment)
+		case binary.Target.String() != template.Target:
+			t.Fatalf("expected target %v, got %v", template.Target, binary.Target)
+		case binary.Height != uint64(template.Height):
+			t.Fatalf("expected height %v, got %v", template.Height, binary.Height)
+		case (binary.Block.V2 != nil) != v2 || (binary.StateLeaf != nil) != v2:
+			t.Fatalf("expected v2 block %v", v2)
+		}
+
+		// the decoded block can be solved and submitted as is
+		b := binary.Block
+		if !coreutils.FindBlockNonce(cn.Chain.TipState(), &b, 10*time.Second) {
+			t.Fatal("failed to find nonce")
+		} else if err := c.MiningSubmitBlock(context.Background(), b); err != nil {
+			t.Fatal(err)
+		} else if cn.Chain.Tip().ID != b.ID() {
+			t.Fatal("expected block to be added to the chain")
+		}
+	}
+}
+
+func TestMineRotatePassword(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V2Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+
+	var password atomic.Value
+	password.Store("foo")
+	minerAPI := api.NewServer(cn.Chain, cn.Syncer, types.Address{1}, api.WithLogger(log), api.WithBasicAuthFunc(func() string {
+		return password.Load().(string)
+	}))
+	server := httptest.NewServer(http.StripPrefix("/mining", minerAPI))
+	defer server.Close()
+
+	oldClient := api.NewClient(server.URL, "foo")
+	newClient := api.NewClient(server.URL, "bar")
+	if _, err := newClient.MiningGetBlockTemplate(context.Background(), ""); err == nil {
+		t.Fatal("expected new password to be rejected before the rotation")
+	}
+	template, err := oldClient.MiningGetBlockTemplate(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// start a long polling request with the old password
+	longPollErr := make(chan error, 1)
+	go func() {
+		_, err := oldClient.MiningGetBlockTemplate(context.Background(), template.LongPollID)
+		longPollErr <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	password.Store("bar")
+	if _, err := oldClient.MiningGetBlockTemplate(context.Background(), ""); err == nil {
+		t.Fatal("expected old password to be rejected after the rotation")
+	}
+
+	// mine a block with the new password to complete the long poll
+	binary, err := newClient.MiningGetBlockTemplateBinary(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := binary.Block
+	if !coreutils.FindBlockNonce(cn.Chain.TipState(), &b, 10*time.Second) {
+		t.Fatal("failed to find nonce")
+	} else if err := newClient.MiningSubmitBlock(context.Background(), b); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-longPollErr:
+		if err != nil {
+			t.Fatal("expected in-flight long poll to survive the rotation:", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("long poll didn't return")
+	}
+}
+
+func TestMineSubmitBlockFullOutlines(t *testing.T) {
+	for _, full := range []bool{false, true} {
+		log := zaptest.NewLogger(t)
+
+		network, genesisBlock := testutil.V2Network()
+		cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+		var opts []api.ServerOption
+		if full {
+			opts = append(opts, api.WithFullBlockOutlines())
+		}
+		c := startMinerServer(t, cn, log, opts...)
+
+		w, err := c.AddWallet(walletdAPI.WalletUpdateRequest{Name: "outline"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := types.GeneratePrivateKey()
+		uc := types.StandardUnlockConditions(key.PublicKey())
+		err = c.Wallet(w.ID).AddAddress(wallet.Address{
+			Address: uc.UnlockHash(),
+			SpendPolicy: &types.SpendPolicy{
+				Type: types.PolicyTypeUnlockConditions(uc),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cn.MineBlocks(t, uc.UnlockHash(), int(network.MaturityDelay)+int(network.HardforkV2.AllowHeight))
+
+		// add a transaction to the node's pool
+		resp, err := c.Wallet(w.ID).ConstructV2([]types.SiacoinOutput{
+			{Address: uc.UnlockHash(), Value: types.Siacoins(100)},
+		}, nil, uc.UnlockHash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		txn := resp.Transaction
+		cs := cn.Chain.TipState()
+		sigHash := cs.InputSigHash(txn)
+		for i := range txn.SiacoinInputs {
+			txn.SiacoinInputs[i].SatisfiedPolicy.Signatures = []types.Signature{key.SignHash(sigHash)}
+		}
+		if _, err := cn.Chain.AddV2PoolTransactions(resp.Basis, []types.V2Transaction{txn}); err != nil {
+			t.Fatal(err)
+		}
+
+		b := types.Block{
+			ParentID:     cs.Index.ID,
+			Timestamp:    types.CurrentTimestamp(),
+			MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward().Add(txn.MinerFee)}},
+			V2: &types.V2BlockData{
+				Height:       cs.Index.Height + 1,
+				Transactions: []types.V2Transaction{txn},
+			},
+		}
+		b.V2.Commitment = cs.Commitment(types.VoidAddress, nil, b.V2.Transactions)
+		if !coreutils.FindBlockNonce(cs, &b, 10*time.Second) {
+			t.Fatal("failed to find nonce")
+		} else if err := c.MiningSubmitBlock(context.Background(), b); err != nil {
+			t.Fatal(err)
+		}
+
+		// by default, pooled transactions are only referenced by their hash
+		outlines := cn.Syncer.BlockOutlines()
+		if len(outlines) != 1 {
+			t.Fatalf("expected 1 block outline, got %d", len(outlines))
+		} else if missing := outlines[0].Missing(); full && len(missing) != 0 {
+			t.Fatalf("expected no missing transactions, got %v", missing)
+		} else if !full && len(missing) != 1 {
+			t.Fatalf("expected 1 missing transaction, got %v", missing)
+		}
+	}
+}
+
+func TestMineSubmitBlockDuplicate(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+
+	cs := cn.Chain.TipState()
+	b := types.Block{
+		ParentID:     cs.Index.ID,
+		Timestamp:    types.CurrentTimestamp(),
+		MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+	}
+	if !coreutils.FindBlockNonce(cs, &b, 10*time.Second) {
+		t.Fatal("failed to find nonce")
+	} else if err := c.MiningSubmitBlock(context.Background(), b); err != nil {
+		t.Fatal(err)
+	} else if err := c.MiningSubmitBlock(context.Background(), b); err == nil || err.Error() != api.SubmitRejectDuplicate {
+		t.Fatalf("expected %q, got %v", api.SubmitRejectDuplicate, err)
+	}
+
+	// rejected blocks are validated again when resubmitted
+	invalid := b
+	invalid.ParentID = b.ID()
+	invalid.MinerPayouts = []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward().Mul64(2)}}
+	for range 2 {
+		if err := c.MiningSubmitBlock(context.Background(), invalid); err == nil || err.Error() == api.SubmitRejectDuplicate {
+			t.Fatalf("expected invalid block to be rejected, got %v", err)
+		}
+	}
+
+	stats, err := c.MiningStats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if stats.BlocksSubmitted != 4 || stats.BlocksAccepted != 1 || stats.BlocksRejected != 2 || stats.BlocksDuplicate != 1 {
+		t.Fatalf("expected 4 submitted, 1 accepted, 2 rejected and 1 duplicate block, got %d, %d, %d and %d", stats.BlocksSubmitted, stats.BlocksAccepted, stats.BlocksRejected, stats.BlocksDuplicate)
+	}
+}
+
+func TestMineSubmitBlockKnown(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+	acceptC := startMinerServer(t, cn, log, api.WithAcceptKnownBlocks())
+
+	mineBlock := func() types.Block {
+		t.Helper()
+		cs := cn.Chain.TipState()
+		b := types.Block{
+			ParentID:     cs.Index.ID,
+			Timestamp:    types.CurrentTimestamp(),
+			MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+		}
+		if !coreutils.FindBlockNonce(cs, &b, 10*time.Second) {
+			t.Fatal("failed to find nonce")
+		}
+		return b
+	}
+
+	// blocks the node received elsewhere are duplicates as well
+	b := mineBlock()
+	if err := cn.Chain.AddBlocks([]types.Block{b}); err != nil {
+		t.Fatal(err)
+	} else if err := c.MiningSubmitBlock(context.Background(), b); err == nil || err.Error() != api.SubmitRejectDuplicate {
+		t.Fatalf("expected %q, got %v", api.SubmitRejectDuplicate, err)
+	} else if err := acceptC.MiningSubmitBlock(context.Background(), b); err != nil {
+		t.Fatalf("expected known block to be accepted, got %v", err)
+	}
+
+	// resubmitting a block through the same server is a success as well
+	b = mineBlock()
+	for range 2 {
+		if err := acceptC.MiningSubmitBlock(context.Background(), b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// invalid blocks are still rejected
+	invalid := mineBlock()
+	invalid.MinerPayouts[0].Value = invalid.MinerPayouts[0].Value.Mul64(2)
+	if err := acceptC.MiningSubmitBlock(context.Background(), invalid); err == nil {
+		t.Fatal("expected invalid block to be rejected")
+	}
+
+	stats, err := acceptC.MiningStats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if stats.BlocksSubmitted != 4 || stats.BlocksAccepted != 1 || stats.BlocksRejected != 1 || stats.BlocksDuplicate != 2 {
+		t.Fatalf("expected 4 submitted, 1 accepted, 1 rejected and 2 duplicate blocks, got %d, %d, %d and %d", stats.BlocksSubmitted, stats.BlocksAccepted, stats.BlocksRejected, stats.BlocksDuplicate)
+	}
+}
+
+func TestMineGetBlockTemplateCoinbaseFlags(t *testing.T) {
+	const flags = "/minerd:test/"
+
+	for _, v2 := range []bool{false, true} {
+		log := zaptest.NewLogger(t)
+
+		network, genesisBlock := testutil.V1Network()
+		if v2 {
+			network, genesisBlock = testutil.V2Network()
+		}
+		cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+		c := startMinerServer(t, cn, log, api.WithCoinbaseFlags(flags))
+		if v2 {
+			cn.MineBlocks(t, types.VoidAddress, int(network.HardforkV2.AllowHeight))
+		}
+
+		template, err := c.MiningGetBlockTemplateBinary(context.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := template.Block
+		var data []byte
+		if v2 {
+			data = b.V2Transactions()[0].ArbitraryData
+		} else {
+			data = b.Transactions[0].ArbitraryData[0]
+		}
+		if string(data) != flags {
+			t.Fatalf("expected coinbase flags %q, got %q", flags, data)
+		}
+
+		// a proposal of the marked block is accepted, even though the v1
+		// marker isn't in the pool
+		if reason, err := c.MiningProposeBlock(context.Background(), b); err != nil {
+			t.Fatal(err)
+		} else if reason != "" {
+			t.Fatalf("expected proposal to be accepted, got %q", reason)
+		}
+
+		// other v1 transactions that aren't in the pool can't be validated
+		if !v2 {
+			unknown := b
+			unknown.Transactions = append(append([]types.Transaction(nil), b.Transactions...), types.Transaction{ArbitraryData: [][]byte{[]byte("unknown")}})
+			if reason, err := c.MiningProposeBlock(context.Background(), unknown); err != nil {
+				t.Fatal(err)
+			} else if reason != api.ProposalRejectInconclusive {
+				t.Fatalf("expected %q, got %q", api.ProposalRejectInconclusive, reason)
+			}
+		}
+
+		// the marked block must be valid
+		if !coreutils.FindBlockNonce(cn.Chain.TipState(), &b, 10*time.Second) {
+			t.Fatal("failed to find nonce")
+		} else if err := c.MiningSubmitBlock(context.Background(), b); err != nil {
+			t.Fatal(err)
+		} else if cn.Chain.Tip().ID != b.ID() {
+			t.Fatal("expected block to be added to the chain")
+		}
+
+		resp, err := c.MiningGetBlockTemplate(context.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		} else if resp.CoinbaseFlags != flags {
+			t.Fatalf("expected coinbase flags %q, got %q", flags, resp.CoinbaseFlags)
+		}
+	}
+}
+
+func TestMineGetBlockTemplateRequireTransactions(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V2Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log, api.WithRequireTransactions(200*time.Millisecond))
+
+	// without transactions, the template is served after the max wait
+	start := time.Now()
+	template, err := c.MiningGetBlockTemplate(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	} else if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("expected template to be held for the max wait, got %v", elapsed)
+	} else if len(template.Transactions) != 0 {
+		t.Fatalf("expected empty template, got %d transactions", len(template.Transactions))
+	}
+
+	c = startMinerServer(t, cn, log, api.WithRequireTransactions(time.Minute))
+	w, err := c.AddWallet(walletdAPI.WalletUpdateRequest{Name: "require"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := types.GeneratePrivateKey()
+	uc := types.StandardUnlockConditions(key.PublicKey())
+	err = c.Wallet(w.ID).AddAddress(wallet.Address{
+		Address: uc.UnlockHash(),
+		SpendPolicy: &types.SpendPolicy{
+			Type: types.PolicyTypeUnlockConditions(uc),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn.MineBlocks(t, uc.UnlockHash(), int(network.MaturityDelay)+int(network.HardforkV2.AllowHeight))
+
+	// the template is held until a fee-paying transaction is in the pool
+	templateCh := make(chan api.MiningGetBlockTemplateResponse, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		template, err := c.MiningGetBlockTemplate(context.Background(), "")
+		if err != nil {
+			errCh <- err
+			return
+		}
+		templateCh <- template
+	}()
+	select {
+	case <-templateCh:
+		t.Fatal("expected template to be held")
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	resp, err := c.Wallet(w.ID).ConstructV2([]types.SiacoinOutput{
+		{Address: uc.UnlockHash(), Value: types.Siacoins(100)},
+	}, nil, uc.UnlockHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn := resp.Transaction
+	sigHash := cn.Chain.TipState().InputSigHash(txn)
+	for i := range txn.SiacoinInputs {
+		txn.SiacoinInputs[i].SatisfiedPolicy.Signatures = []types.Signature{key.SignHash(sigHash)}
+	}
+	if _, err := cn.Chain.AddV2PoolTransactions(resp.Basis, []types.V2Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case template := <-templateCh:
+		if len(template.Transactions) != 1 || template.Transactions[0].TxID != txn.ID().String() {
+			t.Fatalf("expected template to contain %v, got %+v", txn.ID(), template.Transactions)
+		}
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("template wasn't served after adding a transaction")
+	}
+}
+
+func TestMiningDifficulty(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+	cn.MineBlocks(t, types.VoidAddress, 5)
+
+	entries, err := c.MiningDifficulty(context.Background(), 3)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	for i, entry := range entries {
+		index, _ := cn.Chain.BestIndex(5 - uint64(i))
+		b, _ := cn.Chain.Block(index.ID)
+		parent, _ := cn.Chain.State(b.ParentID)
+		switch {
+		case entry.Height != index.Height || entry.ID != index.ID:
+			t.Fatalf("expected entry %d to be %v, got %v::%v", i, index, entry.Height, entry.ID)
+		case !entry.Timestamp.Equal(b.Timestamp):
+			t.Fatalf("expected timestamp %v, got %v", b.Timestamp, entry.Timestamp)
+		case entry.Difficulty != parent.Difficulty:
+			t.Fatalf("expected difficulty %v, got %v", parent.Difficulty, entry.Difficulty)
+		}
+	}
+
+	// the genesis block is never included
+	if entries, err := c.MiningDifficulty(context.Background(), 100); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 5 {
+		t.Fatalf("expected 5 entries, got %d", len(entries))
+	}
+	if _, err := c.MiningDifficulty(context.Background(), 1e9); err == nil {
+		t.Fatal("expected error for excessive count")
+	}
+}
+
+func TestMiningPauseResume(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V2Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+
+	assertStatus := func(fn func(context.Context) (api.MiningMinerStatusResponse, error), expected string) {
+		t.Helper()
+		resp, err := fn(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		} else if resp.Status != expected {
+			t.Fatalf("expected status %q, got %q", expected, resp.Status)
+		}
+	}
+
+	assertStatus(c.MiningMinerStatus, api.MinerStatusRunning)
+	assertStatus(c.MiningPause, api.MinerStatusPaused)
+	assertStatus(c.MiningMinerStatus, api.MinerStatusPaused)
+	// pausing twice is a no-op
+	assertStatus(c.MiningPause, api.MinerStatusPaused)
+	assertStatus(c.MiningResume, api.MinerStatusRunning)
+	assertStatus(c.MiningMinerStatus, api.MinerStatusRunning)
+}
+
+func TestMiningJSONRPC(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+
+	// the endpoint is only served when enabled
+	c := startMinerServer(t, cn, log)
+	if _, err := c.MiningRPC(context.Background(), api.JSONRPCRequest{JSONRPC: "2.0", Method: "getmininginfo", ID: json.RawMessage("1")}); err == nil {
+		t.Fatal("expected error without WithJSONRPC")
+	}
+
+	c = startMinerServer(t, cn, log, api.WithJSONRPC())
+	resp, err := c.MiningRPC(context.Background(), api.JSONRPCRequest{
+		JSONRPC: "2.0",
+		Method:  "getblocktemplate",
+		Params:  json.RawMessage(`[{}]`),
+		ID:      json.RawMessage(`"gbt"`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.Error != nil {
+		t.Fatal(resp.Error)
+	} else if string(resp.ID) != `"gbt"` {
+		t.Fatalf("expected ID %q, got %q", `"gbt"`, resp.ID)
+	}
+	var template api.MiningGetBlockTemplateResponse
+	if err := json.Unmarshal(resp.Result, &template); err != nil {
+		t.Fatal(err)
+	} else if template.LongPollID == "" || template.Height != 1 {
+		t.Fatalf("unexpected template %+v", template)
+	}
+
+	cs := cn.Chain.TipState()
+	b := types.Block{
+		ParentID:     cs.Index.ID,
+		Timestamp:    types.CurrentTimestamp(),
+		MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+	}
+	if !coreutils.FindBlockNonce(cs, &b, 10*time.Second) {
+		t.Fatal("failed to find nonce")
+	}
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	types.V1Block(b).EncodeTo(e)
+	e.Flush()
+	params, _ := json.Marshal([]string{hex.EncodeToString(buf.Bytes())})
+
+	resps, err := c.MiningRPCBatch(context.Background(), []api.JSONRPCRequest{
+		{JSONRPC: "2.0", Method: "submitblock", Params: params, ID: json.RawMessage("1")},
+		{JSONRPC: "2.0", Method: "submitblock", Params: params, ID: json.RawMessage("2")},
+		{JSONRPC: "2.0", Method: "getminingstats"}, // notification
+		{JSONRPC: "2.0", Method: "submitblock", Params: json.RawMessage(`[1]`), ID: json.RawMessage("3")},
+		{JSONRPC: "2.0", Method: "foo", ID: json.RawMessage("4")},
+		{JSONRPC: "1.0", Method: "getmininginfo", ID: json.RawMessage("5")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(resps) != 5 {
+		t.Fatalf("expected 5 responses, got %d", len(resps))
+	}
+	byID := make(map[string]api.JSONRPCResponse)
+	for _, resp := range resps {
+		byID[string(resp.ID)] = resp
+	}
+	assertError := func(id string, code int) {
+		t.Helper()
+		if resp := byID[id]; resp.Error == nil || resp.Error.Code != code {
+			t.Fatalf("expected error code %d for request %s, got %+v", code, id, resp)
+		}
+	}
+	if resp := byID["1"]; resp.Error != nil || string(resp.Result) != "null" {
+		t.Fatalf("expected block to be accepted, got %+v", resp)
+	} else if resp := byID["2"]; resp.Error != nil || string(resp.Result) != `"`+api.SubmitRejectDuplicate+`"` {
+		t.Fatalf("expected duplicate block to be rejected with a reason, got %+v", resp)
+	} else if cn.Chain.Tip().ID != b.ID() {
+		t.Fatal("expected block to be added")
+	}
+	assertError("3", api.JSONRPCInvalidParams)
+	assertError("4", api.JSONRPCMethodNotFound)
+	assertError("5", api.JSONRPCInvalidRequest)
+}
+
+func TestMineSubmitBlockStaleTip(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+
+	// the genesis block is far older than the synced threshold and the node
+	// has no peers, so only accepting a submitted block makes the tip recent
+	// again
+	cs := cn.Chain.TipState()
+	if time.Since(cs.PrevTimestamps[0]) < 24*time.Hour {
+		t.Fatal("expected a stale tip")
+	} else if len(cn.PeerSyncer.Peers()) != 0 {
+		t.Fatal("expected no peers")
+	}
+	b := types.Block{
+		ParentID:     cs.Index.ID,
+		Timestamp:    types.CurrentTimestamp(),
+		MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+	}
+	if !coreutils.FindBlockNonce(cs, &b, 10*time.Second) {
+		t.Fatal("failed to find nonce")
+	} else if err := c.MiningSubmitBlock(context.Background(), b); err != nil {
+		t.Fatal(err)
+	} else if cn.Chain.Tip().ID != b.ID() {
+		t.Fatal("expected block to become the tip")
+	}
+}
+
+func TestMineSubmitBlockNotSynced(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	peer := testutil.NewConsensusNode(t, network, genesisBlock, log)
+
+	minerAPI := api.NewServer(cn.Chain, cn.PeerSyncer, types.Address{1}, api.WithLogger(log), api.WithRejectUnsyncedBlocks(), api.WithJSONRPC())
+	server := httptest.NewServer(http.StripPrefix("/mining", minerAPI))
+	defer server.Close()
+	c := api.NewClient(server.URL, "")
+
+	solvedBlock := func() types.Block {
+		t.Helper()
+		cs := cn.Chain.TipState()
+		b := types.Block{
+			ParentID:     cs.Index.ID,
+			Timestamp:    types.CurrentTimestamp(),
+			MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+		}
+		if !coreutils.FindBlockNonce(cs, &b, 10*time.Second) {
+			t.Fatal("failed to find nonce")
+		}
+		return b
+	}
+
+	// the genesis block is too old for the node to be synced, so start with
+	// a recent tip
+	if err := cn.Chain.AddBlocks([]types.Block{solvedBlock()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// without peers, the node isn't synced
+	b := solvedBlock()
+	if err := c.MiningSubmitBlock(context.Background(), b); err == nil || !strings.Contains(err.Error(), api.SubmitRejectNotSynced) {
+		t.Fatalf("expected %q, got %v", api.SubmitRejectNotSynced, err)
+	} else if cn.Chain.Tip().ID == b.ID() {
+		t.Fatal("expected block to be rejected")
+	}
+
+	// JSON-RPC reports the reason instead of an error
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	types.V1Block(b).EncodeTo(e)
+	e.Flush()
+	params, _ := json.Marshal([]string{hex.EncodeToString(buf.Bytes())})
+	resp, err := c.MiningRPC(context.Background(), api.JSONRPCRequest{JSONRPC: "2.0", Method: "submitblock", Params: params, ID: json.RawMessage("1")})
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.Error != nil || string(resp.Result) != `"`+api.SubmitRejectNotSynced+`"` {
+		t.Fatalf("expected block to be rejected with a reason, got %+v", resp)
+	}
+
+	// once connected, the block is accepted. The block wasn't marked as
+	// submitted, so it isn't rejected as a duplicate.
+	if _, err := cn.PeerSyncer.Connect(context.Background(), peer.PeerSyncer.Addr()); err != nil {
+		t.Fatal(err)
+	} else if err := c.MiningSubmitBlock(context.Background(), b); err != nil {
+		t.Fatal(err)
+	} else if cn.Chain.Tip().ID != b.ID() {
+		t.Fatal("expected block to be added")
+	}
+
+	stats, err := c.MiningStats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if stats.BlocksSubmitted != 3 || stats.BlocksRejected != 2 || stats.BlocksAccepted != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestMiningBlockIntervals(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+
+	// the genesis block has no parent, so there are no intervals yet
+	resp, err := c.MiningBlockIntervals(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(resp.Intervals) != 0 {
+		t.Fatalf("expected no intervals, got %v", resp.Intervals)
+	} else if resp.TargetInterval != network.BlockInterval {
+		t.Fatalf("expected target interval %v, got %v", network.BlockInterval, resp.TargetInterval)
+	}
+
+	// mine blocks with known timestamps
+	timestamp := cn.Chain.TipState().PrevTimestamps[0]
+	deltas := []time.Duration{10 * time.Minute, 5 * time.Minute, 15 * time.Minute, -time.Minute}
+	for _, d := range deltas {
+		cs := cn.Chain.TipState()
+		timestamp = timestamp.Add(d)
+		b := types.Block{
+			ParentID:     cs.Index.ID,
+			Timestamp:    timestamp,
+			MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+		}
+		if !coreutils.FindBlockNonce(cs, &b, 10*time.Second) {
+			t.Fatal("failed to find nonce")
+		} else if err := cn.Chain.AddBlocks([]types.Block{b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err = c.MiningBlockIntervals(context.Background(), 3)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(resp.Intervals) != 3 {
+		t.Fatalf("expected 3 intervals, got %d", len(resp.Intervals))
+	}
+	for i, bi := range resp.Intervals {
+		index, _ := cn.Chain.BestIndex(uint64(i + 2))
+		if bi.Index != index {
+			t.Fatalf("expected index %v, got %v", index, bi.Index)
+		} else if bi.Interval != deltas[i+1] {
+			t.Fatalf("expected interval %v at height %d, got %v", deltas[i+1], index.Height, bi.Interval)
+		}
+	}
+	if expected := (deltas[1] + deltas[2] + deltas[3]) / 3; resp.AverageInterval != expected {
+		t.Fatalf("expected average %v, got %v", expected, resp.AverageInterval)
+	}
+
+	// more intervals than blocks
+	if resp, err := c.MiningBlockIntervals(context.Background(), 100); err != nil {
+		t.Fatal(err)
+	} else if len(resp.Intervals) != len(deltas) {
+		t.Fatalf("expected %d intervals, got %d", len(deltas), len(resp.Intervals))
+	} else if _, err := c.MiningBlockIntervals(context.Background(), -1); err == nil {
+		t.Fatal("expected negative count to be rejected")
+	}
+}
+
+func TestConsensusNodeSnapshot(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V2Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	startMinerServer(t, cn, log)
+	cn.MineBlocks(t, types.Address{1}, 10)
+
+	path := filepath.Join(t.TempDir(), "chain.snapshot")
+	cn.Snapshot(t, path)
+
+	restored := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	restored.Restore(t, path)
+	c := startMinerServer(t, restored, log)
+	restored.WaitForSync(t)
+	if restored.Chain.Tip() != cn.Chain.Tip() {
+		t.Fatalf("expected tip %v, got %v", cn.Chain.Tip(), restored.Chain.Tip())
+	}
+
+	template, err := c.MiningGetBlockTemplate(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	} else if template.Parent != cn.Chain.Tip() {
+		t.Fatalf("expected template to build on %v, got %v", cn.Chain.Tip(), template.Parent)
+	}
+}
+
+func TestMiningChainWork(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+	cn.MineBlocks(t, types.VoidAddress, 5)
+
+	resp, err := c.MiningChainWork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if cs := cn.Chain.TipState(); resp.Tip != cs.Index || resp.TotalWork != cs.TotalWork {
+		t.Fatalf("expected %v with work %v, got %v with work %v", cs.Index, cs.TotalWork, resp.Tip, resp.TotalWork)
+	}
+
+	info, err := c.MiningInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if info.TotalWork != resp.TotalWork {
+		t.Fatalf("expected info to report work %v, got %v", resp.TotalWork, info.TotalWork)
+	}
+
+	// the total work grows with every block
+	cn.MineBlocks(t, types.VoidAddress, 1)
+	if next, err := c.MiningChainWork(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if next.TotalWork.Cmp(resp.TotalWork) <= 0 {
+		t.Fatalf("expected work to increase from %v, got %v", resp.TotalWork, next.TotalWork)
+	}
+}
+
+func TestMineGetBlockTemplateCompact(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V2Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log, api.WithCoinbaseFlags("/minerd:test/"))
+	txn := addV2PoolTransaction(t, cn, c)
+
+	full, err := c.MiningGetBlockTemplate(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compact, err := c.MiningGetBlockTemplateCompact(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	} else if compact.LongPollID != full.LongPollID || compact.Commitment != full.Commitment {
+		t.Fatal("expected compact template to describe the same block")
+	} else if len(compact.Transactions) != 2 || len(full.Transactions) != 2 {
+		t.Fatalf("expected the marker and the pool transaction, got %d and %d transactions", len(compact.Transactions), len(full.Transactions))
+	}
+	for i, ct := range compact.Transactions {
+		ft := full.Transactions[i]
+		switch {
+		case ct.TxID != ft.TxID:
+			t.Fatalf("expected transaction %d to be %v, got %v", i, ft.TxID, ct.TxID)
+		case ft.Data == "":
+			t.Fatalf("expected full template to include the data of transaction %d", i)
+		case ct.TxID == txn.ID().String() && ct.Data != "":
+			t.Fatal("expected compact template to omit the data of the pool transaction")
+		case ct.TxID != txn.ID().String() && ct.Data != ft.Data:
+			t.Fatal("expected compact template to include the data of the coinbase flags marker")
+		}
+	}
+
+	// the cached template isn't modified by compact requests
+	if again, err := c.MiningGetBlockTemplate(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	} else if again.Transactions[1].Data == "" {
+		t.Fatal("expected full template to include transaction data")
+	}
+}
+
+func TestMineLongPollConcurrentSubmit(t *testing.T) {
+	const longPolls = 16
+
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+
+	template, err := c.MiningGetBlockTemplateBinary(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// block long polls on the current template
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	type result struct {
+		template api.MiningGetBlockTemplateResponse
+		err      error
+	}
+	results := make(chan result, longPolls)
+	for range longPolls {
+		go func() {
+			resp, err := c.MiningGetBlockTemplate(ctx, template.LongPollID)
+			results <- result{resp, err}
+		}()
+	}
+
+	// request fresh templates while the block is submitted
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := c.MiningGetBlockTemplate(ctx, ""); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	b := template.Block
+	if !coreutils.FindBlockNonce(cn.Chain.TipState(), &b, 10*time.Second) {
+		t.Fatal("failed to find nonce")
+	} else if err := c.MiningSubmitBlock(context.Background(), b); err != nil {
+		t.Fatal(err)
+	}
+	tip := types.ChainIndex{Height: template.Height, ID: b.ID()}
+
+	// every template served after the submission builds on the new block
+	if resp, err := c.MiningGetBlockTemplate(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	} else if resp.Parent != tip {
+		t.Fatalf("expected template to build on %v, got %v", tip, resp.Parent)
+	}
+	close(stop)
+	wg.Wait()
+
+	// all long polls return the new template promptly
+	for range longPolls {
+		select {
+		case res := <-results:
+			if res.err != nil {
+				t.Fatal(res.err)
+			} else if res.template.Parent != tip {
+				t.Fatalf("expected long poll to return a template building on %v, got %v", tip, res.template.Parent)
+			} else if res.template.LongPollID == template.LongPollID {
+				t.Fatal("expected long poll to return a new template")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("long poll didn't return after the block was submitted")
+		}
+	}
+}
+
+// addV2PoolTransaction funds a new wallet by mining blocks to it and adds a v2
+// transaction spending from it to the pool. The chain must be at height 0.
+func addV2PoolTransaction(tb testing.TB, cn *testutil.ConsensusNode, c *api.Client) types.V2Transaction {
+	tb.Helper()
+
+	basis, txn := signedV2Transaction(tb, cn, c)
+	if _, err := cn.Chain.AddV2PoolTransactions(basis, []types.V2Transaction{txn}); err != nil {
+		tb.Fatal(err)
+	}
+	return txn
+}
+
+// signedV2Transaction funds a new wallet by mining blocks and returns a signed
+// v2 transaction spending from it, without adding it to the pool.
+func signedV2Transaction(tb testing.TB, cn *testutil.ConsensusNode, c *api.Client) (types.ChainIndex, types.V2Transaction) {
+	tb.Helper()
+
+	network := cn.Chain.TipState().Network
+	w, err := c.AddWallet(walletdAPI.WalletUpdateRequest{Name: "pool"})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	key := types.GeneratePrivateKey()
+	uc := types.StandardUnlockConditions(key.PublicKey())
+	err = c.Wallet(w.ID).AddAddress(wallet.Address{
+		Address: uc.UnlockHash(),
+		SpendPolicy: &types.SpendPolicy{
+			Type: types.PolicyTypeUnlockConditions(uc),
+		},
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	cn.MineBlocks(tb, uc.UnlockHash(), int(network.MaturityDelay)+int(network.HardforkV2.AllowHeight))
+
+	resp, err := c.Wallet(w.ID).ConstructV2([]types.SiacoinOutput{
+		{Address: uc.UnlockHash(), Value: types.Siacoins(100)},
+	}, nil, uc.UnlockHash())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	txn := resp.Transaction
+	sigHash := cn.Chain.TipState().InputSigHash(txn)
+	for i := range txn.SiacoinInputs {
+		txn.SiacoinInputs[i].SatisfiedPolicy.Signatures = []types.Signature{key.SignHash(sigHash)}
+	}
+	return resp.Basis, txn
+}
+
+func TestMineGetBlockTemplateEmptyBlocks(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V2Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log, api.WithEmptyBlocks(), api.WithCoinbaseFlags(""))
+	addV2PoolTransaction(t, cn, c)
+
+	template, err := c.MiningGetBlockTemplateBinary(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := template.Block
+	cs := cn.Chain.TipState()
+	if len(b.Transactions) != 0 || len(b.V2Transactions()) != 0 {
+		t.Fatalf("expected empty block, got %d v1 and %d v2 transactions", len(b.Transactions), len(b.V2Transactions()))
+	} else if !b.MinerPayouts[0].Value.Equals(cs.BlockReward()) {
+		t.Fatalf("expected payout %v, got %v", cs.BlockReward(), b.MinerPayouts[0].Value)
+	}
+
+	// the empty block is valid
+	if !coreutils.FindBlockNonce(cs, &b, 10*time.Second) {
+		t.Fatal("failed to find nonce")
+	} else if err := c.MiningSubmitBlock(context.Background(), b); err != nil {
+		t.Fatal(err)
+	} else if len(cn.Chain.V2PoolTransactions()) != 1 {
+		t.Fatal("expected pool transaction to remain in the pool")
+	}
+}
+
+func TestMineGetBlockTemplateMaxTxns(t *testing.T) {
+	network, genesisBlock := testutil.V2Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, zaptest.NewLogger(t))
+	core, logs := observer.New(zap.InfoLevel)
+	c := startMinerServer(t, cn, zap.New(core), api.WithMaxTemplateTxns(1), api.WithoutTemplateCache())
+
+	// sign both transactions before adding them, as funding the second
+	// wallet mines blocks that would confirm the first transaction
+	basis1, txn1 := signedV2Transaction(t, cn, c)
+	basis2, txn2 := signedV2Transaction(t, cn, c)
+	if _, err := cn.Chain.AddV2PoolTransactions(basis1, []types.V2Transaction{txn1}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cn.Chain.AddV2PoolTransactions(basis2, []types.V2Transaction{txn2}); err != nil {
+		t.Fatal(err)
+	} else if n := len(cn.Chain.V2PoolTransactions()); n != 2 {
+		t.Fatalf("expected 2 pool transactions, got %d", n)
+	}
+
+	template, err := c.MiningGetBlockTemplateBinary(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	} else if n := len(template.Block.V2Transactions()); n != 1 {
+		t.Fatalf("expected 1 transaction, got %d", n)
+	}
+
+	entries := logs.FilterMessage("template transaction limit reached").All()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 log entry, got %d", len(entries))
+	} else if fields := entries[0].ContextMap(); fields["transactions"] != int64(1) || fields["limit"] != int64(1) {
+		t.Fatalf("unexpected fields %v", fields)
+	}
+}
+
+func TestMiningReorgLogging(t *testing.T) {
+	for _, minDepth := range []uint64{1, 2} {
+		network, genesisBlock := testutil.V1Network()
+		cn := testutil.NewConsensusNode(t, network, genesisBlock, zaptest.NewLogger(t))
+		core, logs := observer.New(zap.DebugLevel)
+		startMinerServer(t, cn, zap.New(core), api.WithReorgLogMinDepth(minDepth))
+
+		// build a competing chain on a separate chain manager
+		store, tipState, err := chain.NewDBStore(chain.NewMemDB(), network, genesisBlock, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fork := chain.NewManager(store, tipState)
+		var forkBlocks []types.Block
+		for range 2 {
+			b, ok := coreutils.MineBlock(fork, frand.Entropy256(), 10*time.Second)
+			if !ok {
+				t.Fatal("failed to mine block")
+			} else if err := fork.AddBlocks([]types.Block{b}); err != nil {
+				t.Fatal(err)
+			}
+			forkBlocks = append(forkBlocks, b)
+		}
+
+		// extending the tip is logged at debug level
+		genesis := cn.Chain.Tip()
+		cn.MineBlocks(t, types.VoidAddress, 1)
+		oldTip := cn.Chain.Tip()
+		if entries := logs.FilterMessage("new tip").All(); len(entries) != 1 || entries[0].Level != zap.DebugLevel {
+			t.Fatalf("expected 1 debug entry for the new tip, got %+v", entries)
+		} else if fields := entries[0].ContextMap(); fields["oldTip"] != genesis.String() || fields["newTip"] != oldTip.String() {
+			t.Fatalf("unexpected fields %v", fields)
+		}
+
+		// the reorg reverts 1 block, which is logged at info level unless
+		// the min depth is higher
+		if err := cn.Chain.AddBlocks(forkBlocks); err != nil {
+			t.Fatal(err)
+		}
+		expectedLevel := zap.InfoLevel
+		if minDepth > 1 {
+			expectedLevel = zap.DebugLevel
+		}
+		entries := logs.FilterMessage("reorg").All()
+		if len(entries) != 1 || entries[0].Level != expectedLevel {
+			t.Fatalf("expected 1 %v entry for the reorg, got %+v", expectedLevel, entries)
+		}
+		fields := entries[0].ContextMap()
+		switch {
+		case fields["oldTip"] != oldTip.String() || fields["newTip"] != fork.Tip().String():
+			t.Fatalf("expected reorg from %v to %v, got %v", oldTip, fork.Tip(), fields)
+		case fields["reverted"] != int64(1) || fields["applied"] != int64(2):
+			t.Fatalf("expected 1 reverted and 2 applied blocks, got %v", fields)
+		}
+	}
+}
+
+func TestMineGetBlockClient(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	test := func(t *testing.T, network *consensus.Network, genesisBlock types.Block) {
+		cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+		c := startMinerServer(t, cn, log)
+		if network.HardforkV2.AllowHeight <= 1 {
+			addV2PoolTransaction(t, cn, c)
+		} else {
+			cn.MineBlocks(t, types.VoidAddress, 1)
+		}
+
+		b, target, err := c.MiningGetBlock(context.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the block must match the unsolved block of the binary template
+		template, err := c.MiningGetBlockTemplateBinary(context.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		} else if b.ID() != template.Block.ID() {
+			t.Fatalf("expected block %v, got %v", template.Block.ID(), b.ID())
+		} else if target != template.Target {
+			t.Fatalf("expected target %v, got %v", template.Target, target)
+		} else if network.HardforkV2.AllowHeight <= 1 && len(b.V2Transactions()) != 1 {
+			t.Fatalf("expected 1 v2 transaction, got %d", len(b.V2Transactions()))
+		}
+
+		if !coreutils.FindBlockNonce(cn.Chain.TipState(), &b, 10*time.Second) {
+			t.Fatal("failed to find nonce")
+		} else if err := c.MiningSubmitBlock(context.Background(), b); err != nil {
+			t.Fatal(err)
+		} else if tip := cn.Chain.Tip(); tip.ID != b.ID() {
+			t.Fatalf("expected tip %v, got %v", b.ID(), tip.ID)
+		}
+	}
+
+	t.Run("v1", func(t *testing.T) {
+		network, genesisBlock := testutil.V1Network()
+		test(t, network, genesisBlock)
+	})
+
+	t.Run("v2", func(t *testing.T) {
+		network, genesisBlock := testutil.V2Network()
+		test(t, network, genesisBlock)
+	})
+}
+
+func TestMiningNetwork(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V2Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+
+	for _, public := range []bool{false, true} {
+		minerAPI := api.NewServer(cn.Chain, cn.Syncer, types.Address{1}, api.WithLogger(log), api.WithBasicAuth("foo"), api.WithPublicEndpoints(public))
+		server := httptest.NewServer(http.StripPrefix("/mining", minerAPI))
+		defer server.Close()
+
+		resp, err := api.NewClient(server.URL, "foo").Network(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		} else if resp.Name != network.Name {
+			t.Fatalf("expected network %q, got %q", network.Name, resp.Name)
+		} else if resp.GenesisID != genesisBlock.ID() {
+			t.Fatalf("expected genesis block %v, got %v", genesisBlock.ID(), resp.GenesisID)
+		} else if resp.Hardforks.Oak != network.HardforkOak.Height || resp.Hardforks.V2Allow != network.HardforkV2.AllowHeight || resp.Hardforks.V2Require != network.HardforkV2.RequireHeight {
+			t.Fatalf("unexpected hardfork heights %+v", resp.Hardforks)
+		}
+
+		// the endpoint only skips authentication if public endpoints are
+		// enabled
+		_, err = api.NewClient(server.URL, "bar").Network(context.Background())
+		if public && err != nil {
+			t.Fatal(err)
+		} else if !public && err == nil {
+			t.Fatal("expected wrong password to be rejected")
+		}
+		if _, err := api.NewClient(server.URL, "bar").MiningChainWork(context.Background()); err == nil {
+			t.Fatal("expected wrong password to be rejected by a private endpoint")
+		}
+	}
+}
+
+func TestMinePinTransaction(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V2Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	c := startMinerServer(t, cn, log)
+
+	// both transactions are signed before adding them to the pool since
+	// funding the second wallet mines blocks
+	firstBasis, first := signedV2Transaction(t, cn, c)
+	secondBasis, second := signedV2Transaction(t, cn, c)
+	if _, err := cn.Chain.AddV2PoolTransactions(firstBasis, []types.V2Transaction{first}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cn.Chain.AddV2PoolTransactions(secondBasis, []types.V2Transaction{second}); err != nil {
+		t.Fatal(err)
+	}
+
+	// returns the IDs of the template's transactions
+	templateTxns := func() []types.TransactionID {
+		t.Helper()
+		template, err := c.MiningGetBlockTemplateBinary(context.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []types.TransactionID
+		for _, txn := range template.Block.V2Transactions() {
+			ids = append(ids, txn.ID())
+		}
+		return ids
+	}
+	if ids := templateTxns(); len(ids) != 2 || ids[0] != first.ID() || ids[1] != second.ID() {
+		t.Fatalf("expected transactions in pool order, got %v", ids)
+	}
+
+	// pinning the second transaction moves it to the front
+	if err := c.MiningPinTransaction(context.Background(), second.ID()); err != nil {
+		t.Fatal(err)
+	} else if ids := templateTxns(); len(ids) != 2 || ids[0] != second.ID() || ids[1] != first.ID() {
+		t.Fatalf("expected pinned transaction first, got %v", ids)
+	}
+
+	// unpinning restores the pool order
+	if err := c.MiningUnpinTransaction(context.Background(), second.ID()); err != nil {
+		t.Fatal(err)
+	} else if ids := templateTxns(); len(ids) != 2 || ids[0] != first.ID() {
+		t.Fatalf("expected transactions in pool order, got %v", ids)
+	}
+
+	// transactions outside the pool can't be pinned by ID
+	basis, raw := signedV2Transaction(t, cn, c)
+	if err := c.MiningPinTransaction(context.Background(), raw.ID()); err == nil {
+		t.Fatal("expected pinning a transaction outside the pool to fail")
+	}
+
+	// raw transactions are added to the pool and pinned
+	resp, err := c.MiningPinV2Transaction(context.Background(), basis, raw)
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.ID != raw.ID() {
+		t.Fatalf("expected pinned ID %v, got %v", raw.ID(), resp.ID)
+	} else if ids := templateTxns(); len(ids) == 0 || ids[0] != raw.ID() {
+		t.Fatalf("expected raw transaction first, got %v", ids)
+	}
+
+	// invalid raw transactions are rejected
+	invalid := raw
+	invalid.MinerFee = invalid.MinerFee.Add(types.Siacoins(1))
+	if _, err := c.MiningPinV2Transaction(context.Background(), basis, invalid); err == nil {
+		t.Fatal("expected invalid transaction to be rejected")
+	}
+}
+
+func TestMineSubmitBlockRelayPeers(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V2Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	relay := testutil.NewConsensusNode(t, network, genesisBlock, log)
+
+	// v2 blocks are only relayed after the allow height, which both nodes
+	// have to reach without connecting to each other
+	for range network.HardforkV2.AllowHeight {
+		b, ok := coreutils.MineBlock(cn.Chain, types.VoidAddress, 10*time.Second)
+		if !ok {
+			t.Fatal("failed to mine block")
+		} else if err := cn.Chain.AddBlocks([]types.Block{b}); err != nil {
+			t.Fatal(err)
+		} else if err := relay.Chain.AddBlocks([]types.Block{b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	core, logs := observer.New(zap.InfoLevel)
+	minerAPI := api.NewServer(cn.Chain, cn.PeerSyncer, types.Address{1}, api.WithLogger(zap.New(core)), api.WithRelayPeers([]string{relay.PeerSyncer.Addr()}))
+	server := httptest.NewServer(http.StripPrefix("/mining", minerAPI))
+	defer server.Close()
+	c := api.NewClient(server.URL, "")
+
+	template, err := c.MiningGetBlockTemplateBinary(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := template.Block
+	// the node has no connected peers, so the broadcast fails, but the block
+	// is still relayed
+	if !coreutils.FindBlockNonce(cn.Chain.TipState(), &b, 10*time.Second) {
+		t.Fatal("failed to find nonce")
+	} else if err := c.MiningSubmitBlock(context.Background(), b); err == nil || !strings.Contains(err.Error(), "no peers available") {
+		t.Fatalf("expected broadcast to fail without peers, got %v", err)
+	}
+
+	// the node isn't connected to the relay peer, so it has to connect to
+	// it before sending the block
+	for i := 0; i < 100 && relay.Chain.Tip().ID != b.ID(); i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if tip := relay.Chain.Tip(); tip.ID != b.ID() {
+		t.Fatalf("expected relay peer tip %v, got %v", b.ID(), tip)
+	}
+	for i := 0; i < 100 && logs.FilterMessage("relayed block to peer").Len() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if entries := logs.FilterMessage("relayed block to peer").All(); len(entries) != 1 {
+		t.Fatalf("expected 1 relay log entry, got %d", len(entries))
+	} else if fields := entries[0].ContextMap(); fields["peer"] != relay.PeerSyncer.Addr() || fields["block"] != b.ID().String() {
+		t.Fatalf("unexpected fields %v", fields)
+	}
+}
+
+func TestMineSubmitBlockRelayPeerConnected(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V2Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	relay := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	for range network.HardforkV2.AllowHeight {
+		b, ok := coreutils.MineBlock(cn.Chain, types.VoidAddress, 10*time.Second)
+		if !ok {
+			t.Fatal("failed to mine block")
+		} else if err := cn.Chain.AddBlocks([]types.Block{b}); err != nil {
+			t.Fatal(err)
+		} else if err := relay.Chain.AddBlocks([]types.Block{b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the relay peer is configured by hostname and connects to the node
+	// itself, so neither the configured address nor the address it is
+	// connected from match
+	_, nodePort, err := net.SplitHostPort(cn.PeerSyncer.Addr())
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := relay.PeerSyncer.Connect(context.Background(), net.JoinHostPort("127.0.0.1", nodePort)); err != nil {
+		t.Fatal(err)
+	}
+	_, relayPort, err := net.SplitHostPort(relay.PeerSyncer.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	relayAddr := net.JoinHostPort("localhost", relayPort)
+	for i := 0; i < 100 && len(cn.PeerSyncer.Peers()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if peers := cn.PeerSyncer.Peers(); len(peers) != 1 || !peers[0].Inbound {
+		t.Fatalf("expected an inbound peer, got %v", peers)
+	}
+
+	core, logs := observer.New(zap.InfoLevel)
+	minerAPI := api.NewServer(cn.Chain, cn.PeerSyncer, types.Address{1}, api.WithLogger(zap.New(core)), api.WithRelayPeers([]string{relayAddr}))
+	server := httptest.NewServer(http.StripPrefix("/mining", minerAPI))
+	defer server.Close()
+	c := api.NewClient(server.URL, "")
+
+	template, err := c.MiningGetBlockTemplateBinary(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := template.Block
+	if !coreutils.FindBlockNonce(cn.Chain.TipState(), &b, 10*time.Second) {
+		t.Fatal("failed to find nonce")
+	} else if err := c.MiningSubmitBlock(context.Background(), b); err != nil {
+		t.Fatal(err)
+	}
+
+	// the block is sent over the existing connection
+	for i := 0; i < 100 && logs.FilterMessage("relayed block to peer").Len() == 0 && logs.FilterMessage("failed to connect to relay peer").Len() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if entries := logs.FilterMessage("failed to connect to relay peer").All(); len(entries) != 0 {
+		t.Fatalf("expected the connected relay peer to be found, got %v", entries[0].ContextMap())
+	} else if entries := logs.FilterMessage("relayed block to peer").All(); len(entries) != 1 {
+		t.Fatalf("expected 1 relay log entry, got %d", len(entries))
+	} else if fields := entries[0].ContextMap(); fields["peer"] != relayAddr {
+		t.Fatalf("unexpected fields %v", fields)
+	} else if peers := cn.PeerSyncer.Peers(); len(peers) != 1 {
+		t.Fatalf("expected no new connection, got %v", peers)
+	}
+}
+
+func TestMiningPeerInfo(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	peer := testutil.NewConsensusNode(t, network, genesisBlock, log)
+
+	minerAPI := api.NewServer(cn.Chain, cn.PeerSyncer, types.Address{1}, api.WithLogger(log), api.WithBasicAuth("password"))
+	server := httptest.NewServer(http.StripPrefix("/mining", minerAPI))
+	defer server.Close()
+
+	if _, err := api.NewClient(server.URL, "wrong").MiningPeerInfo(context.Background()); err == nil {
+		t.Fatal("expected unauthenticated request to fail")
+	}
+
+	c := api.NewClient(server.URL, "password")
+	peers, err := c.MiningPeerInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if len(peers) != 0 {
+		t.Fatalf("expected no peers, got %v", peers)
+	}
+
+	before := time.Now()
+	if _, err := cn.PeerSyncer.Connect(context.Background(), peer.PeerSyncer.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	peers, err = c.MiningPeerInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if len(peers) != 1 {
+		t.Fatalf("expected 1 peer, got %v", peers)
+	}
+	p := peers[0]
+	_, port, _ := net.SplitHostPort(peer.PeerSyncer.Addr())
+	if _, connPort, _ := net.SplitHostPort(p.Address); connPort != port {
+		t.Fatalf("expected port %q, got address %q", port, p.Address)
+	} else if p.Inbound {
+		t.Fatal("expected outbound peer")
+	} else if p.Version == "" {
+		t.Fatal("expected peer version")
+	} else if p.ConnectedSince.Before(before.Add(-time.Second)) || p.ConnectedSince.After(time.Now()) {
+		t.Fatalf("unexpected connection time %v", p.ConnectedSince)
+	}
+}
+
+func TestMineSubmitBlockExtraParams(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	minerAPI := api.NewServer(cn.Chain, cn.Syncer, types.Address{1}, api.WithLogger(log), api.WithJSONRPC())
+	server := httptest.NewServer(http.StripPrefix("/mining", minerAPI))
+	defer server.Close()
+	c := api.NewClient(server.URL, "")
+
+	mineBlock := func() string {
+		t.Helper()
+		cs := cn.Chain.TipState()
+		b := types.Block{
+			ParentID:     cs.Index.ID,
+			Timestamp:    types.CurrentTimestamp(),
+			MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+		}
+		if !coreutils.FindBlockNonce(cs, &b, 10*time.Second) {
+			t.Fatal("failed to find nonce")
+		}
+		var buf bytes.Buffer
+		e := types.NewEncoder(&buf)
+		types.V1Block(b).EncodeTo(e)
+		e.Flush()
+		return hex.EncodeToString(buf.Bytes())
+	}
+
+	// the BIP 22 parameters object and a target after the block are ignored
+	body := fmt.Sprintf(`{"params": [%q, {"workid": "foo"}, "00000000ffff0000000000000000000000000000000000000000000000000000"]}`, mineBlock())
+	resp, err := http.Post(server.URL+"/mining/submitblock", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected block to be accepted, got status %v", resp.Status)
+	} else if tip := cn.Chain.Tip(); tip.Height != 1 {
+		t.Fatalf("expected tip height 1, got %v", tip)
+	}
+
+	rpcResp, err := c.MiningRPC(context.Background(), api.JSONRPCRequest{
+		JSONRPC: "2.0",
+		Method:  "submitblock",
+		Params:  json.RawMessage(fmt.Sprintf(`[%q, {"workid": "foo"}]`, mineBlock())),
+		ID:      json.RawMessage("1"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if rpcResp.Error != nil || string(rpcResp.Result) != "null" {
+		t.Fatalf("expected block to be accepted, got %+v", rpcResp)
+	} else if tip := cn.Chain.Tip(); tip.Height != 2 {
+		t.Fatalf("expected tip height 2, got %v", tip)
+	}
+
+	// the block itself must still be a string
+	resp, err = http.Post(server.URL+"/mining/submitblock", "application/json", strings.NewReader(`{"params": [{"workid": "foo"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %v", resp.Status)
+	}
+}
@@ -4,9 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"time"
 
+	"go.sia.tech/core/blake2b"
 	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
 	"go.sia.tech/jape"
 	"go.sia.tech/walletd/v2/api"
 )
@@ -25,8 +32,403 @@ func (c *Client) MiningGetBlockTemplate(ctx context.Context, longPollID string)
 	return
 }
 
+// MiningGetBlockTemplateCompact returns a block template without the data of
+// transactions taken from the node's pool. Only their IDs are included.
+func (c *Client) MiningGetBlockTemplateCompact(ctx context.Context, longPollID string) (resp MiningGetBlockTemplateResponse, err error) {
+	err = c.c.POST(ctx, "/mining/getblocktemplate", MiningGetBlockTemplateRequest{
+		LongPollID: longPollID,
+		Compact:    true,
+	}, &resp)
+	return
+}
+
+// MiningGetBlockTemplateMaxAge returns a block template for mining. Long
+// polling requests return a refreshed template once the current one is older
+// than maxAge, instead of waiting for the server's max template age.
+func (c *Client) MiningGetBlockTemplateMaxAge(ctx context.Context, longPollID string, maxAge time.Duration) (resp MiningGetBlockTemplateResponse, err error) {
+	err = c.c.POST(ctx, "/mining/getblocktemplate", MiningGetBlockTemplateRequest{
+		LongPollID: longPollID,
+		MaxAge:     maxAge,
+	}, &resp)
+	return
+}
+
+// MiningGetBlockTemplateBinary returns a block template for mining using the
+// binary template encoding. The template includes the full unsolved block, so
+// it does not need to be assembled from the hex encoded transactions.
+func (c *Client) MiningGetBlockTemplateBinary(ctx context.Context, longPollID string) (resp MiningBlockTemplate, err error) {
+	js, err := json.Marshal(MiningGetBlockTemplateRequest{
+		LongPollID: longPollID,
+	})
+	if err != nil {
+		return MiningBlockTemplate{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%v/mining/getblocktemplate", c.c.BaseURL), bytes.NewReader(js))
+	if err != nil {
+		return MiningBlockTemplate{}, err
+	}
+	req.Header.Set("Accept", binaryContentType)
+	if c.c.Password != "" {
+		req.SetBasicAuth("", c.c.Password)
+	}
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return MiningBlockTemplate{}, err
+	}
+	defer io.Copy(io.Discard, r.Body)
+	defer r.Body.Close()
+	if !(200 <= r.StatusCode && r.StatusCode < 300) {
+		msg, _ := io.ReadAll(io.LimitReader(r.Body, 1024))
+		return MiningBlockTemplate{}, errors.New(string(bytes.TrimSpace(msg)))
+	}
+	d := types.NewDecoder(io.LimitedReader{R: r.Body, N: maxBinaryTemplateSize})
+	resp.DecodeFrom(d)
+	if err := d.Err(); err != nil {
+		return MiningBlockTemplate{}, fmt.Errorf("failed to decode template: %w", err)
+	}
+	return resp, nil
+}
+
+// MiningGetBlock returns the unsolved block of a block template and its
+// target. The block is assembled from the template's hex encoded miner payout
+// and transactions and its commitment is checked against the template's, so
+// it is ready to be mined by searching for a nonce.
+func (c *Client) MiningGetBlock(ctx context.Context, longPollID string) (types.Block, types.BlockID, error) {
+	resp, err := c.MiningGetBlockTemplate(ctx, longPollID)
+	if err != nil {
+		return types.Block{}, types.BlockID{}, err
+	}
+	return decodeTemplateBlock(resp)
+}
+
+// MiningRPC sends a JSON-RPC 2.0 request to /mining/rpc. The request must
+// have an ID since notifications don't receive a response.
+func (c *Client) MiningRPC(ctx context.Context, req JSONRPCRequest) (resp JSONRPCResponse, err error) {
+	err = c.c.POST(ctx, "/mining/rpc", req, &resp)
+	return
+}
+
+// MiningRPCBatch sends a batch of JSON-RPC 2.0 requests to /mining/rpc.
+// Responses are returned in the order they were sent by the node, which
+// doesn't have to match the order of the requests.
+func (c *Client) MiningRPCBatch(ctx context.Context, reqs []JSONRPCRequest) (resp []JSONRPCResponse, err error) {
+	err = c.c.POST(ctx, "/mining/rpc", reqs, &resp)
+	return
+}
+
+// MiningProposeBlock validates a block against the current tip without adding
+// it to the chain or checking its proof of work. An empty reason is returned if
+// the block would be accepted.
+func (c *Client) MiningProposeBlock(ctx context.Context, b types.Block) (reason string, err error) {
+	data, err := encodeBlock(b)
+	if err != nil {
+		return "", err
+	}
+	var resp *string
+	err = c.c.POST(ctx, "/mining/getblocktemplate", MiningGetBlockTemplateRequest{
+		Mode: "proposal",
+		Data: data,
+	}, &resp)
+	if resp != nil {
+		reason = *resp
+	}
+	return
+}
+
 // MiningSubmitBlock submits a mined block to the network.
 func (c *Client) MiningSubmitBlock(ctx context.Context, b types.Block) error {
+	data, err := encodeBlock(b)
+	if err != nil {
+		return err
+	}
+	return c.c.POST(ctx, "/mining/submitblock", MiningSubmitBlockRequest{
+		Params: []string{data},
+	}, nil)
+}
+
+// MiningGetBlockByHeight returns the block at the given height of the best
+// chain.
+func (c *Client) MiningGetBlockByHeight(ctx context.Context, height uint64) (types.Block, error) {
+	var resp MiningGetBlockResponse
+	err := c.c.POST(ctx, "/mining/getblock", MiningGetBlockRequest{Height: &height}, &resp)
+	return resp.Block, err
+}
+
+// MiningDifficulty returns the difficulty of the last count blocks of the best
+// chain, newest first.
+func (c *Client) MiningDifficulty(ctx context.Context, count uint64) (resp []MiningDifficultyEntry, err error) {
+	err = c.c.POST(ctx, "/mining/difficulty", MiningDifficultyRequest{Count: count}, &resp)
+	return
+}
+
+// MiningGetBlockByID returns the block with the given ID.
+func (c *Client) MiningGetBlockByID(ctx context.Context, id types.BlockID) (types.Block, error) {
+	var resp MiningGetBlockResponse
+	err := c.c.POST(ctx, "/mining/getblock", MiningGetBlockRequest{ID: &id}, &resp)
+	return resp.Block, err
+}
+
+// MiningBlockStatus returns whether the node has the block with the given ID
+// and whether it is part of the best chain or orphaned.
+func (c *Client) MiningBlockStatus(ctx context.Context, id types.BlockID) (resp MiningBlockStatusResponse, err error) {
+	err = c.c.POST(ctx, "/mining/blockstatus", MiningBlockStatusRequest{ID: id}, &resp)
+	return
+}
+
+// MiningExcludeTransaction excludes a transaction and its descendants from
+// future block templates.
+func (c *Client) MiningExcludeTransaction(ctx context.Context, id types.TransactionID) error {
+	return c.c.POST(ctx, "/mining/excludetxn", MiningExcludeTransactionRequest{ID: id}, nil)
+}
+
+// MiningIncludeTransaction removes a transaction from the set of excluded
+// transactions.
+func (c *Client) MiningIncludeTransaction(ctx context.Context, id types.TransactionID) error {
+	return c.c.POST(ctx, "/mining/includetxn", MiningIncludeTransactionRequest{ID: id}, nil)
+}
+
+// MiningPinTransaction pins a pool transaction, so it and its ancestors are
+// selected before all other transactions in future block templates.
+func (c *Client) MiningPinTransaction(ctx context.Context, id types.TransactionID) error {
+	return c.c.POST(ctx, "/mining/pintxn", MiningPinTransactionRequest{ID: &id}, nil)
+}
+
+// MiningPinV1Transaction adds a v1 transaction to the pool and pins it.
+func (c *Client) MiningPinV1Transaction(ctx context.Context, txn types.Transaction) (resp MiningPinTransactionResponse, err error) {
+	err = c.c.POST(ctx, "/mining/pintxn", MiningPinTransactionRequest{Transaction: &txn}, &resp)
+	return
+}
+
+// MiningPinV2Transaction adds a v2 transaction that is valid at basis to the
+// pool and pins it.
+func (c *Client) MiningPinV2Transaction(ctx context.Context, basis types.ChainIndex, txn types.V2Transaction) (resp MiningPinTransactionResponse, err error) {
+	err = c.c.POST(ctx, "/mining/pintxn", MiningPinTransactionRequest{V2Transaction: &txn, Basis: basis}, &resp)
+	return
+}
+
+// MiningUnpinTransaction removes a transaction from the set of pinned
+// transactions.
+func (c *Client) MiningUnpinTransaction(ctx context.Context, id types.TransactionID) error {
+	return c.c.POST(ctx, "/mining/unpintxn", MiningUnpinTransactionRequest{ID: id}, nil)
+}
+
+// MiningPause pauses CPU miners started with the mine command.
+func (c *Client) MiningPause(ctx context.Context) (resp MiningMinerStatusResponse, err error) {
+	err = c.c.POST(ctx, "/mining/pause", nil, &resp)
+	return
+}
+
+// MiningResume resumes paused CPU miners.
+func (c *Client) MiningResume(ctx context.Context) (resp MiningMinerStatusResponse, err error) {
+	err = c.c.POST(ctx, "/mining/resume", nil, &resp)
+	return
+}
+
+// MiningMinerStatus returns whether CPU miners are paused or running.
+func (c *Client) MiningMinerStatus(ctx context.Context) (resp MiningMinerStatusResponse, err error) {
+	err = c.c.GET(ctx, "/mining/minerstatus", &resp)
+	return
+}
+
+// MiningWaitForTip blocks until the node's tip differs from the given tip and
+// returns the new tip. If the tip doesn't change within a timeout, the
+// unchanged tip is returned.
+func (c *Client) MiningWaitForTip(ctx context.Context, tip types.ChainIndex) (resp types.ChainIndex, err error) {
+	err = c.c.POST(ctx, "/mining/waitfortip", MiningWaitForTipRequest{Tip: tip}, &resp)
+	return
+}
+
+// MiningUpdates blocks until the node's tip differs from index and returns at
+// most limit chain updates since index. If the tip doesn't change within a
+// timeout, no updates are returned. If index is unknown or was pruned, an
+// error is returned and the client has to resync from a more recent index.
+func (c *Client) MiningUpdates(ctx context.Context, index types.ChainIndex, limit int) ([]chain.RevertUpdate, []chain.ApplyUpdate, error) {
+	var resp ConsensusUpdatesResponse
+	if err := c.c.POST(ctx, "/mining/updates", MiningUpdatesRequest{Index: index, Limit: limit}, &resp); err != nil {
+		return nil, nil, err
+	}
+
+	network, err := c.ConsensusNetwork()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get network: %w", err)
+	}
+	reverted := make([]chain.RevertUpdate, 0, len(resp.Reverted))
+	for _, u := range resp.Reverted {
+		u.State.Network = network
+		reverted = append(reverted, chain.RevertUpdate{RevertUpdate: u.Update, State: u.State, Block: u.Block})
+	}
+	applied := make([]chain.ApplyUpdate, 0, len(resp.Applied))
+	for _, u := range resp.Applied {
+		u.State.Network = network
+		applied = append(applied, chain.ApplyUpdate{ApplyUpdate: u.Update, State: u.State, Block: u.Block})
+	}
+	return reverted, applied, nil
+}
+
+// SubscribeTips returns a channel that receives the node's tip whenever it
+// changes, starting with the current tip. Failed requests are retried with
+// exponential backoff. The channel is closed once ctx is canceled.
+func (c *Client) SubscribeTips(ctx context.Context) <-chan types.ChainIndex {
+	const (
+		minBackoff = time.Second
+		maxBackoff = time.Minute
+	)
+
+	ch := make(chan types.ChainIndex)
+	go func() {
+		defer close(ch)
+
+		var current types.ChainIndex
+		backoff := minBackoff
+		for {
+			tip, err := c.MiningWaitForTip(ctx, current)
+			if ctx.Err() != nil {
+				return
+			} else if err != nil {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				backoff = min(2*backoff, maxBackoff)
+				continue
+			}
+			backoff = minBackoff
+			if tip == current {
+				continue
+			}
+			current = tip
+			select {
+			case ch <- tip:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// MiningGetWork returns a block header to mine using the legacy getwork
+// protocol. Only v1 blocks are supported.
+func (c *Client) MiningGetWork(ctx context.Context) (resp MiningGetWorkResponse, err error) {
+	err = c.c.POST(ctx, "/mining/getwork", MiningGetWorkRequest{}, &resp)
+	return
+}
+
+// MiningSubmitWork submits a header solved using the legacy getwork protocol.
+// It returns true if the resulting block was accepted.
+func (c *Client) MiningSubmitWork(ctx context.Context, h types.BlockHeader) (accepted bool, err error) {
+	err = c.c.POST(ctx, "/mining/getwork", MiningGetWorkRequest{Params: []string{encodeHeader(h)}}, &accepted)
+	return
+}
+
+// MiningAllocateRange allocates a nonce range for the template with the given
+// long poll ID that doesn't overlap with ranges allocated to other workers.
+func (c *Client) MiningAllocateRange(ctx context.Context, longPollID string) (resp MiningAllocateRangeResponse, err error) {
+	err = c.c.POST(ctx, "/mining/allocaterange", MiningAllocateRangeRequest{LongPollID: longPollID}, &resp)
+	return
+}
+
+// MiningWillInclude returns whether the transaction would be included in the
+// next block template.
+func (c *Client) MiningWillInclude(ctx context.Context, id types.TransactionID) (resp MiningWillIncludeResponse, err error) {
+	err = c.c.POST(ctx, "/mining/willinclude", MiningWillIncludeRequest{ID: id}, &resp)
+	return
+}
+
+// MiningPayoutAddress returns the address the node's block templates pay
+// out to.
+func (c *Client) MiningPayoutAddress(ctx context.Context) (resp MiningPayoutAddressResponse, err error) {
+	err = c.c.GET(ctx, "/mining/payoutaddress", &resp)
+	return
+}
+
+// MiningInfo returns the current block template together with the network
+// parameters, target, payout address, recommended fee and sync status.
+func (c *Client) MiningInfo(ctx context.Context) (resp MiningInfoResponse, err error) {
+	err = c.c.POST(ctx, "/mining/info", nil, &resp)
+	return
+}
+
+// MiningChainWork returns the node's tip and the cumulative work of its
+// chain.
+func (c *Client) MiningChainWork(ctx context.Context) (resp MiningChainWorkResponse, err error) {
+	err = c.c.GET(ctx, "/mining/chainwork", &resp)
+	return
+}
+
+// Network returns the name, genesis block ID and hardfork heights of the
+// node's network.
+func (c *Client) Network(ctx context.Context) (resp MiningNetworkResponse, err error) {
+	err = c.c.GET(ctx, "/mining/network", &resp)
+	return
+}
+
+// MiningPeerInfo returns details about the node's connected peers.
+func (c *Client) MiningPeerInfo(ctx context.Context) (resp []GatewayPeer, err error) {
+	err = c.c.POST(ctx, "/mining/peerinfo", nil, &resp)
+	return
+}
+
+// MiningBlockIntervals returns the intervals between the last count blocks
+// and the network's target block interval. If count is zero, the server's
+// default is used.
+func (c *Client) MiningBlockIntervals(ctx context.Context, count int) (resp MiningBlockIntervalsResponse, err error) {
+	err = c.c.POST(ctx, "/mining/blockintervals", MiningBlockIntervalsRequest{Count: count}, &resp)
+	return
+}
+
+// MiningStats returns statistics about the mining API.
+func (c *Client) MiningStats(ctx context.Context) (resp MiningStatsResponse, err error) {
+	err = c.c.GET(ctx, "/mining/stats", &resp)
+	return
+}
+
+// MiningFeeHistogram returns a histogram of the fee rates paid by pool
+// transactions.
+func (c *Client) MiningFeeHistogram(ctx context.Context) (resp MiningFeeHistogramResponse, err error) {
+	err = c.c.GET(ctx, "/mining/feehistogram", &resp)
+	return
+}
+
+// MiningConfirmTarget estimates the number of blocks until a transaction
+// paying the given fee rate per unit of weight is confirmed.
+func (c *Client) MiningConfirmTarget(ctx context.Context, feeRate types.Currency) (resp MiningConfirmTargetResponse, err error) {
+	err = c.c.POST(ctx, "/mining/confirmtarget", MiningConfirmTargetRequest{FeeRate: feeRate}, &resp)
+	return
+}
+
+// DebugBenchTemplate generates block templates for the given duration and
+// returns the achieved throughput. The server must have debug mode enabled.
+func (c *Client) DebugBenchTemplate(ctx context.Context, d time.Duration) (resp DebugBenchTemplateResponse, err error) {
+	err = c.c.POST(ctx, "/mining/debug/benchtemplate", DebugBenchTemplateRequest{Duration: d}, &resp)
+	return
+}
+
+// MiningSubmitBlockAndWait submits a mined block to the network and waits
+// until it is the tip of the node's best chain.
+func (c *Client) MiningSubmitBlockAndWait(ctx context.Context, b types.Block) error {
+	data, err := encodeBlock(b)
+	if err != nil {
+		return err
+	}
+	return c.c.POST(ctx, "/mining/submitblock?wait=true", MiningSubmitBlockRequest{
+		Params: []string{data},
+	}, nil)
+}
+
+// encodeBlock returns the hex-encoded v1 or v2 encoding of b.
+func encodeHeader(h types.BlockHeader) string {
+	buf := new(bytes.Buffer)
+	enc := types.NewEncoder(buf)
+	h.EncodeTo(enc)
+	if err := enc.Flush(); err != nil {
+		panic(err) // can't fail
+	}
+	return hex.EncodeToString(buf.Bytes())
+}
+
+func encodeBlock(b types.Block) (string, error) {
 	buf := new(bytes.Buffer)
 	enc := types.NewEncoder(buf)
 	if b.V2 == nil {
@@ -35,11 +437,92 @@ func (c *Client) MiningSubmitBlock(ctx context.Context, b types.Block) error {
 		types.V2Block(b).EncodeTo(enc)
 	}
 	if err := enc.Flush(); err != nil {
-		return fmt.Errorf("failed to encode block: %w", err)
+		return "", fmt.Errorf("failed to encode block: %w", err)
 	}
-	return c.c.POST(ctx, "/mining/submitblock", MiningSubmitBlockRequest{
-		Params: []string{hex.EncodeToString(buf.Bytes())},
-	}, nil)
+	return hex.EncodeToString(buf.Bytes()), nil
+}
+
+// decodeTemplateBlock assembles the unsolved block of a block template.
+func decodeTemplateBlock(t MiningGetBlockTemplateResponse) (types.Block, types.BlockID, error) {
+	var parentID, target types.BlockID
+	if err := parentID.UnmarshalText([]byte(t.PreviousBlockHash)); err != nil {
+		return types.Block{}, types.BlockID{}, fmt.Errorf("invalid previous block hash: %w", err)
+	} else if err := target.UnmarshalText([]byte(t.Target)); err != nil {
+		return types.Block{}, types.BlockID{}, fmt.Errorf("invalid target: %w", err)
+	} else if len(t.MinerPayout) != 1 {
+		return types.Block{}, types.BlockID{}, fmt.Errorf("expected 1 miner payout, got %d", len(t.MinerPayout))
+	} else if t.Version != 1 && t.Version != 2 {
+		return types.Block{}, types.BlockID{}, fmt.Errorf("unknown template version %d", t.Version)
+	}
+
+	buf, err := hex.DecodeString(t.MinerPayout[0].Data)
+	if err != nil {
+		return types.Block{}, types.BlockID{}, fmt.Errorf("invalid miner payout: %w", err)
+	}
+	var payout types.SiacoinOutput
+	dec := types.NewBufDecoder(buf)
+	if t.Version == 1 {
+		(*types.V1SiacoinOutput)(&payout).DecodeFrom(dec)
+	} else {
+		(*types.V2SiacoinOutput)(&payout).DecodeFrom(dec)
+	}
+	if err := dec.Err(); err != nil {
+		return types.Block{}, types.BlockID{}, fmt.Errorf("failed to decode miner payout: %w", err)
+	}
+
+	b := types.Block{
+		ParentID:     parentID,
+		Timestamp:    time.Unix(int64(t.Timestamp), 0),
+		MinerPayouts: []types.SiacoinOutput{payout},
+	}
+	var v2Txns []types.V2Transaction
+	for i, txn := range t.Transactions {
+		buf, err := hex.DecodeString(txn.Data)
+		if err != nil {
+			return types.Block{}, types.BlockID{}, fmt.Errorf("invalid transaction %d: %w", i, err)
+		}
+		dec := types.NewBufDecoder(buf)
+		switch txn.TxType {
+		case "1":
+			var txn types.Transaction
+			txn.DecodeFrom(dec)
+			b.Transactions = append(b.Transactions, txn)
+		case "2":
+			var txn types.V2Transaction
+			txn.DecodeFrom(dec)
+			v2Txns = append(v2Txns, txn)
+		default:
+			return types.Block{}, types.BlockID{}, fmt.Errorf("transaction %d has unknown type %q", i, txn.TxType)
+		}
+		if err := dec.Err(); err != nil {
+			return types.Block{}, types.BlockID{}, fmt.Errorf("failed to decode transaction %d: %w", i, err)
+		}
+	}
+
+	if t.Version == 2 {
+		// the v2 commitment depends on the parent state, which is only
+		// available through the template's state leaf
+		if t.StateLeaf == nil {
+			return types.Block{}, types.BlockID{}, errors.New("v2 template is missing the state leaf")
+		}
+		var acc blake2b.Accumulator
+		acc.AddLeaf(*t.StateLeaf)
+		for _, txn := range b.Transactions {
+			acc.AddLeaf(txn.MerkleLeafHash())
+		}
+		for _, txn := range v2Txns {
+			acc.AddLeaf(txn.MerkleLeafHash())
+		}
+		b.V2 = &types.V2BlockData{
+			Height:       uint64(t.Height),
+			Commitment:   acc.Root(),
+			Transactions: v2Txns,
+		}
+	}
+	if commitment := b.Header().Commitment; commitment != t.Commitment {
+		return types.Block{}, types.BlockID{}, fmt.Errorf("block commitment %v does not match template commitment %v", commitment, t.Commitment)
+	}
+	return b, target, nil
 }
 
 // NewClient returns a client that communicates with a walletd server listening
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"container/list"
+	"sync"
+
+	"go.sia.tech/core/types"
+)
+
+// recentBlocksSize is the number of recently submitted block IDs remembered to
+// detect duplicate submissions.
+const recentBlocksSize = 64
+
+// recentBlocks is a small LRU cache of recently submitted block IDs.
+type recentBlocks struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // most recently submitted first
+	ids   map[types.BlockID]*list.Element
+}
+
+// Add adds a block ID to the cache. It returns false if the ID was already
+// present.
+func (rb *recentBlocks) Add(id types.BlockID) bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if e, ok := rb.ids[id]; ok {
+		rb.order.MoveToFront(e)
+		return false
+	}
+	rb.ids[id] = rb.order.PushFront(id)
+	if rb.order.Len() > rb.size {
+		oldest := rb.order.Back()
+		rb.order.Remove(oldest)
+		delete(rb.ids, oldest.Value.(types.BlockID))
+	}
+	return true
+}
+
+// Remove removes a block ID from the cache, e.g. because adding the block
+// failed and it should be processed again if resubmitted.
+func (rb *recentBlocks) Remove(id types.BlockID) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if e, ok := rb.ids[id]; ok {
+		rb.order.Remove(e)
+		delete(rb.ids, id)
+	}
+}
+
+func newRecentBlocks(size int) *recentBlocks {
+	return &recentBlocks{
+		size:  size,
+		order: list.New(),
+		ids:   make(map[types.BlockID]*list.Element),
+	}
+}
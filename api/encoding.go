@@ -0,0 +1,78 @@
+package api
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"go.sia.tech/core/types"
+)
+
+// binaryContentType is the content type of binary block templates.
+const binaryContentType = "application/octet-stream"
+
+// binaryTemplateVersion is the version of the binary block template encoding.
+// It is incremented whenever the encoding changes.
+const binaryTemplateVersion = 1
+
+// maxBinaryTemplateSize is the maximum size of a binary block template
+// accepted by the client. It is well above the maximum block weight.
+const maxBinaryTemplateSize = 10 << 20
+
+// EncodeTo implements types.EncoderTo.
+func (t MiningBlockTemplate) EncodeTo(e *types.Encoder) {
+	e.WriteUint8(binaryTemplateVersion)
+	types.V2Block(t.Block).EncodeTo(e)
+	t.Parent.EncodeTo(e)
+	t.Commitment.EncodeTo(e)
+	e.WriteBool(t.StateLeaf != nil)
+	if t.StateLeaf != nil {
+		t.StateLeaf.EncodeTo(e)
+	}
+	e.WriteString(t.LongPollID)
+	t.Target.EncodeTo(e)
+	e.WriteUint64(t.Height)
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (t *MiningBlockTemplate) DecodeFrom(d *types.Decoder) {
+	if v := d.ReadUint8(); v != binaryTemplateVersion {
+		d.SetErr(fmt.Errorf("unsupported binary template version %d", v))
+		return
+	}
+	(*types.V2Block)(&t.Block).DecodeFrom(d)
+	t.Parent.DecodeFrom(d)
+	t.Commitment.DecodeFrom(d)
+	t.StateLeaf = nil
+	if d.ReadBool() {
+		t.StateLeaf = new(types.Hash256)
+		t.StateLeaf.DecodeFrom(d)
+	}
+	t.LongPollID = d.ReadString()
+	t.Target.DecodeFrom(d)
+	t.Height = d.ReadUint64()
+}
+
+// binaryTemplate returns the binary representation of a block template.
+func binaryTemplate(t MiningGetBlockTemplateResponse) MiningBlockTemplate {
+	return MiningBlockTemplate{
+		Block:      t.block,
+		Parent:     t.Parent,
+		Commitment: t.Commitment,
+		StateLeaf:  t.StateLeaf,
+		LongPollID: t.LongPollID,
+		Target:     t.target,
+		Height:     uint64(t.Height),
+	}
+}
+
+// acceptsBinary returns true if the request prefers a binary response.
+func acceptsBinary(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		if mt, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && mt == binaryContentType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+// feeHistogramCacheDuration is the duration for which a computed fee
+// histogram is served from the cache.
+const feeHistogramCacheDuration = 5 * time.Second
+
+// feeHistogramMultipliers are the bucket thresholds in tenths of the
+// recommended fee, ordered from highest to lowest.
+var feeHistogramMultipliers = []uint64{1000, 500, 200, 100, 50, 20, 10, 5, 2, 1, 0}
+
+// A poolFeeRate is the fee rate and weight of a pool transaction.
+type poolFeeRate struct {
+	rate   types.Currency
+	weight uint64
+}
+
+// poolFeeRates returns the fee rate of every pool transaction that can be
+// included in the next block.
+func poolFeeRates(cm ChainManager, cs consensus.State) []poolFeeRate {
+	var rates []poolFeeRate
+	if cs.Index.Height < cs.Network.HardforkV2.RequireHeight {
+		for _, txn := range cm.PoolTransactions() {
+			weight := cs.TransactionWeight(txn)
+			rates = append(rates, poolFeeRate{txn.TotalFees().Div64(max(weight, 1)), weight})
+		}
+	}
+	for _, txn := range cm.V2PoolTransactions() {
+		weight := cs.V2TransactionWeight(txn)
+		rates = append(rates, poolFeeRate{txn.MinerFee.Div64(max(weight, 1)), weight})
+	}
+	return rates
+}
+
+// feeHistogram computes the cumulative number and weight of pool transactions
+// paying at least the fee rate of each bucket.
+func feeHistogram(cm ChainManager) MiningFeeHistogramResponse {
+	cs := cm.TipState()
+	recommended := cm.RecommendedFee()
+	rates := poolFeeRates(cm, cs)
+
+	resp := MiningFeeHistogramResponse{
+		RecommendedFee: recommended,
+		MaxBlockWeight: cs.MaxBlockWeight(),
+		Buckets:        make([]MiningFeeHistogramBucket, len(feeHistogramMultipliers)),
+	}
+	for i, m := range feeHistogramMultipliers {
+		bucket := &resp.Buckets[i]
+		bucket.MinFeeRate = recommended.Mul64(m).Div64(10)
+		for _, r := range rates {
+			if r.rate.Cmp(bucket.MinFeeRate) >= 0 {
+				bucket.Transactions++
+				bucket.Weight += r.weight
+			}
+		}
+	}
+	return resp
+}
+
+// confirmTarget estimates the number of blocks until a transaction paying
+// feeRate is confirmed. Pool transactions paying a higher fee rate are
+// selected first, so the transaction is expected in the block in which their
+// cumulative weight no longer fills the block weight.
+func confirmTarget(cm ChainManager, feeRate types.Currency) MiningConfirmTargetResponse {
+	cs := cm.TipState()
+	resp := MiningConfirmTargetResponse{
+		FeeRate:        feeRate,
+		RecommendedFee: cm.RecommendedFee(),
+		MaxBlockWeight: cs.MaxBlockWeight(),
+	}
+	for _, r := range poolFeeRates(cm, cs) {
+		if r.rate.Cmp(feeRate) > 0 {
+			resp.TransactionsAhead++
+			resp.WeightAhead += r.weight
+		}
+	}
+	resp.Blocks = resp.WeightAhead/resp.MaxBlockWeight + 1
+	return resp
+}
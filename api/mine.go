@@ -2,21 +2,84 @@ package api
 
 import (
 	"bytes"
+	"container/heap"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"math"
 	"math/big"
+	"math/bits"
+	"sort"
+	"time"
 
 	"go.sia.tech/core/consensus"
 	"go.sia.tech/core/types"
 	"lukechampine.com/frand"
 )
 
-func generateBlockTemplate(cm ChainManager, addr types.Address) (MiningGetBlockTemplateResponse, error) {
-	block, cs := unsolvedBlock(cm, addr)
+// templateOptions are the parameters for generating a block template.
+type templateOptions struct {
+	// timestamp is called with the parent state to determine the block's
+	// timestamp.
+	timestamp func(consensus.State) time.Time
+	// excluded transactions and their descendants are not included.
+	excluded map[types.TransactionID]bool
+	// pinned transactions and their ancestors are selected before all other
+	// transactions and placed at the start of the block.
+	pinned map[types.TransactionID]bool
+	// version forces a v1 or v2 block if non-zero.
+	version uint32
+	// coinbaseFlags is added as arbitrary data of the block's first
+	// transaction if non-empty.
+	coinbaseFlags []byte
+	// empty skips transaction selection, so only the coinbase flags marker
+	// is included.
+	empty bool
+	// maxTxns caps the number of pool transactions if non-zero. The coinbase
+	// flags marker doesn't count towards it.
+	maxTxns int
+	// txnLimitReached is called with the number of selected transactions if
+	// maxTxns, rather than the block weight, kept transactions out of the
+	// block.
+	txnLimitReached func(selected int)
+	// minHeight refuses templates below the height if non-zero.
+	minHeight uint64
+}
+
+// MaxCoinbaseFlagsSize is the maximum size of the coinbase flags marker in
+// bytes. The marker is stored in a transaction of its own, so it counts
+// towards the block weight like any other transaction.
+const MaxCoinbaseFlagsSize = 64
+
+// checkMineable returns an error if block, building on cs, can't be mined
+// because of the network's consensus parameters, e.g. the hardfork heights or
+// subsidy of a custom network, or because mining is disabled at its height.
+// The standard networks are always mineable.
+func checkMineable(cs consensus.State, block types.Block, opts templateOptions) error {
+	height := cs.Index.Height + 1
+	if height < opts.minHeight {
+		return fmt.Errorf("mining is disabled below height %d, the next block is at height %d", opts.minHeight, height)
+	}
+	// a forced version is intentionally allowed to produce invalid blocks
+	v2 := cs.Network.HardforkV2
+	if opts.version == 0 && cs.Index.Height >= v2.RequireHeight && cs.Index.Height < v2.AllowHeight {
+		return fmt.Errorf("block at height %d can't be mined since the network's v2 require height %d is below its allow height %d", height, v2.RequireHeight, v2.AllowHeight)
+	}
+	// after the final cut, v2 blocks may omit the miner payout value
+	if block.MinerPayouts[0].Value.IsZero() && (block.V2 == nil || height < v2.FinalCutHeight) {
+		return fmt.Errorf("block at height %d can't be mined since the network's block reward is zero and the block pays no fees", height)
+	}
+	return nil
+}
+
+func generateBlockTemplate(cm ChainManager, addr types.Address, opts templateOptions) (MiningGetBlockTemplateResponse, error) {
+	block, cs := unsolvedBlock(cm, addr, opts)
 
 	// sanity check miner payouts
 	if len(block.MinerPayouts) != 1 {
 		return MiningGetBlockTemplateResponse{}, fmt.Errorf("expected 1 miner payout got %d", len(block.MinerPayouts))
+	} else if err := checkMineable(cs, block, opts); err != nil {
+		return MiningGetBlockTemplateResponse{}, err
 	}
 
 	// figure out encoding version
@@ -69,20 +132,78 @@ func generateBlockTemplate(cm ChainManager, addr types.Address) (MiningGetBlockT
 		}
 	}
 
+	var stateLeaf *types.Hash256
+	if block.V2 != nil {
+		leaf := cs.MerkleLeafHash(addr)
+		stateLeaf = &leaf
+	}
+
 	return MiningGetBlockTemplateResponse{
 		Commitment:        block.Header().Commitment,
 		Transactions:      txns,
 		MinerPayout:       []MiningGetBlockTemplateResponseTxn{minerPayout},
 		PreviousBlockHash: block.ParentID.String(),
+		Parent:            cs.Index,
+		StateLeaf:         stateLeaf,
+		PayoutAddress:     block.MinerPayouts[0].Address.String(),
+		FeeBreakdown:      feeBreakdown(block),
+		CoinbaseFlags:     coinbaseFlags(block, opts.coinbaseFlags),
 		LongPollID:        hex.EncodeToString(frand.Bytes(16)),
 		Target:            cs.PoWTarget().String(),
 		Height:            uint32(cs.Index.Height) + 1,
 		Timestamp:         int32(block.Timestamp.Unix()),
 		Version:           version,
 		Bits:              compressDifficulty(cs.Difficulty),
+		block:             block,
+		target:            cs.PoWTarget(),
 	}, nil
 }
 
+// refreshTemplate returns a copy of the template with a new timestamp and long
+// poll ID. The commitment doesn't cover the timestamp, so the transaction
+// selection and encoding stay valid as long as the template builds on cs and
+// the pool hasn't changed.
+func refreshTemplate(t MiningGetBlockTemplateResponse, cs consensus.State, timestamp time.Time) MiningGetBlockTemplateResponse {
+	t.block.Timestamp = clampTimestamp(cs, timestamp, time.Now())
+	t.Timestamp = int32(t.block.Timestamp.Unix())
+	t.LongPollID = hex.EncodeToString(frand.Bytes(16))
+	return t
+}
+
+// compactTemplate returns a copy of the template without the data of its pool
+// transactions. The coinbase flags marker isn't in any pool, so its data is
+// kept.
+func compactTemplate(t MiningGetBlockTemplateResponse) MiningGetBlockTemplateResponse {
+	var markerID string
+	if t.CoinbaseFlags != "" && t.block.V2 != nil {
+		markerID = t.block.V2.Transactions[0].ID().String()
+	} else if t.CoinbaseFlags != "" {
+		markerID = t.block.Transactions[0].ID().String()
+	}
+
+	txns := make([]MiningGetBlockTemplateResponseTxn, len(t.Transactions))
+	copy(txns, t.Transactions)
+	for i := range txns {
+		if txns[i].TxID != markerID {
+			txns[i].Data = ""
+		}
+	}
+	t.Transactions = txns
+	return t
+}
+
+// coinbaseFlags returns the coinbase flags if they were added to the block.
+func coinbaseFlags(b types.Block, flags []byte) string {
+	if len(flags) == 0 {
+		return ""
+	} else if b.V2 != nil && len(b.V2.Transactions) > 0 && bytes.Equal(b.V2.Transactions[0].ArbitraryData, flags) {
+		return string(flags)
+	} else if len(b.Transactions) > 0 && len(b.Transactions[0].ArbitraryData) == 1 && bytes.Equal(b.Transactions[0].ArbitraryData[0], flags) {
+		return string(flags)
+	}
+	return ""
+}
+
 func compressDifficulty(w consensus.Work) string {
 	buf := new(bytes.Buffer)
 	enc := types.NewEncoder(buf)
@@ -134,11 +255,17 @@ func bigToCompact(n *big.Int) uint32 {
 	return compact
 }
 
-func unsolvedBlock(cm ChainManager, addr types.Address) (types.Block, consensus.State) {
+// unsolvedBlock creates a block on top of the current tip that includes as
+// many pool transactions as fit.
+func unsolvedBlock(cm ChainManager, addr types.Address, opts templateOptions) (types.Block, consensus.State) {
 retry:
 	cs := cm.TipState()
-	txns := cm.PoolTransactions()
-	v2Txns := cm.V2PoolTransactions()
+	var txns []types.Transaction
+	var v2Txns []types.V2Transaction
+	if !opts.empty {
+		txns = cm.PoolTransactions()
+		v2Txns = cm.V2PoolTransactions()
+	}
 	if cs.Index != cm.Tip() {
 		goto retry
 	}
@@ -146,41 +273,698 @@ retry:
 	if cs.Index.Height >= cs.Network.HardforkV2.RequireHeight {
 		txns = nil // ignore potential v1 transactions
 	}
+	if len(opts.excluded) > 0 {
+		txns, v2Txns = filterExcluded(txns, v2Txns, opts.excluded)
+	}
+	// the v1 and v2 transactions are read separately, so the pool might have
+	// changed in between
+	if conflicts := conflictingTransactions(txns, v2Txns); len(conflicts) > 0 {
+		txns, v2Txns = filterExcluded(txns, v2Txns, conflicts)
+	}
 
 	b := types.Block{
 		ParentID:  cs.Index.ID,
-		Timestamp: types.CurrentTimestamp(),
+		Timestamp: clampTimestamp(cs, opts.timestamp(cs), time.Now()),
 		MinerPayouts: []types.SiacoinOutput{{
 			Value:   cs.BlockReward(),
 			Address: addr,
 		}},
 	}
 
+	isV2 := cs.Index.Height >= cs.Network.HardforkV2.AllowHeight
+	if opts.version != 0 {
+		isV2 = opts.version == 2
+	}
+
+	// the coinbase flags are placed in a transaction without inputs or
+	// outputs at the start of the block. v1 blocks at or above the v2 require
+	// height can't contain transactions, so they aren't marked.
 	var weight uint64
-	for _, txn := range txns {
-		if weight += cs.TransactionWeight(txn); weight > cs.MaxBlockWeight() {
-			break
+	if len(opts.coinbaseFlags) > 0 && isV2 {
+		b.V2 = &types.V2BlockData{
+			Transactions: []types.V2Transaction{{ArbitraryData: opts.coinbaseFlags}},
 		}
-		b.Transactions = append(b.Transactions, txn)
-		b.MinerPayouts[0].Value = b.MinerPayouts[0].Value.Add(txn.TotalFees())
+		weight += cs.V2TransactionWeight(b.V2.Transactions[0])
+	} else if len(opts.coinbaseFlags) > 0 && cs.Index.Height+1 < cs.Network.HardforkV2.RequireHeight {
+		b.Transactions = []types.Transaction{{ArbitraryData: [][]byte{opts.coinbaseFlags}}}
+		weight += cs.TransactionWeight(b.Transactions[0])
 	}
 
-	if cs.Index.Height >= cs.Network.HardforkV2.AllowHeight {
-		b.V2 = &types.V2BlockData{
-			Height: cs.Index.Height + 1,
+	maxTxns := math.MaxInt
+	if opts.maxTxns > 0 {
+		maxTxns = opts.maxTxns
+	}
+
+	v1Ptxns := v1PoolTxns(cs, txns)
+	var v2Ptxns []poolTxn
+	var v2Reserved uint64
+	var v2ReservedTxns int
+	if len(opts.pinned) > 0 {
+		for i, txn := range txns {
+			v1Ptxns[i].pinned = opts.pinned[txn.ID()]
 		}
-		for _, txn := range v2Txns {
-			if weight += cs.V2TransactionWeight(txn); weight > cs.MaxBlockWeight() {
-				break
+		if isV2 {
+			// v1 transactions are selected first, so room for the pinned v2
+			// transactions has to be reserved
+			v2Ptxns = v2PoolTxns(cs, v2Txns)
+			for i, txn := range v2Txns {
+				v2Ptxns[i].pinned = opts.pinned[txn.ID()]
 			}
-			b.V2.Transactions = append(b.V2.Transactions, txn)
-			b.MinerPayouts[0].Value = b.MinerPayouts[0].Value.Add(txn.MinerFee)
+			var reserved []int
+			reserved, v2Reserved = selectPinned(v2Ptxns, cs.MaxBlockWeight()-weight, maxTxns)
+			v2ReservedTxns = len(reserved)
+		}
+	}
+
+	selected, v1Weight, limited := selectPackages(v1Ptxns, cs.MaxBlockWeight()-weight-v2Reserved, maxTxns-v2ReservedTxns)
+	weight += v1Weight
+	numSelected := len(selected)
+	for _, i := range selected {
+		b.Transactions = append(b.Transactions, txns[i])
+		b.MinerPayouts[0].Value = b.MinerPayouts[0].Value.Add(txns[i].TotalFees())
+	}
+
+	if isV2 {
+		if b.V2 == nil {
+			b.V2 = new(types.V2BlockData)
+		}
+		b.V2.Height = cs.Index.Height + 1
+		if v2Ptxns == nil {
+			v2Ptxns = v2PoolTxns(cs, v2Txns)
+		}
+		selected, _, v2Limited := selectPackages(v2Ptxns, cs.MaxBlockWeight()-weight, maxTxns-numSelected)
+		limited = limited || v2Limited
+		numSelected += len(selected)
+		for _, i := range selected {
+			b.V2.Transactions = append(b.V2.Transactions, v2Txns[i])
+			b.MinerPayouts[0].Value = b.MinerPayouts[0].Value.Add(v2Txns[i].MinerFee)
 		}
 	}
 
+	if limited && opts.txnLimitReached != nil {
+		opts.txnLimitReached(numSelected)
+	}
+
 	if b.V2 != nil {
 		b.V2.Commitment = cs.Commitment(addr, b.Transactions, b.V2Transactions())
 	}
 
 	return b, cs
 }
+
+// A poolTxn is a pool transaction considered for inclusion in a block.
+type poolTxn struct {
+	weight uint64
+	fee    types.Currency
+	pinned bool
+	// parents are the indices of the pool transactions whose outputs the
+	// transaction spends.
+	parents []int
+}
+
+// v1PoolTxns returns the weights, fees and parents of the given v1
+// transactions. The transactions are expected to be ordered such that parents
+// come before their children.
+func v1PoolTxns(cs consensus.State, txns []types.Transaction) []poolTxn {
+	created := make(map[types.Hash256]int)
+	ptxns := make([]poolTxn, len(txns))
+	for i, txn := range txns {
+		ptxns[i] = poolTxn{
+			weight: cs.TransactionWeight(txn),
+			fee:    txn.TotalFees(),
+		}
+		addParent := func(id types.Hash256) {
+			if j, ok := created[id]; ok {
+				ptxns[i].parents = append(ptxns[i].parents, j)
+			}
+		}
+		for _, sci := range txn.SiacoinInputs {
+			addParent(types.Hash256(sci.ParentID))
+		}
+		for _, sfi := range txn.SiafundInputs {
+			addParent(types.Hash256(sfi.ParentID))
+		}
+		for _, fcr := range txn.FileContractRevisions {
+			addParent(types.Hash256(fcr.ParentID))
+		}
+		for _, sp := range txn.StorageProofs {
+			addParent(types.Hash256(sp.ParentID))
+		}
+
+		for j := range txn.SiacoinOutputs {
+			created[types.Hash256(txn.SiacoinOutputID(j))] = i
+		}
+		for j := range txn.SiafundOutputs {
+			created[types.Hash256(txn.SiafundOutputID(j))] = i
+		}
+		for j := range txn.FileContracts {
+			created[types.Hash256(txn.FileContractID(j))] = i
+		}
+	}
+	return ptxns
+}
+
+// v2PoolTxns returns the weights, fees and parents of the given v2
+// transactions. The transactions are expected to be ordered such that parents
+// come before their children.
+func v2PoolTxns(cs consensus.State, txns []types.V2Transaction) []poolTxn {
+	created := make(map[types.Hash256]int)
+	ptxns := make([]poolTxn, len(txns))
+	for i, txn := range txns {
+		ptxns[i] = poolTxn{
+			weight: cs.V2TransactionWeight(txn),
+			fee:    txn.MinerFee,
+		}
+		addParent := func(id types.Hash256) {
+			if j, ok := created[id]; ok {
+				ptxns[i].parents = append(ptxns[i].parents, j)
+			}
+		}
+		for _, sci := range txn.SiacoinInputs {
+			addParent(types.Hash256(sci.Parent.ID))
+		}
+		for _, sfi := range txn.SiafundInputs {
+			addParent(types.Hash256(sfi.Parent.ID))
+		}
+		for _, fcr := range txn.FileContractRevisions {
+			addParent(types.Hash256(fcr.Parent.ID))
+		}
+		for _, fcr := range txn.FileContractResolutions {
+			addParent(types.Hash256(fcr.Parent.ID))
+		}
+
+		txid := txn.ID()
+		for j := range txn.SiacoinOutputs {
+			created[types.Hash256(txn.SiacoinOutputID(txid, j))] = i
+		}
+		for j := range txn.SiafundOutputs {
+			created[types.Hash256(txn.SiafundOutputID(txid, j))] = i
+		}
+		for j := range txn.FileContracts {
+			created[types.Hash256(txn.V2FileContractID(txid, j))] = i
+		}
+	}
+	return ptxns
+}
+
+// selectPackages selects the transactions to include in a block with the
+// given weight budget and at most maxTxns transactions. Transactions are
+// evaluated by the fee rate of their package, i.e. the transaction and its
+// unselected ancestors, so a child paying a high fee pulls in its low-fee
+// parents. The packages with the highest fee rate are selected first;
+// packages that don't fit are skipped. Pinned transactions and their
+// ancestors are selected before everything else, which drops the lowest fee
+// rate transactions if the block is full. The indices of the selected
+// transactions are returned with the pinned packages first and the others in
+// their original order, which keeps parents before their children, along
+// with their total weight. limited is set if a package fitting the weight
+// budget was skipped because of maxTxns.
+func selectPackages(txns []poolTxn, budget uint64, maxTxns int) (selected []int, weight uint64, limited bool) {
+	ancestors := packageAncestors(txns)
+	included := make([]bool, len(txns))
+	selected, weight, limited = selectPinnedPackages(txns, ancestors, included, budget, maxTxns)
+	count := len(selected)
+
+	descendants := make([][]int, len(txns))
+	for i := range txns {
+		for _, a := range ancestors[i] {
+			descendants[a] = append(descendants[a], i)
+		}
+	}
+
+	// a package's rate only changes when one of its ancestors is selected,
+	// so each package is only re-evaluated after that happens. Entries with
+	// an outdated version are ignored when popped.
+	versions := make([]int, len(txns))
+	evaluate := func(i int) txnPackage {
+		p := txnPackage{index: i, fee: txns[i].fee, weight: txns[i].weight, count: 1, version: versions[i]}
+		for _, a := range ancestors[i] {
+			if !included[a] {
+				p.fee, p.weight, p.count = p.fee.Add(txns[a].fee), p.weight+txns[a].weight, p.count+1
+			}
+		}
+		return p
+	}
+
+	var h packageHeap
+	for i := range txns {
+		if !included[i] {
+			h = append(h, evaluate(i))
+		}
+	}
+	heap.Init(&h)
+
+	skipped := make([]bool, len(txns))
+	for h.Len() > 0 {
+		p := heap.Pop(&h).(txnPackage)
+		if included[p.index] || skipped[p.index] || p.version != versions[p.index] {
+			continue
+		} else if weight+p.weight > budget {
+			skipped[p.index] = true
+			continue
+		} else if count+p.count > maxTxns {
+			skipped[p.index] = true
+			limited = true
+			continue
+		}
+
+		changed := []int{p.index}
+		for _, a := range ancestors[p.index] {
+			if !included[a] {
+				included[a] = true
+				changed = append(changed, a)
+			}
+		}
+		included[p.index] = true
+		weight += p.weight
+		count += p.count
+
+		stale := make(map[int]bool)
+		for _, j := range changed {
+			for _, d := range descendants[j] {
+				if !included[d] && !skipped[d] {
+					stale[d] = true
+				}
+			}
+		}
+		for d := range stale {
+			versions[d]++
+			heap.Push(&h, evaluate(d))
+		}
+	}
+
+	pinnedSelected := make(map[int]bool, len(selected))
+	for _, i := range selected {
+		pinnedSelected[i] = true
+	}
+	for i := range txns {
+		if included[i] && !pinnedSelected[i] {
+			selected = append(selected, i)
+		}
+	}
+	return selected, weight, limited
+}
+
+// A txnPackage is a transaction and its unselected ancestors.
+type txnPackage struct {
+	index   int
+	fee     types.Currency
+	weight  uint64
+	count   int
+	version int
+}
+
+// higherFeeRate returns true if the fee rate of a is higher than the fee rate
+// of b.
+func (a txnPackage) higherFeeRate(b txnPackage) bool {
+	// compare a.fee/a.weight with b.fee/b.weight by cross multiplying, which
+	// fits in 192 bits
+	mul := func(c types.Currency, w uint64) [3]uint64 {
+		w = max(w, 1)
+		loHi, lo := bits.Mul64(c.Lo, w)
+		hi, mid := bits.Mul64(c.Hi, w)
+		mid, carry := bits.Add64(mid, loHi, 0)
+		return [3]uint64{hi + carry, mid, lo}
+	}
+	l, r := mul(a.fee, b.weight), mul(b.fee, a.weight)
+	for i := range l {
+		if l[i] != r[i] {
+			return l[i] > r[i]
+		}
+	}
+	return false
+}
+
+// packageHeap is a max-heap of packages ordered by fee rate. Packages with
+// the same fee rate are ordered by index.
+type packageHeap []txnPackage
+
+func (h packageHeap) Len() int { return len(h) }
+func (h packageHeap) Less(i, j int) bool {
+	if h[i].higherFeeRate(h[j]) {
+		return true
+	} else if h[j].higherFeeRate(h[i]) {
+		return false
+	}
+	return h[i].index < h[j].index
+}
+func (h packageHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *packageHeap) Push(x any)   { *h = append(*h, x.(txnPackage)) }
+func (h *packageHeap) Pop() any {
+	old := *h
+	p := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return p
+}
+
+// selectPinned returns the indices and total weight of the pinned
+// transactions and their ancestors that fit within the given weight budget
+// and transaction limit.
+func selectPinned(txns []poolTxn, budget uint64, maxTxns int) ([]int, uint64) {
+	selected, weight, _ := selectPinnedPackages(txns, packageAncestors(txns), make([]bool, len(txns)), budget, maxTxns)
+	return selected, weight
+}
+
+// selectPinnedPackages selects the packages of the pinned transactions in
+// their original order, skipping packages that don't fit within the budget
+// or the transaction limit. Selected transactions are marked in included.
+func selectPinnedPackages(txns []poolTxn, ancestors [][]int, included []bool, budget uint64, maxTxns int) (selected []int, weight uint64, limited bool) {
+	for i, txn := range txns {
+		if !txn.pinned || included[i] {
+			continue
+		}
+		w, n := txn.weight, 1
+		for _, a := range ancestors[i] {
+			if !included[a] {
+				w, n = w+txns[a].weight, n+1
+			}
+		}
+		if weight+w > budget {
+			continue
+		} else if len(selected)+n > maxTxns {
+			limited = true
+			continue
+		}
+		for _, a := range ancestors[i] {
+			if !included[a] {
+				included[a] = true
+				selected = append(selected, a)
+			}
+		}
+		included[i] = true
+		selected = append(selected, i)
+		weight += w
+	}
+	return selected, weight, limited
+}
+
+// packageAncestors returns the ancestors of each transaction in ascending
+// order.
+func packageAncestors(txns []poolTxn) [][]int {
+	ancestors := make([][]int, len(txns))
+	for i, txn := range txns {
+		seen := make(map[int]bool)
+		for _, p := range txn.parents {
+			seen[p] = true
+			for _, a := range ancestors[p] {
+				seen[a] = true
+			}
+		}
+		for a := range seen {
+			ancestors[i] = append(ancestors[i], a)
+		}
+		sort.Ints(ancestors[i])
+	}
+	return ancestors
+}
+
+// feeBreakdown summarizes the fees paid by the block's transactions by
+// category.
+func feeBreakdown(b types.Block) (fb MiningFeeBreakdown) {
+	add := func(c *MiningFeeCategory, fee types.Currency) {
+		c.Transactions++
+		c.Fees = c.Fees.Add(fee)
+	}
+	for _, txn := range b.Transactions {
+		fee := txn.TotalFees()
+		add(&fb.V1, fee)
+		if len(txn.FileContracts) > 0 || len(txn.FileContractRevisions) > 0 || len(txn.StorageProofs) > 0 {
+			add(&fb.FileContracts, fee)
+		}
+	}
+	for _, txn := range b.V2Transactions() {
+		add(&fb.V2, txn.MinerFee)
+		if len(txn.FileContracts) > 0 || len(txn.FileContractRevisions) > 0 || len(txn.FileContractResolutions) > 0 {
+			add(&fb.FileContracts, txn.MinerFee)
+		}
+	}
+	return
+}
+
+// defaultMinDifficultyDivisor is the factor by which the difficulty may drop
+// below the network's initial difficulty before submitted blocks are
+// rejected.
+const defaultMinDifficultyDivisor = 1_000_000
+
+// defaultMinDifficulty returns the default lower bound for the difficulty of
+// submitted blocks, derived from the network's initial target.
+func defaultMinDifficulty(n *consensus.Network) consensus.Work {
+	initial, _ := new(big.Int).SetString(n.GenesisState().Difficulty.String(), 10)
+	initial.Div(initial, big.NewInt(defaultMinDifficultyDivisor))
+	if initial.Sign() == 0 {
+		initial.SetInt64(1)
+	}
+	var w consensus.Work
+	if err := w.UnmarshalText([]byte(initial.String())); err != nil {
+		panic(err) // can't fail, the value is smaller than the initial difficulty
+	}
+	return w
+}
+
+// medianTimestamp returns the median of the timestamps of the blocks
+// preceding the child of cs. A child block's timestamp must not be before it.
+func medianTimestamp(cs consensus.State) time.Time {
+	n := len(cs.PrevTimestamps)
+	if cs.Index.Height+1 < uint64(n) {
+		n = int(cs.Index.Height + 1)
+	}
+	ts := append([]time.Time(nil), cs.PrevTimestamps[:n]...)
+	sort.Slice(ts, func(i, j int) bool { return ts[i].Before(ts[j]) })
+	if len(ts)%2 != 0 {
+		return ts[len(ts)/2]
+	}
+	l, r := ts[len(ts)/2-1], ts[len(ts)/2]
+	return l.Add(r.Sub(l) / 2)
+}
+
+// clampTimestamp clamps a template timestamp to be after the median timestamp
+// of the previous blocks and no later than the maximum future timestamp. If
+// both can't be satisfied, the median timestamp rule takes precedence since
+// it's a consensus rule.
+func clampTimestamp(cs consensus.State, timestamp, now time.Time) time.Time {
+	if ceiling := cs.MaxFutureTimestamp(now); timestamp.After(ceiling) {
+		timestamp = ceiling.Truncate(time.Second)
+	}
+	if floor := medianTimestamp(cs).Truncate(time.Second).Add(time.Second); timestamp.Before(floor) {
+		timestamp = floor
+	}
+	return timestamp
+}
+
+// blockInclusion returns whether the transaction with the given ID is part of
+// b, its position within the block's transactions and the rank of its fee rate
+// among them. v1 transactions come before v2 transactions, matching the order
+// of block templates.
+func blockInclusion(cs consensus.State, b types.Block, id types.TransactionID) MiningWillIncludeResponse {
+	var rates []types.Currency
+	position := -1
+	for _, txn := range b.Transactions {
+		if txn.ID() == id {
+			position = len(rates)
+		}
+		rates = append(rates, txn.TotalFees().Div64(max(cs.TransactionWeight(txn), 1)))
+	}
+	for _, txn := range b.V2Transactions() {
+		if txn.ID() == id {
+			position = len(rates)
+		}
+		rates = append(rates, txn.MinerFee.Div64(max(cs.V2TransactionWeight(txn), 1)))
+	}
+	if position == -1 {
+		return MiningWillIncludeResponse{Transactions: len(rates)}
+	}
+
+	rank := 1
+	for _, rate := range rates {
+		if rate.Cmp(rates[position]) > 0 {
+			rank++
+		}
+	}
+	return MiningWillIncludeResponse{
+		Included:     true,
+		Position:     position,
+		FeeRank:      rank,
+		FeeRate:      rates[position],
+		Transactions: len(rates),
+	}
+}
+
+// filterExcluded removes the excluded transactions from txns and v2Txns as well
+// as any transactions spending their outputs, directly or indirectly. The
+// transactions are expected to be ordered such that parents come before their
+// children, which is the case for transactions returned by the pool.
+func filterExcluded(txns []types.Transaction, v2Txns []types.V2Transaction, excluded map[types.TransactionID]bool) ([]types.Transaction, []types.V2Transaction) {
+	// IDs of elements created by skipped transactions
+	skipped := make(map[types.Hash256]bool)
+
+	var filtered []types.Transaction
+	for _, txn := range txns {
+		skip := excluded[txn.ID()]
+		for _, sci := range txn.SiacoinInputs {
+			skip = skip || skipped[types.Hash256(sci.ParentID)]
+		}
+		for _, sfi := range txn.SiafundInputs {
+			skip = skip || skipped[types.Hash256(sfi.ParentID)]
+		}
+		for _, fcr := range txn.FileContractRevisions {
+			skip = skip || skipped[types.Hash256(fcr.ParentID)]
+		}
+		for _, sp := range txn.StorageProofs {
+			skip = skip || skipped[types.Hash256(sp.ParentID)]
+		}
+		if !skip {
+			filtered = append(filtered, txn)
+			continue
+		}
+		for i := range txn.SiacoinOutputs {
+			skipped[types.Hash256(txn.SiacoinOutputID(i))] = true
+		}
+		for i := range txn.SiafundOutputs {
+			skipped[types.Hash256(txn.SiafundOutputID(i))] = true
+		}
+		for i := range txn.FileContracts {
+			skipped[types.Hash256(txn.FileContractID(i))] = true
+		}
+	}
+
+	var filteredV2 []types.V2Transaction
+	for _, txn := range v2Txns {
+		txid := txn.ID()
+		skip := excluded[txid]
+		for _, sci := range txn.SiacoinInputs {
+			skip = skip || skipped[types.Hash256(sci.Parent.ID)]
+		}
+		for _, sfi := range txn.SiafundInputs {
+			skip = skip || skipped[types.Hash256(sfi.Parent.ID)]
+		}
+		for _, fcr := range txn.FileContractRevisions {
+			skip = skip || skipped[types.Hash256(fcr.Parent.ID)]
+		}
+		for _, fcr := range txn.FileContractResolutions {
+			skip = skip || skipped[types.Hash256(fcr.Parent.ID)]
+		}
+		if !skip {
+			filteredV2 = append(filteredV2, txn)
+			continue
+		}
+		for i := range txn.SiacoinOutputs {
+			skipped[types.Hash256(txn.SiacoinOutputID(txid, i))] = true
+		}
+		for i := range txn.SiafundOutputs {
+			skipped[types.Hash256(txn.SiafundOutputID(txid, i))] = true
+		}
+		for i := range txn.FileContracts {
+			skipped[types.Hash256(txn.V2FileContractID(txid, i))] = true
+		}
+	}
+	return filtered, filteredV2
+}
+
+// conflictingTransactions returns the IDs of the transactions that spend an
+// output that was already spent by a preceding transaction.
+func conflictingTransactions(txns []types.Transaction, v2Txns []types.V2Transaction) map[types.TransactionID]bool {
+	spent := make(map[types.Hash256]bool)
+	conflicts := make(map[types.TransactionID]bool)
+	checkInputs := func(id types.TransactionID, inputs []types.Hash256) {
+		for _, input := range inputs {
+			if spent[input] {
+				conflicts[id] = true
+				return
+			}
+		}
+		for _, input := range inputs {
+			spent[input] = true
+		}
+	}
+
+	for _, txn := range txns {
+		var inputs []types.Hash256
+		for _, sci := range txn.SiacoinInputs {
+			inputs = append(inputs, types.Hash256(sci.ParentID))
+		}
+		for _, sfi := range txn.SiafundInputs {
+			inputs = append(inputs, types.Hash256(sfi.ParentID))
+		}
+		checkInputs(txn.ID(), inputs)
+	}
+	for _, txn := range v2Txns {
+		var inputs []types.Hash256
+		for _, sci := range txn.SiacoinInputs {
+			inputs = append(inputs, types.Hash256(sci.Parent.ID))
+		}
+		for _, sfi := range txn.SiafundInputs {
+			inputs = append(inputs, types.Hash256(sfi.Parent.ID))
+		}
+		for _, fcr := range txn.FileContractResolutions {
+			inputs = append(inputs, types.Hash256(fcr.Parent.ID))
+		}
+		checkInputs(txn.ID(), inputs)
+	}
+	return conflicts
+}
+
+// validateProposal validates a block proposal against the current tip. The
+// proof of work is not checked since proposals are validated before a nonce
+// is found.
+//
+// v1 transactions can only be validated if they are in the transaction pool
+// since the node can't construct a supplement for them. The coinbase flags
+// marker added to v1 templates is never in the pool, but it doesn't spend
+// anything, so it is valid without a supplement. If any other v1 transaction
+// is unknown, or a v2 transaction fails validation in a block that also
+// contains such transactions, the proposal is considered inconclusive.
+func validateProposal(cm ChainManager, b types.Block, coinbaseFlags []byte) error {
+	cs := cm.TipState()
+	if _, ok := cm.Block(b.ID()); ok {
+		return errors.New(ProposalRejectDuplicate)
+	} else if b.ParentID != cs.Index.ID {
+		return errors.New(ProposalRejectBadPrevBlock)
+	} else if b.Timestamp.After(cs.MaxFutureTimestamp(time.Now())) {
+		return errors.New(ProposalRejectTimeTooNew)
+	}
+
+	// validate the header and payouts against a state with the lowest
+	// possible difficulty to skip the proof of work check
+	noWork := cs
+	for i := range noWork.ChildTarget {
+		noWork.ChildTarget[i] = 0xff
+	}
+	if err := noWork.Difficulty.UnmarshalText([]byte("1")); err != nil {
+		panic(err) // should never happen
+	}
+	if err := consensus.ValidateOrphan(noWork, b); err != nil {
+		return err
+	} else if b.V2 != nil && b.V2.Commitment != cs.Commitment(b.MinerPayouts[0].Address, b.Transactions, b.V2Transactions()) {
+		return consensus.ErrCommitmentMismatch
+	}
+
+	txns := b.Transactions
+	if len(coinbaseFlags) > 0 && len(txns) > 0 {
+		marker := types.Transaction{ArbitraryData: [][]byte{coinbaseFlags}}
+		if txns[0].ID() == marker.ID() {
+			txns = txns[1:]
+		}
+	}
+	if len(txns) > 0 {
+		inPool := make(map[types.TransactionID]bool)
+		for _, txn := range cm.PoolTransactions() {
+			inPool[txn.ID()] = true
+		}
+		for _, txn := range txns {
+			if !inPool[txn.ID()] {
+				return errors.New(ProposalRejectInconclusive)
+			}
+		}
+	}
+
+	ms := consensus.NewMidState(cs)
+	for i, txn := range b.V2Transactions() {
+		if err := consensus.ValidateV2Transaction(ms, txn); err != nil && len(txns) > 0 {
+			return errors.New(ProposalRejectInconclusive)
+		} else if err != nil {
+			return fmt.Errorf("v2 transaction %v is invalid: %w", i, err)
+		}
+		ms.ApplyV2Transaction(txn)
+	}
+	return nil
+}
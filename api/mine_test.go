@@ -0,0 +1,431 @@
+package api
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+	"lukechampine.com/frand"
+)
+
+func TestFilterExcluded(t *testing.T) {
+	parent := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Value: types.Siacoins(1)}},
+	}
+	child := types.Transaction{
+		SiacoinInputs:  []types.SiacoinInput{{ParentID: parent.SiacoinOutputID(0)}},
+		SiafundOutputs: []types.SiafundOutput{{Value: 1}},
+	}
+	grandchild := types.Transaction{
+		SiafundInputs: []types.SiafundInput{{ParentID: child.SiafundOutputID(0)}},
+	}
+	unrelated := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{ParentID: frand.Entropy256()}},
+	}
+
+	v2Parent := types.V2Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Value: types.Siacoins(1)}},
+	}
+	v2Child := types.V2Transaction{
+		SiacoinInputs: []types.V2SiacoinInput{{Parent: v2Parent.EphemeralSiacoinOutput(0)}},
+	}
+	v2Unrelated := types.V2Transaction{
+		MinerFee: types.Siacoins(1),
+	}
+
+	txns := []types.Transaction{parent, unrelated, child, grandchild}
+	v2Txns := []types.V2Transaction{v2Parent, v2Unrelated, v2Child}
+
+	// nothing excluded
+	filtered, filteredV2 := filterExcluded(txns, v2Txns, nil)
+	if len(filtered) != len(txns) || len(filteredV2) != len(v2Txns) {
+		t.Fatalf("expected all transactions, got %d and %d", len(filtered), len(filteredV2))
+	}
+
+	// excluding the parents should also exclude their descendants
+	filtered, filteredV2 = filterExcluded(txns, v2Txns, map[types.TransactionID]bool{
+		parent.ID():   true,
+		v2Parent.ID(): true,
+	})
+	if len(filtered) != 1 || filtered[0].ID() != unrelated.ID() {
+		t.Fatalf("expected only the unrelated v1 transaction, got %v", filtered)
+	} else if len(filteredV2) != 1 || filteredV2[0].ID() != v2Unrelated.ID() {
+		t.Fatalf("expected only the unrelated v2 transaction, got %v", filteredV2)
+	}
+
+	// excluding a child should keep its parent
+	filtered, _ = filterExcluded(txns, v2Txns, map[types.TransactionID]bool{
+		child.ID(): true,
+	})
+	if len(filtered) != 2 || filtered[0].ID() != parent.ID() || filtered[1].ID() != unrelated.ID() {
+		t.Fatalf("expected parent and unrelated transaction, got %v", filtered)
+	}
+}
+
+func TestConflictingTransactions(t *testing.T) {
+	parentID := types.SiacoinOutputID(frand.Entropy256())
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{ParentID: parentID}},
+	}
+	doubleSpend := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{ParentID: parentID}},
+		ArbitraryData: [][]byte{{1}},
+	}
+	v2DoubleSpend := types.V2Transaction{
+		SiacoinInputs: []types.V2SiacoinInput{{Parent: types.SiacoinElement{ID: parentID}}},
+	}
+	unrelated := types.V2Transaction{
+		SiacoinInputs: []types.V2SiacoinInput{{Parent: types.SiacoinElement{ID: frand.Entropy256()}}},
+	}
+
+	conflicts := conflictingTransactions([]types.Transaction{txn, doubleSpend}, []types.V2Transaction{v2DoubleSpend, unrelated})
+	if len(conflicts) != 2 {
+		t.Fatalf("expected 2 conflicts, got %d", len(conflicts))
+	} else if !conflicts[doubleSpend.ID()] || !conflicts[v2DoubleSpend.ID()] {
+		t.Fatal("expected double spends to conflict")
+	}
+}
+
+func TestClampTimestamp(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var cs consensus.State
+	cs.Index.Height = uint64(len(cs.PrevTimestamps))
+	for i := range cs.PrevTimestamps {
+		cs.PrevTimestamps[i] = now.Add(time.Hour)
+	}
+
+	// timestamps before the median are raised
+	if ts := clampTimestamp(cs, now, now); !ts.Equal(now.Add(time.Hour + time.Second)) {
+		t.Fatalf("expected timestamp to be raised to the median, got %v", ts)
+	}
+	// timestamps too far in the future are lowered
+	if ts := clampTimestamp(cs, now.Add(24*time.Hour), now); !ts.Equal(cs.MaxFutureTimestamp(now)) {
+		t.Fatalf("expected timestamp to be lowered to the max future timestamp, got %v", ts)
+	}
+	// valid timestamps are unchanged
+	if ts := clampTimestamp(cs, now.Add(2*time.Hour), now); !ts.Equal(now.Add(2 * time.Hour)) {
+		t.Fatalf("expected timestamp to be unchanged, got %v", ts)
+	}
+}
+
+func TestCheckMineable(t *testing.T) {
+	var n consensus.Network
+	n.HardforkV2.AllowHeight = 10
+	n.HardforkV2.RequireHeight = 20
+	n.HardforkV2.FinalCutHeight = 30
+	cs := consensus.State{Network: &n}
+	block := types.Block{MinerPayouts: []types.SiacoinOutput{{Value: types.Siacoins(1)}}}
+
+	// templates below the min height are refused
+	cs.Index.Height = 4
+	if err := checkMineable(cs, block, templateOptions{minHeight: 6}); err == nil {
+		t.Fatal("expected error below min height")
+	} else if err := checkMineable(cs, block, templateOptions{minHeight: 5}); err != nil {
+		t.Fatal(err)
+	}
+
+	// blocks paying nothing are refused until the final cut allows v2
+	// blocks to omit the payout value
+	zero := types.Block{MinerPayouts: []types.SiacoinOutput{{}}}
+	if err := checkMineable(cs, zero, templateOptions{}); err == nil {
+		t.Fatal("expected error for zero payout")
+	}
+	cs.Index.Height = 30
+	zero.V2 = &types.V2BlockData{}
+	if err := checkMineable(cs, zero, templateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// networks requiring v2 blocks before allowing them can't be mined in
+	// between, unless the version is forced
+	n.HardforkV2.AllowHeight = 25
+	cs.Index.Height = 22
+	if err := checkMineable(cs, block, templateOptions{}); err == nil {
+		t.Fatal("expected error between the require and allow heights")
+	} else if err := checkMineable(cs, block, templateOptions{version: 2}); err != nil {
+		t.Fatal(err)
+	}
+	cs.Index.Height = 25
+	if err := checkMineable(cs, block, templateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFeeBreakdown(t *testing.T) {
+	b := types.Block{
+		Transactions: []types.Transaction{
+			{MinerFees: []types.Currency{types.Siacoins(1)}},
+			{MinerFees: []types.Currency{types.Siacoins(2)}, FileContracts: []types.FileContract{{}}},
+		},
+		V2: &types.V2BlockData{
+			Transactions: []types.V2Transaction{
+				{MinerFee: types.Siacoins(3)},
+				{MinerFee: types.Siacoins(4), FileContractResolutions: []types.V2FileContractResolution{{}}},
+				{MinerFee: types.Siacoins(5)},
+			},
+		},
+	}
+
+	fb := feeBreakdown(b)
+	switch {
+	case fb.V1.Transactions != 2 || !fb.V1.Fees.Equals(types.Siacoins(3)):
+		t.Fatalf("unexpected v1 breakdown: %+v", fb.V1)
+	case fb.V2.Transactions != 3 || !fb.V2.Fees.Equals(types.Siacoins(12)):
+		t.Fatalf("unexpected v2 breakdown: %+v", fb.V2)
+	case fb.FileContracts.Transactions != 2 || !fb.FileContracts.Fees.Equals(types.Siacoins(6)):
+		t.Fatalf("unexpected file contract breakdown: %+v", fb.FileContracts)
+	}
+}
+
+func TestSelectPackages(t *testing.T) {
+	var cs consensus.State
+
+	// a 3-deep CPFP chain where only the child pays a meaningful fee
+	grandparent := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Value: types.Siacoins(1)}},
+		MinerFees:      []types.Currency{types.NewCurrency64(1)},
+	}
+	parent := types.Transaction{
+		SiacoinInputs:  []types.SiacoinInput{{ParentID: grandparent.SiacoinOutputID(0)}},
+		SiacoinOutputs: []types.SiacoinOutput{{Value: types.Siacoins(1)}},
+		MinerFees:      []types.Currency{types.NewCurrency64(1)},
+	}
+	child := types.Transaction{
+		SiacoinInputs:  []types.SiacoinInput{{ParentID: parent.SiacoinOutputID(0)}},
+		SiacoinOutputs: []types.SiacoinOutput{{Value: types.Siacoins(1)}},
+		MinerFees:      []types.Currency{types.Siacoins(1)},
+	}
+	// unrelated transactions paying a higher fee than the parents, but a
+	// lower fee rate than the package
+	unrelated := func() types.Transaction {
+		return types.Transaction{
+			SiacoinInputs:  []types.SiacoinInput{{ParentID: frand.Entropy256()}},
+			SiacoinOutputs: []types.SiacoinOutput{{Value: types.Siacoins(1)}},
+			MinerFees:      []types.Currency{types.Siacoins(1).Div64(10)},
+		}
+	}
+	txns := []types.Transaction{unrelated(), unrelated(), grandparent, parent, child}
+
+	ptxns := v1PoolTxns(cs, txns)
+	if len(ptxns[3].parents) != 1 || ptxns[3].parents[0] != 2 {
+		t.Fatalf("expected parent to depend on grandparent, got %v", ptxns[3].parents)
+	} else if len(ptxns[4].parents) != 1 || ptxns[4].parents[0] != 3 {
+		t.Fatalf("expected child to depend on parent, got %v", ptxns[4].parents)
+	}
+
+	// with room for 4 transactions, naive selection would pick the unrelated
+	// transactions and leave out the parents, and therefore the child
+	var budget uint64
+	for _, i := range []int{0, 2, 3, 4} {
+		budget += ptxns[i].weight
+	}
+	selected, weight, _ := selectPackages(ptxns, budget, math.MaxInt)
+	if weight != budget {
+		t.Fatalf("expected weight %d, got %d", budget, weight)
+	} else if len(selected) != 4 || selected[0] != 0 || selected[1] != 2 || selected[2] != 3 || selected[3] != 4 {
+		t.Fatalf("expected the package and one unrelated transaction, got %v", selected)
+	}
+
+	// without room for the whole package, the child is skipped
+	budget = ptxns[2].weight + ptxns[3].weight + ptxns[4].weight - 1
+	selected, weight, _ = selectPackages(ptxns, budget, math.MaxInt)
+	if weight > budget {
+		t.Fatalf("expected weight of at most %d, got %d", budget, weight)
+	}
+	for _, i := range selected {
+		if i == 4 {
+			t.Fatal("expected child to be skipped")
+		}
+	}
+}
+
+// naiveSelectPackages is a reference implementation of selectPackages that
+// re-evaluates every package after each selection.
+func naiveSelectPackages(txns []poolTxn, budget uint64, maxTxns int) (selected []int, weight uint64, limited bool) {
+	ancestors := packageAncestors(txns)
+	included := make([]bool, len(txns))
+	selected, weight, limited = selectPinnedPackages(txns, ancestors, included, budget, maxTxns)
+	count := len(selected)
+	skipped := make([]bool, len(txns))
+	for {
+		best := txnPackage{index: -1}
+		for i, txn := range txns {
+			if included[i] || skipped[i] {
+				continue
+			}
+			p := txnPackage{index: i, fee: txn.fee, weight: txn.weight, count: 1}
+			for _, a := range ancestors[i] {
+				if !included[a] {
+					p.fee, p.weight, p.count = p.fee.Add(txns[a].fee), p.weight+txns[a].weight, p.count+1
+				}
+			}
+			if best.index == -1 || p.higherFeeRate(best) {
+				best = p
+			}
+		}
+		if best.index == -1 {
+			break
+		} else if weight+best.weight > budget {
+			skipped[best.index] = true
+			continue
+		} else if count+best.count > maxTxns {
+			skipped[best.index] = true
+			limited = true
+			continue
+		}
+		for _, a := range ancestors[best.index] {
+			included[a] = true
+		}
+		included[best.index] = true
+		weight += best.weight
+		count += best.count
+	}
+	pinned := len(selected)
+	for i := range txns {
+		if included[i] && !slices.Contains(selected[:pinned], i) {
+			selected = append(selected, i)
+		}
+	}
+	return selected, weight, limited
+}
+
+func TestSelectPackagesReference(t *testing.T) {
+	for range 100 {
+		txns := make([]poolTxn, frand.Intn(50))
+		for i := range txns {
+			txns[i] = poolTxn{
+				weight: 1 + uint64(frand.Intn(100)),
+				fee:    types.NewCurrency64(uint64(frand.Intn(1000))),
+				pinned: frand.Intn(20) == 0,
+			}
+			for j := 0; j < i && len(txns[i].parents) < 3; j++ {
+				if frand.Intn(10) == 0 {
+					txns[i].parents = append(txns[i].parents, j)
+				}
+			}
+		}
+		budget, maxTxns := uint64(frand.Intn(2000)), 1+frand.Intn(40)
+		selected, weight, limited := selectPackages(txns, budget, maxTxns)
+		expSelected, expWeight, expLimited := naiveSelectPackages(txns, budget, maxTxns)
+		if !slices.Equal(selected, expSelected) || weight != expWeight || limited != expLimited {
+			t.Fatalf("expected %v (%d, %v), got %v (%d, %v)", expSelected, expWeight, expLimited, selected, weight, limited)
+		}
+	}
+}
+
+func TestHigherFeeRate(t *testing.T) {
+	maxFee := types.NewCurrency(math.MaxUint64, math.MaxUint64)
+	tests := []struct {
+		a, b txnPackage
+		want bool
+	}{
+		{txnPackage{fee: types.NewCurrency64(2), weight: 1}, txnPackage{fee: types.NewCurrency64(1), weight: 1}, true},
+		{txnPackage{fee: types.NewCurrency64(1), weight: 1}, txnPackage{fee: types.NewCurrency64(2), weight: 2}, false},
+		{txnPackage{fee: types.NewCurrency64(3), weight: 2}, txnPackage{fee: types.NewCurrency64(1), weight: 1}, true},
+		// a zero weight is treated as 1
+		{txnPackage{fee: types.NewCurrency64(1), weight: 0}, txnPackage{fee: types.NewCurrency64(1), weight: 1}, false},
+		// the products overflow 128 bits
+		{txnPackage{fee: maxFee, weight: math.MaxUint64}, txnPackage{fee: maxFee.Sub(types.NewCurrency64(1)), weight: math.MaxUint64}, true},
+		{txnPackage{fee: maxFee, weight: math.MaxUint64}, txnPackage{fee: maxFee, weight: math.MaxUint64 - 1}, false},
+		{txnPackage{fee: maxFee, weight: math.MaxUint64 - 1}, txnPackage{fee: maxFee, weight: math.MaxUint64}, true},
+	}
+	for i, tt := range tests {
+		if got := tt.a.higherFeeRate(tt.b); got != tt.want {
+			t.Errorf("%d: expected %v, got %v", i, tt.want, got)
+		}
+	}
+}
+
+func TestSelectPackagesPinned(t *testing.T) {
+	// two unrelated transactions paying a high fee and a low fee package
+	txns := []poolTxn{
+		{weight: 100, fee: types.Siacoins(1)},
+		{weight: 100, fee: types.Siacoins(1)},
+		{weight: 100, fee: types.NewCurrency64(1)},
+		{weight: 100, fee: types.NewCurrency64(1), parents: []int{2}, pinned: true},
+	}
+
+	// the pinned package is selected first even though it pays the lowest
+	// fee, dropping the other transactions
+	selected, weight, _ := selectPackages(txns, 200, math.MaxInt)
+	if weight != 200 {
+		t.Fatalf("expected weight 200, got %d", weight)
+	} else if len(selected) != 2 || selected[0] != 2 || selected[1] != 3 {
+		t.Fatalf("expected the pinned package, got %v", selected)
+	}
+
+	// the pinned package is placed before the other transactions
+	selected, _, _ = selectPackages(txns, 300, math.MaxInt)
+	if len(selected) != 3 || selected[0] != 2 || selected[1] != 3 || selected[2] != 0 {
+		t.Fatalf("expected the pinned package followed by a high fee transaction, got %v", selected)
+	}
+
+	// pinned packages that don't fit are skipped
+	selected, _, _ = selectPackages(txns, 150, math.MaxInt)
+	if len(selected) != 1 || selected[0] != 0 {
+		t.Fatalf("expected a high fee transaction, got %v", selected)
+	} else if _, reserved := selectPinned(txns, 150, math.MaxInt); reserved != 0 {
+		t.Fatalf("expected no reserved weight, got %d", reserved)
+	} else if _, reserved := selectPinned(txns, 200, math.MaxInt); reserved != 200 {
+		t.Fatalf("expected 200 reserved weight, got %d", reserved)
+	}
+}
+
+func TestSelectPackagesMaxTxns(t *testing.T) {
+	// a high fee package of two transactions, two transactions paying a
+	// medium fee and a low fee transaction
+	txns := []poolTxn{
+		{weight: 100, fee: types.NewCurrency64(1)},
+		{weight: 100, fee: types.Siacoins(1)},
+		{weight: 100, fee: types.Siacoins(1)},
+		{weight: 100, fee: types.NewCurrency64(1)},
+		{weight: 100, fee: types.Siacoins(10), parents: []int{3}},
+	}
+
+	// without a limit, everything fits
+	selected, _, limited := selectPackages(txns, 500, math.MaxInt)
+	if len(selected) != 5 {
+		t.Fatalf("expected all transactions, got %v", selected)
+	} else if limited {
+		t.Fatal("expected no limit")
+	}
+
+	// the highest fee rate transactions are kept
+	selected, weight, limited := selectPackages(txns, 500, 3)
+	if len(selected) != 3 || selected[0] != 1 || selected[1] != 3 || selected[2] != 4 {
+		t.Fatalf("expected the package and a medium fee transaction, got %v", selected)
+	} else if weight != 300 {
+		t.Fatalf("expected weight 300, got %d", weight)
+	} else if !limited {
+		t.Fatal("expected the limit to be reached")
+	}
+
+	// a package is only selected as a whole
+	selected, _, limited = selectPackages(txns, 500, 2)
+	if len(selected) != 2 || selected[0] != 3 || selected[1] != 4 {
+		t.Fatalf("expected the package, got %v", selected)
+	} else if !limited {
+		t.Fatal("expected the limit to be reached")
+	}
+
+	// both limits are enforced, and the limit isn't reported if the weight
+	// is the binding constraint
+	selected, _, limited = selectPackages(txns, 200, 3)
+	if len(selected) != 2 || selected[0] != 3 || selected[1] != 4 {
+		t.Fatalf("expected the package, got %v", selected)
+	} else if limited {
+		t.Fatal("expected the weight to be the binding constraint")
+	}
+
+	// pinned transactions count towards the limit
+	txns[0].pinned = true
+	selected, _, _ = selectPackages(txns, 500, 2)
+	if len(selected) != 2 || selected[0] != 0 || selected[1] != 1 {
+		t.Fatalf("expected the pinned transaction and a medium fee transaction, got %v", selected)
+	} else if reserved, _ := selectPinned(txns, 500, 0); len(reserved) != 0 {
+		t.Fatalf("expected no reserved transactions, got %v", reserved)
+	}
+}
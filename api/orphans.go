@@ -0,0 +1,80 @@
+package api
+
+import (
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// submittedBlockRetention is the duration for which blocks submitted through
+// the API are remembered to detect whether they were orphaned.
+const submittedBlockRetention = 24 * time.Hour
+
+// recordSubmittedBlock remembers a block that was accepted through the API so
+// it can be counted if it is reorged out later.
+func (s *server) recordSubmittedBlock(id types.BlockID) {
+	s.orphansMu.Lock()
+	defer s.orphansMu.Unlock()
+
+	for bid, submitted := range s.submittedBlocks {
+		if time.Since(submitted) > submittedBlockRetention {
+			delete(s.submittedBlocks, bid)
+		}
+	}
+	s.submittedBlocks[id] = time.Now()
+}
+
+// trackOrphans counts the blocks that were reverted since the last call and
+// logs the tip change. It is called after every reorg.
+func (s *server) trackOrphans() {
+	s.orphansMu.Lock()
+	defer s.orphansMu.Unlock()
+
+	oldTip := s.orphansTip
+	var reverted, applied int
+	defer func() {
+		s.logTipChange(oldTip, s.orphansTip, reverted, applied)
+	}()
+
+	for s.orphansTip != s.cm.Tip() {
+		reverts, applies, err := s.cm.UpdatesSince(s.orphansTip, 100)
+		if err != nil {
+			s.log.Warn("failed to get chain updates", zap.Stringer("index", s.orphansTip), zap.Error(err))
+			return
+		} else if len(reverts) == 0 && len(applies) == 0 {
+			return
+		}
+		reverted += len(reverts)
+		applied += len(applies)
+		for _, cru := range reverts {
+			s.stats.orphanedBlocks.Add(1)
+			id := cru.Block.ID()
+			if _, ok := s.submittedBlocks[id]; ok {
+				s.stats.submittedBlocksOrphaned.Add(1)
+				delete(s.submittedBlocks, id)
+			}
+			s.orphansTip = cru.State.Index
+		}
+		for _, cau := range applies {
+			s.orphansTip = cau.State.Index
+		}
+	}
+}
+
+// logTipChange logs a change of the tip. Reorgs reverting at least
+// reorgLogMinDepth blocks are logged at info level, all other tip changes at
+// debug level.
+func (s *server) logTipChange(oldTip, newTip types.ChainIndex, reverted, applied int) {
+	if oldTip == newTip {
+		return
+	}
+	level, msg := zap.DebugLevel, "new tip"
+	if reverted > 0 {
+		msg = "reorg"
+	}
+	if uint64(reverted) >= s.reorgLogMinDepth {
+		level = zap.InfoLevel
+	}
+	s.log.Log(level, msg, zap.Stringer("oldTip", oldTip), zap.Stringer("newTip", newTip), zap.Int("reverted", reverted), zap.Int("applied", applied))
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"go.sia.tech/core/gateway"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// relayPeerTimeout is the timeout for connecting to a relay peer and for
+// sending it a block outline.
+const relayPeerTimeout = 10 * time.Second
+
+// relayBlockOutline sends a block outline to each relay peer, connecting to
+// peers that aren't connected yet. The outline is sent in the background and
+// the results are logged.
+func (s *server) relayBlockOutline(id types.BlockID, bo gateway.V2BlockOutline) {
+	peers := s.s.Peers()
+	for _, addr := range s.relayPeers {
+		go func() {
+			log := s.log.With(zap.String("peer", addr), zap.Stringer("block", id))
+			start := time.Now()
+			ctx, cancel := context.WithTimeout(context.Background(), relayPeerTimeout)
+			defer cancel()
+			p, ok := s.relayPeerMatcher.Find(ctx, peers, addr)
+			if !ok {
+				var err error
+				p, err = s.s.Connect(ctx, addr)
+				if err == nil {
+					s.relayPeerMatcher.Connected(addr, p)
+				} else if p, ok = s.relayPeerMatcher.Find(ctx, s.s.Peers(), addr); !ok {
+					// the peer may have connected in the meantime
+					log.Warn("failed to connect to relay peer", zap.Error(err))
+					return
+				}
+			}
+			if err := p.RelayV2BlockOutline(bo, relayPeerTimeout); err != nil {
+				log.Warn("failed to relay block to peer", zap.Error(err))
+				return
+			}
+			log.Info("relayed block to peer", zap.Duration("elapsed", time.Since(start)))
+		}()
+	}
+}
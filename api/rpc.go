@@ -0,0 +1,213 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"go.sia.tech/jape"
+)
+
+// maxRPCBatchSize is the maximum number of requests in a JSON-RPC batch.
+const maxRPCBatchSize = 100
+
+// An rpcMethod maps a JSON-RPC method to a REST handler of the mining API.
+type rpcMethod struct {
+	method  string // HTTP method of the handler
+	handler jape.Handler
+	// body converts the JSON-RPC params to the handler's request body.
+	body func(params json.RawMessage) ([]byte, error)
+}
+
+// rpcResponseWriter captures the response of a REST handler called through
+// /mining/rpc.
+type rpcResponseWriter struct {
+	header http.Header
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *rpcResponseWriter) Header() http.Header         { return w.header }
+func (w *rpcResponseWriter) Write(b []byte) (int, error) { return w.buf.Write(b) }
+func (w *rpcResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// rpcParamsArray returns the positional params of a request. Omitted params
+// are treated as an empty array.
+func rpcParamsArray(params json.RawMessage) ([]json.RawMessage, error) {
+	var arr []json.RawMessage
+	if len(params) == 0 || string(params) == "null" {
+		return nil, nil
+	} else if err := json.Unmarshal(params, &arr); err != nil {
+		return nil, errors.New("params must be an array")
+	}
+	return arr, nil
+}
+
+// rpcNoParams ignores the params of methods that don't take any.
+func rpcNoParams(json.RawMessage) ([]byte, error) { return nil, nil }
+
+// rpcObjectParam passes the request object, which can be given directly or
+// as the first positional param like bitcoind expects, as the request body.
+func rpcObjectParam(params json.RawMessage) ([]byte, error) {
+	if p := bytes.TrimSpace(params); len(p) > 0 && p[0] == '{' {
+		return p, nil
+	}
+	arr, err := rpcParamsArray(params)
+	if err != nil {
+		return nil, err
+	} else if len(arr) == 0 || string(arr[0]) == "null" {
+		return []byte("{}"), nil
+	}
+	return arr[0], nil
+}
+
+// rpcSubmitBlockParams passes the positional params of submitblock as the
+// "params" field of the request body. Only the first param, the block, has to
+// be a string. The optional params clients append after it are ignored.
+func rpcSubmitBlockParams(params json.RawMessage) ([]byte, error) {
+	arr, err := rpcParamsArray(params)
+	if err != nil {
+		return nil, err
+	} else if len(arr) > 0 {
+		var block string
+		if err := json.Unmarshal(arr[0], &block); err != nil {
+			return nil, errors.New("block must be a hex-encoded string")
+		}
+	}
+	return json.Marshal(struct {
+		Params []json.RawMessage `json:"params"`
+	}{arr})
+}
+
+// rpcStringParams passes the positional params as the "params" field of the
+// request body, which is how getwork takes its arguments.
+func rpcStringParams(params json.RawMessage) ([]byte, error) {
+	arr, err := rpcParamsArray(params)
+	if err != nil {
+		return nil, err
+	}
+	strs := make([]string, len(arr))
+	for i := range arr {
+		if err := json.Unmarshal(arr[i], &strs[i]); err != nil {
+			return nil, errors.New("params must be strings")
+		}
+	}
+	return json.Marshal(MiningSubmitBlockRequest{Params: strs})
+}
+
+func (s *server) rpcMethods() map[string]rpcMethod {
+	return map[string]rpcMethod{
+		"getblocktemplate": {http.MethodPost, s.miningGetBlockTemplateHandler, rpcObjectParam},
+		"submitblock":      {http.MethodPost, s.miningSubmitBlockTemplateHandler, rpcSubmitBlockParams},
+		"getwork":          {http.MethodPost, s.miningGetWorkHandler, rpcStringParams},
+		"getmininginfo":    {http.MethodPost, s.miningInfoHandler, rpcNoParams},
+		"getminingstats":   {http.MethodGet, s.miningStatsHandler, rpcNoParams},
+	}
+}
+
+func rpcErrorResponse(id json.RawMessage, code int, msg string) *JSONRPCResponse {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return &JSONRPCResponse{
+		JSONRPC: "2.0",
+		Error:   &JSONRPCError{Code: code, Message: msg},
+		ID:      id,
+	}
+}
+
+// callRPC handles a single JSON-RPC request. It returns nil for
+// notifications.
+func (s *server) callRPC(jc jape.Context, methods map[string]rpcMethod, raw json.RawMessage) *JSONRPCResponse {
+	var req JSONRPCRequest
+	if err := json.Unmarshal(raw, &req); err != nil || req.JSONRPC != "2.0" || req.Method == "" {
+		return rpcErrorResponse(req.ID, JSONRPCInvalidRequest, "invalid request")
+	}
+	resp := s.dispatchRPC(jc, methods, req)
+	if len(req.ID) == 0 {
+		return nil // notification
+	}
+	resp.ID = req.ID
+	return resp
+}
+
+// dispatchRPC calls the REST handler of a JSON-RPC method and wraps its
+// response.
+func (s *server) dispatchRPC(jc jape.Context, methods map[string]rpcMethod, req JSONRPCRequest) *JSONRPCResponse {
+	m, ok := methods[req.Method]
+	if !ok {
+		return rpcErrorResponse(nil, JSONRPCMethodNotFound, "method not found")
+	}
+	body, err := m.body(req.Params)
+	if err != nil {
+		return rpcErrorResponse(nil, JSONRPCInvalidParams, err.Error())
+	}
+	r, err := http.NewRequestWithContext(jc.Request.Context(), m.method, "/"+req.Method, bytes.NewReader(body))
+	if err != nil {
+		return rpcErrorResponse(nil, JSONRPCInternalError, err.Error())
+	}
+	r.Header.Set("Content-Type", "application/json")
+	w := &rpcResponseWriter{header: make(http.Header)}
+	m.handler(jape.Context{ResponseWriter: w, Request: r})
+
+	msg := strings.TrimSpace(w.buf.String())
+	switch {
+	case w.status == 0 || (200 <= w.status && w.status < 300):
+		result := json.RawMessage(bytes.TrimSpace(w.buf.Bytes()))
+		if len(result) == 0 {
+			result = json.RawMessage("null")
+		}
+		return &JSONRPCResponse{JSONRPC: "2.0", Result: result}
+	case req.Method == "submitblock" && (msg == SubmitRejectNotSynced || (w.status != http.StatusInternalServerError && w.status != http.StatusServiceUnavailable)):
+		// BIP 22: rejected blocks are reported as a reason string instead
+		// of an error
+		result, _ := json.Marshal(msg)
+		return &JSONRPCResponse{JSONRPC: "2.0", Result: result}
+	case w.status == http.StatusBadRequest:
+		return rpcErrorResponse(nil, JSONRPCInvalidParams, msg)
+	default:
+		return rpcErrorResponse(nil, JSONRPCInternalError, msg)
+	}
+}
+
+// miningRPCHandler serves the mining API as JSON-RPC 2.0, including batch
+// requests.
+func (s *server) miningRPCHandler(jc jape.Context) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(jc.Request.Body).Decode(&raw); err != nil {
+		jc.Encode(rpcErrorResponse(nil, JSONRPCParseError, "parse error"))
+		return
+	}
+	methods := s.rpcMethods()
+
+	if raw = bytes.TrimSpace(raw); raw[0] != '[' {
+		if resp := s.callRPC(jc, methods, raw); resp != nil {
+			jc.Encode(resp)
+		}
+		return
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(raw, &batch); err != nil || len(batch) == 0 {
+		jc.Encode(rpcErrorResponse(nil, JSONRPCInvalidRequest, "invalid request"))
+		return
+	} else if len(batch) > maxRPCBatchSize {
+		jc.Encode(rpcErrorResponse(nil, JSONRPCInvalidRequest, "batch too large"))
+		return
+	}
+	resps := make([]*JSONRPCResponse, 0, len(batch))
+	for _, req := range batch {
+		if resp := s.callRPC(jc, methods, req); resp != nil {
+			resps = append(resps, resp)
+		}
+	}
+	if len(resps) > 0 {
+		jc.Encode(resps)
+	}
+}
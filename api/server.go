@@ -4,20 +4,79 @@ import (
 	"context"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/http/pprof"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.sia.tech/jape"
 	"go.uber.org/zap"
+	"lukechampine.com/frand"
 
 	"go.sia.tech/core/consensus"
 	"go.sia.tech/core/gateway"
 	"go.sia.tech/core/types"
 	"go.sia.tech/coreutils/chain"
 	"go.sia.tech/coreutils/syncer"
+	"go.sia.tech/minerd/internal/syncerutil"
 )
 
+// maxBenchTemplateDuration is the longest a template benchmark can run for.
+const maxBenchTemplateDuration = time.Minute
+
+// tipLongPollTimeout is the maximum duration /mining/waitfortip waits for the
+// tip to change before returning the unchanged tip.
+const tipLongPollTimeout = 30 * time.Second
+
+// defaultUpdatesLimit and maxUpdatesLimit are the default and maximum number
+// of updates returned by /mining/updates.
+const (
+	defaultUpdatesLimit = 10
+	maxUpdatesLimit     = 100
+)
+
+// defaultDifficultyCount and maxDifficultyCount are the default and maximum
+// number of blocks returned by /mining/difficulty.
+const (
+	defaultDifficultyCount = 100
+	maxDifficultyCount     = 10000
+)
+
+// infoSnapshotRetryInterval is the interval at which /mining/info retries if
+// the cached template doesn't build on the current tip.
+const infoSnapshotRetryInterval = 50 * time.Millisecond
+
+// minRequestMaxTemplateAge is the lowest max template age a long polling
+// request can ask for. Template timestamps have a resolution of one second, so
+// shorter ages would expire templates immediately.
+const minRequestMaxTemplateAge = time.Second
+
+// defaultBlockIntervals and maxBlockIntervals are the default and maximum
+// number of blocks /mining/blockintervals returns intervals for.
+const (
+	defaultBlockIntervals = 100
+	maxBlockIntervals     = 10000
+)
+
+// syncedMaxTipAge is the maximum age of the tip block for the node to be
+// considered synced.
+const syncedMaxTipAge = 3 * time.Hour
+
+// defaultAddBlockTimeout is the default maximum duration submit requests
+// wait for a block to be validated. Validating a block, including any reorg
+// it causes, normally takes far less.
+const defaultAddBlockTimeout = 2 * time.Minute
+
+// errAddBlockTimeout is returned if a submitted block is still being validated
+// when the submit request times out.
+var errAddBlockTimeout = errors.New("timed out waiting for the block to be validated, it is still being processed")
+
 // A ServerOption sets an optional parameter for the server.
 type ServerOption func(*server)
 
@@ -35,6 +94,80 @@ func WithBasicAuth(password string) ServerOption {
 	}
 }
 
+// WithBasicAuthFunc sets a function that is called to determine the password
+// for basic authentication. It is called for every request, so the password
+// can be rotated without restarting the server. Requests that were already
+// authenticated, e.g. long polling requests, are not affected by a rotation.
+// It takes precedence over WithBasicAuth.
+func WithBasicAuthFunc(fn func() string) ServerOption {
+	return func(s *server) {
+		s.passwordFn = fn
+	}
+}
+
+// WithDebug enables the debug endpoints.
+func WithDebug() ServerOption {
+	return func(s *server) {
+		s.debugEnabled = true
+	}
+}
+
+// WithPublicEndpoints disables authentication on endpoints that are safe to
+// expose publicly, e.g. /mining/network.
+func WithPublicEndpoints(public bool) ServerOption {
+	return func(s *server) {
+		s.publicEndpoints = public
+	}
+}
+
+// WithAllowedCIDRs restricts the API to requests from the given networks.
+// Requests from other addresses are rejected before authentication. If
+// empty, requests from all addresses are allowed.
+func WithAllowedCIDRs(prefixes []netip.Prefix) ServerOption {
+	return func(s *server) {
+		s.allowedCIDRs = prefixes
+	}
+}
+
+// WithTrustedProxies sets the networks of reverse proxies whose
+// X-Forwarded-For header is used to determine the client address when
+// checking the allowed CIDRs.
+func WithTrustedProxies(prefixes []netip.Prefix) ServerOption {
+	return func(s *server) {
+		s.trustedProxies = prefixes
+	}
+}
+
+// WithStartupGracePeriod makes getblocktemplate return a 503 until the
+// server has been running for the given duration or the node is connected to
+// at least one peer. This prevents miners from building on a stale tip right
+// after startup.
+func WithStartupGracePeriod(d time.Duration) ServerOption {
+	return func(s *server) {
+		s.startupGracePeriod = d
+	}
+}
+
+// WithRequireTransactions makes getblocktemplate wait until the template
+// contains at least one transaction paying a fee. If no such transaction
+// arrives within maxWait, the template is served anyway. This is mostly useful
+// for tests that assert transaction inclusion.
+func WithRequireTransactions(maxWait time.Duration) ServerOption {
+	return func(s *server) {
+		s.requireTransactions = true
+		s.requireTransactionsMaxWait = maxWait
+	}
+}
+
+// WithRejectUnsyncedBlocks rejects submitted blocks while the node isn't
+// synced, i.e. not connected to peers or its tip is older than a few hours.
+// Such blocks are likely to be orphaned once the node catches up.
+func WithRejectUnsyncedBlocks() ServerOption {
+	return func(s *server) {
+		s.rejectUnsyncedBlocks = true
+	}
+}
+
 // WithMaxTemplateAge sets the maximum age of the cached block template before
 // it gets invalidated.
 func WithMaxTemplateAge(maxAge time.Duration) ServerOption {
@@ -43,158 +176,1466 @@ func WithMaxTemplateAge(maxAge time.Duration) ServerOption {
 	}
 }
 
+// WithMaxTemplateAgeCeiling sets the highest max template age requests may
+// ask for to override the server's max template age. Higher values are
+// clamped. If zero, requests can only shorten the max template age.
+func WithMaxTemplateAgeCeiling(ceiling time.Duration) ServerOption {
+	return func(s *server) {
+		s.maxTemplateAgeCeiling = ceiling
+	}
+}
+
+// WithMaxTemplateTxns caps the number of pool transactions included in block
+// templates, independent of the block weight. The transactions with the
+// highest fee rate are kept. If zero, there is no limit.
+func WithMaxTemplateTxns(n int) ServerOption {
+	if n < 0 {
+		panic("max template transactions must not be negative") // developer error
+	}
+	return func(s *server) {
+		s.maxTemplateTxns = n
+	}
+}
+
+// WithNonceRangeSize sets the number of nonces allocated to a worker by
+// /mining/allocaterange.
+func WithNonceRangeSize(size uint64) ServerOption {
+	if size == 0 {
+		panic("nonce range size must be greater than zero") // developer error
+	}
+	return func(s *server) {
+		s.nonceRangeSize = size
+	}
+}
+
+// WithReorgDebounce coalesces template invalidations caused by reorgs within
+// the given window. The first reorg invalidates the template immediately,
+// further reorgs within the window cause a single invalidation once it
+// expires. This reduces churn from rapid reorg flaps while ensuring the final
+// template builds on the settled tip. A window of 0 disables debouncing.
+func WithReorgDebounce(d time.Duration) ServerOption {
+	return func(s *server) {
+		s.reorgDebounce = d
+	}
+}
+
+// WithReorgLogMinDepth sets the minimum number of reverted blocks for a reorg
+// to be logged at info level. Smaller reorgs are logged at debug level. A
+// depth of 0 logs every tip change, including blocks extending the tip, at
+// info level. The default is 1, which logs every reorg.
+func WithReorgLogMinDepth(depth uint64) ServerOption {
+	return func(s *server) {
+		s.reorgLogMinDepth = depth
+	}
+}
+
+// WithEmptyBlocks makes block templates skip transaction selection. Templates
+// only contain the miner payout and the coinbase flags marker regardless of
+// the pool, which minimizes their generation time and size, e.g. to measure
+// block propagation latency.
+func WithEmptyBlocks() ServerOption {
+	return func(s *server) {
+		s.emptyBlocks = true
+	}
+}
+
+// WithTemplateWatchdog invalidates the cached template if it still builds on
+// a previous tip after the given threshold, guarding against missed reorg
+// notifications leaving miners on an old template. The threshold should be
+// longer than the reorg debounce window. A threshold of 0 disables the
+// watchdog.
+func WithTemplateWatchdog(threshold time.Duration) ServerOption {
+	return func(s *server) {
+		s.templateWatchdog = threshold
+	}
+}
+
+// WithDifficultyBounds sets the range of difficulties submitted blocks may be
+// mined at. Blocks extending the tip are rejected before being added to the
+// chain if the tip's difficulty is outside the range. This guards against
+// misconfigured custom networks. If min is zero, it defaults to the network's
+// initial difficulty divided by defaultMinDifficultyDivisor. If max is zero,
+// there is no upper bound.
+func WithDifficultyBounds(min, max consensus.Work) ServerOption {
+	return func(s *server) {
+		s.minDifficulty, s.maxDifficulty = min, max
+	}
+}
+
+// WithSlowTemplateThreshold sets the duration after which generating a block
+// template is logged as a warning. A threshold of 0 disables the warning.
+func WithSlowTemplateThreshold(d time.Duration) ServerOption {
+	return func(s *server) {
+		s.slowTemplateThreshold = d
+	}
+}
+
+// WithLongPollJitter sets the fraction of the max template age by which the
+// expiry of long polling requests is randomly shifted. This staggers the
+// wakeups of clients that started long polling at the same time. A value of
+// 0.1 spreads the expiry over ±10% of the max template age.
+func WithLongPollJitter(fraction float64) ServerOption {
+	return func(s *server) {
+		s.longPollJitter = fraction
+	}
+}
+
+// WithAddBlockTimeout sets the maximum duration a submitted block is validated
+// for before the request returns an error. The block is still processed once
+// validation completes. A timeout of 0 disables it.
+func WithAddBlockTimeout(d time.Duration) ServerOption {
+	return func(s *server) {
+		s.addBlockTimeout = d
+	}
+}
+
+// WithSubmitBlockWaitTimeout sets the maximum duration a submitblock request
+// waits for the submitted block to become the tip if waiting was requested.
+func WithSubmitBlockWaitTimeout(d time.Duration) ServerOption {
+	return func(s *server) {
+		s.submitBlockWaitTimeout = d
+	}
+}
+
+// WithForceBlockVersion forces the server to generate and decode v1 or v2
+// blocks regardless of the current height. This is only intended for testing
+// the v2 transition on custom networks since blocks with the wrong version are
+// rejected by consensus.
+func WithForceBlockVersion(version uint32) ServerOption {
+	if version != 1 && version != 2 {
+		panic(fmt.Sprintf("invalid block version %d", version)) // developer error
+	}
+	return func(s *server) {
+		s.forceBlockVersion = version
+	}
+}
+
+// WithMinMiningHeight refuses to generate block templates below the given
+// height, e.g. on custom networks where mining earlier blocks is pointless.
+// Submitted blocks are not affected.
+func WithMinMiningHeight(height uint64) ServerOption {
+	return func(s *server) {
+		s.minMiningHeight = height
+	}
+}
+
+// WithPayoutAddressFunc sets a function that is called to determine the payout
+// address whenever a new block template is generated. It takes precedence over
+// the static payout address passed to NewServer.
+func WithPayoutAddressFunc(fn func() (types.Address, error)) ServerOption {
+	return func(s *server) {
+		s.payoutAddrFn = fn
+	}
+}
+
+// WithIndexedTipFunc sets a function returning the last block indexed by the
+// wallet indexer. If set, /mining/stats reports how far the indexer lags
+// behind the chain tip.
+func WithIndexedTipFunc(fn func() (types.ChainIndex, error)) ServerOption {
+	return func(s *server) {
+		s.indexedTipFn = fn
+	}
+}
+
+// WithoutTemplateCache disables caching of block templates. Every call to
+// getblocktemplate generates a fresh template and long polling requests return
+// immediately. This is mostly useful for tests that require deterministic
+// behavior.
+func WithoutTemplateCache() ServerOption {
+	return func(s *server) {
+		s.templateCacheDisabled = true
+	}
+}
+
+// WithCoinbaseFlags adds a marker, e.g. "/minerd/", to the arbitrary data of
+// generated block templates so blocks mined from them can be identified. The
+// marker is placed in a transaction of its own at the start of the block. It
+// must not be longer than MaxCoinbaseFlagsSize.
+func WithCoinbaseFlags(flags string) ServerOption {
+	return func(s *server) {
+		s.coinbaseFlags = []byte(flags)
+	}
+}
+
+// WithPinnedTimestamp sets the timestamp of generated block templates to the
+// timestamp of the parent block plus the given offset instead of the current
+// time. This makes templates deterministic for a given chain which is useful
+// for generating test vectors. Since the timestamp no longer advances, the max
+// template age is ignored.
+func WithPinnedTimestamp(offset time.Duration) ServerOption {
+	return func(s *server) {
+		s.timestampPinned = true
+		s.timestampOffset = offset
+	}
+}
+
+// WithFullBlockOutlines makes the outlines of submitted v2 blocks include
+// every transaction of the block in full. By default, transactions that are in
+// the node's pool are only referenced by their hash since peers usually have
+// them already, which reveals which of the block's transactions the node knew
+// about. Full outlines don't depend on the pool at the cost of bandwidth.
+func WithFullBlockOutlines() ServerOption {
+	return func(s *server) {
+		s.fullBlockOutlines = true
+	}
+}
+
+// WithAcceptKnownBlocks makes /mining/submitblock report blocks the node
+// already has as a success with the result "duplicate" instead of failing,
+// e.g. for pools with redundant workers resubmitting the same block.
+func WithAcceptKnownBlocks() ServerOption {
+	return func(s *server) {
+		s.acceptKnownBlocks = true
+	}
+}
+
+// WithRelayPeers sets peers that submitted v2 blocks are sent to directly, in
+// addition to the broadcast to all connected peers. Peers that aren't
+// connected are connected to first.
+func WithRelayPeers(addrs []string) ServerOption {
+	return func(s *server) {
+		s.relayPeers = addrs
+	}
+}
+
+// WithJSONRPC serves the mining methods as JSON-RPC 2.0 at /mining/rpc for
+// mining software that doesn't support the REST endpoints.
+func WithJSONRPC() ServerOption {
+	return func(s *server) {
+		s.jsonRPC = true
+	}
+}
+
 type (
 	// A ChainManager manages blockchain and txpool state.
 	ChainManager interface {
 		UpdatesSince(types.ChainIndex, int) ([]chain.RevertUpdate, []chain.ApplyUpdate, error)
 
-		Tip() types.ChainIndex
-		BestIndex(height uint64) (types.ChainIndex, bool)
-		Block(id types.BlockID) (types.Block, bool)
-		TipState() consensus.State
-		AddBlocks([]types.Block) error
-		RecommendedFee() types.Currency
-		PoolTransactions() []types.Transaction
-		V2PoolTransactions() []types.V2Transaction
-		AddPoolTransactions(txns []types.Transaction) (bool, error)
-		AddV2PoolTransactions(index types.ChainIndex, txns []types.V2Transaction) (bool, error)
-		OnPoolChange(fn func()) (cancel func())
-		OnReorg(fn func(types.ChainIndex)) (cancel func())
-		UnconfirmedParents(txn types.Transaction) []types.Transaction
-		UpdateV2TransactionSet(txns []types.V2Transaction, from types.ChainIndex, to types.ChainIndex) ([]types.V2Transaction, error)
+		Tip() types.ChainIndex
+		BestIndex(height uint64) (types.ChainIndex, bool)
+		Block(id types.BlockID) (types.Block, bool)
+		State(id types.BlockID) (consensus.State, bool)
+		TipState() consensus.State
+		AddBlocks([]types.Block) error
+		RecommendedFee() types.Currency
+		PoolTransactions() []types.Transaction
+		V2PoolTransactions() []types.V2Transaction
+		AddPoolTransactions(txns []types.Transaction) (bool, error)
+		AddV2PoolTransactions(index types.ChainIndex, txns []types.V2Transaction) (bool, error)
+		OnPoolChange(fn func()) (cancel func())
+		OnReorg(fn func(types.ChainIndex)) (cancel func())
+		UnconfirmedParents(txn types.Transaction) []types.Transaction
+		UpdateV2TransactionSet(txns []types.V2Transaction, from types.ChainIndex, to types.ChainIndex) ([]types.V2Transaction, error)
+	}
+
+	// A Syncer can connect to other peers and synchronize the blockchain.
+	Syncer interface {
+		Addr() string
+		Peers() []*syncer.Peer
+		PeerInfo(addr string) (syncer.PeerInfo, error)
+		Connect(ctx context.Context, addr string) (*syncer.Peer, error)
+		BroadcastV2BlockOutline(bo gateway.V2BlockOutline) error
+	}
+)
+
+// serverStats are counters exposed by /mining/stats.
+type serverStats struct {
+	templatesServed     atomic.Uint64
+	templateCacheHits   atomic.Uint64
+	templateCacheMisses atomic.Uint64
+	templatesRefreshed  atomic.Uint64
+	blocksSubmitted     atomic.Uint64
+	blocksAccepted      atomic.Uint64
+	blocksRejected      atomic.Uint64
+	blocksDuplicate     atomic.Uint64
+
+	orphanedBlocks          atomic.Uint64
+	submittedBlocksOrphaned atomic.Uint64
+}
+
+type server struct {
+	startTime               time.Time
+	startupGracePeriod      time.Duration
+	debugEnabled            bool
+	publicEndpoints         bool
+	password                string
+	passwordFn              func() string
+	allowedCIDRs            []netip.Prefix
+	trustedProxies          []netip.Prefix
+	relayPeers              []string
+	relayPeerMatcher        syncerutil.PeerMatcher
+	payoutAddr              types.Address
+	payoutAddrFn            func() (types.Address, error)
+	indexedTipFn            func() (types.ChainIndex, error)
+	poolInvalidationTimeout time.Duration
+	submitBlockWaitTimeout  time.Duration
+	addBlockTimeout         time.Duration
+	fullBlockOutlines       bool // include every transaction of submitted v2 blocks in their outlines
+	jsonRPC                 bool // serve the mining methods as JSON-RPC 2.0 at /rpc
+	acceptKnownBlocks       bool // report submitted blocks the node already has as a success
+	emptyBlocks             bool // generate templates without pool transactions
+	maxTemplateTxns         int  // caps the number of pool transactions in templates if non-zero
+
+	requireTransactions        bool          // hold templates without fee-paying transactions
+	rejectUnsyncedBlocks       bool          // reject submitted blocks while the node isn't synced
+	requireTransactionsMaxWait time.Duration // serve templates without transactions after waiting this long
+
+	templateCacheDisabled     bool
+	timestampPinned           bool          // use the parent's timestamp plus timestampOffset for templates
+	timestampOffset           time.Duration // offset added to the parent's timestamp if timestampPinned is set
+	forceBlockVersion         uint32        // forces v1 or v2 blocks if non-zero
+	minMiningHeight           uint64        // refuses templates below the height if non-zero
+	coinbaseFlags             []byte        // marker added to the arbitrary data of templates if non-empty
+	cachedTemplateMu          sync.Mutex
+	cachedTemplate            *MiningGetBlockTemplateResponse // cached template, set to 'nil' when invalidated
+	cachedTemplatePayoutAddr  types.Address                   // payout address of the cached template
+	reusableTemplate          *MiningGetBlockTemplateResponse // last generated template, refreshed instead of regenerated if reusableTemplateKey still matches
+	reusableTemplateKey       templateKey                     // state reusableTemplate was generated from
+	poolGeneration            atomic.Uint64                   // incremented on every pool change
+	getWorkMu                 sync.Mutex
+	getWorkBlocks             map[types.Hash256]types.Block // unsolved blocks handed out by getwork, by commitment
+	nonceRangeSize            uint64                        // number of nonces allocated per /mining/allocaterange call
+	nonceRangeTemplate        string                        // long poll ID of the template nonce ranges are allocated for
+	nonceRangeNext            uint64                        // start of the next nonce range
+	nonceRangeExhausted       bool                          // set once the whole nonce space of the template is allocated
+	cachedTemplateMaxAge      time.Duration                 // maximum age of the cached template before it is invalidated
+	maxTemplateAgeCeiling     time.Duration                 // highest max template age a request may override cachedTemplateMaxAge with
+	longPollJitter            float64                       // fraction of cachedTemplateMaxAge to randomly shift long polling expiry by
+	slowTemplateThreshold     time.Duration                 // generating a template for longer than this logs a warning
+	minDifficulty             consensus.Work                // submitted blocks must be mined at least at this difficulty, derived from the network if zero
+	maxDifficulty             consensus.Work                // submitted blocks must be mined at most at this difficulty if non-zero
+	cachedTemplateInvalidated chan struct{}                 // closed when the cached template is invalidated
+	lastPoolInvalidate        time.Time                     // last time the template was invalidated due to a pool change
+
+	reorgDebounce      time.Duration
+	reorgDebounceMu    sync.Mutex
+	reorgDebounceTimer *time.Timer // non-nil while reorgs are being coalesced
+	reorgPending       bool        // set if a reorg happened while reorgDebounceTimer was running
+	reorgDebouncing    atomic.Bool // set while reorgDebounceTimer is running, readable without reorgDebounceMu
+	reorgLogMinDepth   uint64      // reorgs reverting fewer blocks are logged at debug level
+
+	// watchdog state, only accessed by the watchdog goroutine
+	templateWatchdog     time.Duration // staleness threshold of the cached template, disabled if zero
+	watchdogStaleSince   time.Time     // when the cached template was first seen building on a previous tip
+	watchdogLockedSince  time.Time     // when the template cache was first seen locked
+	watchdogLockReported bool          // set once a long-held template cache lock was logged
+
+	feeHistogramMu      sync.Mutex
+	feeHistogram        *MiningFeeHistogramResponse
+	feeHistogramExpires time.Time
+
+	excludedTxnsMu sync.Mutex
+	excludedTxns   map[types.TransactionID]bool // transactions that are excluded from templates
+
+	pinnedTxnsMu sync.Mutex
+	pinnedTxns   map[types.TransactionID]bool // transactions that are selected before all others
+
+	stats serverStats
+
+	orphansMu       sync.Mutex
+	orphansTip      types.ChainIndex            // last index processed by trackOrphans
+	submittedBlocks map[types.BlockID]time.Time // blocks accepted through the API and when
+
+	recentBlocks *recentBlocks // recently submitted blocks to reject duplicates
+
+	minerPaused atomic.Bool // set while CPU miners are paused through the API
+
+	log *zap.Logger
+	cm  ChainManager
+	s   Syncer
+}
+
+// A templateKey identifies the state a block template was generated from.
+// Templates generated from the same state select the same transactions.
+type templateKey struct {
+	parent         types.ChainIndex
+	poolGeneration uint64
+	payoutAddr     types.Address
+}
+
+func (s *server) invalidateCachedTemplate() {
+	s.cachedTemplateMu.Lock()
+	s.cachedTemplate = nil
+	s.reusableTemplate = nil
+	if s.cachedTemplateInvalidated != nil {
+		close(s.cachedTemplateInvalidated)
+	}
+	s.cachedTemplateInvalidated = make(chan struct{})
+	s.cachedTemplateMu.Unlock()
+}
+
+func (s *server) miningGetBlockTemplateHandler(jc jape.Context) {
+	var req MiningGetBlockTemplateRequest
+	if jc.Decode(&req) != nil {
+		return
+	} else if req.MaxAge < 0 {
+		jc.Error(fmt.Errorf("max age must not be negative, got %v", req.MaxAge), http.StatusBadRequest)
+		return
+	}
+
+	switch req.Mode {
+	case "", "template":
+	case "proposal":
+		s.miningProposeBlockHandler(jc, req.Data)
+		return
+	default:
+		jc.Error(fmt.Errorf("unknown mode %q", req.Mode), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.templateUnavailable(); err != nil {
+		jc.Error(err, http.StatusServiceUnavailable)
+		return
+	}
+
+	// if transactions are required, templates without fees are held back
+	// until the deadline
+	var requireTxnsChan <-chan time.Time
+	if s.requireTransactions {
+		requireTxnsChan = time.After(s.requireTransactionsMaxWait)
+	}
+
+	serve := func(template MiningGetBlockTemplateResponse) {
+		s.stats.templatesServed.Add(1)
+		if acceptsBinary(jc.Request) {
+			jc.ResponseWriter.Header().Set("Content-Type", binaryContentType)
+			e := types.NewEncoder(jc.ResponseWriter)
+			binaryTemplate(template).EncodeTo(e)
+			e.Flush()
+			return
+		} else if req.Compact {
+			template = compactTemplate(template)
+		}
+		jc.Encode(template)
+	}
+
+	// copies of the template refreshed for a previous request of this client
+	// expire relative to their own timestamp
+	longPollID, refreshedTimestamp, refreshed := parseLongPollID(req.LongPollID)
+
+	for {
+		// get template or generate new one
+		template, invalidateChan, err := s.currentTemplate()
+		if jc.Check("failed to get template", err) != nil {
+			return
+		}
+
+		// wait for a template paying fees if required
+		if requireTxnsChan != nil && template.FeeBreakdown.V1.Fees.IsZero() && template.FeeBreakdown.V2.Fees.IsZero() {
+			select {
+			case <-jc.Request.Context().Done():
+				return
+			case <-invalidateChan:
+			case <-requireTxnsChan:
+				s.log.Debug("serving template without transactions after waiting", zap.Duration("maxWait", s.requireTransactionsMaxWait))
+				requireTxnsChan = nil
+			}
+			continue
+		}
+
+		// if we got a new template, return it
+		if template.LongPollID != longPollID {
+			serve(template)
+			return
+		}
+
+		// otherwise, wait until the template is invalidated again or the
+		// template has reached its maximum age
+		var maxAgeChan <-chan time.Time
+		if maxAge := s.longPollMaxAge(req.MaxAge); maxAge > 0 && !s.timestampPinned {
+			timestamp := template.Timestamp
+			if refreshed {
+				timestamp = refreshedTimestamp
+			}
+			blockMaxTime := time.Unix(int64(timestamp), 0).Add(maxAge + s.maxAgeJitter(maxAge))
+			maxAgeChan = time.After(time.Until(blockMaxTime))
+		}
+
+		select {
+		case <-jc.Request.Context().Done():
+			return
+		case <-invalidateChan:
+			continue
+		case <-maxAgeChan:
+			// once the shared template expired, it is regenerated for
+			// everyone
+			s.cachedTemplateMu.Lock()
+			expired := s.shouldRegenerateTemplate() || s.cachedTemplateBehindTip()
+			s.cachedTemplateMu.Unlock()
+			if expired {
+				continue
+			}
+			// otherwise it only expired for this request, e.g. because of a
+			// shorter max age. Serve a copy with a fresh timestamp without
+			// touching the shared template, so other long polling requests
+			// keep waiting on it.
+			cs := s.cm.TipState()
+			if cs.Index != template.Parent {
+				continue
+			}
+			template = refreshTemplate(template, cs, s.templateTimestamp(cs))
+			template.LongPollID = refreshedLongPollID(longPollID, template.Timestamp)
+			s.stats.templatesRefreshed.Add(1)
+			serve(template)
+			return
+		}
+	}
+}
+
+func (s *server) miningSubmitBlockTemplateHandler(jc jape.Context) {
+	var wait bool
+	if jc.DecodeForm("wait", &wait) != nil {
+		return
+	}
+	var req MiningSubmitBlockRequest
+	if jc.Decode(&req) != nil {
+		return
+	} else if len(req.Params) < 1 {
+		jc.Error(errors.New("expected block hex in request params array"), http.StatusBadRequest)
+		return
+	}
+	block, isV2, err := s.decodeBlock(req.Params[0])
+	if err != nil {
+		jc.Error(err, http.StatusBadRequest)
+		return
+	} else if s.rejectUnsyncedBlocks && !isSynced(len(s.s.Peers()), s.cm.TipState()) {
+		s.stats.blocksSubmitted.Add(1)
+		s.stats.blocksRejected.Add(1)
+		s.log.Warn("rejected block submitted while not synced", zap.Stringer("id", block.ID()))
+		jc.Error(errors.New(SubmitRejectNotSynced), http.StatusServiceUnavailable)
+		return
+	}
+
+	// reject blocks the node already has or that were just submitted, e.g.
+	// by multiple workers solving the same template, without validating
+	// them again
+	_, known := s.cm.Block(block.ID())
+	if known || !s.recentBlocks.Add(block.ID()) {
+		s.stats.blocksSubmitted.Add(1)
+		s.stats.blocksDuplicate.Add(1)
+		// blocks that are still being validated may turn out to be
+		// invalid, so only blocks the node has are reported as a success
+		if known && s.acceptKnownBlocks {
+			jc.Encode(SubmitRejectDuplicate)
+			return
+		}
+		jc.Error(errors.New(SubmitRejectDuplicate), http.StatusConflict)
+		return
+	}
+
+	// snapshot the pool before adding the block since the block's
+	// transactions are removed from it once the block is applied. Only
+	// transactions that were in the pool are omitted from the outline, all
+	// others, e.g. transactions the submitter never broadcast, are included
+	// in full so peers can reconstruct the block. Full outlines include
+	// every transaction, so the pool isn't needed.
+	var poolTxns []types.Transaction
+	var poolV2Txns []types.V2Transaction
+	if isV2 && !s.fullBlockOutlines {
+		poolTxns, poolV2Txns = s.cm.PoolTransactions(), s.cm.V2PoolTransactions()
+	}
+
+	// verify and broadcast block
+	s.stats.blocksSubmitted.Add(1)
+	if err := s.checkDifficulty(block); err != nil {
+		s.recentBlocks.Remove(block.ID())
+		s.stats.blocksRejected.Add(1)
+		jc.Error(err, http.StatusBadRequest)
+		return
+	}
+	err = s.addBlock(jc.Request.Context(), block, func(err error) error {
+		if err != nil {
+			s.recentBlocks.Remove(block.ID())
+			s.stats.blocksRejected.Add(1)
+			return fmt.Errorf("failed to add block to chain manager: %w", err)
+		}
+		s.stats.blocksAccepted.Add(1)
+		s.recordSubmittedBlock(block.ID())
+		if isV2 {
+			// relay peers are sent the block even if the broadcast fails,
+			// e.g. because no other peers are connected
+			outline := gateway.OutlineBlock(block, poolTxns, poolV2Txns)
+			s.relayBlockOutline(block.ID(), outline)
+			if err := s.s.BroadcastV2BlockOutline(outline); err != nil {
+				return fmt.Errorf("failed to broadcast block outline: %w", err)
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errAddBlockTimeout) {
+		jc.Error(err, http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		jc.Error(err, http.StatusInternalServerError)
+		return
+	}
+	if wait {
+		ctx, cancel := context.WithTimeout(jc.Request.Context(), s.submitBlockWaitTimeout)
+		defer cancel()
+		if jc.Check("block was added but didn't become the tip", s.waitForTip(ctx, block.ID())) != nil {
+			return
+		}
+	}
+	jc.Encode(nil)
+}
+
+// isSynced returns true if the node is connected to peers and its tip is
+// recent.
+func isSynced(peers int, cs consensus.State) bool {
+	return peers > 0 && time.Since(cs.PrevTimestamps[0]) < syncedMaxTipAge
+}
+
+// addBlock adds b to the chain manager and returns the result of done, which
+// is called with the result of adding the block. If adding the block takes
+// longer than addBlockTimeout or ctx is canceled first, errAddBlockTimeout is
+// returned instead. The block is still processed in the background and done
+// is called once it completes, so an accepted block is never lost.
+func (s *server) addBlock(ctx context.Context, b types.Block, done func(error) error) error {
+	if s.addBlockTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.addBlockTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	result := make(chan error, 1)
+	go func() { result <- s.cm.AddBlocks([]types.Block{b}) }()
+	select {
+	case err := <-result:
+		return done(err)
+	case <-ctx.Done():
+	}
+
+	log := s.log.With(zap.Stringer("id", b.ID()))
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Warn("block validation is taking unusually long", zap.Duration("timeout", s.addBlockTimeout))
+	}
+	go func() {
+		err := done(<-result)
+		log.Info("finished processing block in the background", zap.Duration("elapsed", time.Since(start)), zap.Error(err))
+	}()
+	return errAddBlockTimeout
+}
+
+// checkDifficulty returns an error if b extends the tip and the tip's
+// difficulty is outside the configured bounds.
+func (s *server) checkDifficulty(b types.Block) error {
+	cs := s.cm.TipState()
+	if b.ParentID != cs.Index.ID {
+		return nil // not mined on the tip, leave it to the chain manager
+	}
+	minDifficulty := s.minDifficulty
+	if minDifficulty == (consensus.Work{}) {
+		minDifficulty = defaultMinDifficulty(cs.Network)
+	}
+	if cs.Difficulty.Cmp(minDifficulty) < 0 {
+		return fmt.Errorf("block difficulty %v is below the minimum of %v, check the network parameters", cs.Difficulty, minDifficulty)
+	} else if s.maxDifficulty != (consensus.Work{}) && cs.Difficulty.Cmp(s.maxDifficulty) > 0 {
+		return fmt.Errorf("block difficulty %v is above the maximum of %v, check the network parameters", cs.Difficulty, s.maxDifficulty)
+	}
+	return nil
+}
+
+// waitForTip blocks until the block with the given id is the tip of the chain
+// manager or the context is canceled.
+func (s *server) waitForTip(ctx context.Context, id types.BlockID) error {
+	return s.waitForTipMatching(ctx, func(tip types.ChainIndex) bool { return tip.ID == id })
+}
+
+// waitForTipMatching blocks until fn returns true for the tip of the chain
+// manager or the context is canceled.
+func (s *server) waitForTipMatching(ctx context.Context, fn func(types.ChainIndex) bool) error {
+	reorgCh := make(chan struct{}, 1)
+	unsubscribe := s.cm.OnReorg(func(types.ChainIndex) {
+		select {
+		case reorgCh <- struct{}{}:
+		default:
+		}
+	})
+	defer unsubscribe()
+
+	for !fn(s.cm.Tip()) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-reorgCh:
+		}
+	}
+	return nil
+}
+
+func (s *server) miningWaitForTipHandler(jc jape.Context) {
+	var req MiningWaitForTipRequest
+	if jc.Decode(&req) != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(jc.Request.Context(), tipLongPollTimeout)
+	defer cancel()
+	// a timeout isn't an error, the client receives the unchanged tip and
+	// polls again
+	_ = s.waitForTipMatching(ctx, func(tip types.ChainIndex) bool { return tip != req.Tip })
+	jc.Encode(s.cm.Tip())
+}
+
+func (s *server) miningUpdatesHandler(jc jape.Context) {
+	var req MiningUpdatesRequest
+	if jc.Decode(&req) != nil {
+		return
+	} else if req.Limit == 0 {
+		req.Limit = defaultUpdatesLimit
+	} else if req.Limit < 0 || req.Limit > maxUpdatesLimit {
+		jc.Error(fmt.Errorf("limit must be between 1 and %d", maxUpdatesLimit), http.StatusBadRequest)
+		return
+	} else if req.Index != (types.ChainIndex{}) {
+		// check the index before waiting so clients holding an unknown or
+		// pruned index are told to resync instead of waiting for a new tip
+		cs, ok := s.cm.State(req.Index.ID)
+		if _, known := s.cm.Block(req.Index.ID); !ok || !known || cs.Index != req.Index {
+			jc.Error(fmt.Errorf("can't get updates since %v, resync from a more recent index: %w", req.Index, chain.ErrMissingBlock), http.StatusGone)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(jc.Request.Context(), tipLongPollTimeout)
+	defer cancel()
+	// a timeout isn't an error, the client receives no updates and polls
+	// again
+	_ = s.waitForTipMatching(ctx, func(tip types.ChainIndex) bool { return tip != req.Index })
+
+	reverted, applied, err := s.cm.UpdatesSince(req.Index, req.Limit)
+	if errors.Is(err, chain.ErrMissingBlock) {
+		// a block on the path was pruned while we were waiting
+		jc.Error(fmt.Errorf("can't get updates since %v, resync from a more recent index: %w", req.Index, err), http.StatusGone)
+		return
+	} else if jc.Check("failed to get updates", err) != nil {
+		return
+	}
+
+	var resp ConsensusUpdatesResponse
+	for _, ru := range reverted {
+		resp.Reverted = append(resp.Reverted, RevertUpdate{
+			Update: ru.RevertUpdate,
+			State:  ru.State,
+			Block:  ru.Block,
+		})
+	}
+	for _, au := range applied {
+		resp.Applied = append(resp.Applied, ApplyUpdate{
+			Update: au.ApplyUpdate,
+			State:  au.State,
+			Block:  au.Block,
+		})
+	}
+	jc.Encode(resp)
+}
+
+func (s *server) miningDifficultyHandler(jc jape.Context) {
+	var req MiningDifficultyRequest
+	if jc.Decode(&req) != nil {
+		return
+	} else if req.Count > maxDifficultyCount {
+		jc.Error(fmt.Errorf("count must not exceed %d", maxDifficultyCount), http.StatusBadRequest)
+		return
+	} else if req.Count == 0 {
+		req.Count = defaultDifficultyCount
+	}
+
+	// walk back from the tip. Each block was mined at the difficulty of its
+	// parent state, so the genesis block is never included.
+	tip := s.cm.Tip()
+	resp := make([]MiningDifficultyEntry, 0, min(req.Count, tip.Height))
+	for height := tip.Height; height > 0 && uint64(len(resp)) < req.Count; height-- {
+		index, ok := s.cm.BestIndex(height)
+		if !ok {
+			jc.Error(fmt.Errorf("no block found at height %d", height), http.StatusNotFound)
+			return
+		}
+		b, ok := s.cm.Block(index.ID)
+		if !ok {
+			jc.Error(fmt.Errorf("block %v not found", index.ID), http.StatusNotFound)
+			return
+		}
+		parent, ok := s.cm.State(b.ParentID)
+		if !ok {
+			jc.Error(fmt.Errorf("state of block %v not found", b.ParentID), http.StatusNotFound)
+			return
+		}
+		resp = append(resp, MiningDifficultyEntry{
+			Height:     height,
+			ID:         index.ID,
+			Timestamp:  b.Timestamp,
+			Difficulty: parent.Difficulty,
+		})
+	}
+	jc.Encode(resp)
+}
+
+func (s *server) miningGetBlockHandler(jc jape.Context) {
+	var req MiningGetBlockRequest
+	if jc.Decode(&req) != nil {
+		return
+	} else if (req.Height == nil) == (req.ID == nil) {
+		jc.Error(errors.New("either height or id must be specified"), http.StatusBadRequest)
+		return
+	}
+
+	var id types.BlockID
+	if req.ID != nil {
+		id = *req.ID
+	} else if tip := s.cm.Tip(); *req.Height > tip.Height {
+		jc.Error(fmt.Errorf("height %d is greater than the current tip height %d", *req.Height, tip.Height), http.StatusNotFound)
+		return
+	} else if index, ok := s.cm.BestIndex(*req.Height); !ok {
+		jc.Error(fmt.Errorf("no block found at height %d", *req.Height), http.StatusNotFound)
+		return
+	} else {
+		id = index.ID
+	}
+
+	block, ok := s.cm.Block(id)
+	if !ok {
+		jc.Error(fmt.Errorf("block %v not found", id), http.StatusNotFound)
+		return
+	}
+	data, err := encodeBlock(block)
+	if jc.Check("failed to encode block", err) != nil {
+		return
+	}
+	jc.Encode(MiningGetBlockResponse{
+		ID:    id,
+		Block: block,
+		Data:  data,
+	})
+}
+
+func (s *server) miningBlockStatusHandler(jc jape.Context) {
+	var req MiningBlockStatusRequest
+	if jc.Decode(&req) != nil {
+		return
+	}
+	jc.Encode(s.blockStatus(req.ID))
+}
+
+// blockStatus returns the status of the block with the specified ID. Blocks
+// whose state can't be found are reported as unknown rather than guessing
+// their height.
+func (s *server) blockStatus(id types.BlockID) (resp MiningBlockStatusResponse) {
+	if _, ok := s.cm.Block(id); !ok {
+		return
+	}
+	// the state after a block is indexed by the block's ID
+	cs, ok := s.cm.State(id)
+	if !ok || cs.Index.ID != id {
+		return
+	}
+	resp.Known = true
+	resp.Height = cs.Index.Height
+	tip := s.cm.Tip()
+	if index, ok := s.cm.BestIndex(resp.Height); ok && index.ID == id {
+		resp.MainChain = true
+		resp.Confirmations = tip.Height - resp.Height + 1
+	} else {
+		resp.Orphaned = true
+	}
+	return
+}
+
+func (s *server) miningGetWorkHandler(jc jape.Context) {
+	var req MiningGetWorkRequest
+	if jc.Decode(&req) != nil {
+		return
+	} else if len(req.Params) > 0 {
+		s.miningSubmitWorkHandler(jc, req.Params[0])
+		return
+	}
+
+	payoutAddr, err := s.payoutAddress()
+	if jc.Check("failed to get payout address", err) != nil {
+		return
+	} else if payoutAddr == types.VoidAddress {
+		jc.Error(errors.New("can't use getwork without specifying a payout address"), http.StatusServiceUnavailable)
+		return
+	}
+	opts := s.templateOptions()
+	b, cs := unsolvedBlock(s.cm, payoutAddr, opts)
+	if b.V2 != nil {
+		jc.Error(errors.New("getwork only supports v1 blocks"), http.StatusBadRequest)
+		return
+	} else if jc.Check("failed to get work", checkMineable(cs, b, opts)) != nil {
+		return
+	}
+	h := b.Header()
+
+	// remember the block so it can be reassembled from the solved header.
+	// Blocks building on a previous tip can't be submitted anymore.
+	s.getWorkMu.Lock()
+	for commitment, wb := range s.getWorkBlocks {
+		if wb.ParentID != b.ParentID {
+			delete(s.getWorkBlocks, commitment)
+		}
+	}
+	s.getWorkBlocks[h.Commitment] = b
+	s.getWorkMu.Unlock()
+
+	jc.Encode(MiningGetWorkResponse{
+		Data:   encodeHeader(h),
+		Target: cs.PoWTarget().String(),
+	})
+}
+
+func (s *server) miningSubmitWorkHandler(jc jape.Context, headerHex string) {
+	rawHeader, err := hex.DecodeString(headerHex)
+	if err != nil {
+		jc.Error(fmt.Errorf("couldn't decode header hex: %w", err), http.StatusBadRequest)
+		return
+	}
+	var h types.BlockHeader
+	dec := types.NewBufDecoder(rawHeader)
+	h.DecodeFrom(dec)
+	if err := dec.Err(); err != nil {
+		jc.Error(fmt.Errorf("couldn't decode header: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	s.getWorkMu.Lock()
+	b, ok := s.getWorkBlocks[h.Commitment]
+	s.getWorkMu.Unlock()
+	if !ok || b.ParentID != h.ParentID || b.ParentID != s.cm.Tip().ID {
+		jc.Error(errors.New("unknown or stale work"), http.StatusBadRequest)
+		return
+	}
+	b.Nonce = h.Nonce
+	b.Timestamp = h.Timestamp
+
+	s.stats.blocksSubmitted.Add(1)
+	if !s.recentBlocks.Add(b.ID()) {
+		s.stats.blocksDuplicate.Add(1)
+		s.log.Debug("duplicate getwork block", zap.Stringer("id", b.ID()))
+		jc.Encode(false)
+		return
+	} else if err := s.checkDifficulty(b); err != nil {
+		s.recentBlocks.Remove(b.ID())
+		s.stats.blocksRejected.Add(1)
+		jc.Error(err, http.StatusBadRequest)
+		return
+	}
+	err = s.addBlock(jc.Request.Context(), b, func(err error) error {
+		if err != nil {
+			s.recentBlocks.Remove(b.ID())
+			s.stats.blocksRejected.Add(1)
+			return err
+		}
+		s.stats.blocksAccepted.Add(1)
+		s.recordSubmittedBlock(b.ID())
+		return nil
+	})
+	if errors.Is(err, errAddBlockTimeout) {
+		jc.Error(err, http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		s.log.Debug("getwork block rejected", zap.Stringer("id", b.ID()), zap.Error(err))
+		jc.Encode(false)
+		return
+	}
+	jc.Encode(true)
+}
+
+func (s *server) miningAllocateRangeHandler(jc jape.Context) {
+	var req MiningAllocateRangeRequest
+	if jc.Decode(&req) != nil {
+		return
+	}
+
+	s.cachedTemplateMu.Lock()
+	defer s.cachedTemplateMu.Unlock()
+
+	// ranges are only allocated for the current template and copies of it
+	// refreshed for a single request, allocations for previous templates
+	// expire once it is replaced
+	longPollID, _, _ := parseLongPollID(req.LongPollID)
+	if s.cachedTemplate == nil || s.cachedTemplate.LongPollID != longPollID {
+		jc.Error(errors.New("unknown or expired template"), http.StatusGone)
+		return
+	} else if s.nonceRangeTemplate != longPollID {
+		s.nonceRangeTemplate = longPollID
+		s.nonceRangeNext = 0
+		s.nonceRangeExhausted = false
+	}
+
+	if s.nonceRangeExhausted {
+		jc.Error(errors.New("nonce space exhausted"), http.StatusServiceUnavailable)
+		return
+	}
+	start := s.nonceRangeNext
+	size := s.nonceRangeSize
+	if start+size < start || start+size == 0 {
+		// the last range is truncated to the end of the nonce space
+		size = -start
+		s.nonceRangeExhausted = true
+	}
+	s.nonceRangeNext = start + size
+	jc.Encode(MiningAllocateRangeResponse{Start: start, Size: size})
+}
+
+func (s *server) miningWillIncludeHandler(jc jape.Context) {
+	var req MiningWillIncludeRequest
+	if jc.Decode(&req) != nil {
+		return
+	}
+	// the payout address doesn't affect which transactions are selected
+	b, cs := unsolvedBlock(s.cm, s.payoutAddr, s.templateOptions())
+	jc.Encode(blockInclusion(cs, b, req.ID))
+}
+
+func (s *server) miningPayoutAddressHandler(jc jape.Context) {
+	s.cachedTemplateMu.Lock()
+	defer s.cachedTemplateMu.Unlock()
+
+	// if a template is cached, return the address it pays out to. Otherwise
+	// return the address the next template will use.
+	addr := s.cachedTemplatePayoutAddr
+	if s.shouldRegenerateTemplate() {
+		var err error
+		addr, err = s.payoutAddress()
+		if jc.Check("failed to get payout address", err) != nil {
+			return
+		}
+	}
+	jc.Encode(MiningPayoutAddressResponse{Address: addr})
+}
+
+func (s *server) miningChainWorkHandler(jc jape.Context) {
+	cs := s.cm.TipState()
+	jc.Encode(MiningChainWorkResponse{
+		Tip:       cs.Index,
+		TotalWork: cs.TotalWork,
+	})
+}
+
+func (s *server) miningBlockIntervalsHandler(jc jape.Context) {
+	var req MiningBlockIntervalsRequest
+	if jc.Decode(&req) != nil {
+		return
+	} else if req.Count < 0 || req.Count > maxBlockIntervals {
+		jc.Error(fmt.Errorf("count must be between 0 and %d", maxBlockIntervals), http.StatusBadRequest)
+		return
+	} else if req.Count == 0 {
+		req.Count = defaultBlockIntervals
+	}
+
+	// walk back from the tip by parent ID, so a reorg while the blocks are
+	// read can't mix blocks of different chains
+	cs := s.cm.TipState()
+	b, ok := s.cm.Block(cs.Index.ID)
+	if !ok {
+		jc.Error(fmt.Errorf("tip block %v not found", cs.Index), http.StatusInternalServerError)
+		return
+	}
+	index := cs.Index
+	intervals := make([]MiningBlockInterval, 0, min(uint64(req.Count), index.Height))
+	for len(intervals) < req.Count && index.Height > 0 {
+		parent, ok := s.cm.Block(b.ParentID)
+		if !ok {
+			jc.Error(fmt.Errorf("block %v not found", b.ParentID), http.StatusInternalServerError)
+			return
+		}
+		intervals = append(intervals, MiningBlockInterval{
+			Index:     index,
+			Timestamp: b.Timestamp,
+			Interval:  b.Timestamp.Sub(parent.Timestamp),
+		})
+		b, index = parent, types.ChainIndex{Height: index.Height - 1, ID: b.ParentID}
+	}
+	slices.Reverse(intervals)
+
+	resp := MiningBlockIntervalsResponse{
+		TargetInterval: cs.BlockInterval(),
+		Intervals:      intervals,
+	}
+	if len(intervals) > 0 {
+		var total time.Duration
+		for _, bi := range intervals {
+			total += bi.Interval
+		}
+		resp.AverageInterval = total / time.Duration(len(intervals))
+	}
+	jc.Encode(resp)
+}
+
+func (s *server) miningNetworkHandler(jc jape.Context) {
+	genesis, ok := s.cm.BestIndex(0)
+	if !ok {
+		jc.Error(errors.New("genesis block not found"), http.StatusInternalServerError)
+		return
+	}
+	n := s.cm.TipState().Network
+	jc.Encode(MiningNetworkResponse{
+		Name:      n.Name,
+		GenesisID: genesis.ID,
+		Hardforks: MiningNetworkHardforks{
+			DevAddr:      n.HardforkDevAddr.Height,
+			Tax:          n.HardforkTax.Height,
+			StorageProof: n.HardforkStorageProof.Height,
+			Oak:          n.HardforkOak.Height,
+			ASIC:         n.HardforkASIC.Height,
+			Foundation:   n.HardforkFoundation.Height,
+			V2Allow:      n.HardforkV2.AllowHeight,
+			V2Require:    n.HardforkV2.RequireHeight,
+			V2FinalCut:   n.HardforkV2.FinalCutHeight,
+		},
+	})
+}
+
+func (s *server) miningStatsHandler(jc jape.Context) {
+	resp := MiningStatsResponse{
+		StartTime:           s.startTime,
+		Uptime:              time.Since(s.startTime),
+		TemplatesServed:     s.stats.templatesServed.Load(),
+		TemplateCacheHits:   s.stats.templateCacheHits.Load(),
+		TemplateCacheMisses: s.stats.templateCacheMisses.Load(),
+		TemplatesRefreshed:  s.stats.templatesRefreshed.Load(),
+		BlocksSubmitted:     s.stats.blocksSubmitted.Load(),
+		BlocksAccepted:      s.stats.blocksAccepted.Load(),
+		BlocksRejected:      s.stats.blocksRejected.Load(),
+		BlocksDuplicate:     s.stats.blocksDuplicate.Load(),
+
+		OrphanedBlocks:          s.stats.orphanedBlocks.Load(),
+		SubmittedBlocksOrphaned: s.stats.submittedBlocksOrphaned.Load(),
+	}
+	if lookups := resp.TemplateCacheHits + resp.TemplateCacheMisses; lookups > 0 {
+		resp.TemplateCacheHitRate = float64(resp.TemplateCacheHits) / float64(lookups)
+	}
+	if s.indexedTipFn != nil {
+		indexed, err := s.indexedTipFn()
+		if jc.Check("failed to get last indexed block", err) != nil {
+			return
+		}
+		var lag uint64
+		if tip := s.cm.Tip(); tip.Height > indexed.Height {
+			lag = tip.Height - indexed.Height
+		}
+		resp.IndexLag = &lag
+	}
+	jc.Encode(resp)
+}
+
+// minerStatus returns the status reported to CPU miners.
+func (s *server) minerStatus() MiningMinerStatusResponse {
+	if s.minerPaused.Load() {
+		return MiningMinerStatusResponse{Status: MinerStatusPaused}
+	}
+	return MiningMinerStatusResponse{Status: MinerStatusRunning}
+}
+
+func (s *server) miningPauseHandler(jc jape.Context) {
+	if !s.minerPaused.Swap(true) {
+		s.log.Info("paused CPU mining")
 	}
+	jc.Encode(s.minerStatus())
+}
 
-	// A Syncer can connect to other peers and synchronize the blockchain.
-	Syncer interface {
-		Addr() string
-		Peers() []*syncer.Peer
-		PeerInfo(addr string) (syncer.PeerInfo, error)
-		Connect(ctx context.Context, addr string) (*syncer.Peer, error)
-		BroadcastV2BlockOutline(bo gateway.V2BlockOutline) error
+func (s *server) miningResumeHandler(jc jape.Context) {
+	if s.minerPaused.Swap(false) {
+		s.log.Info("resumed CPU mining")
 	}
-)
+	jc.Encode(s.minerStatus())
+}
 
-type server struct {
-	startTime               time.Time
-	debugEnabled            bool
-	publicEndpoints         bool
-	password                string
-	payoutAddr              types.Address
-	poolInvalidationTimeout time.Duration
+func (s *server) miningMinerStatusHandler(jc jape.Context) {
+	jc.Encode(s.minerStatus())
+}
 
-	cachedTemplateMu          sync.Mutex
-	cachedTemplate            *MiningGetBlockTemplateResponse // cached template, set to 'nil' when invalidated
-	cachedTemplateMaxAge      time.Duration                   // maximum age of the cached template before it is invalidated
-	cachedTemplateInvalidated chan struct{}                   // closed when the cached template is invalidated
-	lastPoolInvalidate        time.Time                       // last time the template was invalidated due to a pool change
+func (s *server) miningFeeHistogramHandler(jc jape.Context) {
+	s.feeHistogramMu.Lock()
+	defer s.feeHistogramMu.Unlock()
+	if s.feeHistogram == nil || time.Now().After(s.feeHistogramExpires) {
+		histogram := feeHistogram(s.cm)
+		s.feeHistogram = &histogram
+		s.feeHistogramExpires = time.Now().Add(feeHistogramCacheDuration)
+	}
+	jc.Encode(*s.feeHistogram)
+}
 
-	log *zap.Logger
-	cm  ChainManager
-	s   Syncer
+func (s *server) miningConfirmTargetHandler(jc jape.Context) {
+	var req MiningConfirmTargetRequest
+	if jc.Decode(&req) != nil {
+		return
+	}
+	jc.Encode(confirmTarget(s.cm, req.FeeRate))
 }
 
-func (s *server) invalidateCachedTemplate() {
-	s.cachedTemplateMu.Lock()
-	s.cachedTemplate = nil
-	if s.cachedTemplateInvalidated != nil {
-		close(s.cachedTemplateInvalidated)
+func (s *server) miningExcludeTransactionHandler(jc jape.Context) {
+	var req MiningExcludeTransactionRequest
+	if jc.Decode(&req) != nil {
+		return
 	}
-	s.cachedTemplateInvalidated = make(chan struct{})
-	s.cachedTemplateMu.Unlock()
+	s.excludedTxnsMu.Lock()
+	changed := !s.excludedTxns[req.ID]
+	s.excludedTxns[req.ID] = true
+	s.excludedTxnsMu.Unlock()
+	if changed {
+		s.invalidateCachedTemplate()
+	}
+	jc.Encode(nil)
 }
 
-func (s *server) miningGetBlockTemplateHandler(jc jape.Context) {
-	if s.payoutAddr == types.VoidAddress {
-		jc.Error(errors.New("can't use getblocktemplate without specifying a payout address"), http.StatusServiceUnavailable)
+func (s *server) miningIncludeTransactionHandler(jc jape.Context) {
+	var req MiningIncludeTransactionRequest
+	if jc.Decode(&req) != nil {
 		return
 	}
+	s.excludedTxnsMu.Lock()
+	changed := s.excludedTxns[req.ID]
+	delete(s.excludedTxns, req.ID)
+	s.excludedTxnsMu.Unlock()
+	if changed {
+		s.invalidateCachedTemplate()
+	}
+	jc.Encode(nil)
+}
+
+// excludedTransactions returns a copy of the set of transactions that are
+// excluded from block templates.
+func (s *server) excludedTransactions() map[types.TransactionID]bool {
+	s.excludedTxnsMu.Lock()
+	defer s.excludedTxnsMu.Unlock()
+	excluded := make(map[types.TransactionID]bool, len(s.excludedTxns))
+	for id := range s.excludedTxns {
+		excluded[id] = true
+	}
+	return excluded
+}
 
-	var req MiningGetBlockTemplateRequest
+func (s *server) miningPinTransactionHandler(jc jape.Context) {
+	var req MiningPinTransactionRequest
 	if jc.Decode(&req) != nil {
 		return
 	}
 
-	for {
-		// get template or generate new one
-		template, invalidateChan, err := func() (MiningGetBlockTemplateResponse, <-chan struct{}, error) {
-			s.cachedTemplateMu.Lock()
-			defer s.cachedTemplateMu.Unlock()
-
-			// generate new template if required
-			if s.shouldRegenerateTemplate() {
-				template, err := generateBlockTemplate(s.cm, s.payoutAddr)
-				if err != nil {
-					return MiningGetBlockTemplateResponse{}, nil, err
-				}
-				s.cachedTemplate = &template
-			}
-			return *s.cachedTemplate, s.cachedTemplateInvalidated, nil
-		}()
-		if jc.Check("failed to get template", err) != nil {
+	var id types.TransactionID
+	switch {
+	case req.ID != nil && req.Transaction == nil && req.V2Transaction == nil:
+		id = *req.ID
+		if !s.inPool(id) {
+			jc.Error(fmt.Errorf("transaction %v is not in the pool", id), http.StatusNotFound)
 			return
 		}
-
-		// if we got a new template, return it
-		if template.LongPollID != req.LongPollID {
-			jc.Encode(s.cachedTemplate)
+	case req.ID == nil && req.Transaction != nil && req.V2Transaction == nil:
+		id = req.Transaction.ID()
+		if _, err := s.cm.AddPoolTransactions([]types.Transaction{*req.Transaction}); err != nil {
+			jc.Error(fmt.Errorf("invalid transaction: %w", err), http.StatusBadRequest)
 			return
 		}
-
-		// otherwise, wait until the template is invalidated again or the
-		// template has reached its maximum age
-		var maxAgeChan <-chan time.Time
-		if s.cachedTemplateMaxAge > 0 {
-			blockMaxTime := time.Unix(int64(template.Timestamp), 0).Add(s.cachedTemplateMaxAge)
-			maxAgeChan = time.After(time.Until(blockMaxTime))
-		}
-
-		select {
-		case <-jc.Request.Context().Done():
+	case req.ID == nil && req.Transaction == nil && req.V2Transaction != nil:
+		id = req.V2Transaction.ID()
+		if _, err := s.cm.AddV2PoolTransactions(req.Basis, []types.V2Transaction{*req.V2Transaction}); err != nil {
+			jc.Error(fmt.Errorf("invalid transaction: %w", err), http.StatusBadRequest)
 			return
-		case <-invalidateChan:
-			continue
-		case <-maxAgeChan:
-			continue
 		}
+	default:
+		jc.Error(errors.New("exactly one of id, transaction and v2transaction must be set"), http.StatusBadRequest)
+		return
+	}
+
+	s.pinnedTxnsMu.Lock()
+	changed := !s.pinnedTxns[id]
+	s.pinnedTxns[id] = true
+	s.pinnedTxnsMu.Unlock()
+	if changed {
+		s.invalidateCachedTemplate()
 	}
+	jc.Encode(MiningPinTransactionResponse{ID: id})
 }
 
-func (s *server) miningSubmitBlockTemplateHandler(jc jape.Context) {
-	var req MiningSubmitBlockRequest
+func (s *server) miningUnpinTransactionHandler(jc jape.Context) {
+	var req MiningUnpinTransactionRequest
 	if jc.Decode(&req) != nil {
 		return
-	} else if len(req.Params) < 1 {
-		jc.Error(errors.New("expected block hex in request params array"), http.StatusBadRequest)
-		return
 	}
-	rawBlock, err := hex.DecodeString(req.Params[0])
-	if jc.Check("couldn't decode block hex", err) != nil {
+	s.pinnedTxnsMu.Lock()
+	changed := s.pinnedTxns[req.ID]
+	delete(s.pinnedTxns, req.ID)
+	s.pinnedTxnsMu.Unlock()
+	if changed {
+		s.invalidateCachedTemplate()
+	}
+	jc.Encode(nil)
+}
+
+// inPool returns true if the transaction with the given ID is in the pool.
+func (s *server) inPool(id types.TransactionID) bool {
+	for _, txn := range s.cm.PoolTransactions() {
+		if txn.ID() == id {
+			return true
+		}
+	}
+	for _, txn := range s.cm.V2PoolTransactions() {
+		if txn.ID() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// pinnedTransactions returns a copy of the set of transactions that are
+// selected before all others.
+func (s *server) pinnedTransactions() map[types.TransactionID]bool {
+	s.pinnedTxnsMu.Lock()
+	defer s.pinnedTxnsMu.Unlock()
+	pinned := make(map[types.TransactionID]bool, len(s.pinnedTxns))
+	for id := range s.pinnedTxns {
+		pinned[id] = true
+	}
+	return pinned
+}
+
+// miningProposeBlockHandler validates a block proposal as described in BIP
+// 0023. It responds with null if the block would be accepted or with a reject
+// reason otherwise.
+func (s *server) miningProposeBlockHandler(jc jape.Context, data string) {
+	block, _, err := s.decodeBlock(data)
+	if err != nil {
+		jc.Error(err, http.StatusBadRequest)
 		return
 	}
 
-	// decode block
+	var reason *string
+	if err := validateProposal(s.cm, block, s.coinbaseFlags); err != nil {
+		str := err.Error()
+		reason = &str
+	}
+	jc.Encode(reason)
+}
+
+// decodeBlock decodes a hex-encoded block. Whether the block is expected to be
+// v1 or v2 encoded depends on the current tip.
+func (s *server) decodeBlock(blockHex string) (types.Block, bool, error) {
+	rawBlock, err := hex.DecodeString(blockHex)
+	if err != nil {
+		return types.Block{}, false, fmt.Errorf("couldn't decode block hex: %w", err)
+	}
+
 	var block types.Block
 	isV2 := s.cm.Tip().Height >= s.cm.TipState().Network.HardforkV2.AllowHeight
+	if s.forceBlockVersion != 0 {
+		isV2 = s.forceBlockVersion == 2
+	}
 	dec := types.NewBufDecoder(rawBlock)
 	if !isV2 {
 		(*types.V1Block)(&block).DecodeFrom(dec)
 	} else {
 		(*types.V2Block)(&block).DecodeFrom(dec)
 	}
-	if jc.Check("couldn't decode block", dec.Err()) != nil {
-		return
+	if err := dec.Err(); err != nil {
+		return types.Block{}, false, fmt.Errorf("couldn't decode block: %w", err)
 	}
+	return block, isV2, nil
+}
 
-	// verify and broadcast block
-	if jc.Check("failed to add block to chain manager", s.cm.AddBlocks([]types.Block{block})) != nil {
-		return
+// payoutAddress returns the address that the miner payout of a new block
+// template should be sent to.
+func (s *server) payoutAddress() (types.Address, error) {
+	if s.payoutAddrFn != nil {
+		return s.payoutAddrFn()
 	}
-	if isV2 {
-		if jc.Check("failed to broadcast block outline", s.s.BroadcastV2BlockOutline(gateway.OutlineBlock(block, s.cm.PoolTransactions(), s.cm.V2PoolTransactions()))) != nil {
-			return
-		}
+	return s.payoutAddr, nil
+}
+
+// templateSize returns the encoded size of the template's transactions and
+// miner payout in bytes.
+func templateSize(template MiningGetBlockTemplateResponse) (size int) {
+	for _, txn := range template.Transactions {
+		size += len(txn.Data) / 2
 	}
-	jc.Encode(nil)
+	for _, payout := range template.MinerPayout {
+		size += len(payout.Data) / 2
+	}
+	return
+}
+
+// longPollMaxAge returns the max template age a long polling request waits
+// for. A requested age overrides the server's max template age, clamped to
+// [minRequestMaxTemplateAge, maxTemplateAgeCeiling]. Without a ceiling,
+// requests can only shorten the server's max template age. The override only
+// affects when the request wakes up. A request waking up before the cached
+// template expires is served a refreshed copy, the cached template and its
+// long poll ID stay valid for other requests.
+func (s *server) longPollMaxAge(requested time.Duration) time.Duration {
+	if requested == 0 {
+		return s.cachedTemplateMaxAge
+	}
+	ceiling := s.maxTemplateAgeCeiling
+	if ceiling == 0 {
+		ceiling = s.cachedTemplateMaxAge
+	}
+	requested = max(requested, minRequestMaxTemplateAge)
+	if ceiling > 0 {
+		requested = min(requested, ceiling)
+	}
+	return requested
+}
+
+// refreshedLongPollID returns the long poll ID of a copy of the template with
+// the given long poll ID, refreshed for a single request. The refreshed
+// timestamp is embedded so the client's next long poll waits on the shared
+// template until the copy expires.
+func refreshedLongPollID(longPollID string, timestamp int32) string {
+	return fmt.Sprintf("%s-%d", longPollID, timestamp)
+}
+
+// parseLongPollID returns the long poll ID of the shared template a client's
+// long poll ID refers to and, if it refers to a refreshed copy, the copy's
+// timestamp.
+func parseLongPollID(id string) (longPollID string, timestamp int32, refreshed bool) {
+	longPollID, ts, ok := strings.Cut(id, "-")
+	if !ok {
+		return id, 0, false
+	}
+	n, err := strconv.ParseInt(ts, 10, 32)
+	if err != nil {
+		return id, 0, false
+	}
+	return longPollID, int32(n), true
+}
+
+// maxAgeJitter returns a random duration within ±longPollJitter of the max
+// template age.
+func (s *server) maxAgeJitter(maxAge time.Duration) time.Duration {
+	maxJitter := int64(float64(maxAge) * s.longPollJitter)
+	if maxJitter <= 0 {
+		return 0
+	}
+	return time.Duration(int64(frand.Uint64n(uint64(2*maxJitter+1))) - maxJitter)
+}
+
+// templateOptions returns the options for generating a new block template.
+func (s *server) templateOptions() templateOptions {
+	return templateOptions{
+		timestamp:       s.templateTimestamp,
+		excluded:        s.excludedTransactions(),
+		pinned:          s.pinnedTransactions(),
+		version:         s.forceBlockVersion,
+		coinbaseFlags:   s.coinbaseFlags,
+		empty:           s.emptyBlocks,
+		maxTxns:         s.maxTemplateTxns,
+		txnLimitReached: s.logTemplateTxnLimit,
+		minHeight:       s.minMiningHeight,
+	}
+}
+
+// logTemplateTxnLimit logs that the transaction limit, rather than the block
+// weight, kept pool transactions out of a block template.
+func (s *server) logTemplateTxnLimit(selected int) {
+	s.log.Info("template transaction limit reached", zap.Int("transactions", selected), zap.Int("limit", s.maxTemplateTxns))
+}
+
+// templateTimestamp returns the timestamp for a new block template on top of
+// the provided parent state.
+func (s *server) templateTimestamp(parent consensus.State) time.Time {
+	if s.timestampPinned {
+		return parent.PrevTimestamps[0].Add(s.timestampOffset)
+	}
+	return types.CurrentTimestamp()
 }
 
 // shouldRegenerateTemplate checks if the cached block template should be
@@ -202,15 +1643,84 @@ func (s *server) miningSubmitBlockTemplateHandler(jc jape.Context) {
 // maximum age and needs to be regenerated. Expects cachedTemplateMu to be
 // locked.
 func (s *server) shouldRegenerateTemplate() bool {
-	if s.cachedTemplate == nil {
+	if s.templateCacheDisabled {
+		return true // caching disabled, always generate a fresh template
+	} else if s.cachedTemplate == nil {
 		return true // no template cached, needs to be generated
-	} else if s.cachedTemplateMaxAge == 0 {
+	} else if s.cachedTemplateMaxAge == 0 || s.timestampPinned {
 		return false // no max age set, template never expires
 	}
 	blockTime := time.Unix(int64(s.cachedTemplate.Timestamp), 0)
 	return time.Since(blockTime) >= s.cachedTemplateMaxAge
 }
 
+// cachedTemplateBehindTip returns true if the cached template doesn't build on
+// the current tip. Reorg notifications are delivered after the tip changes, so
+// requests racing a new block could otherwise be served the previous
+// template. While reorgs are being debounced, the template is allowed to lag
+// behind the tip. The caller must hold cachedTemplateMu.
+func (s *server) cachedTemplateBehindTip() bool {
+	if s.cachedTemplate == nil {
+		return false
+	}
+	return !s.reorgDebouncing.Load() && s.cachedTemplate.Parent != s.cm.Tip()
+}
+
+// debugPprofHandler serves the net/http/pprof profiles. The special
+// cmdline, profile, symbol and trace handlers are served as well as the
+// named runtime profiles, e.g. goroutine, heap and allocs.
+func (s *server) debugPprofHandler(jc jape.Context) {
+	var profile string
+	if jc.DecodeParam("profile", &profile) != nil {
+		return
+	}
+
+	switch profile {
+	case "cmdline":
+		pprof.Cmdline(jc.ResponseWriter, jc.Request)
+	case "profile":
+		pprof.Profile(jc.ResponseWriter, jc.Request)
+	case "symbol":
+		pprof.Symbol(jc.ResponseWriter, jc.Request)
+	case "trace":
+		pprof.Trace(jc.ResponseWriter, jc.Request)
+	default:
+		pprof.Handler(profile).ServeHTTP(jc.ResponseWriter, jc.Request)
+	}
+}
+
+func (s *server) debugBenchTemplateHandler(jc jape.Context) {
+	var req DebugBenchTemplateRequest
+	if jc.Decode(&req) != nil {
+		return
+	} else if req.Duration <= 0 || req.Duration > maxBenchTemplateDuration {
+		jc.Error(fmt.Errorf("duration must be between 0 and %v", maxBenchTemplateDuration), http.StatusBadRequest)
+		return
+	}
+
+	ctx := jc.Request.Context()
+	resp := DebugBenchTemplateResponse{
+		PoolTransactions: len(s.cm.PoolTransactions()) + len(s.cm.V2PoolTransactions()),
+	}
+	var txns int
+	start := time.Now()
+	for time.Since(start) < req.Duration {
+		if ctx.Err() != nil {
+			return
+		}
+		template, err := generateBlockTemplate(s.cm, types.VoidAddress, s.templateOptions())
+		if jc.Check("failed to generate template", err) != nil {
+			return
+		}
+		resp.Templates++
+		txns += len(template.Transactions)
+	}
+	resp.Elapsed = time.Since(start)
+	resp.TemplatesPerSecond = float64(resp.Templates) / resp.Elapsed.Seconds()
+	resp.AvgTransactions = float64(txns) / float64(resp.Templates)
+	jc.Encode(resp)
+}
+
 func (s *server) syncerPeersHandler(jc jape.Context) {
 	// get peers
 	peers := s.s.Peers()
@@ -232,6 +1742,32 @@ func (s *server) syncerPeersHandler(jc jape.Context) {
 	jc.Encode(peerInfos)
 }
 
+// miningPeerInfoHandler returns details about every connected peer. Unlike
+// /syncer/peers, peers without stored metadata are still listed.
+func (s *server) miningPeerInfoHandler(jc jape.Context) {
+	peers := s.s.Peers()
+	resp := make([]GatewayPeer, 0, len(peers))
+	for _, p := range peers {
+		gp := GatewayPeer{
+			Address: p.ConnAddr,
+			Inbound: p.Inbound,
+			Version: p.Version(),
+		}
+		info, err := s.s.PeerInfo(p.Addr())
+		if err == nil {
+			gp.FirstSeen = info.FirstSeen
+			gp.ConnectedSince = info.LastConnect
+			gp.SyncedBlocks = info.SyncedBlocks
+			gp.SyncDuration = info.SyncDuration
+		} else if !errors.Is(err, syncer.ErrPeerNotFound) {
+			jc.Error(fmt.Errorf("failed to get info of peer %v: %w", p, err), http.StatusInternalServerError)
+			return
+		}
+		resp = append(resp, gp)
+	}
+	jc.Encode(resp)
+}
+
 func (s *server) syncerPeersConnectHandler(jc jape.Context) {
 	var addr string
 	if jc.Decode(&addr) != nil {
@@ -252,10 +1788,21 @@ func newServer(cm ChainManager, s Syncer, payoutAddr types.Address, opts ...Serv
 		debugEnabled:            false,
 		payoutAddr:              payoutAddr,
 		poolInvalidationTimeout: 200 * time.Millisecond,
+		submitBlockWaitTimeout:  30 * time.Second,
+		addBlockTimeout:         defaultAddBlockTimeout,
+		longPollJitter:          0.1,
+		slowTemplateThreshold:   500 * time.Millisecond,
+		nonceRangeSize:          1 << 32,
 		publicEndpoints:         false,
 		startTime:               time.Now(),
 
 		cachedTemplateInvalidated: make(chan struct{}, 1),
+		excludedTxns:              make(map[types.TransactionID]bool),
+		pinnedTxns:                make(map[types.TransactionID]bool),
+		getWorkBlocks:             make(map[types.Hash256]types.Block),
+		submittedBlocks:           make(map[types.BlockID]time.Time),
+		recentBlocks:              newRecentBlocks(recentBlocksSize),
+		reorgLogMinDepth:          1,
 
 		cm: cm,
 		s:  s,
@@ -272,14 +1819,18 @@ func NewServer(cm ChainManager, s Syncer, payoutAddr types.Address, opts ...Serv
 
 	// checkAuth checks the request for basic authentication.
 	checkAuth := func(jc jape.Context) bool {
-		if srv.password == "" {
+		password := srv.password
+		if srv.passwordFn != nil {
+			password = srv.passwordFn()
+		}
+		if password == "" {
 			// unset password is equivalent to no auth
 			return true
 		}
 
 		// verify auth header
 		_, pass, ok := jc.Request.BasicAuth()
-		if ok && pass == srv.password {
+		if ok && pass == password {
 			return true
 		}
 
@@ -290,7 +1841,18 @@ func NewServer(cm ChainManager, s Syncer, payoutAddr types.Address, opts ...Serv
 	// wrapAuthHandler wraps a jape handler with an authentication check.
 	wrapAuthHandler := func(h jape.Handler) jape.Handler {
 		return func(jc jape.Context) {
-			if !checkAuth(jc) {
+			if !srv.checkClientAddr(jc) || !checkAuth(jc) {
+				return
+			}
+			h(jc)
+		}
+	}
+
+	// wrapPublicAuthHandler wraps a jape handler with an authentication check
+	// unless publicEndpoints is true.
+	wrapPublicAuthHandler := func(h jape.Handler) jape.Handler {
+		return func(jc jape.Context) {
+			if !srv.checkClientAddr(jc) || (!srv.publicEndpoints && !checkAuth(jc)) {
 				return
 			}
 			h(jc)
@@ -299,25 +1861,279 @@ func NewServer(cm ChainManager, s Syncer, payoutAddr types.Address, opts ...Serv
 
 	// invalidate cached template on pool change
 	_ = cm.OnPoolChange(func() {
-		if srv.shouldPoolChangeInvalidateTemplate() {
+		// invalidations are rate limited, so the generation is what keeps
+		// templates from being refreshed after any pool change
+		srv.poolGeneration.Add(1)
+		if srv.emptyBlocks {
+			return // pool changes don't affect empty templates
+		} else if srv.shouldPoolChangeInvalidateTemplate() {
 			srv.invalidateCachedTemplate()
 		}
 	})
 
 	// invlaidate cached template on reorg
 	_ = cm.OnReorg(func(_ types.ChainIndex) {
-		srv.invalidateCachedTemplate()
+		srv.handleReorg()
+	})
+
+	if srv.templateWatchdog > 0 {
+		go srv.runTemplateWatchdog()
+	}
+
+	// count orphaned blocks on reorg
+	srv.orphansTip = cm.Tip()
+	_ = cm.OnReorg(func(_ types.ChainIndex) {
+		srv.trackOrphans()
 	})
 
 	handlers := map[string]jape.Handler{
 		"POST /syncer/connect":   wrapAuthHandler(srv.syncerPeersConnectHandler),
 		"GET /syncer/peers":      wrapAuthHandler(srv.syncerPeersHandler),
+		"POST /excludetxn":       wrapAuthHandler(srv.miningExcludeTransactionHandler),
+		"POST /includetxn":       wrapAuthHandler(srv.miningIncludeTransactionHandler),
+		"POST /pintxn":           wrapAuthHandler(srv.miningPinTransactionHandler),
+		"POST /unpintxn":         wrapAuthHandler(srv.miningUnpinTransactionHandler),
+		"GET /feehistogram":      wrapAuthHandler(srv.miningFeeHistogramHandler),
+		"POST /confirmtarget":    wrapAuthHandler(srv.miningConfirmTargetHandler),
+		"GET /stats":             wrapAuthHandler(srv.miningStatsHandler),
+		"GET /chainwork":         wrapAuthHandler(srv.miningChainWorkHandler),
+		"POST /blockintervals":   wrapAuthHandler(srv.miningBlockIntervalsHandler),
+		"GET /network":           wrapPublicAuthHandler(srv.miningNetworkHandler),
+		"POST /pause":            wrapAuthHandler(srv.miningPauseHandler),
+		"POST /resume":           wrapAuthHandler(srv.miningResumeHandler),
+		"GET /minerstatus":       wrapAuthHandler(srv.miningMinerStatusHandler),
+		"GET /payoutaddress":     wrapAuthHandler(srv.miningPayoutAddressHandler),
+		"POST /info":             wrapAuthHandler(srv.miningInfoHandler),
+		"POST /peerinfo":         wrapAuthHandler(srv.miningPeerInfoHandler),
+		"POST /willinclude":      wrapAuthHandler(srv.miningWillIncludeHandler),
+		"POST /allocaterange":    wrapAuthHandler(srv.miningAllocateRangeHandler),
+		"POST /getwork":          wrapAuthHandler(srv.miningGetWorkHandler),
+		"POST /waitfortip":       wrapAuthHandler(srv.miningWaitForTipHandler),
+		"POST /updates":          wrapAuthHandler(srv.miningUpdatesHandler),
+		"POST /getblock":         wrapAuthHandler(srv.miningGetBlockHandler),
+		"POST /blockstatus":      wrapAuthHandler(srv.miningBlockStatusHandler),
+		"POST /difficulty":       wrapAuthHandler(srv.miningDifficultyHandler),
 		"POST /getblocktemplate": wrapAuthHandler(srv.miningGetBlockTemplateHandler),
 		"POST /submitblock":      wrapAuthHandler(srv.miningSubmitBlockTemplateHandler),
 	}
+	if srv.jsonRPC {
+		handlers["POST /rpc"] = wrapAuthHandler(srv.miningRPCHandler)
+	}
+	if srv.debugEnabled {
+		handlers["POST /debug/benchtemplate"] = wrapAuthHandler(srv.debugBenchTemplateHandler)
+		handlers["GET /debug/pprof/:profile"] = wrapAuthHandler(srv.debugPprofHandler)
+	}
 	return jape.Mux(handlers)
 }
 
+// miningInfoHandler returns the current template together with everything a
+// new miner needs to get started.
+func (s *server) miningInfoHandler(jc jape.Context) {
+	if err := s.templateUnavailable(); err != nil {
+		jc.Error(err, http.StatusServiceUnavailable)
+		return
+	}
+
+	for {
+		template, invalidateChan, err := s.currentTemplate()
+		if jc.Check("failed to get template", err) != nil {
+			return
+		}
+		// the tip state has to match the template. If it doesn't, the
+		// template is about to be invalidated by a reorg.
+		cs := s.cm.TipState()
+		if cs.Index != template.Parent {
+			select {
+			case <-jc.Request.Context().Done():
+				return
+			case <-invalidateChan:
+			case <-time.After(infoSnapshotRetryInterval):
+			}
+			continue
+		}
+
+		var payoutAddr types.Address
+		if jc.Check("failed to parse payout address", payoutAddr.UnmarshalText([]byte(template.PayoutAddress))) != nil {
+			return
+		}
+		peers := len(s.s.Peers())
+		jc.Encode(MiningInfoResponse{
+			Template: template,
+			Network:  cs.Network.Name,
+			Hardfork: MiningHardforkHeights{
+				DevAddr:      cs.Network.HardforkDevAddr.Height,
+				Tax:          cs.Network.HardforkTax.Height,
+				StorageProof: cs.Network.HardforkStorageProof.Height,
+				Oak:          cs.Network.HardforkOak.Height,
+				ASIC:         cs.Network.HardforkASIC.Height,
+				Foundation:   cs.Network.HardforkFoundation.Height,
+				V2Allow:      cs.Network.HardforkV2.AllowHeight,
+				V2Require:    cs.Network.HardforkV2.RequireHeight,
+				V2FinalCut:   cs.Network.HardforkV2.FinalCutHeight,
+			},
+			Target:         template.Target,
+			Bits:           template.Bits,
+			Difficulty:     cs.Difficulty,
+			TotalWork:      cs.TotalWork,
+			PayoutAddress:  payoutAddr,
+			RecommendedFee: s.cm.RecommendedFee(),
+			Synced:         isSynced(peers, cs),
+			Peers:          peers,
+		})
+		return
+	}
+}
+
+// templateUnavailable returns an error if block templates can't be served
+// yet.
+func (s *server) templateUnavailable() error {
+	if s.payoutAddrFn == nil && s.payoutAddr == types.VoidAddress {
+		return errors.New("can't use getblocktemplate without specifying a payout address")
+	} else if s.warmingUp() {
+		return errors.New("warming up")
+	}
+	return nil
+}
+
+// currentTemplate returns the cached block template, generating a new one if
+// required, along with the channel that is closed when it is invalidated.
+func (s *server) currentTemplate() (MiningGetBlockTemplateResponse, <-chan struct{}, error) {
+	s.cachedTemplateMu.Lock()
+	defer s.cachedTemplateMu.Unlock()
+
+	// generate new template if required
+	if s.shouldRegenerateTemplate() || s.cachedTemplateBehindTip() {
+		payoutAddr, err := s.payoutAddress()
+		if err != nil {
+			return MiningGetBlockTemplateResponse{}, nil, fmt.Errorf("failed to get payout address: %w", err)
+		}
+		template, err := s.generateTemplate(payoutAddr)
+		if err != nil {
+			return MiningGetBlockTemplateResponse{}, nil, err
+		}
+		s.cachedTemplate = &template
+		s.cachedTemplatePayoutAddr = payoutAddr
+		s.stats.templateCacheMisses.Add(1)
+	} else {
+		s.stats.templateCacheHits.Add(1)
+	}
+	return *s.cachedTemplate, s.cachedTemplateInvalidated, nil
+}
+
+// generateTemplate generates a block template paying out to payoutAddr. If
+// only the timestamp of the last generated template is outdated, i.e. neither
+// the tip nor the pool changed since, its transaction selection is reused and
+// just the timestamp is updated. Expects cachedTemplateMu to be locked.
+func (s *server) generateTemplate(payoutAddr types.Address) (MiningGetBlockTemplateResponse, error) {
+	cs := s.cm.TipState()
+	key := templateKey{
+		parent:         cs.Index,
+		poolGeneration: s.poolGeneration.Load(),
+		payoutAddr:     payoutAddr,
+	}
+	if !s.templateCacheDisabled && s.reusableTemplate != nil && s.reusableTemplateKey == key {
+		template := refreshTemplate(*s.reusableTemplate, cs, s.templateTimestamp(cs))
+		s.reusableTemplate = &template
+		s.stats.templatesRefreshed.Add(1)
+		return template, nil
+	}
+
+	start := time.Now()
+	template, err := generateBlockTemplate(s.cm, payoutAddr, s.templateOptions())
+	if err != nil {
+		return MiningGetBlockTemplateResponse{}, err
+	} else if elapsed := time.Since(start); s.slowTemplateThreshold > 0 && elapsed > s.slowTemplateThreshold {
+		s.log.Warn("slow block template generation", zap.Duration("elapsed", elapsed), zap.Int("transactions", len(template.Transactions)), zap.Int("size", templateSize(template)))
+	}
+	// the tip might have changed since cs was read. The pool generation is
+	// read before the pool, so a concurrent pool change is never missed.
+	key.parent = template.Parent
+	s.reusableTemplate = &template
+	s.reusableTemplateKey = key
+	return template, nil
+}
+
+// warmingUp returns true if the startup grace period hasn't passed yet and
+// the node isn't connected to any peers.
+func (s *server) warmingUp() bool {
+	return time.Since(s.startTime) < s.startupGracePeriod && len(s.s.Peers()) == 0
+}
+
+// checkClientAddr checks whether the client's address is in one of the allowed
+// CIDRs. If not, a 403 is written to the response.
+func (s *server) checkClientAddr(jc jape.Context) bool {
+	if len(s.allowedCIDRs) == 0 {
+		return true
+	}
+	addr, ok := clientAddr(jc.Request, s.trustedProxies)
+	if ok && prefixesContain(s.allowedCIDRs, addr) {
+		return true
+	}
+	jc.Error(errors.New("forbidden"), http.StatusForbidden)
+	return false
+}
+
+// clientAddr returns the address of the client that sent the request. If the
+// request was forwarded by a trusted proxy, the X-Forwarded-For header is
+// walked from right to left until the first untrusted address.
+func clientAddr(req *http.Request, trustedProxies []netip.Prefix) (netip.Addr, bool) {
+	addrPort, err := netip.ParseAddrPort(req.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr := addrPort.Addr().Unmap()
+
+	forwarded := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0 && prefixesContain(trustedProxies, addr); i-- {
+		next, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		addr = next.Unmap()
+	}
+	return addr, true
+}
+
+// prefixesContain returns true if any of the prefixes contains addr.
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleReorg invalidates the cached template after a reorg. If reorg
+// debouncing is enabled, reorgs following within the debounce window are
+// coalesced into a single invalidation at the end of the window.
+func (s *server) handleReorg() {
+	if s.reorgDebounce == 0 {
+		s.invalidateCachedTemplate()
+		return
+	}
+
+	s.reorgDebounceMu.Lock()
+	defer s.reorgDebounceMu.Unlock()
+	if s.reorgDebounceTimer != nil {
+		s.reorgPending = true
+		return
+	}
+	s.invalidateCachedTemplate()
+	s.reorgDebouncing.Store(true)
+	s.reorgDebounceTimer = time.AfterFunc(s.reorgDebounce, func() {
+		s.reorgDebounceMu.Lock()
+		pending := s.reorgPending
+		s.reorgPending = false
+		s.reorgDebounceTimer = nil
+		s.reorgDebouncing.Store(false)
+		s.reorgDebounceMu.Unlock()
+		if pending {
+			s.invalidateCachedTemplate()
+		}
+	})
+}
+
 func (s *server) shouldPoolChangeInvalidateTemplate() bool {
 	s.cachedTemplateMu.Lock()
 	defer s.cachedTemplateMu.Unlock()
@@ -1,10 +1,23 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
 	"testing"
 	"time"
 
+	"go.sia.tech/core/consensus"
 	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils"
+	coreutilsTestutil "go.sia.tech/coreutils/testutil"
+	"go.sia.tech/jape"
+	"go.sia.tech/minerd/internal/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
 )
 
 func TestShouldPoolChangeInvalidateTemplate(t *testing.T) {
@@ -52,3 +65,446 @@ func TestShouldRegenerateTemplate(t *testing.T) {
 		t.Fatal("expected shouldRegenerateTemplate to return true when template cached and beyond max age")
 	}
 }
+
+func TestShouldRegenerateTemplateCacheDisabled(t *testing.T) {
+	srv := newServer(nil, nil, types.VoidAddress, WithoutTemplateCache())
+	srv.cachedTemplate = &MiningGetBlockTemplateResponse{Timestamp: int32(time.Now().Unix())}
+	if !srv.shouldRegenerateTemplate() {
+		t.Fatal("expected shouldRegenerateTemplate to return true when caching is disabled")
+	}
+}
+
+func TestMaxAgeJitter(t *testing.T) {
+	srv := newServer(nil, nil, types.VoidAddress)
+	if srv.maxAgeJitter(srv.cachedTemplateMaxAge) != 0 {
+		t.Fatal("expected no jitter without max age")
+	}
+
+	srv = newServer(nil, nil, types.VoidAddress, WithMaxTemplateAge(10*time.Second), WithLongPollJitter(0.1))
+	for range 100 {
+		if j := srv.maxAgeJitter(srv.cachedTemplateMaxAge); j < -time.Second || j > time.Second {
+			t.Fatalf("expected jitter within ±1s, got %v", j)
+		}
+	}
+
+	srv = newServer(nil, nil, types.VoidAddress, WithMaxTemplateAge(10*time.Second), WithLongPollJitter(0))
+	if srv.maxAgeJitter(srv.cachedTemplateMaxAge) != 0 {
+		t.Fatal("expected no jitter when disabled")
+	}
+}
+
+func TestLongPollMaxAge(t *testing.T) {
+	tests := []struct {
+		maxAge, ceiling, requested, expected time.Duration
+	}{
+		{0, 0, 0, 0},
+		{0, 0, 5 * time.Second, 5 * time.Second},
+		{time.Minute, 0, 0, time.Minute},
+		{time.Minute, 0, 5 * time.Second, 5 * time.Second},
+		{time.Minute, 0, time.Hour, time.Minute},
+		{time.Minute, 0, time.Millisecond, minRequestMaxTemplateAge},
+		{time.Minute, 10 * time.Minute, time.Hour, 10 * time.Minute},
+		{0, 10 * time.Minute, 5 * time.Minute, 5 * time.Minute},
+	}
+	for _, test := range tests {
+		srv := newServer(nil, nil, types.VoidAddress, WithMaxTemplateAge(test.maxAge), WithMaxTemplateAgeCeiling(test.ceiling))
+		if got := srv.longPollMaxAge(test.requested); got != test.expected {
+			t.Errorf("max age %v, ceiling %v, requested %v: expected %v, got %v", test.maxAge, test.ceiling, test.requested, test.expected, got)
+		}
+	}
+}
+
+func TestClientAddr(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"192.0.2.1:1234", "", "192.0.2.1"},
+		// forwarded header from an untrusted client is ignored
+		{"192.0.2.1:1234", "198.51.100.1", "192.0.2.1"},
+		// forwarded header from a trusted proxy is used
+		{"10.0.0.1:1234", "198.51.100.1", "198.51.100.1"},
+		// only the addresses appended by trusted proxies are used
+		{"10.0.0.1:1234", "203.0.113.1, 198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		{"[::ffff:10.0.0.1]:1234", "198.51.100.1", "198.51.100.1"},
+	}
+	for _, test := range tests {
+		req := &http.Request{RemoteAddr: test.remoteAddr, Header: make(http.Header)}
+		if test.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		addr, ok := clientAddr(req, trusted)
+		if !ok {
+			t.Fatalf("failed to determine client address for %q", test.remoteAddr)
+		} else if addr.String() != test.want {
+			t.Fatalf("expected %q, got %q", test.want, addr)
+		}
+	}
+
+	if _, ok := clientAddr(&http.Request{RemoteAddr: "@"}, trusted); ok {
+		t.Fatal("expected unparsable remote address to fail")
+	}
+}
+
+func TestDebugPprof(t *testing.T) {
+	srv := newServer(nil, nil, types.VoidAddress)
+	mux := jape.Mux(map[string]jape.Handler{
+		"GET /debug/pprof/:profile": srv.debugPprofHandler,
+	})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	if rec := get("/debug/pprof/goroutine?debug=1"); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	} else if !strings.Contains(rec.Body.String(), "goroutine profile") {
+		t.Fatalf("expected goroutine profile, got %q", rec.Body.String())
+	}
+
+	if rec := get("/debug/pprof/cmdline"); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+
+	if rec := get("/debug/pprof/unknown"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 for unknown profile, got %d", rec.Code)
+	}
+}
+
+func TestRecentBlocks(t *testing.T) {
+	rb := newRecentBlocks(2)
+	a, b, c := types.BlockID{1}, types.BlockID{2}, types.BlockID{3}
+	if !rb.Add(a) || !rb.Add(b) {
+		t.Fatal("expected new blocks to be added")
+	} else if rb.Add(a) {
+		t.Fatal("expected duplicate block to be detected")
+	}
+
+	// b is the least recently submitted block and gets evicted
+	if !rb.Add(c) {
+		t.Fatal("expected new block to be added")
+	} else if rb.Add(a) {
+		t.Fatal("expected a to be kept")
+	} else if !rb.Add(b) {
+		t.Fatal("expected b to be evicted")
+	}
+
+	rb.Remove(b)
+	if !rb.Add(b) {
+		t.Fatal("expected removed block to be added again")
+	}
+}
+
+// tipChainManager is a ChainManager that only reports a tip.
+type tipChainManager struct {
+	ChainManager
+	tip types.ChainIndex
+}
+
+func (cm *tipChainManager) Tip() types.ChainIndex { return cm.tip }
+
+func TestTemplateWatchdog(t *testing.T) {
+	const threshold = time.Minute
+	cm := &tipChainManager{tip: types.ChainIndex{Height: 1, ID: types.BlockID{1}}}
+	srv := newServer(cm, nil, types.VoidAddress, WithTemplateWatchdog(threshold))
+	srv.cachedTemplate = &MiningGetBlockTemplateResponse{Parent: cm.tip}
+
+	// a template building on the tip is left alone
+	now := time.Now()
+	srv.checkTemplateWatchdog(now)
+	srv.checkTemplateWatchdog(now.Add(2 * threshold))
+	if srv.cachedTemplate == nil {
+		t.Fatal("expected template building on the tip to be kept")
+	}
+
+	// a missed reorg leaves the template building on the previous tip
+	cm.tip = types.ChainIndex{Height: 2, ID: types.BlockID{2}}
+	srv.checkTemplateWatchdog(now)
+	srv.checkTemplateWatchdog(now.Add(threshold / 2))
+	if srv.cachedTemplate == nil {
+		t.Fatal("expected stale template to be kept until the threshold passes")
+	}
+	invalidated := srv.cachedTemplateInvalidated
+	srv.checkTemplateWatchdog(now.Add(threshold))
+	if srv.cachedTemplate != nil {
+		t.Fatal("expected stale template to be invalidated")
+	}
+	select {
+	case <-invalidated:
+	default:
+		t.Fatal("expected long polls to be notified")
+	}
+
+	// a locked template cache is never invalidated
+	srv.cachedTemplate = &MiningGetBlockTemplateResponse{Parent: types.ChainIndex{Height: 1, ID: types.BlockID{1}}}
+	srv.cachedTemplateMu.Lock()
+	srv.checkTemplateWatchdog(now)
+	srv.checkTemplateWatchdog(now.Add(2 * threshold))
+	srv.cachedTemplateMu.Unlock()
+	if srv.cachedTemplate == nil || !srv.watchdogLockReported {
+		t.Fatal("expected locked template cache to be reported without invalidating the template")
+	}
+}
+
+func TestCurrentTemplateBehindTip(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+
+	// newServer doesn't subscribe to reorgs, which simulates a request
+	// racing a new block before the reorg notification is delivered
+	srv := newServer(cn.Chain, cn.Syncer, types.Address{1})
+	first, _, err := srv.currentTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	coreutilsTestutil.MineBlocks(t, cn.Chain, types.VoidAddress, 1)
+	second, _, err := srv.currentTemplate()
+	if err != nil {
+		t.Fatal(err)
+	} else if second.Parent != cn.Chain.Tip() {
+		t.Fatalf("expected template to build on %v, got %v", cn.Chain.Tip(), second.Parent)
+	} else if second.LongPollID == first.LongPollID {
+		t.Fatal("expected a new template")
+	}
+
+	// while reorgs are debounced, the template may lag behind the tip
+	srv.reorgDebouncing.Store(true)
+	coreutilsTestutil.MineBlocks(t, cn.Chain, types.VoidAddress, 1)
+	if third, _, err := srv.currentTemplate(); err != nil {
+		t.Fatal(err)
+	} else if third.LongPollID != second.LongPollID {
+		t.Fatal("expected cached template while debouncing")
+	}
+}
+
+// addV1PoolTransactions adds n chained v1 transactions to the pool of cn.
+func addV1PoolTransactions(tb testing.TB, cn *testutil.ConsensusNode, n int) {
+	tb.Helper()
+
+	key := types.GeneratePrivateKey()
+	uc := types.StandardUnlockConditions(key.PublicKey())
+	coreutilsTestutil.MineBlocks(tb, cn.Chain, uc.UnlockHash(), 1)
+	b, ok := cn.Chain.Block(cn.Chain.Tip().ID)
+	if !ok {
+		tb.Fatal("missing tip block")
+	}
+	coreutilsTestutil.MineBlocks(tb, cn.Chain, types.VoidAddress, int(cn.Chain.TipState().MaturityHeight()-cn.Chain.Tip().Height))
+
+	sign := func(txn *types.Transaction) {
+		cs := cn.Chain.TipState()
+		for _, sci := range txn.SiacoinInputs {
+			sig := key.SignHash(cs.WholeSigHash(*txn, types.Hash256(sci.ParentID), 0, 0, nil))
+			txn.Signatures = append(txn.Signatures, types.TransactionSignature{
+				ParentID:       types.Hash256(sci.ParentID),
+				CoveredFields:  types.CoveredFields{WholeTransaction: true},
+				PublicKeyIndex: 0,
+				Signature:      sig[:],
+			})
+		}
+	}
+
+	fee := types.Siacoins(1)
+	value := b.MinerPayouts[0].Value.Sub(fee).Div64(uint64(n))
+	split := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{ParentID: b.ID().MinerOutputID(0), UnlockConditions: uc}},
+		MinerFees:     []types.Currency{b.MinerPayouts[0].Value.Sub(value.Mul64(uint64(n)))},
+	}
+	for range n {
+		split.SiacoinOutputs = append(split.SiacoinOutputs, types.SiacoinOutput{Address: uc.UnlockHash(), Value: value})
+	}
+	sign(&split)
+	txns := []types.Transaction{split}
+	for i := range n {
+		txn := types.Transaction{
+			SiacoinInputs:  []types.SiacoinInput{{ParentID: split.SiacoinOutputID(i), UnlockConditions: uc}},
+			SiacoinOutputs: []types.SiacoinOutput{{Address: uc.UnlockHash(), Value: value.Sub(fee)}},
+			MinerFees:      []types.Currency{fee},
+		}
+		sign(&txn)
+		txns = append(txns, txn)
+	}
+	if _, err := cn.Chain.AddPoolTransactions(txns); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestCurrentTemplateRefresh(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	addV1PoolTransactions(t, cn, 10)
+
+	srv := newServer(cn.Chain, cn.Syncer, types.Address{1})
+	first, _, err := srv.currentTemplate()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(first.Transactions) != 11 {
+		t.Fatalf("expected 11 transactions, got %d", len(first.Transactions))
+	}
+
+	// age the template and let it expire like a long polling request does
+	srv.reusableTemplate.block.Timestamp = srv.reusableTemplate.block.Timestamp.Add(-time.Hour)
+	srv.cachedTemplate = nil
+	second, _, err := srv.currentTemplate()
+	if err != nil {
+		t.Fatal(err)
+	} else if srv.stats.templatesRefreshed.Load() != 1 {
+		t.Fatal("expected template to be refreshed")
+	} else if second.LongPollID == first.LongPollID {
+		t.Fatal("expected a new long poll ID")
+	} else if second.Commitment != first.Commitment || len(second.Transactions) != len(first.Transactions) {
+		t.Fatal("expected the same transactions")
+	} else if time.Since(second.block.Timestamp) > time.Minute || second.Timestamp != int32(second.block.Timestamp.Unix()) {
+		t.Fatalf("expected a current timestamp, got %v", second.block.Timestamp)
+	}
+
+	// any pool change forces a full regeneration, even if the invalidation
+	// was rate limited
+	srv.poolGeneration.Add(1)
+	srv.cachedTemplate = nil
+	if _, _, err := srv.currentTemplate(); err != nil {
+		t.Fatal(err)
+	} else if srv.stats.templatesRefreshed.Load() != 1 {
+		t.Fatal("expected template to be regenerated after a pool change")
+	}
+
+	// the refreshed block is valid
+	b := second.block
+	if !coreutils.FindBlockNonce(cn.Chain.TipState(), &b, 10*time.Second) {
+		t.Fatal("failed to find nonce")
+	} else if err := cn.Chain.AddBlocks([]types.Block{b}); err != nil {
+		t.Fatal(err)
+	}
+
+	// and its new tip forces a full regeneration as well
+	srv.reusableTemplate.block.Timestamp = srv.reusableTemplate.block.Timestamp.Add(-time.Hour)
+	srv.cachedTemplate = nil
+	if third, _, err := srv.currentTemplate(); err != nil {
+		t.Fatal(err)
+	} else if srv.stats.templatesRefreshed.Load() != 1 {
+		t.Fatal("expected template to be regenerated after a new tip")
+	} else if third.Parent != cn.Chain.Tip() {
+		t.Fatalf("expected template to build on %v, got %v", cn.Chain.Tip(), third.Parent)
+	}
+
+	// as does an invalidation, e.g. after excluding a transaction
+	srv.invalidateCachedTemplate()
+	if _, _, err := srv.currentTemplate(); err != nil {
+		t.Fatal(err)
+	} else if srv.stats.templatesRefreshed.Load() != 1 {
+		t.Fatal("expected template to be regenerated after an invalidation")
+	}
+}
+
+func BenchmarkGenerateTemplate(b *testing.B) {
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(b, network, genesisBlock, zap.NewNop())
+	addV1PoolTransactions(b, cn, 500)
+	srv := newServer(cn.Chain, cn.Syncer, types.Address{1})
+	srv.cachedTemplateMu.Lock()
+	defer srv.cachedTemplateMu.Unlock()
+
+	b.Run("full", func(b *testing.B) {
+		for b.Loop() {
+			srv.reusableTemplate = nil
+			if _, err := srv.generateTemplate(types.Address{1}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("refresh", func(b *testing.B) {
+		if _, err := srv.generateTemplate(types.Address{1}); err != nil {
+			b.Fatal(err)
+		}
+		for b.Loop() {
+			if _, err := srv.generateTemplate(types.Address{1}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// slowChainManager is a ChainManager whose AddBlocks blocks until release is
+// closed.
+type slowChainManager struct {
+	ChainManager
+	release chan struct{}
+}
+
+func (cm *slowChainManager) AddBlocks([]types.Block) error {
+	<-cm.release
+	return nil
+}
+
+func TestAddBlockTimeout(t *testing.T) {
+	cm := &slowChainManager{release: make(chan struct{})}
+	srv := newServer(cm, nil, types.VoidAddress, WithAddBlockTimeout(10*time.Millisecond), WithLogger(zaptest.NewLogger(t)))
+
+	done := make(chan error, 1)
+	err := srv.addBlock(context.Background(), types.Block{}, func(err error) error {
+		done <- err
+		return err
+	})
+	if !errors.Is(err, errAddBlockTimeout) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	select {
+	case <-done:
+		t.Fatal("expected block to still be processed")
+	default:
+	}
+
+	// the block is still processed once validation completes
+	close(cm.release)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected done to be called")
+	}
+
+	// the result of done is returned if validation completes in time
+	doneErr := errors.New("done")
+	if err := srv.addBlock(context.Background(), types.Block{}, func(error) error { return doneErr }); !errors.Is(err, doneErr) {
+		t.Fatalf("expected done error, got %v", err)
+	}
+}
+
+// statelessChainManager is a ChainManager that has lost the state of every
+// block.
+type statelessChainManager struct {
+	ChainManager
+}
+
+func (cm *statelessChainManager) State(types.BlockID) (consensus.State, bool) {
+	return consensus.State{}, false
+}
+
+func TestBlockStatusMissingState(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	coreutilsTestutil.MineBlocks(t, cn.Chain, types.VoidAddress, 1)
+	tip := cn.Chain.Tip()
+
+	srv := newServer(cn.Chain, nil, types.VoidAddress)
+	if status := srv.blockStatus(tip.ID); status != (MiningBlockStatusResponse{Known: true, Height: 1, MainChain: true, Confirmations: 1}) {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	// a block whose state is missing must not be reported as an orphan at
+	// height 0
+	srv = newServer(&statelessChainManager{cn.Chain}, nil, types.VoidAddress)
+	if status := srv.blockStatus(tip.ID); status != (MiningBlockStatusResponse{}) {
+		t.Fatalf("expected block without state to be unknown, got %+v", status)
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// unixScheme is the URL scheme of requests sent to a Unix socket. The host of
+// such URLs is the hex-encoded path of the socket.
+const unixScheme = "http+unix"
+
+// unixTLSScheme is the URL scheme of requests sent to a Unix socket that
+// serves HTTPS.
+const unixTLSScheme = "https+unix"
+
+var registerUnixTransportOnce sync.Once
+
+// dialUnix dials the Unix socket whose path is hex-encoded in the host of
+// addr.
+func dialUnix(ctx context.Context, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	path, err := hex.DecodeString(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid socket path %q: %w", host, err)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", string(path))
+}
+
+// registerUnixTransport registers a transport for the unixScheme and
+// unixTLSScheme with the default HTTP client. TLS connections use the TLS
+// config of the default transport at the time they are dialed.
+func registerUnixTransport() {
+	registerUnixTransportOnce.Do(func() {
+		t, ok := http.DefaultTransport.(*http.Transport)
+		if !ok {
+			panic("default transport is not an *http.Transport") // should never happen
+		}
+		unix := &http.Transport{
+			DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+				return dialUnix(ctx, addr)
+			},
+			DialTLSContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+				conn, err := dialUnix(ctx, addr)
+				if err != nil {
+					return nil, err
+				}
+				config := t.TLSClientConfig.Clone()
+				if config == nil {
+					config = new(tls.Config)
+				}
+				// the default transport may negotiate HTTP/2, this one
+				// only speaks HTTP/1.1
+				config.NextProtos = nil
+				tlsConn := tls.Client(conn, config)
+				if err := tlsConn.HandshakeContext(ctx); err != nil {
+					conn.Close()
+					return nil, err
+				}
+				return tlsConn, nil
+			},
+		}
+		t.RegisterProtocol(unixScheme, unixRoundTripper{t: unix, scheme: "http"})
+		t.RegisterProtocol(unixTLSScheme, unixRoundTripper{t: unix, scheme: "https"})
+	})
+}
+
+// unixRoundTripper rewrites requests with a Unix socket scheme to plain HTTP
+// or HTTPS requests before passing them to a transport that dials Unix
+// sockets.
+type unixRoundTripper struct {
+	t      *http.Transport
+	scheme string
+}
+
+// RoundTrip implements http.RoundTripper.
+func (rt unixRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = rt.scheme
+	return rt.t.RoundTrip(req)
+}
+
+// UnixSocketURL returns a base URL for clients that connect to an API served
+// on the Unix socket at path. Any path the API is served under, e.g. "/api",
+// has to be appended to the returned URL.
+func UnixSocketURL(path string) string {
+	registerUnixTransport()
+	return unixScheme + "://" + hex.EncodeToString([]byte(path))
+}
+
+// UnixSocketTLSURL is like UnixSocketURL for an API served over HTTPS on the
+// Unix socket at path. The certificate is verified using the TLS config of
+// http.DefaultTransport.
+func UnixSocketTLSURL(path string) string {
+	registerUnixTransport()
+	return unixTLSScheme + "://" + hex.EncodeToString([]byte(path))
+}
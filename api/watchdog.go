@@ -0,0 +1,59 @@
+package api
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// templateWatchdogChecks is the number of times the watchdog checks the
+// cached template per staleness threshold.
+const templateWatchdogChecks = 4
+
+// runTemplateWatchdog periodically checks the cached template for the
+// lifetime of the process.
+func (s *server) runTemplateWatchdog() {
+	t := time.NewTicker(s.templateWatchdog / templateWatchdogChecks)
+	defer t.Stop()
+	for now := range t.C {
+		s.checkTemplateWatchdog(now)
+	}
+}
+
+// checkTemplateWatchdog invalidates the cached template if it has been
+// building on a previous tip for longer than the staleness threshold, e.g.
+// because a reorg notification was missed. If the template cache stays locked
+// for longer than the threshold, an error is logged since a deadlock can't be
+// recovered from.
+func (s *server) checkTemplateWatchdog(now time.Time) {
+	if !s.cachedTemplateMu.TryLock() {
+		if s.watchdogLockedSince.IsZero() {
+			s.watchdogLockedSince = now
+		} else if locked := now.Sub(s.watchdogLockedSince); locked >= s.templateWatchdog && !s.watchdogLockReported {
+			s.log.Error("template cache has been locked for too long, template generation may be stuck", zap.Duration("locked", locked))
+			s.watchdogLockReported = true
+		}
+		return
+	}
+	s.watchdogLockedSince, s.watchdogLockReported = time.Time{}, false
+	tip := s.cm.Tip()
+	stale := s.cachedTemplate != nil && s.cachedTemplate.Parent != tip
+	parent := tip
+	if stale {
+		parent = s.cachedTemplate.Parent
+	}
+	s.cachedTemplateMu.Unlock()
+
+	if !stale {
+		s.watchdogStaleSince = time.Time{}
+		return
+	} else if s.watchdogStaleSince.IsZero() {
+		s.watchdogStaleSince = now
+		return
+	} else if now.Sub(s.watchdogStaleSince) < s.templateWatchdog {
+		return
+	}
+	s.log.Error("cached template builds on a previous tip, invalidating it", zap.Stringer("parent", parent), zap.Stringer("tip", tip), zap.Duration("stale", now.Sub(s.watchdogStaleSince)))
+	s.watchdogStaleSince = time.Time{}
+	s.invalidateCachedTemplate()
+}
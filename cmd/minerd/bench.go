@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.sia.tech/minerd/api"
+)
+
+func runTemplateBenchmark(addr, password string, d time.Duration) {
+	c := api.NewClient(addr, password)
+
+	fmt.Printf("Benchmarking template generation for %v...\n", d)
+	ctx, cancel := context.WithTimeout(context.Background(), d+30*time.Second)
+	defer cancel()
+	resp, err := c.DebugBenchTemplate(ctx, d)
+	checkFatalError("failed to run benchmark", err)
+
+	fmt.Println("Pool Transactions:", resp.PoolTransactions)
+	fmt.Println("Templates:", resp.Templates)
+	fmt.Printf("Templates/sec: %.2f\n", resp.TemplatesPerSecond)
+	fmt.Printf("Avg. Transactions: %.2f\n", resp.AvgTransactions)
+}
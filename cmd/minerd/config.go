@@ -99,7 +99,7 @@ func setAPIPassword() {
 		fmt.Println("This password will be required to access the admin UI in your web browser.")
 		fmt.Println("(The password must be at least 4 characters.)")
 		cfg.HTTP.Password = readPasswordInput("Enter password")
-		if len(cfg.HTTP.Password) >= 4 {
+		if len(cfg.HTTP.Password) >= minAPIPasswordLength {
 			break
 		}
 
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigUnsyncedSubmissions(t *testing.T) {
+	load := func(contents string) Config {
+		t.Helper()
+		fp := filepath.Join(t.TempDir(), "minerd.yml")
+		if err := os.WriteFile(fp, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		c := cfg
+		if err := LoadFile(fp, &c); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	// blocks submitted while the node isn't synced are rejected unless the
+	// config opts out, e.g. on a devnet without peers
+	if c := load("mining:\n  jsonRPC: true\n"); c.Mining.AllowUnsyncedSubmissions {
+		t.Fatal("expected unsynced submissions to be rejected by default")
+	} else if c := load("mining:\n  allowUnsyncedSubmissions: true\n"); !c.Mining.AllowUnsyncedSubmissions {
+		t.Fatal("expected unsynced submissions to be allowed")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.sia.tech/minerd/api"
+)
+
+const (
+	// healthCheckTimeout is the timeout for all requests of a health check.
+	healthCheckTimeout = 30 * time.Second
+	// healthCheckMaxTipAge is the maximum age of the tip block before the
+	// node is considered to be out of sync.
+	healthCheckMaxTipAge = 3 * time.Hour
+)
+
+// runHealthCheck checks whether the node at addr is synced, connected to peers
+// and able to generate block templates.
+func runHealthCheck(addr, password string) error {
+	c := api.NewClient(addr, password)
+
+	cs, err := c.ConsensusTipState()
+	if err != nil {
+		return fmt.Errorf("failed to get tip state: %w", err)
+	} else if age := time.Since(cs.PrevTimestamps[0]); age > healthCheckMaxTipAge {
+		return fmt.Errorf("node is not synced, tip %v is %v old", cs.Index, age.Round(time.Second))
+	}
+
+	peers, err := c.SyncerPeers()
+	if err != nil {
+		return fmt.Errorf("failed to get peers: %w", err)
+	} else if len(peers) == 0 {
+		return errors.New("node is not connected to any peers")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	if _, err := c.MiningGetBlockTemplate(ctx, ""); err != nil {
+		return fmt.Errorf("failed to get block template: %w", err)
+	}
+	return nil
+}
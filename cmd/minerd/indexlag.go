@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// indexLagCheckInterval is the interval at which the wallet indexer's lag
+// behind the chain tip is checked.
+const indexLagCheckInterval = time.Minute
+
+// monitorIndexLag periodically compares the last block indexed by the wallet
+// indexer with the chain tip. A warning is logged once the indexer falls more
+// than threshold blocks behind, and again when it has caught up. Mining
+// doesn't depend on the indexer, but the wallet endpoints return stale data
+// while it lags.
+func monitorIndexLag(ctx context.Context, threshold uint64, indexed func() (types.ChainIndex, error), tip func() types.ChainIndex, log *zap.Logger) {
+	t := time.NewTicker(indexLagCheckInterval)
+	defer t.Stop()
+
+	var lagging bool
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		index, err := indexed()
+		if err != nil {
+			log.Warn("failed to get last indexed block", zap.Error(err))
+			continue
+		}
+		current := tip()
+		var lag uint64
+		if current.Height > index.Height {
+			lag = current.Height - index.Height
+		}
+
+		switch {
+		case lag > threshold && !lagging:
+			lagging = true
+			log.Warn("wallet indexer is falling behind the chain tip", zap.Uint64("lag", lag), zap.Stringer("indexed", index), zap.Stringer("tip", current))
+		case lag > threshold:
+			log.Debug("wallet indexer is still behind the chain tip", zap.Uint64("lag", lag), zap.Stringer("indexed", index), zap.Stringer("tip", current))
+		case lagging:
+			lagging = false
+			log.Info("wallet indexer caught up with the chain tip", zap.Uint64("lag", lag), zap.Stringer("indexed", index), zap.Stringer("tip", current))
+		}
+	}
+}
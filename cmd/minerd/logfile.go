@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// logFileFailureThreshold is the number of consecutive failed writes
+	// after which the log file is considered unwritable, e.g. because its
+	// disk is full.
+	logFileFailureThreshold = 5
+	// logFileRetryInterval is the interval at which writing to an unwritable
+	// log file is retried.
+	logFileRetryInterval = 30 * time.Second
+)
+
+// A logFileWriter writes log entries to a log file and detects persistent
+// write failures. Once the file is unwritable, the operator is notified on
+// stdout and entries are written to the fallback writer instead, if any,
+// until a retry succeeds.
+type logFileWriter struct {
+	path     string
+	file     zapcore.WriteSyncer
+	notify   io.Writer
+	fallback io.Writer // nil if entries are already logged to stdout
+
+	mu        sync.Mutex
+	failures  int       // consecutive failed writes
+	failing   bool      // set once failures reaches logFileFailureThreshold
+	lastRetry time.Time // last write attempt while failing
+	partial   bool      // set if the last write only wrote part of an entry
+}
+
+// Write implements zapcore.WriteSyncer. Errors are handled by the writer
+// itself, so zap doesn't report every failed entry on stderr.
+func (w *logFileWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.failing && time.Since(w.lastRetry) < logFileRetryInterval {
+		return w.writeFallback(p)
+	}
+	w.lastRetry = time.Now()
+
+	// start a new line after a partial write so the entry isn't merged
+	// with the truncated one
+	buf := p
+	if w.partial {
+		buf = append([]byte{'\n'}, p...)
+	}
+	n, err := w.file.Write(buf)
+	if err == nil {
+		if w.failing {
+			fmt.Fprintf(w.notify, "writing to log file %q succeeded again after %d failed attempts, resuming file logging\n", w.path, w.failures)
+		}
+		w.failures, w.failing, w.partial = 0, false, false
+		return len(p), nil
+	}
+
+	w.partial = w.partial || n > 0
+	w.failures++
+	if !w.failing && w.failures >= logFileFailureThreshold {
+		w.failing = true
+		msg := "logging to stdout"
+		if w.fallback == nil {
+			msg = "only logging to the other sinks"
+		}
+		fmt.Fprintf(w.notify, "failed to write to log file %q %d times in a row, %s until writes succeed again: %v\n", w.path, w.failures, msg, err)
+	}
+	return w.writeFallback(p)
+}
+
+// writeFallback writes an entry that couldn't be written to the log file to
+// the fallback writer. Expects mu to be locked.
+func (w *logFileWriter) writeFallback(p []byte) (int, error) {
+	if w.fallback != nil {
+		w.fallback.Write(p)
+	}
+	return len(p), nil
+}
+
+// Sync implements zapcore.WriteSyncer.
+func (w *logFileWriter) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failing {
+		return nil
+	}
+	return w.file.Sync()
+}
+
+// newLogFileWriter returns a logFileWriter for the log file at path. If
+// entries aren't logged to stdout already, they are written to stdout while
+// the file is unwritable.
+func newLogFileWriter(file zapcore.WriteSyncer, path string, stdoutEnabled bool) *logFileWriter {
+	w := &logFileWriter{
+		path:   path,
+		file:   file,
+		notify: os.Stdout,
+	}
+	if !stdoutEnabled {
+		w.fallback = os.Stdout
+	}
+	return w
+}
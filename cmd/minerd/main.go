@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
@@ -11,10 +12,10 @@ import (
 	"syscall"
 	"time"
 
+	"go.sia.tech/core/consensus"
 	"go.sia.tech/core/types"
 	cwallet "go.sia.tech/coreutils/wallet"
 	"go.sia.tech/minerd/internal/build"
-	"go.sia.tech/walletd/v2/api"
 	"go.sia.tech/walletd/v2/config"
 	"go.sia.tech/walletd/v2/wallet"
 	"go.uber.org/zap"
@@ -28,6 +29,22 @@ const (
 	dataDirEnvVar     = "MINERD_DATA_DIR"
 	logFileEnvVar     = "MINERD_LOG_FILE_PATH"
 	payoutAddrEnvVar  = "MINERD_PAYOUT_ADDRESS"
+	payoutSeedEnvVar  = "MINERD_PAYOUT_SEED"
+)
+
+// exitCodeAddressInUse is the exit code used if minerd fails to start
+// because one of its listen addresses is already in use. It matches the value
+// of EADDRINUSE on Linux.
+const exitCodeAddressInUse = 98
+
+const (
+	// minMaxTemplateAge is the lowest accepted max template age. Anything
+	// lower causes templates to be regenerated faster than miners can fetch
+	// them.
+	minMaxTemplateAge = time.Second
+	// recommendedMinMaxTemplateAge is the max template age below which a
+	// warning is logged on startup.
+	recommendedMinMaxTemplateAge = 5 * time.Second
 )
 
 const (
@@ -37,9 +54,14 @@ const (
     Run 'minerd' with no arguments to start the blockchain node and API server.
 
 Actions:
-    version     print minerd version
-    seed        generate a recovery phrase
-    mine        run CPU miner`
+    version         print minerd version
+    seed            generate a recovery phrase
+    mine            run CPU miner
+    bench-template  benchmark block template generation
+    healthcheck     check the health of a running node
+    migrate-paths   move files from deprecated default paths
+    passwd          change the API password
+    sweep           send mature payouts to a cold address`
 
 	versionUsage = `Usage:
     minerd version
@@ -55,40 +77,306 @@ Generates a secure BIP-39 recovery phrase.
     minerd mine
 
 Runs a CPU miner. Not intended for production use.
+
+Mining pauses while the node has no peers since blocks found without peers
+can't propagate. Use -allowIsolated to keep mining on isolated devnets.
+
+Use -maxDifficulty to stop mining once the network difficulty exceeds a cap,
+e.g. to keep CI runs on low-difficulty testnets from hanging.
+
+By default, the miner connects to the local node. Use -node to mine with a
+remote node instead, e.g. -node https://node.example.com:9980. The API
+password of the remote node is read from the config file or the
+MINERD_API_PASSWORD environment variable. Use -insecure to accept a
+self-signed TLS certificate.
+
+Use -output json to write every found block to stdout as a JSON object on a
+line of its own, with its height, id, version, nonce, timestamp and reward in
+hastings, e.g. to pipe it into another process. The status output is written
+to stderr instead.
+`
+	healthCheckUsage = `Usage:
+    minerd healthcheck
+
+Checks whether a running node is synced, connected to peers and able to
+generate block templates. Exits with a non-zero exit code if it isn't.
+`
+	migratePathsUsage = `Usage:
+    minerd migrate-paths
+
+Moves the config file from the deprecated /var/lib/minerd/minerd.yml to
+/etc/minerd/minerd.yml. The data directory is unchanged. Stop the node before
+migrating.
+`
+	passwdUsage = `Usage:
+    minerd passwd
+
+Changes the API password in the config file. The new password is read
+interactively or, with -file, from a file. The rest of the config file is left
+unchanged. Send SIGHUP to a running node to reload the password without a
+restart. Requests that are already in progress, e.g. long polling requests,
+are not interrupted.
+`
+	sweepUsage = `Usage:
+    minerd sweep -to <address>
+
+Constructs a v2 transaction sending the mature siacoins of the payout wallet of
+a running node to a cold address, e.g. one whose key is kept offline. Block
+rewards are spendable once they have matured; immature rewards stay in the
+wallet until the next sweep. The fee is estimated from the node's transaction
+pool and deducted from the swept amount.
+
+The transaction is written to stdout as JSON. If the payout addresses are
+derived from a recovery phrase, i.e. mining.seed or mining.seedFile is set,
+the inputs are signed with it. Otherwise, sign each input over inputSigHash
+offline and broadcast the transaction with the txpool API. Use -broadcast to
+broadcast a fully signed transaction right away.
+`
+	benchTemplateUsage = `Usage:
+    minerd bench-template
+
+Repeatedly generates block templates on a running node for a fixed duration and
+reports the throughput. The node must be started with the -debug flag.
 `
 )
 
 type Mining struct {
 	MaxTemplateAge time.Duration `yaml:"maxTemplateAge,omitempty"`
+	LongPollJitter float64       `yaml:"longPollJitter"`
 	PayoutAddress  string        `yaml:"payoutAddress,omitempty"`
+	Seed           string        `yaml:"seed,omitempty"`
+	SeedFile       string        `yaml:"seedFile,omitempty"`
+
+	// PayoutIndexStep is the number of derivation indices seed-derived payout
+	// addresses advance by after an address is used. It must not exceed
+	// PayoutGapLimit, the gap limit of the wallet used to recover the seed,
+	// or recovery scans miss addresses.
+	PayoutIndexStep uint64 `yaml:"payoutIndexStep"`
+	PayoutGapLimit  uint64 `yaml:"payoutGapLimit"`
+
+	// PayoutPolicy is the spend policy of PayoutAddress, e.g. a multisig
+	// policy, either in its string form or as JSON. If set, the address is
+	// added to the "Mining Payouts" wallet with the policy so the embedded
+	// wallet can spend the block rewards.
+	PayoutPolicy string `yaml:"payoutPolicy,omitempty"`
+
+	// PayoutWallet is the name of a walletd wallet whose address receives the
+	// block rewards. It replaces PayoutAddress.
+	PayoutWallet string `yaml:"payoutWallet,omitempty"`
+
+	// TimestampOffset pins the timestamp of block templates to the parent
+	// block's timestamp plus the offset. If zero, the current time is used.
+	TimestampOffset time.Duration `yaml:"timestampOffset,omitempty"`
+	// StartupGracePeriod delays serving templates after startup until the
+	// period has passed or the node is connected to a peer.
+	StartupGracePeriod time.Duration `yaml:"startupGracePeriod,omitempty"`
+	// SlowTemplateThreshold is the duration after which generating a block
+	// template logs a warning. Zero disables the warning.
+	SlowTemplateThreshold time.Duration `yaml:"slowTemplateThreshold"`
+	// AddBlockTimeout bounds how long submit requests wait for a block to be
+	// validated. The block is still processed if validation takes longer.
+	// Zero disables the timeout.
+	AddBlockTimeout time.Duration `yaml:"addBlockTimeout"`
+	// NonceRangeSize is the number of nonces allocated to a worker by
+	// /mining/allocaterange. If zero, the default is used.
+	NonceRangeSize uint64 `yaml:"nonceRangeSize,omitempty"`
+	// MaxTemplateAgeCeiling is the highest max template age long polling
+	// requests may ask for. If zero, requests can only shorten
+	// MaxTemplateAge.
+	MaxTemplateAgeCeiling time.Duration `yaml:"maxTemplateAgeCeiling,omitempty"`
+	// ReorgDebounce coalesces template invalidations caused by reorgs
+	// happening within this window. Zero disables debouncing.
+	ReorgDebounce time.Duration `yaml:"reorgDebounce,omitempty"`
+	// TemplateWatchdog invalidates the cached block template if it still
+	// builds on a previous tip after this long. Zero disables the watchdog.
+	TemplateWatchdog time.Duration `yaml:"templateWatchdog"`
+	// ReorgLogMinDepth is the number of reverted blocks from which reorgs
+	// are logged at info level. Zero logs every tip change at info level.
+	ReorgLogMinDepth uint64 `yaml:"reorgLogMinDepth"`
+	// MinDifficulty and MaxDifficulty bound the difficulty submitted blocks
+	// may be mined at. If MinDifficulty is zero, it is derived from the
+	// network. If MaxDifficulty is zero, there is no upper bound.
+	MinDifficulty consensus.Work `yaml:"minDifficulty"`
+	MaxDifficulty consensus.Work `yaml:"maxDifficulty"`
+	// FullBlockOutlines includes every transaction of submitted v2 blocks in
+	// the broadcast outline instead of referencing pooled transactions by
+	// their hash.
+	FullBlockOutlines bool `yaml:"fullBlockOutlines,omitempty"`
+	// MinHeight refuses to serve block templates below the height, e.g. on
+	// custom networks where mining earlier blocks is pointless. Zero serves
+	// templates at any height.
+	MinHeight uint64 `yaml:"minHeight,omitempty"`
+	// AcceptKnownBlocks reports submitted blocks the node already has as a
+	// success with the result "duplicate" instead of an error.
+	AcceptKnownBlocks bool `yaml:"acceptKnownBlocks,omitempty"`
+	// AllowUnsyncedSubmissions accepts submitted blocks while the node isn't
+	// synced, e.g. on devnets without peers.
+	AllowUnsyncedSubmissions bool `yaml:"allowUnsyncedSubmissions,omitempty"`
+	// CoinbaseFlags is a marker added to the arbitrary data of block
+	// templates to identify blocks mined with minerd. Empty disables it.
+	CoinbaseFlags string `yaml:"coinbaseFlags"`
+	// RequireTransactions holds block templates until they contain a
+	// transaction paying a fee or RequireTransactionsMaxWait has passed.
+	RequireTransactions        bool          `yaml:"requireTransactions,omitempty"`
+	RequireTransactionsMaxWait time.Duration `yaml:"requireTransactionsMaxWait,omitempty"`
+	// JSONRPC serves the mining methods as JSON-RPC 2.0 at /api/mining/rpc.
+	JSONRPC bool `yaml:"jsonRPC,omitempty"`
+	// EmptyBlocks generates block templates without any pool transactions.
+	EmptyBlocks bool `yaml:"emptyBlocks,omitempty"`
+	// MaxTemplateTxns caps the number of pool transactions in block
+	// templates, keeping the ones with the highest fee rate. Zero disables
+	// the cap.
+	MaxTemplateTxns int `yaml:"maxTemplateTxns,omitempty"`
+	// RelayPeers are sent submitted v2 blocks directly, in addition to the
+	// broadcast to all connected peers.
+	RelayPeers []string `yaml:"relayPeers,omitempty"`
+}
+
+// HTTP extends walletd's HTTP config with minerd specific settings.
+type HTTP struct {
+	config.HTTP `yaml:",inline"`
+	TLSCert     string `yaml:"tlsCert,omitempty"`
+	TLSKey      string `yaml:"tlsKey,omitempty"`
+	// UnixSocketMode is the octal file mode of the Unix socket if Address
+	// starts with "unix:".
+	UnixSocketMode string `yaml:"unixSocketMode,omitempty"`
+	// AllowedCIDRs restricts the mining API to clients in the given
+	// networks. If empty, all clients are allowed.
+	AllowedCIDRs []string `yaml:"allowedCIDRs,omitempty"`
+	// TrustedProxies are the networks of reverse proxies whose
+	// X-Forwarded-For header is used to determine the client address.
+	TrustedProxies []string `yaml:"trustedProxies,omitempty"`
+}
+
+// Consensus extends walletd's consensus config with minerd specific settings.
+type Consensus struct {
+	config.Consensus `yaml:",inline"`
+	// NoAutoReset prevents the consensus database from being deleted
+	// automatically if it needs to be resynced after an update.
+	NoAutoReset bool `yaml:"noAutoReset,omitempty"`
+	// MaxReorgDepth is the maximum number of blocks the node reverts to
+	// switch to a different chain. Blocks forking off deeper are rejected. If
+	// zero, reorgs of any depth are accepted.
+	MaxReorgDepth uint64 `yaml:"maxReorgDepth,omitempty"`
+	// DatabasePath overrides the path of the consensus database. If empty,
+	// consensus.db in the data directory is used.
+	DatabasePath string `yaml:"databasePath,omitempty"`
+}
+
+// Syncer extends walletd's syncer config with minerd specific settings.
+type Syncer struct {
+	config.Syncer `yaml:",inline"`
+	// DialTimeout is the timeout for connecting to a peer.
+	DialTimeout time.Duration `yaml:"dialTimeout,omitempty"`
+	// MaxOutbound is the maximum number of outbound peer connections.
+	MaxOutbound int `yaml:"maxOutbound,omitempty"`
+}
+
+// Syslog contains the configuration for logging to syslog.
+type Syslog struct {
+	Enabled bool            `yaml:"enabled,omitempty"`
+	Level   zap.AtomicLevel `yaml:"level,omitempty"`
+	Format  string          `yaml:"format,omitempty"`
+	// Network is "udp" or "tcp" to log to a remote syslog daemon at
+	// Address. If empty, the local syslog daemon is used.
+	Network  string `yaml:"network,omitempty"`
+	Address  string `yaml:"address,omitempty"`
+	Facility string `yaml:"facility,omitempty"`
+	Tag      string `yaml:"tag,omitempty"`
+}
+
+// Log extends walletd's log config with minerd specific settings.
+type Log struct {
+	config.Log `yaml:",inline"`
+	Syslog     Syslog `yaml:"syslog,omitempty"`
+}
+
+// Index extends walletd's index config with minerd specific settings.
+type Index struct {
+	config.Index `yaml:",inline"`
+	// VacuumInterval is the interval at which the wallet database is
+	// vacuumed and optimized. If zero, the database is never vacuumed.
+	VacuumInterval time.Duration `yaml:"vacuumInterval,omitempty"`
+	// DatabasePath overrides the path of the wallet database. If empty,
+	// minerd.sqlite3 in the data directory is used.
+	DatabasePath string `yaml:"databasePath,omitempty"`
+	// LagWarnThreshold is the number of blocks the wallet indexer may lag
+	// behind the chain tip before a warning is logged. Zero disables the
+	// warning.
+	LagWarnThreshold uint64 `yaml:"lagWarnThreshold"`
 }
 
+// Config mirrors walletd's config with minerd specific extensions.
 type Config struct {
-	config.Config `yaml:",inline"`
-	Mining        Mining `yaml:"mining,omitempty"`
+	Name          string `yaml:"name,omitempty"`
+	Directory     string `yaml:"directory,omitempty"`
+	AutoOpenWebUI bool   `yaml:"autoOpenWebUI,omitempty"`
+	Debug         bool   `yaml:"debug,omitempty"`
+	// PIDFile is the path the process's PID is written to on startup. It is
+	// removed on shutdown. If empty, no PID file is written.
+	PIDFile string `yaml:"pidFile,omitempty"`
+
+	HTTP      HTTP      `yaml:"http,omitempty"`
+	Consensus Consensus `yaml:"consensus,omitempty"`
+	Syncer    Syncer    `yaml:"syncer,omitempty"`
+	Log       Log       `yaml:"log,omitempty"`
+	Index     Index     `yaml:"index,omitempty"`
+
+	Checkpoint types.ChainIndex `yaml:"checkpoint,omitempty"`
+
+	Mining Mining `yaml:"mining,omitempty"`
+
+	// Profiles are named deployments, e.g. one per network, selected with
+	// the -profile flag.
+	Profiles map[string]Profile `yaml:"profiles,omitempty"`
+}
+
+// A Profile overrides the settings of a deployment when selected with the
+// -profile flag. Empty fields keep the value of the rest of the config.
+type Profile struct {
+	Directory     string `yaml:"directory,omitempty"`
+	Network       string `yaml:"network,omitempty"`
+	HTTPAddress   string `yaml:"httpAddress,omitempty"`
+	SyncerAddress string `yaml:"syncerAddress,omitempty"`
+	// PayoutAddress and PayoutWallet replace both payout settings of the
+	// rest of the config if either is set.
+	PayoutAddress string `yaml:"payoutAddress,omitempty"`
+	PayoutWallet  string `yaml:"payoutWallet,omitempty"`
 }
 
 var cfg = Config{
-	Config: config.Config{
-		Name:          "minerd",
-		Directory:     os.Getenv(dataDirEnvVar),
-		AutoOpenWebUI: true,
+	Name:          "minerd",
+	Directory:     os.Getenv(dataDirEnvVar),
+	AutoOpenWebUI: true,
+	HTTP: HTTP{
 		HTTP: config.HTTP{
 			Address:         "localhost:9980",
 			Password:        os.Getenv(apiPasswordEnvVar),
 			PublicEndpoints: false,
 		},
+		UnixSocketMode: "0600",
+	},
+	Syncer: Syncer{
 		Syncer: config.Syncer{
 			Address:   ":9981",
 			Bootstrap: true,
 		},
+		DialTimeout: 10 * time.Second,
+		MaxOutbound: 16,
+	},
+	Consensus: Consensus{
 		Consensus: config.Consensus{
 			Network: "mainnet",
 		},
+	},
+	Index: Index{
 		Index: config.Index{
 			Mode:      wallet.IndexModePersonal,
 			BatchSize: 1000,
 		},
+		LagWarnThreshold: 1000,
+	},
+	Log: Log{
 		Log: config.Log{
 			Level: zap.NewAtomicLevelAt(zapcore.InfoLevel),
 			File: config.LogFile{
@@ -102,10 +390,26 @@ var cfg = Config{
 				EnableANSI: runtime.GOOS != "windows",
 			},
 		},
+		Syslog: Syslog{
+			Format:   "json",
+			Facility: "daemon",
+			Tag:      "minerd",
+		},
 	},
 	Mining: Mining{
-		MaxTemplateAge: 0,
-		PayoutAddress:  os.Getenv(payoutAddrEnvVar),
+		MaxTemplateAge:        0,
+		LongPollJitter:        0.1,
+		SlowTemplateThreshold: 500 * time.Millisecond,
+		AddBlockTimeout:       2 * time.Minute,
+		TemplateWatchdog:      time.Minute,
+		ReorgLogMinDepth:      1,
+		PayoutIndexStep:       1,
+		PayoutGapLimit:        20,
+		PayoutAddress:         os.Getenv(payoutAddrEnvVar),
+		Seed:                  os.Getenv(payoutSeedEnvVar),
+		CoinbaseFlags:         "/minerd:" + build.Version() + "/",
+
+		RequireTransactionsMaxWait: 30 * time.Second,
 	},
 }
 
@@ -120,7 +424,7 @@ func mustSetAPIPassword() {
 		fmt.Println("This password will be required to access the admin UI in your web browser.")
 		fmt.Println("(The password must be at least 4 characters.)")
 		cfg.HTTP.Password = readPasswordInput("Enter password")
-		if len(cfg.HTTP.Password) >= 4 {
+		if len(cfg.HTTP.Password) >= minAPIPasswordLength {
 			break
 		}
 
@@ -154,6 +458,22 @@ func tryLoadConfig() string {
 	return ""
 }
 
+// printConfigPaths prints the paths searched for a config file in the order
+// they are checked and whether they exist.
+func printConfigPaths(loaded string) {
+	for i, fp := range tryConfigPaths() {
+		status := "not found"
+		if fp == loaded {
+			status = "loaded"
+		} else if _, err := os.Stat(fp); err == nil {
+			status = "found"
+		} else if !errors.Is(err, os.ErrNotExist) {
+			status = err.Error()
+		}
+		fmt.Printf("%d. %s (%s)\n", i+1, fp, status)
+	}
+}
+
 // jsonEncoder returns a zapcore.Encoder that encodes logs as JSON intended for
 // parsing.
 func jsonEncoder() zapcore.Encoder {
@@ -192,39 +512,106 @@ func main() {
 	// attempt to load the config file, command line flags will override any
 	// values set in the config file
 	configPath := tryLoadConfig()
-	if configPath != "" {
+	if deprecated, current := configPathMigration(); configPath != "" && configPath == deprecated {
+		log.Warn("loaded config file from deprecated path, run 'minerd migrate-paths' to move it", zap.String("path", configPath), zap.String("newPath", current))
+	} else if configPath != "" {
 		log.Info("loaded config file", zap.String("path", configPath))
+	} else {
+		log.Debug("no config file found", zap.Strings("paths", tryConfigPaths()))
 	}
 	// set the data directory to the default if it is not set
-	cfg.Directory = defaultDataDirectory(cfg.Directory)
+	cfg.Directory = defaultDataDirectory(cfg.Directory, cfg.Index.DatabasePath)
 
 	indexModeStr := cfg.Index.Mode.String()
 
 	var minerAddrStr string
 	var minerBlocks int
+	var minerAllowIsolated bool
+	var minerMaxDifficulty consensus.Work
+	var minerNode string
+	var minerInsecure bool
+	var minerEmptyBlocks bool
+	var minerOutput string
+	var benchDuration time.Duration
 	var enableDebug bool
+	var resetData bool
+	var ephemeral bool
+	var selfTest bool
+	var profile string
+	var debugConfigPaths bool
+	var passwordFile string
+	var sweepTo string
+	var sweepWalletName string
+	var sweepBroadcast bool
 
 	rootCmd := flagg.Root
 	rootCmd.Usage = flagg.SimpleUsage(rootCmd, rootUsage)
 	rootCmd.BoolVar(&enableDebug, "debug", false, "enable debug mode with additional profiling and mining endpoints")
+	rootCmd.BoolVar(&resetData, "reset", false, "delete the consensus and wallet databases before starting, e.g. after switching networks")
+	rootCmd.BoolVar(&ephemeral, "ephemeral", false, "keep all state in memory and discard it on shutdown, e.g. for throwaway testnets. Disables file logging")
+	rootCmd.BoolVar(&selfTest, "selftest", false, "start the node, mine a block from one of its templates and submit it, then exit with a non-zero exit code if it didn't become the tip. Only for devnets, refused on mainnet")
+	rootCmd.StringVar(&profile, "profile", "", "name of the profile in the config file to run, e.g. to run several networks from one config file")
+	rootCmd.BoolVar(&debugConfigPaths, "debugConfigPaths", false, "print the paths searched for a config file in order and exit")
 	rootCmd.StringVar(&cfg.Directory, "dir", cfg.Directory, "directory to store node state in")
-	rootCmd.StringVar(&cfg.HTTP.Address, "http", cfg.HTTP.Address, "address to serve API on")
+	rootCmd.StringVar(&cfg.PIDFile, "pidfile", cfg.PIDFile, "write the process ID to this file on startup and remove it on shutdown. Refuses to start if it refers to a running process")
+	rootCmd.StringVar(&cfg.HTTP.Address, "http", cfg.HTTP.Address, "address to serve API on. Use unix:/path/to/socket to serve on a Unix socket")
 	rootCmd.BoolVar(&cfg.HTTP.PublicEndpoints, "http.public", cfg.HTTP.PublicEndpoints, "disables auth on endpoints that should be publicly accessible when running minerd as a service")
+	rootCmd.StringVar(&cfg.HTTP.TLSCert, "http.tlsCert", cfg.HTTP.TLSCert, "path to a TLS certificate to serve the API over HTTPS. Reloaded on SIGHUP")
+	rootCmd.StringVar(&cfg.HTTP.TLSKey, "http.tlsKey", cfg.HTTP.TLSKey, "path to the TLS certificate's private key")
 
 	rootCmd.StringVar(&cfg.Syncer.Address, "addr", cfg.Syncer.Address, "p2p address to listen on")
 	rootCmd.StringVar(&cfg.Consensus.Network, "network", cfg.Consensus.Network, "network to connect to; must be one of 'mainnet', 'zen', 'anagami', or the path to a custom network file for a local testnet")
+	rootCmd.Uint64Var(&cfg.Consensus.MaxReorgDepth, "consensus.maxReorgDepth", cfg.Consensus.MaxReorgDepth, "reject chains that would revert more than this many blocks (0 for unlimited)")
+	rootCmd.StringVar(&cfg.Consensus.DatabasePath, "consensus.databasePath", cfg.Consensus.DatabasePath, "path of the consensus database. Defaults to consensus.db in the data directory")
+	rootCmd.BoolVar(&cfg.Consensus.NoAutoReset, "consensus.noAutoReset", cfg.Consensus.NoAutoReset, "refuse to start instead of deleting the consensus database if it needs to be resynced")
 	rootCmd.BoolVar(&cfg.Syncer.EnableUPnP, "upnp", cfg.Syncer.EnableUPnP, "attempt to forward ports and discover IP with UPnP")
 	rootCmd.BoolVar(&cfg.Syncer.Bootstrap, "bootstrap", cfg.Syncer.Bootstrap, "attempt to bootstrap the network")
+	rootCmd.DurationVar(&cfg.Syncer.DialTimeout, "syncer.dialTimeout", cfg.Syncer.DialTimeout, "timeout for connecting to a peer")
+	rootCmd.IntVar(&cfg.Syncer.MaxOutbound, "syncer.maxOutbound", cfg.Syncer.MaxOutbound, "maximum number of outbound peer connections")
 
 	rootCmd.StringVar(&indexModeStr, "index.mode", indexModeStr, "address index mode (personal, full, none)")
+	rootCmd.StringVar(&cfg.Index.DatabasePath, "index.databasePath", cfg.Index.DatabasePath, "path of the wallet database. Defaults to minerd.sqlite3 in the data directory")
+	rootCmd.DurationVar(&cfg.Index.VacuumInterval, "index.vacuumInterval", cfg.Index.VacuumInterval, "interval at which the wallet database is vacuumed (0 to disable)")
+	rootCmd.Uint64Var(&cfg.Index.LagWarnThreshold, "index.lagWarnThreshold", cfg.Index.LagWarnThreshold, "log a warning when the wallet indexer lags behind the chain tip by more than this many blocks (0 to disable)")
 	rootCmd.IntVar(&cfg.Index.BatchSize, "index.batch", cfg.Index.BatchSize, "max number of blocks to index at a time. Increasing this will increase scan speed, but also increase memory and cpu usage.")
 
 	rootCmd.StringVar(&cfg.Mining.PayoutAddress, "mining.payoutAddress", cfg.Mining.PayoutAddress, "payout address to include within block templates")
+	rootCmd.StringVar(&cfg.Mining.PayoutPolicy, "mining.payoutPolicy", cfg.Mining.PayoutPolicy, "spend policy of the payout address to register with the embedded wallet, e.g. thresh(2,[pk(0x...),pk(0x...)])")
+	rootCmd.StringVar(&cfg.Mining.PayoutWallet, "mining.payoutWallet", cfg.Mining.PayoutWallet, "name of a wallet whose address to include as the payout address within block templates")
+	rootCmd.StringVar(&cfg.Mining.SeedFile, "mining.seedFile", cfg.Mining.SeedFile, "path to a file containing a recovery phrase to derive payout addresses from")
+	rootCmd.Uint64Var(&cfg.Mining.PayoutIndexStep, "mining.payoutIndexStep", cfg.Mining.PayoutIndexStep, "number of derivation indices seed-derived payout addresses advance by after being used")
+	rootCmd.Uint64Var(&cfg.Mining.PayoutGapLimit, "mining.payoutGapLimit", cfg.Mining.PayoutGapLimit, "gap limit of the wallet used to recover the payout seed")
 	rootCmd.DurationVar(&cfg.Mining.MaxTemplateAge, "mining.maxTemplateAge", cfg.Mining.MaxTemplateAge, "max age of a template before it gets invalidated. By default there is no max age")
+	rootCmd.DurationVar(&cfg.Mining.MaxTemplateAgeCeiling, "mining.maxTemplateAgeCeiling", cfg.Mining.MaxTemplateAgeCeiling, "highest max template age long polling requests may ask for. By default requests can only shorten it")
+	rootCmd.DurationVar(&cfg.Mining.ReorgDebounce, "mining.reorgDebounce", cfg.Mining.ReorgDebounce, "coalesce template invalidations caused by reorgs within this window (0 to disable)")
+	rootCmd.DurationVar(&cfg.Mining.TemplateWatchdog, "mining.templateWatchdog", cfg.Mining.TemplateWatchdog, "invalidate the cached template if it still builds on a previous tip after this long (0 to disable)")
+	rootCmd.Uint64Var(&cfg.Mining.ReorgLogMinDepth, "mining.reorgLogMinDepth", cfg.Mining.ReorgLogMinDepth, "log reorgs reverting at least this many blocks at info level (0 to log every tip change)")
+	rootCmd.TextVar(&cfg.Mining.MinDifficulty, "mining.minDifficulty", cfg.Mining.MinDifficulty, "reject submitted blocks mined below this difficulty (0 to derive from the network)")
+	rootCmd.TextVar(&cfg.Mining.MaxDifficulty, "mining.maxDifficulty", cfg.Mining.MaxDifficulty, "reject submitted blocks mined above this difficulty (0 for no limit)")
+	rootCmd.Uint64Var(&cfg.Mining.NonceRangeSize, "mining.nonceRangeSize", cfg.Mining.NonceRangeSize, "number of nonces allocated to a worker per allocaterange request (0 for the default of 2^32)")
+	rootCmd.DurationVar(&cfg.Mining.AddBlockTimeout, "mining.addBlockTimeout", cfg.Mining.AddBlockTimeout, "max duration submit requests wait for a block to be validated. The block is still processed if it takes longer (0 to disable)")
+	rootCmd.DurationVar(&cfg.Mining.SlowTemplateThreshold, "mining.slowTemplateThreshold", cfg.Mining.SlowTemplateThreshold, "log a warning when generating a block template takes longer than this (0 to disable)")
+	rootCmd.Float64Var(&cfg.Mining.LongPollJitter, "mining.longPollJitter", cfg.Mining.LongPollJitter, "fraction of the max template age by which long polling requests expire early or late to stagger clients")
+	rootCmd.DurationVar(&cfg.Mining.StartupGracePeriod, "mining.startupGracePeriod", cfg.Mining.StartupGracePeriod, "if set, templates are not served until the node has been running for this long or is connected to a peer")
+	rootCmd.StringVar(&cfg.Mining.CoinbaseFlags, "mining.coinbaseFlags", cfg.Mining.CoinbaseFlags, "marker added to the arbitrary data of block templates (empty to disable)")
+	rootCmd.BoolVar(&cfg.Mining.RequireTransactions, "mining.requireTransactions", cfg.Mining.RequireTransactions, "hold block templates until they contain a transaction paying a fee, e.g. for integration tests")
+	rootCmd.DurationVar(&cfg.Mining.RequireTransactionsMaxWait, "mining.requireTransactionsMaxWait", cfg.Mining.RequireTransactionsMaxWait, "serve templates without transactions after waiting this long if mining.requireTransactions is set")
+	rootCmd.BoolVar(&cfg.Mining.EmptyBlocks, "mining.emptyBlocks", cfg.Mining.EmptyBlocks, "generate block templates without any pool transactions")
+	rootCmd.IntVar(&cfg.Mining.MaxTemplateTxns, "mining.maxTemplateTxns", cfg.Mining.MaxTemplateTxns, "max number of pool transactions in block templates (0 for no limit)")
+	rootCmd.BoolVar(&cfg.Mining.JSONRPC, "mining.jsonRPC", cfg.Mining.JSONRPC, "serve the mining methods as JSON-RPC 2.0 at /api/mining/rpc")
+	rootCmd.Uint64Var(&cfg.Mining.MinHeight, "mining.minHeight", cfg.Mining.MinHeight, "refuse to serve block templates below this height (0 to serve templates at any height)")
+	rootCmd.BoolVar(&cfg.Mining.AcceptKnownBlocks, "mining.acceptKnownBlocks", cfg.Mining.AcceptKnownBlocks, "report submitted blocks the node already has as a success with the result \"duplicate\" instead of an error")
+	rootCmd.BoolVar(&cfg.Mining.AllowUnsyncedSubmissions, "mining.allowUnsyncedSubmissions", cfg.Mining.AllowUnsyncedSubmissions, "accept submitted blocks while the node isn't synced, e.g. on devnets without peers")
+	rootCmd.BoolVar(&cfg.Mining.FullBlockOutlines, "mining.fullBlockOutlines", cfg.Mining.FullBlockOutlines, "include every transaction of submitted v2 blocks in the broadcast outline instead of referencing pooled transactions")
+	rootCmd.DurationVar(&cfg.Mining.TimestampOffset, "mining.timestampOffset", cfg.Mining.TimestampOffset, "if set, template timestamps are the parent block's timestamp plus this offset instead of the current time")
 
 	rootCmd.TextVar(&cfg.Log.Level, "log.level", cfg.Log.Level, "log level (debug, info, warn, error)")
 	rootCmd.BoolVar(&cfg.Log.File.Enabled, "log.file.enabled", cfg.Log.File.Enabled, "enable file logging")
 	rootCmd.BoolVar(&cfg.Log.StdOut.Enabled, "log.stdout.enabled", cfg.Log.StdOut.Enabled, "enable stdout logging")
+	rootCmd.BoolVar(&cfg.Log.Syslog.Enabled, "log.syslog.enabled", cfg.Log.Syslog.Enabled, "enable syslog logging")
+	rootCmd.StringVar(&cfg.Log.Syslog.Network, "log.syslog.network", cfg.Log.Syslog.Network, "network of the remote syslog daemon (udp or tcp). If empty, the local syslog daemon is used")
+	rootCmd.StringVar(&cfg.Log.Syslog.Address, "log.syslog.address", cfg.Log.Syslog.Address, "address of the remote syslog daemon")
+	rootCmd.StringVar(&cfg.Log.Syslog.Facility, "log.syslog.facility", cfg.Log.Syslog.Facility, "syslog facility, e.g. daemon or local0")
 
 	versionCmd := flagg.New("version", versionUsage)
 	seedCmd := flagg.New("seed", seedUsage)
@@ -233,6 +620,24 @@ func main() {
 	mineCmd := flagg.New("mine", mineUsage)
 	mineCmd.IntVar(&minerBlocks, "n", -1, "mine this many blocks. If negative, mine indefinitely")
 	mineCmd.StringVar(&minerAddrStr, "addr", "", "address to send block rewards to (required)")
+	mineCmd.BoolVar(&minerAllowIsolated, "allowIsolated", false, "keep mining when the node has no peers, e.g. on an isolated devnet")
+	mineCmd.TextVar(&minerMaxDifficulty, "maxDifficulty", minerMaxDifficulty, "stop mining once the network difficulty exceeds this value. If zero, there is no cap")
+	mineCmd.StringVar(&minerNode, "node", "", "URL or host:port of the node to mine with. If empty, the local node is used")
+	mineCmd.BoolVar(&minerInsecure, "insecure", false, "skip verifying the node's TLS certificate, e.g. if it is self-signed")
+	mineCmd.BoolVar(&minerEmptyBlocks, "empty", false, "mine empty blocks without any pool transactions")
+	mineCmd.StringVar(&minerOutput, "output", "text", "format of found blocks, either 'text' or 'json' to write them to stdout as newline-delimited JSON")
+
+	benchTemplateCmd := flagg.New("bench-template", benchTemplateUsage)
+	benchTemplateCmd.DurationVar(&benchDuration, "d", 10*time.Second, "duration of the benchmark")
+
+	healthCheckCmd := flagg.New("healthcheck", healthCheckUsage)
+	migratePathsCmd := flagg.New("migrate-paths", migratePathsUsage)
+	passwdCmd := flagg.New("passwd", passwdUsage)
+	passwdCmd.StringVar(&passwordFile, "file", "", "read the new password from a file instead of stdin")
+	sweepCmd := flagg.New("sweep", sweepUsage)
+	sweepCmd.StringVar(&sweepTo, "to", "", "address to send the mature payouts to (required)")
+	sweepCmd.StringVar(&sweepWalletName, "wallet", payoutWalletName, "name of the wallet to sweep")
+	sweepCmd.BoolVar(&sweepBroadcast, "broadcast", false, "broadcast the signed transaction")
 
 	cmd := flagg.Parse(flagg.Tree{
 		Cmd: rootCmd,
@@ -241,20 +646,41 @@ func main() {
 			{Cmd: versionCmd},
 			{Cmd: seedCmd},
 			{Cmd: mineCmd},
+			{Cmd: benchTemplateCmd},
+			{Cmd: healthCheckCmd},
+			{Cmd: migratePathsCmd},
+			{Cmd: passwdCmd},
+			{Cmd: sweepCmd},
 		},
 	})
 
+	if profile != "" {
+		// flags passed explicitly take precedence over the profile
+		set := make(map[string]bool)
+		rootCmd.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		checkFatalError("failed to apply profile", applyProfile(&cfg, profile, set))
+		log.Info("using profile", zap.String("profile", profile), zap.String("network", cfg.Consensus.Network), zap.String("dir", cfg.Directory))
+	}
+
 	switch cmd {
 	case rootCmd:
 		if len(cmd.Args()) != 0 {
 			cmd.Usage()
 			return
+		} else if debugConfigPaths {
+			printConfigPaths(configPath)
+			return
 		}
 
 		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGKILL)
 		defer cancel()
 
-		if cfg.Directory != "" {
+		if ephemeral && resetData {
+			checkFatalError("invalid flags", errors.New("-reset has no effect in ephemeral mode"))
+		} else if ephemeral {
+			// nothing is written to the data directory
+			cfg.Log.File.Enabled = false
+		} else if cfg.Directory != "" {
 			checkFatalError("failed to create data directory", os.MkdirAll(cfg.Directory, 0700))
 		}
 
@@ -262,6 +688,12 @@ func main() {
 
 		checkFatalError("failed to parse index mode", cfg.Index.Mode.UnmarshalText([]byte(indexModeStr)))
 
+		if cfg.Mining.MaxTemplateAge > 0 && cfg.Mining.MaxTemplateAge < minMaxTemplateAge {
+			checkFatalError("invalid max template age", fmt.Errorf("max template age must be at least %v, got %v", minMaxTemplateAge, cfg.Mining.MaxTemplateAge))
+		} else if cfg.Mining.MaxTemplateAgeCeiling < 0 || (cfg.Mining.MaxTemplateAgeCeiling > 0 && cfg.Mining.MaxTemplateAgeCeiling < minMaxTemplateAge) {
+			checkFatalError("invalid max template age ceiling", fmt.Errorf("max template age ceiling must be at least %v, got %v", minMaxTemplateAge, cfg.Mining.MaxTemplateAgeCeiling))
+		}
+
 		var logCores []zapcore.Core
 		if cfg.Log.StdOut.Enabled {
 			// if no log level is set for stdout, use the global log level
@@ -306,7 +738,29 @@ func main() {
 			defer closeFn()
 
 			// create the file logger
-			logCores = append(logCores, zapcore.NewCore(encoder, zapcore.Lock(fileWriter), cfg.Log.File.Level))
+			logCores = append(logCores, zapcore.NewCore(encoder, newLogFileWriter(fileWriter, cfg.Log.File.Path, cfg.Log.StdOut.Enabled), cfg.Log.File.Level))
+		}
+
+		if cfg.Log.Syslog.Enabled {
+			// if no log level is set for syslog, use the global log level
+			if cfg.Log.Syslog.Level == (zap.AtomicLevel{}) {
+				cfg.Log.Syslog.Level = cfg.Log.Level
+			}
+
+			var encoder zapcore.Encoder
+			switch cfg.Log.Syslog.Format {
+			case "human":
+				encoder = humanEncoder(false)
+			default: // syslog defaults to JSON
+				encoder = jsonEncoder()
+			}
+
+			syslogCore, closeFn, err := newSyslogCore(cfg.Log.Syslog.Network, cfg.Log.Syslog.Address, cfg.Log.Syslog.Facility, cfg.Log.Syslog.Tag, encoder, cfg.Log.Syslog.Level)
+			checkFatalError("failed to initialize syslog", err)
+			defer closeFn()
+
+			// create the syslog logger
+			logCores = append(logCores, syslogCore)
 		}
 
 		var log *zap.Logger
@@ -320,7 +774,35 @@ func main() {
 		// redirect stdlib log to zap
 		zap.RedirectStdLog(log.Named("stdlib"))
 
-		checkFatalError("failed to run node", runNode(ctx, cfg, log, enableDebug))
+		if cfg.Mining.MaxTemplateAge > 0 && cfg.Mining.MaxTemplateAge < recommendedMinMaxTemplateAge {
+			log.Warn("max template age is very low. Every expiry forces miners to fetch a new template and restart their work, which wastes hashrate and increases the load on the node. Consider increasing it unless templates need to be refreshed this often",
+				zap.Duration("maxTemplateAge", cfg.Mining.MaxTemplateAge),
+				zap.Duration("recommended", recommendedMinMaxTemplateAge))
+		}
+
+		if cfg.PIDFile != "" {
+			checkFatalError("failed to write PID file", writePIDFile(cfg.PIDFile))
+			log.Debug("wrote PID file", zap.String("path", cfg.PIDFile), zap.Int("pid", os.Getpid()))
+		}
+		var err error
+		if selfTest {
+			err = runNodeSelfTest(ctx, cfg, configPath, log, enableDebug, resetData, ephemeral)
+		} else {
+			err = runNode(ctx, cfg, configPath, log, enableDebug, resetData, ephemeral, nil)
+		}
+		if cfg.PIDFile != "" {
+			// removed before exiting since deferred calls don't run on
+			// os.Exit
+			if err := removePIDFile(cfg.PIDFile); err != nil {
+				log.Warn("failed to remove PID file", zap.String("path", cfg.PIDFile), zap.Error(err))
+			}
+		}
+		if errors.Is(err, errAddressInUse) {
+			os.Stderr.WriteString(fmt.Sprintf("failed to run node: %s\n", err))
+			os.Exit(exitCodeAddressInUse)
+		} else {
+			checkFatalError("failed to run node", err)
+		}
 	case versionCmd:
 		if len(cmd.Args()) != 0 {
 			cmd.Usage()
@@ -354,10 +836,77 @@ func main() {
 			return
 		}
 
-		minerAddr, err := types.ParseAddress(minerAddrStr)
-		checkFatalError("failed to parse miner address", err)
+		minerAddr, err := parsePayoutAddress(minerAddrStr)
+		checkFatalError("invalid miner address", err)
+		if minerOutput != "text" && minerOutput != "json" {
+			checkFatalError("invalid output format", fmt.Errorf("must be 'text' or 'json', got %q", minerOutput))
+		}
+		var nodeURL string
+		if minerNode != "" {
+			nodeURL, err = nodeAPIURL(minerNode)
+			checkFatalError("invalid node address", err)
+		} else {
+			nodeURL, err = apiURL(cfg.HTTP)
+			checkFatalError("failed to determine API address", err)
+		}
+		if minerInsecure {
+			disableTLSVerification()
+		}
+		mustSetAPIPassword()
+		runCPUMiner(nodeURL, cfg.HTTP.Password, minerAddr, minerBlocks, minerAllowIsolated, minerEmptyBlocks, minerMaxDifficulty, minerOutput == "json")
+	case benchTemplateCmd:
+		if len(cmd.Args()) != 0 {
+			cmd.Usage()
+			return
+		}
+
+		mustSetAPIPassword()
+		nodeURL, err := apiURL(cfg.HTTP)
+		checkFatalError("failed to determine API address", err)
+		runTemplateBenchmark(nodeURL, cfg.HTTP.Password, benchDuration)
+	case healthCheckCmd:
+		if len(cmd.Args()) != 0 {
+			cmd.Usage()
+			return
+		}
+
+		nodeURL, err := apiURL(cfg.HTTP)
+		checkFatalError("failed to determine API address", err)
+		checkFatalError("node is unhealthy", runHealthCheck(nodeURL, cfg.HTTP.Password))
+		fmt.Println("node is healthy")
+	case migratePathsCmd:
+		if len(cmd.Args()) != 0 {
+			cmd.Usage()
+			return
+		}
+
+		migrated, err := migrateConfigPath()
+		checkFatalError("failed to migrate config file", err)
+		if deprecated, current := configPathMigration(); migrated {
+			fmt.Printf("Moved config file from %q to %q\n", deprecated, current)
+		} else {
+			fmt.Println("Nothing to migrate")
+		}
+	case passwdCmd:
+		if len(cmd.Args()) != 0 {
+			cmd.Usage()
+			return
+		}
+
+		checkFatalError("failed to change API password", runPasswd(configPath, passwordFile))
+	case sweepCmd:
+		if len(cmd.Args()) != 0 {
+			cmd.Usage()
+			return
+		}
+
+		dest, err := parsePayoutAddress(sweepTo)
+		checkFatalError("invalid destination address", err)
+		phrase, err := loadSeedPhrase(cfg.Mining)
+		checkFatalError("failed to load recovery phrase", err)
 		mustSetAPIPassword()
-		c := api.NewClient("http://"+cfg.HTTP.Address+"/api", cfg.HTTP.Password)
-		runCPUMiner(c, minerAddr, minerBlocks)
+		nodeURL, err := apiURL(cfg.HTTP)
+		checkFatalError("failed to determine API address", err)
+		checkFatalError("failed to sweep payouts", runSweep(nodeURL, cfg.HTTP.Password, sweepWalletName, dest, phrase, sweepBroadcast))
 	}
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// configPathMigration returns the deprecated config path of the Linux service
+// and the path that replaced it. Both are empty on systems without a
+// deprecated path.
+func configPathMigration() (deprecated, current string) {
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd":
+		return filepath.Join(string(filepath.Separator), "var", "lib", "minerd", "minerd.yml"),
+			filepath.Join(string(filepath.Separator), "etc", "minerd", "minerd.yml")
+	default:
+		return "", ""
+	}
+}
+
+// migrateConfigPath moves the config file from the deprecated path to the
+// current default path. It returns false if there is nothing to migrate.
+func migrateConfigPath() (bool, error) {
+	deprecated, current := configPathMigration()
+	if deprecated == "" {
+		return false, nil
+	} else if _, err := os.Stat(deprecated); errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to stat %q: %w", deprecated, err)
+	} else if _, err := os.Stat(current); err == nil {
+		return false, fmt.Errorf("config files exist at both %q and %q, remove one of them", deprecated, current)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("failed to stat %q: %w", current, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(current), 0700); err != nil {
+		return false, fmt.Errorf("failed to create config directory: %w", err)
+	} else if err := os.Rename(deprecated, current); err == nil {
+		return true, nil
+	}
+
+	// renaming fails if the paths are on different file systems, so fall
+	// back to copying the file
+	if err := copyFile(deprecated, current); err != nil {
+		os.Remove(current)
+		return false, fmt.Errorf("failed to copy config file: %w", err)
+	} else if err := os.Remove(deprecated); err != nil {
+		return false, fmt.Errorf("failed to remove %q after copying it: %w", deprecated, err)
+	}
+	return true, nil
+}
+
+// copyFile copies the file at src to dst, keeping its permissions.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	} else if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	} else if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
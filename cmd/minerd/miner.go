@@ -1,35 +1,172 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math/big"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 
+	"go.sia.tech/core/consensus"
 	"go.sia.tech/core/types"
 	"go.sia.tech/coreutils"
-	"go.sia.tech/walletd/v2/api"
+	"go.sia.tech/minerd/api"
 	"lukechampine.com/frand"
 )
 
-func runCPUMiner(c *api.Client, minerAddr types.Address, n int) {
+// nodeAPIURL returns the base URL of the API of the node at the given address.
+// The address is either a URL, e.g. https://node.example.com:9980, or a host
+// and port, which is reached over plain HTTP. If the URL has no path, the API
+// is expected under "/api".
+func nodeAPIURL(node string) (string, error) {
+	if !strings.Contains(node, "://") {
+		node = "http://" + node
+	}
+	u, err := url.Parse(node)
+	if err != nil {
+		return "", fmt.Errorf("invalid node address: %w", err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid node address: unsupported scheme %q", u.Scheme)
+	} else if u.Host == "" {
+		return "", errors.New("invalid node address: missing host")
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/api"
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// isUnauthorized returns true if err was caused by a rejected API password.
+func isUnauthorized(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "unauthorized")
+}
+
+// isNotFound returns true if err was caused by a route the node doesn't
+// serve, e.g. an endpoint added in a newer version.
+func isNotFound(err error) bool {
+	return strings.Contains(err.Error(), "404 page not found")
+}
+
+// isSuperseded checks whether a block that failed to be submitted was
+// rejected because another block was found first.
+func isSuperseded(c *api.Client, b types.Block) bool {
+	reason, err := c.MiningProposeBlock(context.Background(), b)
+	return err == nil && (reason == api.ProposalRejectBadPrevBlock || reason == api.ProposalRejectDuplicate)
+}
+
+// peerCheckInterval is the interval at which a paused miner checks whether
+// the node is connected to peers again.
+const peerCheckInterval = 5 * time.Second
+
+// waitForPeers blocks until the node is connected to at least one peer.
+// Blocks mined without peers can't propagate and will likely be orphaned once
+// the node reconnects.
+func waitForPeers(c *api.Client) {
+	var paused bool
+	for {
+		peers, err := c.SyncerPeers()
+		checkFatalError("failed to get peers:", err)
+		if len(peers) > 0 {
+			if paused {
+				log.Println("Node is connected to peers again, resuming mining")
+			}
+			return
+		} else if !paused {
+			log.Println("WARN: node has no peers, pausing mining until it reconnects")
+			paused = true
+		}
+		time.Sleep(peerCheckInterval)
+	}
+}
+
+// miningRoundDuration is how long the miner hashes a block before fetching a
+// new one. A pause takes effect at the end of the current round.
+const miningRoundDuration = time.Minute
+
+// waitForResume blocks while mining is paused through the API. Nodes that
+// don't serve the miner status, e.g. remote nodes running an older version,
+// are treated as never paused.
+func waitForResume(c *api.Client) {
+	var paused bool
+	for {
+		status, err := c.MiningMinerStatus(context.Background())
+		if err != nil && isNotFound(err) {
+			return
+		}
+		checkFatalError("failed to get miner status:", err)
+		if status.Status != api.MinerStatusPaused {
+			if paused {
+				log.Println("Mining resumed")
+			}
+			return
+		} else if !paused {
+			log.Println("Mining paused through the API, waiting to be resumed")
+			paused = true
+		}
+		time.Sleep(peerCheckInterval)
+	}
+}
+
+// A foundBlock is written to stdout for every block found by the CPU miner if
+// the JSON output is enabled.
+type foundBlock struct {
+	Height    uint64         `json:"height"`
+	ID        types.BlockID  `json:"id"`
+	Version   int            `json:"version"`
+	Nonce     uint64         `json:"nonce"`
+	Timestamp time.Time      `json:"timestamp"`
+	Reward    types.Currency `json:"reward"`
+}
+
+// runCPUMiner mines n blocks, or indefinitely if n is negative. If
+// maxDifficulty is non-zero, mining stops once the network difficulty exceeds
+// it. If empty is set, the blocks don't include any pool transactions. If
+// jsonOutput is set, found blocks are written to stdout as newline-delimited
+// JSON and the status output goes to stderr.
+func runCPUMiner(addr, password string, minerAddr types.Address, n int, allowIsolated, empty bool, maxDifficulty consensus.Work, jsonOutput bool) {
+	c := api.NewClient(addr, password)
 	log.Println("Started mining into", minerAddr)
 	start := time.Now()
 
+	var status io.Writer = os.Stdout
+	if jsonOutput {
+		status = os.Stderr
+	}
+	enc := json.NewEncoder(os.Stdout)
+
 	var blocksFound int
 	for {
 		if n >= 0 && blocksFound >= n {
 			break
+		} else if !allowIsolated {
+			waitForPeers(c)
 		}
+		// the tip state is fetched after waiting, so a resumed miner never
+		// continues on a stale block
+		waitForResume(c)
 		elapsed := time.Since(start)
 		cs, err := c.ConsensusTipState()
 		checkFatalError("failed to get consensus tip state:", err)
+		if maxDifficulty != (consensus.Work{}) && cs.Difficulty.Cmp(maxDifficulty) > 0 {
+			log.Printf("Network difficulty %v exceeds the maximum difficulty %v, stopping", cs.Difficulty, maxDifficulty)
+			return
+		}
 		d, _ := new(big.Int).SetString(cs.PoWTarget().String(), 10)
 		d.Mul(d, big.NewInt(int64(1+elapsed)))
-		fmt.Printf("\rMining block %4v...(%.2f blocks/day), difficulty %v)", cs.Index.Height+1, float64(blocksFound)*float64(24*time.Hour)/float64(elapsed), cs.Difficulty)
+		fmt.Fprintf(status, "\rMining block %4v...(%.2f blocks/day), difficulty %v)", cs.Index.Height+1, float64(blocksFound)*float64(24*time.Hour)/float64(elapsed), cs.Difficulty)
 
-		_, txns, v2txns, err := c.TxpoolTransactions()
-		checkFatalError("failed to get pool transactions:", err)
+		var txns []types.Transaction
+		var v2txns []types.V2Transaction
+		if !empty {
+			_, txns, v2txns, err = c.TxpoolTransactions()
+			checkFatalError("failed to get pool transactions:", err)
+		}
 		b := types.Block{
 			ParentID:     cs.Index.ID,
 			Nonce:        cs.NonceFactor() * frand.Uint64n(100),
@@ -50,7 +187,7 @@ func runCPUMiner(c *api.Client, minerAddr types.Address, n int) {
 			}
 			b.V2.Commitment = cs.Commitment(b.MinerPayouts[0].Address, b.Transactions, b.V2Transactions())
 		}
-		if !coreutils.FindBlockNonce(cs, &b, time.Minute) {
+		if !coreutils.FindBlockNonce(cs, &b, miningRoundDuration) {
 			continue
 		}
 		blocksFound++
@@ -58,13 +195,33 @@ func runCPUMiner(c *api.Client, minerAddr types.Address, n int) {
 		tip, err := c.ConsensusTip()
 		checkFatalError("failed to get consensus tip:", err)
 		if tip != cs.Index {
-			fmt.Printf("\nMined %v but tip changed, starting over\n", index)
-		} else if err := c.SyncerBroadcastBlock(b); err != nil {
-			fmt.Printf("\nMined invalid block: %v\n", err)
-		} else if b.V2 == nil {
-			fmt.Printf("\nFound v1 block %v\n", index)
-		} else {
-			fmt.Printf("\nFound v2 block %v\n", index)
+			fmt.Fprintf(status, "\nBlock %v superseded, starting over\n", index)
+			continue
+		} else if err := c.SyncerBroadcastBlock(b); err != nil && isUnauthorized(err) {
+			checkFatalError("failed to submit block", err)
+		} else if err != nil && isSuperseded(c, b) {
+			fmt.Fprintf(status, "\nBlock %v superseded, starting over\n", index)
+			continue
+		} else if err != nil {
+			fmt.Fprintf(status, "\nMined invalid block: %v\n", err)
+			continue
+		}
+
+		version := 1
+		if b.V2 != nil {
+			version = 2
+		}
+		fmt.Fprintf(status, "\nFound v%d block %v\n", version, index)
+		if jsonOutput {
+			err := enc.Encode(foundBlock{
+				Height:    index.Height,
+				ID:        index.ID,
+				Version:   version,
+				Nonce:     b.Nonce,
+				Timestamp: b.Timestamp,
+				Reward:    b.MinerPayouts[0].Value,
+			})
+			checkFatalError("failed to write found block", err)
 		}
 	}
 }
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.sia.tech/minerd/api"
+)
+
+func TestWaitForResumeWithoutMinerStatus(t *testing.T) {
+	// a remote node running a version without the pause endpoints
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	done := make(chan struct{})
+	go func() {
+		waitForResume(api.NewClient(srv.URL+"/api", "password"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a node without the miner status to be treated as not paused")
+	}
+}
@@ -2,16 +2,21 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"go.sia.tech/core/consensus"
@@ -27,7 +32,7 @@ import (
 	"go.sia.tech/walletd/v2/wallet"
 	"go.sia.tech/web/walletd"
 	"go.uber.org/zap"
-	"lukechampine.com/upnp"
+	"lukechampine.com/frand"
 )
 
 func tryConfigPaths() []string {
@@ -48,25 +53,28 @@ func tryConfigPaths() []string {
 	case "darwin":
 		paths = append(paths, filepath.Join(os.Getenv("HOME"), "Library", "Application Support", "minerd", "minerd.yml"))
 	case "linux", "freebsd", "openbsd":
-		paths = append(paths,
-			filepath.Join(string(filepath.Separator), "etc", "minerd", "minerd.yml"),
-			filepath.Join(string(filepath.Separator), "var", "lib", "minerd", "minerd.yml"), // old default for the Linux service
-		)
+		deprecated, current := configPathMigration()
+		paths = append(paths, current, deprecated)
 	}
 	return paths
 }
 
-func defaultDataDirectory(fp string) string {
+// defaultDataDirectory returns fp or, if it is empty, the default data
+// directory. If walletDBPath is set, the wallet database is stored outside of
+// the data directory, so the current directory isn't checked for it.
+func defaultDataDirectory(fp, walletDBPath string) string {
 	// use the provided path if it's not empty
 	if fp != "" {
 		return fp
 	}
 
 	// check for databases in the current directory
-	if _, err := os.Stat("minerd.db"); err == nil {
-		return "."
-	} else if _, err := os.Stat("minerd.sqlite3"); err == nil {
-		return "."
+	if walletDBPath == "" {
+		if _, err := os.Stat("minerd.db"); err == nil {
+			return "."
+		} else if _, err := os.Stat("minerd.sqlite3"); err == nil {
+			return "."
+		}
 	}
 
 	// default to the operating system's application directory
@@ -82,22 +90,6 @@ func defaultDataDirectory(fp string) string {
 	}
 }
 
-func setupUPNP(ctx context.Context, port uint16, log *zap.Logger) (string, error) {
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-	d, err := upnp.Discover(ctx)
-	if err != nil {
-		return "", fmt.Errorf("couldn't discover UPnP router: %w", err)
-	} else if !d.IsForwarded(port, "TCP") {
-		if err := d.Forward(uint16(port), "TCP", "minerd"); err != nil {
-			log.Debug("couldn't forward port", zap.Error(err))
-		} else {
-			log.Debug("upnp: forwarded p2p port", zap.Uint16("port", port))
-		}
-	}
-	return d.ExternalIP()
-}
-
 func loadCustomNetwork(fp string) (*consensus.Network, types.Block, error) {
 	f, err := os.Open(fp)
 	if err != nil {
@@ -116,9 +108,140 @@ func loadCustomNetwork(fp string) (*consensus.Network, types.Block, error) {
 	return &network.Network, network.Genesis, nil
 }
 
+// errNetworkMismatch is returned by runNode if the data directory was
+// initialized for a different network.
+var errNetworkMismatch = errors.New("the data directory belongs to a different network")
+
+// networkMismatchHint tells the operator how to resolve errNetworkMismatch.
+const networkMismatchHint = "Use a different data directory or restart with -reset to delete the consensus and wallet databases"
+
+// checkConsensusNetwork checks that the consensus database at fp, if it
+// exists, was initialized with the given network and genesis block.
+func checkConsensusNetwork(fp string, n *consensus.Network, genesis types.Block, log *zap.Logger) error {
+	if _, err := os.Stat(fp); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	bdb, err := coreutils.OpenBoltChainDB(fp)
+	if err != nil {
+		return fmt.Errorf("failed to open consensus database: %w", err)
+	}
+	defer bdb.Close()
+
+	// the store refuses to open a database initialized with a different
+	// network, so the stored network name is compared first
+	if b := bdb.Bucket([]byte("Network")); b != nil {
+		if stored := b.Get([]byte("Network")); len(stored) != 0 && string(stored) != n.Name {
+			return fmt.Errorf("%w (database network %q doesn't match %q). %s", errNetworkMismatch, stored, n.Name, networkMismatchHint)
+		}
+	}
+
+	dbstore, _, err := chain.NewDBStore(bdb, n, genesis, chain.NewZapMigrationLogger(log))
+	if err != nil {
+		return fmt.Errorf("failed to create chain store: %w", err)
+	} else if stored, ok := dbstore.BestIndex(0); ok && stored.ID != genesis.ID() {
+		return fmt.Errorf("%w (database genesis block %v doesn't match network genesis block %v). %s", errNetworkMismatch, stored.ID, genesis.ID(), networkMismatchHint)
+	}
+	return nil
+}
+
+// memoryDBPath is the path of the wallet database in ephemeral mode. SQLite
+// keeps it in memory, and it is discarded once its connection is closed. The
+// store uses a single connection, so the database isn't lost in between.
+const memoryDBPath = ":memory:"
+
+// consensusDBPath returns the path of the consensus database. It defaults to
+// consensus.db in the data directory.
+func consensusDBPath(cfg Config) string {
+	if cfg.Consensus.DatabasePath != "" {
+		return cfg.Consensus.DatabasePath
+	}
+	return filepath.Join(cfg.Directory, "consensus.db")
+}
+
+// walletDBPath returns the path of the wallet database. It defaults to
+// minerd.sqlite3 in the data directory.
+func walletDBPath(cfg Config) string {
+	if cfg.Index.DatabasePath != "" {
+		return cfg.Index.DatabasePath
+	}
+	return filepath.Join(cfg.Directory, "minerd.sqlite3")
+}
+
+// resetDatabases deletes the consensus and wallet databases.
+func resetDatabases(consensusPath, storePath string, log *zap.Logger) error {
+	if removed, err := removeConsensusDB(consensusPath); err != nil {
+		return err
+	} else if removed {
+		log.Info("removed database", zap.String("path", consensusPath))
+	}
+	for _, fp := range []string{storePath, storePath + "-wal", storePath + "-shm"} {
+		if err := os.Remove(fp); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %q: %w", fp, err)
+		} else if err == nil {
+			log.Info("removed database", zap.String("path", fp))
+		}
+	}
+	return nil
+}
+
+// consensusResetSuffix is appended to the path of a consensus database that
+// is being deleted.
+const consensusResetSuffix = ".reset"
+
+// consensusResetAttempts and consensusResetRetryInterval control how often
+// moving the consensus database is retried, e.g. if another process still
+// holds the file open on Windows.
+const (
+	consensusResetAttempts      = 5
+	consensusResetRetryInterval = 200 * time.Millisecond
+)
+
+// removeConsensusDB deletes the consensus database at fp. The database is
+// atomically renamed before it is deleted, so a crash leaves either the
+// intact database or a leftover that is removed by cleanupConsensusReset on
+// the next startup, never a partially deleted database at fp. It returns false
+// if there is no database at fp.
+func removeConsensusDB(fp string) (bool, error) {
+	tmp := fp + consensusResetSuffix
+	var err error
+	for i := 0; i < consensusResetAttempts; i++ {
+		if i > 0 {
+			time.Sleep(consensusResetRetryInterval)
+		}
+		if err = os.Rename(fp, tmp); err == nil || errors.Is(err, os.ErrNotExist) {
+			break
+		}
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to move consensus database: %w", err)
+	} else if err := os.RemoveAll(tmp); err != nil {
+		return false, fmt.Errorf("failed to delete consensus database: %w", err)
+	}
+	return true, nil
+}
+
+// cleanupConsensusReset removes a consensus database left behind by an
+// interrupted removeConsensusDB.
+func cleanupConsensusReset(fp string, log *zap.Logger) error {
+	tmp := fp + consensusResetSuffix
+	if _, err := os.Stat(tmp); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to check for interrupted reset: %w", err)
+	} else if err := os.RemoveAll(tmp); err != nil {
+		return fmt.Errorf("failed to remove consensus database of interrupted reset: %w", err)
+	}
+	log.Info("removed consensus database of interrupted reset", zap.String("path", tmp))
+	return nil
+}
+
 // migrateConsensusDB checks if the consensus database needs to be migrated
-// to match the new v2 commitment.
-func migrateConsensusDB(fp string, n *consensus.Network, genesis types.Block, log *zap.Logger) error {
+// to match the new v2 commitment. If allowReset is false, an error is returned
+// instead of deleting the database.
+func migrateConsensusDB(fp string, n *consensus.Network, genesis types.Block, allowReset bool, log *zap.Logger) error {
 	bdb, err := coreutils.OpenBoltChainDB(fp)
 	if err != nil {
 		return fmt.Errorf("failed to open consensus database: %w", err)
@@ -152,17 +275,38 @@ func migrateConsensusDB(fp string, n *consensus.Network, genesis types.Block, lo
 		return nil
 	}
 	// reset the database if the commitment is not a merkle root
+	if !allowReset {
+		return fmt.Errorf("the consensus database needs to be resynced to match the new v2 commitment but automatic reset is disabled. To resync, stop minerd, delete %q and start minerd again", fp)
+	}
 	log.Debug("resetting consensus database for new v2 commitment")
 	if err := bdb.Close(); err != nil {
 		return fmt.Errorf("failed to close old consensus database: %w", err)
-	} else if err := os.RemoveAll(fp); err != nil {
+	} else if _, err := removeConsensusDB(fp); err != nil {
 		return fmt.Errorf("failed to remove old consensus database: %w", err)
 	}
 	log.Debug("consensus database reset")
 	return nil
 }
 
-func runNode(ctx context.Context, cfg Config, log *zap.Logger, enableDebug bool) error {
+// errAddressInUse is returned by runNode if one of its listen addresses is
+// already in use.
+var errAddressInUse = errors.New("address already in use, is another minerd running?")
+
+// listen listens on the given TCP address. If the address is already in use,
+// the returned error wraps errAddressInUse.
+func listen(addr string) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return nil, fmt.Errorf("failed to listen on %q: %w", addr, errAddressInUse)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to listen on %q: %w", addr, err)
+	}
+	return l, nil
+}
+
+// runNode runs the node until ctx is canceled. If onStarted is not nil, it is
+// called once the API is being served.
+func runNode(ctx context.Context, cfg Config, configPath string, log *zap.Logger, enableDebug, reset, ephemeral bool, onStarted func()) error {
 	var network *consensus.Network
 	var genesisBlock types.Block
 	var bootstrapPeers []string
@@ -181,52 +325,115 @@ func runNode(ctx context.Context, cfg Config, log *zap.Logger, enableDebug bool)
 	}
 	payoutAddr := types.VoidAddress
 	if cfg.Mining.PayoutAddress != "" {
-		if err := payoutAddr.UnmarshalText([]byte(cfg.Mining.PayoutAddress)); err != nil {
-			return fmt.Errorf("failed to parse payout address: %w", err)
+		addr, err := parsePayoutAddress(cfg.Mining.PayoutAddress)
+		if err != nil {
+			return err
 		}
+		payoutAddr = addr
 	}
-
-	consensusPath := filepath.Join(cfg.Directory, "consensus.db")
-	if err := migrateConsensusDB(consensusPath, network, genesisBlock, log.Named("migrate")); err != nil {
-		return fmt.Errorf("failed to open consensus database: %w", err)
+	var payoutPolicy *types.SpendPolicy
+	if cfg.Mining.PayoutPolicy != "" {
+		policy, err := parsePayoutPolicy(cfg.Mining.PayoutPolicy)
+		if err != nil {
+			return err
+		} else if payoutAddr == types.VoidAddress {
+			return errors.New("payout policy requires a payout address")
+		} else if policy.Address() != payoutAddr {
+			return fmt.Errorf("payout policy address %v doesn't match the payout address %v", policy.Address(), payoutAddr)
+		}
+		payoutPolicy = &policy
 	}
-
-	bdb, err := coreutils.OpenBoltChainDB(consensusPath)
+	if cfg.Syncer.DialTimeout <= 0 {
+		return fmt.Errorf("syncer dial timeout must be positive, got %v", cfg.Syncer.DialTimeout)
+	} else if cfg.Syncer.MaxOutbound <= 0 {
+		return fmt.Errorf("syncer max outbound peers must be positive, got %v", cfg.Syncer.MaxOutbound)
+	}
+	if (cfg.HTTP.TLSCert == "") != (cfg.HTTP.TLSKey == "") {
+		return errors.New("both the TLS certificate and key must be set to enable TLS")
+	}
+	payoutSeed, err := loadSeedPhrase(cfg.Mining)
 	if err != nil {
-		return fmt.Errorf("failed to open consensus database: %w", err)
+		return fmt.Errorf("failed to load payout seed: %w", err)
+	} else if payoutSeed != "" && payoutAddr != types.VoidAddress {
+		return errors.New("payout address and payout seed are mutually exclusive")
+	} else if cfg.Mining.PayoutWallet != "" && (payoutSeed != "" || payoutAddr != types.VoidAddress) {
+		return errors.New("payout wallet, payout address and payout seed are mutually exclusive")
+	} else if cfg.Mining.PayoutIndexStep == 0 {
+		return errors.New("payout index step must be positive")
+	} else if cfg.Mining.PayoutIndexStep > cfg.Mining.PayoutGapLimit {
+		return fmt.Errorf("payout index step %d must not exceed the payout gap limit %d, or wallet recovery would miss payout addresses", cfg.Mining.PayoutIndexStep, cfg.Mining.PayoutGapLimit)
+	}
+
+	var db chain.DB
+	consensusPath, storePath := consensusDBPath(cfg), walletDBPath(cfg)
+	if ephemeral {
+		log.Info("running in ephemeral mode, all state is discarded on shutdown")
+		db = chain.NewMemDB()
+		storePath = memoryDBPath
+	} else {
+		for _, fp := range []string{consensusPath, storePath} {
+			if err := os.MkdirAll(filepath.Dir(fp), 0700); err != nil {
+				return fmt.Errorf("failed to create database directory: %w", err)
+			}
+		}
+		if err := cleanupConsensusReset(consensusPath, log.Named("reset")); err != nil {
+			return err
+		}
+		if reset {
+			if err := resetDatabases(consensusPath, storePath, log.Named("reset")); err != nil {
+				return fmt.Errorf("failed to reset databases: %w", err)
+			}
+		}
+
+		if err := checkConsensusNetwork(consensusPath, network, genesisBlock, log.Named("migrate")); err != nil {
+			return err
+		} else if err := migrateConsensusDB(consensusPath, network, genesisBlock, !cfg.Consensus.NoAutoReset, log.Named("migrate")); err != nil {
+			return fmt.Errorf("failed to open consensus database: %w", err)
+		}
+
+		bdb, err := coreutils.OpenBoltChainDB(consensusPath)
+		if err != nil {
+			return fmt.Errorf("failed to open consensus database: %w", err)
+		}
+		defer bdb.Close()
+		db = bdb
 	}
-	defer bdb.Close()
 
-	dbstore, tipState, err := chain.NewDBStore(bdb, network, genesisBlock, chain.NewZapMigrationLogger(log.Named("chaindb")))
+	dbstore, tipState, err := chain.NewDBStore(db, network, genesisBlock, chain.NewZapMigrationLogger(log.Named("chaindb")))
 	if err != nil {
 		return fmt.Errorf("failed to create chain store: %w", err)
 	}
 	cm := chain.NewManager(dbstore, tipState)
 
-	syncerListener, err := net.Listen("tcp", cfg.Syncer.Address)
+	syncerListener, err := listen(cfg.Syncer.Address)
 	if err != nil {
-		return fmt.Errorf("failed to listen on %q: %w", cfg.Syncer.Address, err)
+		return err
 	}
 	defer syncerListener.Close()
 
-	httpListener, err := net.Listen("tcp", cfg.HTTP.Address)
+	httpListener, err := listenHTTP(cfg.HTTP)
 	if err != nil {
-		return fmt.Errorf("failed to listen on %q: %w", cfg.HTTP.Address, err)
+		return err
 	}
 	defer httpListener.Close()
 
 	syncerAddr := syncerListener.Addr().String()
+	var upnpPort uint16
+	var externalIP string
 	if cfg.Syncer.EnableUPnP {
 		_, portStr, _ := net.SplitHostPort(cfg.Syncer.Address)
 		port, err := strconv.ParseUint(portStr, 10, 16)
 		if err != nil {
 			return fmt.Errorf("failed to parse syncer port: %w", err)
 		}
-
-		ip, err := setupUPNP(context.Background(), uint16(port), log)
-		if err != nil {
-			log.Warn("failed to set up UPnP", zap.Error(err))
+		upnpPort = uint16(port)
+		// try once before the address is announced; failures are retried in
+		// the background once the syncer is running
+		if ip, err := setupUPNP(ctx, upnpPort, log.Named("upnp")); err != nil {
+			log.Warn("failed to set up UPnP, retrying in the background", zap.Error(err))
 		} else {
+			log.Info("UPnP port forwarding established", zap.String("externalIP", ip), zap.Uint16("port", upnpPort))
+			externalIP = ip
 			syncerAddr = net.JoinHostPort(ip, portStr)
 		}
 	}
@@ -236,8 +443,10 @@ func runNode(ctx context.Context, cfg Config, log *zap.Logger, enableDebug bool)
 	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
 		syncerAddr = net.JoinHostPort("127.0.0.1", port)
 	}
+	announced := &announcedListener{Listener: syncerListener}
+	announced.SetAddr(syncerAddr)
 
-	store, err := sqlite.OpenDatabase(filepath.Join(cfg.Directory, "minerd.sqlite3"), sqlite.WithLog(log.Named("sqlite3")))
+	store, err := sqlite.OpenDatabase(storePath, sqlite.WithLog(log.Named("sqlite3")))
 	if err != nil {
 		return fmt.Errorf("failed to open wallet database: %w", err)
 	}
@@ -267,34 +476,196 @@ func runNode(ctx context.Context, cfg Config, log *zap.Logger, enableDebug bool)
 		NetAddress: syncerAddr,
 	}
 
-	s := syncer.New(syncerListener, cm, ps, header,
+	var scm syncer.ChainManager = cm
+	if cfg.Consensus.MaxReorgDepth > 0 {
+		scm = &reorgLimiter{Manager: cm, maxDepth: cfg.Consensus.MaxReorgDepth, log: log.Named("reorg")}
+	}
+	s := syncer.New(announced, scm, ps, header,
 		syncer.WithLogger(log.Named("syncer")),
 		syncer.WithMaxInboundPeers(1024),
+		syncer.WithMaxOutboundPeers(cfg.Syncer.MaxOutbound),
+		syncer.WithConnectTimeout(cfg.Syncer.DialTimeout),
 		syncer.WithMaxInflightRPCs(1024))
+	log.Info("syncer settings", zap.Int("maxOutbound", cfg.Syncer.MaxOutbound), zap.Duration("dialTimeout", cfg.Syncer.DialTimeout))
 	defer s.Close()
 	go s.Run()
 
+	if cfg.Syncer.EnableUPnP {
+		_, portStr, _ := net.SplitHostPort(syncerAddr)
+		go maintainUPnP(ctx, upnpPort, externalIP, func(ip string) {
+			// the handshake header is fixed when the syncer is created, but
+			// peers only take the port from it and dial back the address we
+			// connected from, so updating the reported address is enough
+			addr := net.JoinHostPort(ip, portStr)
+			announced.SetAddr(addr)
+			log.Info("updated announced syncer address", zap.String("address", addr))
+		}, log.Named("upnp"))
+	}
+
+	if len(cfg.Syncer.Peers) > 0 {
+		go maintainStaticPeers(ctx, s, cfg.Syncer.Peers, log.Named("peers"))
+	}
+
 	wm, err := wallet.NewManager(cm, store, wallet.WithLogger(log.Named("wallet")), wallet.WithIndexMode(cfg.Index.Mode), wallet.WithSyncBatchSize(cfg.Index.BatchSize))
 	if err != nil {
 		return fmt.Errorf("failed to create wallet manager: %w", err)
 	}
 	defer wm.Close()
 
+	if payoutPolicy != nil {
+		if err := registerPayoutPolicy(wm, *payoutPolicy); err != nil {
+			return fmt.Errorf("failed to register payout policy: %w", err)
+		}
+		log.Info("registered payout policy", zap.Stringer("address", payoutAddr), zap.Stringer("policy", *payoutPolicy))
+	}
+
+	if cfg.Mining.PayoutWallet != "" {
+		payoutAddr, err = walletPayoutAddress(wm, cfg.Mining.PayoutWallet)
+		if err != nil {
+			return err
+		}
+		log.Info("paying block rewards to wallet", zap.String("wallet", cfg.Mining.PayoutWallet), zap.Stringer("address", payoutAddr))
+	}
+
+	if cfg.Index.VacuumInterval > 0 && cfg.Index.Mode == wallet.IndexModeNone {
+		// the indexer never advances in none mode and the database is
+		// maintained by the node indexing it
+		log.Warn("database maintenance is disabled in index mode none")
+	} else if cfg.Index.VacuumInterval > 0 && !ephemeral {
+		go runDatabaseMaintenance(ctx, storePath, cfg.Index.VacuumInterval, store.LastCommittedIndex, cm.Tip, log.Named("maintenance"))
+	}
+	// the indexer never advances in none mode, so there is no lag to monitor
+	if cfg.Index.Mode != wallet.IndexModeNone && cfg.Index.LagWarnThreshold > 0 {
+		go monitorIndexLag(ctx, cfg.Index.LagWarnThreshold, store.LastCommittedIndex, cm.Tip, log.Named("index"))
+	}
+
+	// walletd checks a fixed password. To support rotating the password on
+	// SIGHUP, walletd is given a random internal password and requests
+	// authenticated with the current password are rewritten to use it.
+	password := newAPIPassword(configPath, cfg.HTTP.Password)
+	walletdPassword := hex.EncodeToString(frand.Bytes(16))
 	walletdAPIOpts := []wAPI.ServerOption{
 		wAPI.WithLogger(log.Named("api")),
 		wAPI.WithPublicEndpoints(cfg.HTTP.PublicEndpoints),
-		wAPI.WithBasicAuth(cfg.HTTP.Password),
+		wAPI.WithBasicAuth(walletdPassword),
 	}
 	if enableDebug {
 		walletdAPIOpts = append(walletdAPIOpts, wAPI.WithDebug())
 	}
 	minerAPIOpts := []api.ServerOption{
 		api.WithLogger(log.Named("api")),
-		api.WithBasicAuth(cfg.HTTP.Password),
+		api.WithBasicAuthFunc(password.Load),
+		api.WithPublicEndpoints(cfg.HTTP.PublicEndpoints),
+	}
+	if enableDebug {
+		minerAPIOpts = append(minerAPIOpts, api.WithDebug())
+	}
+	if cfg.Index.Mode != wallet.IndexModeNone {
+		minerAPIOpts = append(minerAPIOpts, api.WithIndexedTipFunc(store.LastCommittedIndex))
 	}
 	if cfg.Mining.MaxTemplateAge > 0 {
 		minerAPIOpts = append(minerAPIOpts, api.WithMaxTemplateAge(cfg.Mining.MaxTemplateAge))
 	}
+	if cfg.Mining.MaxTemplateAgeCeiling > 0 {
+		minerAPIOpts = append(minerAPIOpts, api.WithMaxTemplateAgeCeiling(cfg.Mining.MaxTemplateAgeCeiling))
+	}
+	if cfg.Mining.LongPollJitter < 0 || cfg.Mining.LongPollJitter >= 1 {
+		return fmt.Errorf("long poll jitter must be in the range [0, 1), got %v", cfg.Mining.LongPollJitter)
+	}
+	minerAPIOpts = append(minerAPIOpts, api.WithLongPollJitter(cfg.Mining.LongPollJitter))
+	if cfg.Mining.SlowTemplateThreshold < 0 {
+		return fmt.Errorf("slow template threshold must not be negative, got %v", cfg.Mining.SlowTemplateThreshold)
+	}
+	minerAPIOpts = append(minerAPIOpts, api.WithSlowTemplateThreshold(cfg.Mining.SlowTemplateThreshold))
+	if cfg.Mining.AddBlockTimeout < 0 {
+		return fmt.Errorf("add block timeout must not be negative, got %v", cfg.Mining.AddBlockTimeout)
+	}
+	minerAPIOpts = append(minerAPIOpts, api.WithAddBlockTimeout(cfg.Mining.AddBlockTimeout))
+	if cfg.Mining.MaxDifficulty != (consensus.Work{}) && cfg.Mining.MinDifficulty.Cmp(cfg.Mining.MaxDifficulty) > 0 {
+		return fmt.Errorf("min difficulty %v must not exceed max difficulty %v", cfg.Mining.MinDifficulty, cfg.Mining.MaxDifficulty)
+	}
+	minerAPIOpts = append(minerAPIOpts, api.WithDifficultyBounds(cfg.Mining.MinDifficulty, cfg.Mining.MaxDifficulty))
+	if cfg.Mining.ReorgDebounce > 0 {
+		minerAPIOpts = append(minerAPIOpts, api.WithReorgDebounce(cfg.Mining.ReorgDebounce))
+	}
+	if cfg.Mining.TemplateWatchdog < 0 {
+		return fmt.Errorf("template watchdog threshold must not be negative, got %v", cfg.Mining.TemplateWatchdog)
+	} else if cfg.Mining.TemplateWatchdog > 0 && cfg.Mining.TemplateWatchdog <= cfg.Mining.ReorgDebounce {
+		return fmt.Errorf("template watchdog threshold %v must be longer than the reorg debounce window %v", cfg.Mining.TemplateWatchdog, cfg.Mining.ReorgDebounce)
+	} else if cfg.Mining.TemplateWatchdog > 0 {
+		minerAPIOpts = append(minerAPIOpts, api.WithTemplateWatchdog(cfg.Mining.TemplateWatchdog))
+	}
+	minerAPIOpts = append(minerAPIOpts, api.WithReorgLogMinDepth(cfg.Mining.ReorgLogMinDepth))
+	if cfg.Mining.NonceRangeSize > 0 {
+		minerAPIOpts = append(minerAPIOpts, api.WithNonceRangeSize(cfg.Mining.NonceRangeSize))
+	}
+	if cfg.Mining.StartupGracePeriod > 0 {
+		minerAPIOpts = append(minerAPIOpts, api.WithStartupGracePeriod(cfg.Mining.StartupGracePeriod))
+	}
+	if len(cfg.Mining.CoinbaseFlags) > api.MaxCoinbaseFlagsSize {
+		return fmt.Errorf("coinbase flags must not be longer than %d bytes, got %d", api.MaxCoinbaseFlagsSize, len(cfg.Mining.CoinbaseFlags))
+	} else if cfg.Mining.CoinbaseFlags != "" {
+		minerAPIOpts = append(minerAPIOpts, api.WithCoinbaseFlags(cfg.Mining.CoinbaseFlags))
+	}
+	if cfg.Mining.RequireTransactions && cfg.Mining.RequireTransactionsMaxWait <= 0 {
+		return fmt.Errorf("require transactions max wait must be positive, got %v", cfg.Mining.RequireTransactionsMaxWait)
+	} else if cfg.Mining.RequireTransactions {
+		minerAPIOpts = append(minerAPIOpts, api.WithRequireTransactions(cfg.Mining.RequireTransactionsMaxWait))
+	}
+	if !cfg.Mining.AllowUnsyncedSubmissions {
+		minerAPIOpts = append(minerAPIOpts, api.WithRejectUnsyncedBlocks())
+	}
+	if cfg.Mining.MinHeight > 0 {
+		minerAPIOpts = append(minerAPIOpts, api.WithMinMiningHeight(cfg.Mining.MinHeight))
+	}
+	if cfg.Mining.AcceptKnownBlocks {
+		minerAPIOpts = append(minerAPIOpts, api.WithAcceptKnownBlocks())
+	}
+	if cfg.Mining.FullBlockOutlines {
+		minerAPIOpts = append(minerAPIOpts, api.WithFullBlockOutlines())
+	}
+	if cfg.Mining.EmptyBlocks && cfg.Mining.RequireTransactions {
+		return errors.New("empty blocks and require transactions are mutually exclusive")
+	} else if cfg.Mining.EmptyBlocks {
+		minerAPIOpts = append(minerAPIOpts, api.WithEmptyBlocks())
+	}
+	if cfg.Mining.MaxTemplateTxns < 0 {
+		return fmt.Errorf("max template transactions must not be negative, got %d", cfg.Mining.MaxTemplateTxns)
+	} else if cfg.Mining.MaxTemplateTxns > 0 {
+		minerAPIOpts = append(minerAPIOpts, api.WithMaxTemplateTxns(cfg.Mining.MaxTemplateTxns))
+	}
+	if len(cfg.Mining.RelayPeers) > 0 {
+		for _, addr := range cfg.Mining.RelayPeers {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				return fmt.Errorf("invalid relay peer %q: %w", addr, err)
+			}
+		}
+		minerAPIOpts = append(minerAPIOpts, api.WithRelayPeers(cfg.Mining.RelayPeers))
+	}
+	if cfg.Mining.JSONRPC {
+		minerAPIOpts = append(minerAPIOpts, api.WithJSONRPC())
+	}
+	if cfg.Mining.TimestampOffset > 0 {
+		minerAPIOpts = append(minerAPIOpts, api.WithPinnedTimestamp(cfg.Mining.TimestampOffset))
+	}
+	if len(cfg.HTTP.AllowedCIDRs) > 0 {
+		allowed, err := parsePrefixes(cfg.HTTP.AllowedCIDRs)
+		if err != nil {
+			return fmt.Errorf("failed to parse allowed CIDRs: %w", err)
+		}
+		trusted, err := parsePrefixes(cfg.HTTP.TrustedProxies)
+		if err != nil {
+			return fmt.Errorf("failed to parse trusted proxies: %w", err)
+		}
+		minerAPIOpts = append(minerAPIOpts, api.WithAllowedCIDRs(allowed), api.WithTrustedProxies(trusted))
+	}
+	if payoutSeed != "" {
+		sp, err := newSeedPayouts(payoutSeed, cfg.Mining.PayoutIndexStep, cm, wm, log.Named("payouts"))
+		if err != nil {
+			return fmt.Errorf("failed to initialize seed payouts: %w", err)
+		}
+		minerAPIOpts = append(minerAPIOpts, api.WithPayoutAddressFunc(sp.PayoutAddress))
+	}
 	walletdAPI := wAPI.NewServer(store, cm, s, wm, walletdAPIOpts...)
 	minerAPI := api.NewServer(cm, s, payoutAddr, minerAPIOpts...)
 	web := walletd.Handler()
@@ -309,6 +680,9 @@ func runNode(ctx context.Context, cfg Config, log *zap.Logger, enableDebug bool)
 			// serve walletd API
 			if strings.HasPrefix(r.URL.Path, "/api") {
 				r.URL.Path = strings.TrimPrefix(r.URL.Path, "/api")
+				if user, pass, ok := r.BasicAuth(); ok && pass == password.Load() {
+					r.SetBasicAuth(user, walletdPassword)
+				}
 				walletdAPI.ServeHTTP(w, r)
 				return
 			}
@@ -317,10 +691,73 @@ func runNode(ctx context.Context, cfg Config, log *zap.Logger, enableDebug bool)
 		ReadTimeout: 10 * time.Second,
 	}
 	defer server.Close()
-	go server.Serve(httpListener)
+
+	var cr *certReloader
+	if cfg.HTTP.TLSCert != "" && cfg.HTTP.TLSKey != "" {
+		cr, err = newCertReloader(cfg.HTTP.TLSCert, cfg.HTTP.TLSKey)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = &tls.Config{GetCertificate: cr.GetCertificate}
+	}
+
+	// reload the API password and the certificate on SIGHUP to support
+	// rotations and renewals without a restart. Requests that were already
+	// authenticated, e.g. long polling requests, are not interrupted.
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	defer signal.Stop(sigChan)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sigChan:
+				if changed, err := password.Reload(); err != nil {
+					log.Error("failed to reload API password", zap.Error(err))
+				} else if changed {
+					log.Info("reloaded API password")
+				}
+				if cr == nil {
+					continue
+				} else if err := cr.Reload(); err != nil {
+					log.Error("failed to reload TLS certificate", zap.Error(err))
+				} else {
+					log.Info("reloaded TLS certificate")
+				}
+			}
+		}
+	}()
+
+	if cr != nil {
+		go server.ServeTLS(httpListener, "", "")
+	} else {
+		go server.Serve(httpListener)
+	}
 
 	log.Info("node started", zap.String("network", network.Name), zap.Stringer("syncer", syncerListener.Addr()), zap.Stringer("http", httpListener.Addr()), zap.String("version", build.Version()), zap.String("commit", build.Commit()))
+	if onStarted != nil {
+		onStarted()
+	}
 	<-ctx.Done()
 	log.Info("shutting down")
 	return nil
 }
+
+// parsePrefixes parses a list of CIDRs. Single addresses are treated as
+// prefixes covering only that address.
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(cidr)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
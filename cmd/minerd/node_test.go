@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"go.sia.tech/coreutils"
+	"go.sia.tech/coreutils/chain"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestCheckConsensusNetwork(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	fp := filepath.Join(t.TempDir(), "consensus.db")
+
+	// a missing database matches any network
+	zen, zenGenesis := chain.TestnetZen()
+	if err := checkConsensusNetwork(fp, zen, zenGenesis, log); err != nil {
+		t.Fatal(err)
+	}
+
+	bdb, err := coreutils.OpenBoltChainDB(fp)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, _, err := chain.NewDBStore(bdb, zen, zenGenesis, nil); err != nil {
+		t.Fatal(err)
+	} else if err := bdb.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkConsensusNetwork(fp, zen, zenGenesis, log); err != nil {
+		t.Fatal(err)
+	}
+	mainnet, mainnetGenesis := chain.Mainnet()
+	if err := checkConsensusNetwork(fp, mainnet, mainnetGenesis, log); !errors.Is(err, errNetworkMismatch) {
+		t.Fatalf("expected %v, got %v", errNetworkMismatch, err)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v3"
+)
+
+// minAPIPasswordLength is the minimum length of the API password.
+const minAPIPasswordLength = 4
+
+// An apiPassword holds the API password of a running node. It can be reloaded
+// from the config file to rotate the password without a restart.
+type apiPassword struct {
+	configPath string
+	password   atomic.Pointer[string]
+}
+
+// Load returns the current password.
+func (p *apiPassword) Load() string {
+	return *p.password.Load()
+}
+
+// Reload reads the password from the config file. It returns false if the
+// password is unchanged. If the config file doesn't set a password, the
+// current one is kept.
+func (p *apiPassword) Reload() (bool, error) {
+	if p.configPath == "" {
+		return false, errors.New("no config file loaded")
+	}
+	password, err := readConfigPassword(p.configPath)
+	if err != nil {
+		return false, err
+	} else if password == "" || password == p.Load() {
+		return false, nil
+	} else if err := validateAPIPassword(password); err != nil {
+		return false, err
+	}
+	p.password.Store(&password)
+	return true, nil
+}
+
+func newAPIPassword(configPath, password string) *apiPassword {
+	p := &apiPassword{configPath: configPath}
+	p.password.Store(&password)
+	return p
+}
+
+// validateAPIPassword returns an error if the password is too short.
+func validateAPIPassword(password string) error {
+	if len(password) < minAPIPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minAPIPasswordLength)
+	}
+	return nil
+}
+
+// readConfigPassword returns the API password set in a config file.
+func readConfigPassword(fp string) (string, error) {
+	buf, err := os.ReadFile(fp)
+	if err != nil {
+		return "", fmt.Errorf("failed to read config file: %w", err)
+	}
+	var c struct {
+		HTTP struct {
+			Password string `yaml:"password"`
+		} `yaml:"http"`
+	}
+	if err := yaml.Unmarshal(buf, &c); err != nil {
+		return "", fmt.Errorf("failed to decode config file: %w", err)
+	}
+	return c.HTTP.Password, nil
+}
+
+// mappingValue returns the value of key in a YAML mapping node. If the key
+// doesn't exist, it is added with an empty value of the given kind.
+func mappingValue(m *yaml.Node, key string, kind yaml.Kind) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	v := &yaml.Node{Kind: kind}
+	if kind == yaml.ScalarNode {
+		v.Tag = "!!str"
+	}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, v)
+	return v
+}
+
+// writeConfigPassword sets the API password in a config file. The rest of the
+// file is left unchanged. If the file doesn't exist, it is created.
+func writeConfigPassword(fp, password string) error {
+	buf, err := os.ReadFile(fp)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(fp); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return fmt.Errorf("failed to decode config file: %w", err)
+	} else if doc.Kind == 0 {
+		// empty or missing file
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	} else if doc.Content[0].Kind != yaml.MappingNode {
+		return errors.New("config file is not a mapping")
+	}
+	httpNode := mappingValue(doc.Content[0], "http", yaml.MappingNode)
+	if httpNode.Kind != yaml.MappingNode {
+		return errors.New("http section of config file is not a mapping")
+	}
+	passwordNode := mappingValue(httpNode, "password", yaml.ScalarNode)
+	passwordNode.Kind, passwordNode.Tag, passwordNode.Value = yaml.ScalarNode, "!!str", password
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	if err := enc.Encode(&doc); err != nil {
+		return fmt.Errorf("failed to encode config file: %w", err)
+	} else if err := enc.Close(); err != nil {
+		return fmt.Errorf("failed to encode config file: %w", err)
+	}
+
+	// write to a temporary file and rename it to avoid leaving a partially
+	// written config file behind
+	if err := os.MkdirAll(filepath.Dir(fp), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	tmp := fp + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return fmt.Errorf("failed to create config file: %w", err)
+	}
+	defer os.Remove(tmp)
+	if _, err := f.Write(out.Bytes()); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write config file: %w", err)
+	} else if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync config file: %w", err)
+	} else if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close config file: %w", err)
+	} else if err := os.Rename(tmp, fp); err != nil {
+		return fmt.Errorf("failed to replace config file: %w", err)
+	}
+	return nil
+}
+
+// readNewPassword reads the new API password from a file or, if path is
+// empty, interactively from stdin.
+func readNewPassword(path string) (string, error) {
+	if path != "" {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password file: %w", err)
+		}
+		password := strings.TrimRight(string(buf), "\r\n")
+		return password, validateAPIPassword(password)
+	}
+
+	// retry until a valid password is entered and confirmed
+	for {
+		password := readPasswordInput("Enter new password")
+		if err := validateAPIPassword(password); err != nil {
+			stdoutError(err.Error())
+			continue
+		} else if readPasswordInput("Confirm new password") != password {
+			stdoutError("Passwords don't match!")
+			continue
+		}
+		return password, nil
+	}
+}
+
+// runPasswd updates the API password in the config file at fp. If fp is
+// empty, the default config path is used.
+func runPasswd(fp, passwordFile string) error {
+	if fp == "" {
+		fp = configPath()
+	}
+	password, err := readNewPassword(passwordFile)
+	if err != nil {
+		return err
+	} else if err := writeConfigPassword(fp, password); err != nil {
+		return err
+	}
+	fmt.Printf("Updated the API password in %q\n", fp)
+	fmt.Println("Send SIGHUP to a running node to reload it without a restart")
+	return nil
+}
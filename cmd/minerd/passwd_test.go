@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPasswdRotation(t *testing.T) {
+	dir := t.TempDir()
+	fp := filepath.Join(dir, "minerd.yml")
+	const existing = `# managed by ansible
+directory: /var/lib/minerd
+http:
+  address: :9980 # keep the API local
+  password: old password
+`
+	if err := os.WriteFile(fp, []byte(existing), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	// the running node starts with the password from the config file
+	password := newAPIPassword(fp, "old password")
+	if changed, err := password.Reload(); err != nil {
+		t.Fatal(err)
+	} else if changed {
+		t.Fatal("expected reload without a rotation to keep the password")
+	}
+
+	passwordFile := filepath.Join(dir, "password")
+	if err := os.WriteFile(passwordFile, []byte("new password\n"), 0600); err != nil {
+		t.Fatal(err)
+	} else if err := runPasswd(fp, passwordFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// the node still loads the rewritten config file, with only the password
+	// changed
+	var c Config
+	if err := LoadFile(fp, &c); err != nil {
+		t.Fatal(err)
+	} else if c.HTTP.Password != "new password" {
+		t.Fatalf("expected the trailing newline of the password file to be trimmed, got %q", c.HTTP.Password)
+	} else if c.Directory != "/var/lib/minerd" || c.HTTP.Address != ":9980" {
+		t.Fatalf("expected the other settings to be kept, got %q and %q", c.Directory, c.HTTP.Address)
+	}
+	buf, err := os.ReadFile(fp)
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(buf), "# managed by ansible") || !strings.Contains(string(buf), "# keep the API local") {
+		t.Fatalf("expected comments to be kept, got\n%s", buf)
+	} else if info, err := os.Stat(fp); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0640 {
+		t.Fatalf("expected the file mode to be kept, got %v", info.Mode().Perm())
+	}
+
+	// SIGHUP picks up the new password once
+	if changed, err := password.Reload(); err != nil {
+		t.Fatal(err)
+	} else if !changed || password.Load() != "new password" {
+		t.Fatalf("expected the new password to be loaded, got %q", password.Load())
+	} else if changed, err := password.Reload(); err != nil || changed {
+		t.Fatalf("expected a second reload to be a no-op, got %v %v", changed, err)
+	}
+
+	// passwords that are too short are rejected before touching the config
+	if err := os.WriteFile(passwordFile, []byte("abc"), 0600); err != nil {
+		t.Fatal(err)
+	} else if err := runPasswd(fp, passwordFile); err == nil {
+		t.Fatal("expected short password to be rejected")
+	} else if after, err := os.ReadFile(fp); err != nil {
+		t.Fatal(err)
+	} else if string(after) != string(buf) {
+		t.Fatalf("expected the config file to be unchanged, got\n%s", after)
+	}
+
+	// a short or removed password edited into the config by hand doesn't
+	// lock clients out of the running node
+	for _, edited := range []string{"http:\n  password: abc\n", "directory: /var/lib/minerd\n"} {
+		if err := os.WriteFile(fp, []byte(edited), 0640); err != nil {
+			t.Fatal(err)
+		}
+		password.Reload()
+		if password.Load() != "new password" {
+			t.Fatalf("expected the password to be kept after reloading %q, got %q", edited, password.Load())
+		}
+	}
+}
+
+func TestPasswdNewConfig(t *testing.T) {
+	dir := t.TempDir()
+	fp := filepath.Join(dir, "minerd", "minerd.yml")
+	passwordFile := filepath.Join(dir, "password")
+	if err := os.WriteFile(passwordFile, []byte("new password"), 0600); err != nil {
+		t.Fatal(err)
+	} else if err := runPasswd(fp, passwordFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// the config file holds a secret, so it is only readable by the owner
+	var c Config
+	if err := LoadFile(fp, &c); err != nil {
+		t.Fatal(err)
+	} else if c.HTTP.Password != "new password" {
+		t.Fatalf("expected password %q, got %q", "new password", c.HTTP.Password)
+	} else if info, err := os.Stat(fp); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	// a config file whose http section isn't a mapping isn't clobbered
+	if err := os.WriteFile(fp, []byte("http: localhost:9980\n"), 0600); err != nil {
+		t.Fatal(err)
+	} else if err := runPasswd(fp, passwordFile); err == nil {
+		t.Fatal("expected an error for an invalid http section")
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	cwallet "go.sia.tech/coreutils/wallet"
+	"go.sia.tech/walletd/v2/wallet"
+	"go.uber.org/zap"
+)
+
+// payoutWalletName is the name of the wallet that seed-derived payout
+// addresses are added to.
+const payoutWalletName = "Mining Payouts"
+
+type (
+	// payoutAddressMetadata is the metadata stored alongside each
+	// seed-derived payout address in the wallet store.
+	payoutAddressMetadata struct {
+		SeedIndex uint64 `json:"seedIndex"`
+	}
+
+	// payoutWalletMetadata is the metadata stored alongside the payout
+	// wallet. HighestUsedIndex is nil until a block paying a derived address
+	// is added to the best chain. CurrentIndex is the index of the address
+	// handed out last and ScanFrom the chain index from which blocks paying
+	// it haven't been checked yet, so an address paid right before a crash
+	// isn't handed out again after a restart.
+	payoutWalletMetadata struct {
+		HighestUsedIndex *uint64           `json:"highestUsedIndex,omitempty"`
+		CurrentIndex     *uint64           `json:"currentIndex,omitempty"`
+		ScanFrom         *types.ChainIndex `json:"scanFrom,omitempty"`
+	}
+
+	// A seedPayouts derives payout addresses from a seed. A fresh address,
+	// step indices after the current one, is derived whenever a block paying
+	// the current address is added to the best chain. Derived addresses are
+	// added to a wallet in the store so the rewards are tracked, and the
+	// highest used index is stored in the wallet's metadata so it survives
+	// restarts.
+	seedPayouts struct {
+		cm   *chain.Manager
+		wm   *wallet.Manager
+		log  *zap.Logger
+		step uint64
+
+		mu           sync.Mutex
+		seed         [32]byte
+		payoutWallet wallet.Wallet
+		index        uint64
+		addr         types.Address
+		used         bool             // set if highestUsed was paid by a block on the best chain
+		highestUsed  uint64           // highest index paid by a block on the best chain
+		scanned      types.ChainIndex // last chain index checked for payouts to addr
+	}
+)
+
+// truncateAddress shortens an address string for use in error messages.
+func truncateAddress(s string) string {
+	if len(s) <= 20 {
+		return s
+	}
+	return s[:8] + "..." + s[len(s)-8:]
+}
+
+// parsePayoutAddress parses a payout address. Compared to
+// types.ParseAddress, the errors are meant to help operators spot typos.
+func parsePayoutAddress(s string) (types.Address, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "addr:") // legacy prefix
+
+	const addrLen = 2 * (32 + 6) // hash + checksum
+	if len(s) != addrLen {
+		return types.Address{}, fmt.Errorf("payout address %q must be %d hex characters, got %d", truncateAddress(s), addrLen, len(s))
+	}
+	for i, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return types.Address{}, fmt.Errorf("payout address %q contains invalid character %q at position %d", truncateAddress(s), c, i+1)
+		}
+	}
+	addr, err := types.ParseAddress(s)
+	if err != nil {
+		return types.Address{}, fmt.Errorf("checksum mismatch in payout address %q, did you copy the whole address?", truncateAddress(s))
+	}
+	return addr, nil
+}
+
+// payoutWallet returns the wallet minerd adds payout addresses to, creating
+// it if it doesn't exist yet.
+func payoutWallet(wm *wallet.Manager) (_ wallet.Wallet, created bool, _ error) {
+	wallets, err := wm.Wallets()
+	if err != nil {
+		return wallet.Wallet{}, false, fmt.Errorf("failed to get wallets: %w", err)
+	}
+	for _, w := range wallets {
+		if w.Name == payoutWalletName {
+			return w, false, nil
+		}
+	}
+	w, err := wm.AddWallet(wallet.Wallet{
+		Name:        payoutWalletName,
+		Description: "addresses minerd pays block rewards to",
+	})
+	if err != nil {
+		return wallet.Wallet{}, false, fmt.Errorf("failed to add payout wallet: %w", err)
+	}
+	return w, true, nil
+}
+
+// parsePayoutPolicy parses the spend policy of the payout address, either in
+// its string form, e.g. thresh(2,[pk(0x...),pk(0x...)]), or as JSON.
+func parsePayoutPolicy(s string) (types.SpendPolicy, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "{") {
+		var sp types.SpendPolicy
+		if err := json.Unmarshal([]byte(s), &sp); err != nil {
+			return types.SpendPolicy{}, fmt.Errorf("invalid payout policy: %w", err)
+		}
+		return sp, nil
+	}
+	sp, err := types.ParseSpendPolicy(s)
+	if err != nil {
+		return types.SpendPolicy{}, fmt.Errorf("invalid payout policy: %w", err)
+	}
+	return sp, nil
+}
+
+// registerPayoutPolicy adds the payout address along with its spend policy
+// to the payout wallet, so the wallet can spend the block rewards.
+func registerPayoutPolicy(wm *wallet.Manager, policy types.SpendPolicy) error {
+	w, _, err := payoutWallet(wm)
+	if err != nil {
+		return err
+	}
+	err = wm.AddAddresses(w.ID, wallet.Address{
+		Address:     policy.Address(),
+		Description: "payout address",
+		SpendPolicy: &policy,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add payout address: %w", err)
+	}
+	return nil
+}
+
+// walletPayoutAddress returns the payout address of the walletd wallet with
+// the given name. walletd wallets don't hold keys, so no new address can be
+// generated and one of the wallet's existing addresses is used. If the wallet
+// has multiple addresses, the one that sorts first is used so the choice is
+// stable across restarts.
+func walletPayoutAddress(wm *wallet.Manager, name string) (types.Address, error) {
+	wallets, err := wm.Wallets()
+	if err != nil {
+		return types.VoidAddress, fmt.Errorf("failed to get wallets: %w", err)
+	}
+	var matches []wallet.Wallet
+	for _, w := range wallets {
+		if w.Name == name {
+			matches = append(matches, w)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return types.VoidAddress, fmt.Errorf("payout wallet %q does not exist", name)
+	case 1:
+	default:
+		return types.VoidAddress, fmt.Errorf("multiple wallets are named %q, rename all but the payout wallet", name)
+	}
+
+	addrs, err := wm.Addresses(matches[0].ID)
+	if err != nil {
+		return types.VoidAddress, fmt.Errorf("failed to get addresses of payout wallet %q: %w", name, err)
+	} else if len(addrs) == 0 {
+		return types.VoidAddress, fmt.Errorf("payout wallet %q has no addresses", name)
+	}
+	addr := addrs[0].Address
+	for _, a := range addrs[1:] {
+		if bytes.Compare(a.Address[:], addr[:]) < 0 {
+			addr = a.Address
+		}
+	}
+	return addr, nil
+}
+
+// loadSeedPhrase returns the recovery phrase from the mining config. The
+// phrase is read from SeedFile if Seed is not set.
+func loadSeedPhrase(m Mining) (string, error) {
+	if m.Seed != "" && m.SeedFile != "" {
+		return "", errors.New("only one of seed and seed file can be set")
+	} else if m.SeedFile == "" {
+		return m.Seed, nil
+	}
+	buf, err := os.ReadFile(m.SeedFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read seed file: %w", err)
+	}
+	return strings.TrimSpace(string(buf)), nil
+}
+
+func (sp *seedPayouts) deriveAddress(index uint64) wallet.Address {
+	uc := types.StandardUnlockConditions(cwallet.KeyFromSeed(&sp.seed, index).PublicKey())
+	meta, _ := json.Marshal(payoutAddressMetadata{SeedIndex: index})
+	return wallet.Address{
+		Address:     uc.UnlockHash(),
+		Description: fmt.Sprintf("payout address %d", index),
+		SpendPolicy: &types.SpendPolicy{Type: types.PolicyTypeUnlockConditions(uc)},
+		Metadata:    meta,
+	}
+}
+
+// useIndex derives the address at the given index and persists it along with
+// the highest used index. The address is only handed out once the index is
+// stored in the payout wallet's metadata. Expects mu to be locked.
+func (sp *seedPayouts) useIndex(index uint64, used bool, highestUsed uint64) error {
+	addr := sp.deriveAddress(index)
+	if err := sp.wm.AddAddresses(sp.payoutWallet.ID, addr); err != nil {
+		return fmt.Errorf("failed to add payout address: %w", err)
+	}
+
+	meta := payoutWalletMetadata{
+		CurrentIndex: &index,
+		ScanFrom:     &sp.scanned,
+	}
+	if used {
+		meta.HighestUsedIndex = &highestUsed
+	}
+	buf, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to encode payout wallet metadata: %w", err)
+	}
+	w := sp.payoutWallet
+	w.Metadata = buf
+	w, err = sp.wm.UpdateWallet(w)
+	if err != nil {
+		return fmt.Errorf("failed to update payout wallet: %w", err)
+	}
+	sp.payoutWallet, sp.used, sp.highestUsed = w, used, highestUsed
+	sp.index, sp.addr = index, addr.Address
+	return nil
+}
+
+// paysAddress returns true if one of the block's miner payouts is sent to
+// addr.
+func paysAddress(b types.Block, addr types.Address) bool {
+	for _, sco := range b.MinerPayouts {
+		if sco.Address == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// PayoutAddress returns the address that block rewards should be paid to. If
+// a block paying the current address was added to the best chain since the
+// last call, the next address is derived first. If a block paying the highest
+// used address was reverted, that address is used again, so reorgs don't
+// leave gaps of unused addresses.
+func (sp *seedPayouts) PayoutAddress() (types.Address, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	for sp.scanned != sp.cm.Tip() {
+		reverted, applied, err := sp.cm.UpdatesSince(sp.scanned, 100)
+		if err != nil {
+			return types.VoidAddress, fmt.Errorf("failed to get chain updates: %w", err)
+		}
+		for _, cru := range reverted {
+			// the reverted block's parent is the new scan position
+			sp.scanned = cru.State.Index
+			if sp.used && paysAddress(cru.Block, sp.deriveAddress(sp.highestUsed).Address) {
+				index := sp.highestUsed
+				// addresses are only rotated after being used, so the
+				// previously used address is one step before
+				var err error
+				if index >= sp.step {
+					err = sp.useIndex(index, true, index-sp.step)
+				} else {
+					err = sp.useIndex(index, false, 0)
+				}
+				if err != nil {
+					return types.VoidAddress, err
+				}
+				sp.log.Info("reusing payout address of reverted block", zap.Uint64("index", sp.index), zap.Stringer("address", sp.addr))
+			}
+		}
+		var used bool
+		for _, cau := range applied {
+			used = used || paysAddress(cau.Block, sp.addr)
+			sp.scanned = cau.State.Index
+		}
+		if used {
+			if err := sp.useIndex(sp.index+sp.step, true, sp.index); err != nil {
+				return types.VoidAddress, err
+			}
+			sp.log.Info("derived new payout address", zap.Uint64("index", sp.index), zap.Stringer("address", sp.addr), zap.Uint64("highestUsed", sp.highestUsed))
+		}
+	}
+	return sp.addr, nil
+}
+
+// newSeedPayouts initializes a seedPayouts from the given recovery phrase. The
+// derivation index is restored from the payout wallet and advances by step
+// every time an address is used.
+func newSeedPayouts(phrase string, step uint64, cm *chain.Manager, wm *wallet.Manager, log *zap.Logger) (*seedPayouts, error) {
+	if step == 0 {
+		panic("payout index step must be greater than zero") // developer error
+	}
+	sp := &seedPayouts{
+		cm:      cm,
+		wm:      wm,
+		log:     log,
+		step:    step,
+		scanned: cm.Tip(),
+	}
+	if err := cwallet.SeedFromPhrase(&sp.seed, phrase); err != nil {
+		return nil, fmt.Errorf("invalid recovery phrase: %w", err)
+	}
+
+	w, created, err := payoutWallet(wm)
+	if err != nil {
+		return nil, err
+	}
+	sp.payoutWallet = w
+	if created {
+		if err := sp.useIndex(0, false, 0); err != nil {
+			return nil, err
+		}
+		return sp, nil
+	}
+
+	var meta payoutWalletMetadata
+	if len(sp.payoutWallet.Metadata) > 0 {
+		if err := json.Unmarshal(sp.payoutWallet.Metadata, &meta); err != nil {
+			return nil, fmt.Errorf("failed to decode payout wallet metadata: %w", err)
+		}
+	}
+	used, highestUsed := meta.HighestUsedIndex != nil, uint64(0)
+	if used {
+		highestUsed = *meta.HighestUsedIndex
+	}
+	if meta.CurrentIndex != nil {
+		index := *meta.CurrentIndex
+		if used && highestUsed+step > index {
+			index = highestUsed + step
+		}
+		// blocks paying the current address may have been added after it
+		// was last checked. Rescan them on the next call so the address is
+		// rotated if it was used, or skip it if that isn't possible.
+		if meta.ScanFrom != nil && sp.chainHas(*meta.ScanFrom) {
+			sp.scanned = *meta.ScanFrom
+		} else {
+			index += step
+		}
+		if err := sp.useIndex(index, used, highestUsed); err != nil {
+			return nil, err
+		}
+		return sp, nil
+	} else if used {
+		if err := sp.useIndex(highestUsed+step, true, highestUsed); err != nil {
+			return nil, err
+		}
+		return sp, nil
+	}
+
+	// the highest used index wasn't tracked before, so continue with the
+	// last derived address
+	addrs, err := wm.Addresses(sp.payoutWallet.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payout addresses: %w", err)
+	}
+	var last uint64
+	for _, addr := range addrs {
+		var meta payoutAddressMetadata
+		if err := json.Unmarshal(addr.Metadata, &meta); err != nil {
+			continue // not a derived address
+		} else if meta.SeedIndex >= last {
+			last = meta.SeedIndex
+		}
+	}
+	if err := sp.useIndex(last, false, 0); err != nil {
+		return nil, err
+	}
+	return sp, nil
+}
+
+// chainHas returns true if the chain manager has the block at index and its
+// state, i.e. updates since index can be computed.
+func (sp *seedPayouts) chainHas(index types.ChainIndex) bool {
+	if index == (types.ChainIndex{}) {
+		return true
+	} else if _, ok := sp.cm.Block(index.ID); !ok {
+		return false
+	}
+	cs, ok := sp.cm.State(index.ID)
+	return ok && cs.Index == index
+}
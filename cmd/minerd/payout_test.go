@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"go.sia.tech/core/types"
+	coreutilsTestutil "go.sia.tech/coreutils/testutil"
+	cwallet "go.sia.tech/coreutils/wallet"
+	"go.sia.tech/minerd/internal/testutil"
+	"go.sia.tech/walletd/v2/wallet"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestSeedPayoutsRestart(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	network, genesisBlock := testutil.V1Network()
+	cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	wm, err := wallet.NewManager(cn.Chain, cn.Store, wallet.WithLogger(log.Named("wallet")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wm.Close()
+
+	phrase := cwallet.NewSeedPhrase()
+	payoutAddress := func() (*seedPayouts, types.Address) {
+		t.Helper()
+		sp, err := newSeedPayouts(phrase, 1, cn.Chain, wm, log.Named("payouts"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr, err := sp.PayoutAddress()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sp, addr
+	}
+
+	sp, first := payoutAddress()
+	if first != sp.deriveAddress(0).Address {
+		t.Fatal("expected the first address to be derived at index 0")
+	}
+
+	// an unused address is handed out again after a restart
+	if _, addr := payoutAddress(); addr != first {
+		t.Fatal("expected unused address to be reused after a restart")
+	}
+
+	// a block paying the address is added before the node notices, e.g.
+	// because it crashed
+	coreutilsTestutil.MineBlocks(t, cn.Chain, first, 1)
+	coreutilsTestutil.MineBlocks(t, cn.Chain, types.VoidAddress, 2)
+	sp, addr := payoutAddress()
+	if addr == first {
+		t.Fatal("expected used address not to be handed out again after a restart")
+	} else if addr != sp.deriveAddress(1).Address {
+		t.Fatal("expected the next address to be derived")
+	} else if !sp.used || sp.highestUsed != 0 {
+		t.Fatalf("expected highest used index 0, got %v %d", sp.used, sp.highestUsed)
+	}
+
+	// the rotation is persisted
+	if _, next := payoutAddress(); next != addr {
+		t.Fatal("expected the rotated address to be handed out after a restart")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.sia.tech/coreutils/syncer"
+	"go.sia.tech/minerd/internal/syncerutil"
+	"go.uber.org/zap"
+)
+
+const (
+	// staticPeerCheckInterval is the interval at which the connections to
+	// static peers are checked.
+	staticPeerCheckInterval = 30 * time.Second
+	// staticPeerConnectTimeout is the timeout for connecting to a static peer.
+	staticPeerConnectTimeout = 30 * time.Second
+	// maxStaticPeerBackoff is the maximum delay between reconnection attempts
+	// to a static peer that keeps failing.
+	maxStaticPeerBackoff = 30 * time.Minute
+)
+
+// staticPeer tracks the connection state of a configured peer.
+type staticPeer struct {
+	connected   bool
+	failures    int
+	nextAttempt time.Time
+}
+
+// maintainStaticPeers periodically reconnects to the given peers if they are
+// not connected. Failing peers are retried with exponential backoff. It blocks
+// until ctx is canceled.
+func maintainStaticPeers(ctx context.Context, s *syncer.Syncer, addrs []string, log *zap.Logger) {
+	peers := make(map[string]*staticPeer, len(addrs))
+	for _, addr := range addrs {
+		peers[addr] = &staticPeer{}
+	}
+
+	var matcher syncerutil.PeerMatcher
+	t := time.NewTicker(staticPeerCheckInterval)
+	defer t.Stop()
+	for {
+		maintainStaticPeersOnce(ctx, s, peers, &matcher, log)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// maintainStaticPeersOnce checks the connection to each static peer and
+// reconnects to the peers that aren't connected and aren't backing off.
+func maintainStaticPeersOnce(ctx context.Context, s *syncer.Syncer, peers map[string]*staticPeer, matcher *syncerutil.PeerMatcher, log *zap.Logger) {
+	connected := s.Peers()
+	for addr, p := range peers {
+		if _, ok := matcher.Find(ctx, connected, addr); ok {
+			if !p.connected {
+				log.Info("connected to static peer", zap.String("peer", addr))
+			}
+			p.connected, p.failures = true, 0
+			continue
+		} else if p.connected {
+			log.Warn("lost connection to static peer", zap.String("peer", addr))
+			p.connected = false
+		}
+		if time.Now().Before(p.nextAttempt) {
+			continue
+		}
+
+		connectCtx, cancel := context.WithTimeout(ctx, staticPeerConnectTimeout)
+		peer, err := s.Connect(connectCtx, addr)
+		if err == nil {
+			matcher.Connected(addr, peer)
+		} else if _, ok := matcher.Find(connectCtx, s.Peers(), addr); ok {
+			// the peer connected in the meantime
+			err = nil
+		}
+		cancel()
+		if err != nil {
+			p.failures++
+			backoff := min(staticPeerCheckInterval<<min(p.failures, 10), maxStaticPeerBackoff)
+			p.nextAttempt = time.Now().Add(backoff)
+			log.Debug("failed to connect to static peer", zap.String("peer", addr), zap.Duration("backoff", backoff), zap.Error(err))
+			continue
+		}
+		log.Info("connected to static peer", zap.String("peer", addr))
+		p.connected, p.failures = true, 0
+	}
+}
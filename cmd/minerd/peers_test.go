@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"go.sia.tech/minerd/internal/syncerutil"
+	"go.sia.tech/minerd/internal/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMaintainStaticPeers(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	network, genesisBlock := testutil.V1Network()
+	node := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	inbound := testutil.NewConsensusNode(t, network, genesisBlock, log)
+	outbound := testutil.NewConsensusNode(t, network, genesisBlock, log)
+
+	localhost := func(addr string) string {
+		t.Helper()
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return net.JoinHostPort("localhost", port)
+	}
+	inboundAddr, outboundAddr := localhost(inbound.PeerSyncer.Addr()), localhost(outbound.PeerSyncer.Addr())
+
+	// one static peer connects to the node on its own
+	_, port, _ := net.SplitHostPort(node.PeerSyncer.Addr())
+	if _, err := inbound.PeerSyncer.Connect(context.Background(), net.JoinHostPort("127.0.0.1", port)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && len(node.PeerSyncer.Peers()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	core, logs := observer.New(zap.DebugLevel)
+	peers := map[string]*staticPeer{
+		inboundAddr:  {},
+		outboundAddr: {},
+	}
+	var matcher syncerutil.PeerMatcher
+	for range 3 {
+		maintainStaticPeersOnce(context.Background(), node.PeerSyncer, peers, &matcher, zap.New(core))
+	}
+
+	for addr, p := range peers {
+		if !p.connected || p.failures != 0 {
+			t.Fatalf("expected static peer %v to be connected, got %+v", addr, *p)
+		}
+	}
+	if n := len(node.PeerSyncer.Peers()); n != 2 {
+		t.Fatalf("expected 2 peers, got %d", n)
+	} else if entries := logs.FilterMessage("failed to connect to static peer").All(); len(entries) != 0 {
+		t.Fatalf("expected connected peers not to be redialed, got %v", entries[0].ContextMap())
+	} else if n := logs.FilterMessage("connected to static peer").Len(); n != 2 {
+		t.Fatalf("expected each connection to be logged once, got %d", n)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readPIDFile returns the PID stored in the PID file at fp.
+func readPIDFile(fp string) (int, error) {
+	buf, err := os.ReadFile(fp)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid PID file %q: %w", fp, err)
+	}
+	return pid, nil
+}
+
+// writePIDFile writes the PID of the current process to fp. If the file
+// already exists and the process it refers to is still running, another
+// instance is assumed to be running and an error is returned. Stale files,
+// e.g. left behind by a crash, are replaced.
+func writePIDFile(fp string) error {
+	pid, err := readPIDFile(fp)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	case pid != os.Getpid() && processRunning(pid):
+		return fmt.Errorf("PID file %q refers to running process %d, is another instance running?", fp, pid)
+	default:
+		if err := os.Remove(fp); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove stale PID file: %w", err)
+		}
+	}
+
+	// O_EXCL prevents overwriting the file of an instance started
+	// concurrently
+	f, err := os.OpenFile(fp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create PID file: %w", err)
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "%d\n", os.Getpid()); err != nil {
+		return fmt.Errorf("failed to write PID file: %w", err)
+	}
+	return f.Sync()
+}
+
+// removePIDFile removes the PID file at fp if it still contains the PID of
+// the current process.
+func removePIDFile(fp string) error {
+	if pid, err := readPIDFile(fp); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	} else if pid != os.Getpid() {
+		return fmt.Errorf("PID file %q was replaced by process %d", fp, pid)
+	}
+	return os.Remove(fp)
+}
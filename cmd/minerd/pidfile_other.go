@@ -0,0 +1,16 @@
+//go:build windows || plan9
+
+package main
+
+import "os"
+
+// processRunning returns true if a process with the given PID exists.
+func processRunning(pid int) bool {
+	// on Windows, FindProcess fails if the process doesn't exist
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
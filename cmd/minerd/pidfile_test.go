@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestPIDFileHelperProcess isn't a real test. It is started by TestPIDFile
+// as another minerd instance that runs until it is killed.
+func TestPIDFileHelperProcess(t *testing.T) {
+	if os.Getenv("MINERD_TEST_PIDFILE_HELPER") != "1" {
+		t.Skip("helper process")
+	}
+	time.Sleep(time.Minute)
+	os.Exit(0)
+}
+
+func TestPIDFile(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "minerd.pid")
+	assertPID := func(expected int) {
+		t.Helper()
+		if pid, err := readPIDFile(fp); err != nil {
+			t.Fatal(err)
+		} else if pid != expected {
+			t.Fatalf("expected PID %d, got %d", expected, pid)
+		}
+	}
+
+	// another instance is running
+	other := exec.Command(os.Args[0], "-test.run=^TestPIDFileHelperProcess$")
+	other.Env = append(os.Environ(), "MINERD_TEST_PIDFILE_HELPER=1")
+	if err := other.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Process.Kill()
+	if err := os.WriteFile(fp, []byte(strconv.Itoa(other.Process.Pid)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	} else if err := writePIDFile(fp); err == nil {
+		t.Fatal("expected an error while the other instance is running")
+	}
+	assertPID(other.Process.Pid)
+
+	// it crashes, leaving a stale PID file behind
+	if err := other.Process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	other.Wait()
+	if err := writePIDFile(fp); err != nil {
+		t.Fatal(err)
+	}
+	assertPID(os.Getpid())
+
+	// a restart reusing the same PID, e.g. as PID 1 of a container, isn't
+	// mistaken for another instance
+	if err := writePIDFile(fp); err != nil {
+		t.Fatal(err)
+	}
+	assertPID(os.Getpid())
+
+	// a file taken over by another instance is left alone on shutdown
+	if err := os.WriteFile(fp, []byte(strconv.Itoa(os.Getppid())+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	} else if err := removePIDFile(fp); err == nil {
+		t.Fatal("expected an error removing a replaced PID file")
+	}
+	assertPID(os.Getppid())
+
+	// a clean shutdown removes the file
+	if err := os.WriteFile(fp, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	} else if err := removePIDFile(fp); err != nil {
+		t.Fatal(err)
+	} else if _, err := os.Stat(fp); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the PID file to be removed, got %v", err)
+	} else if err := removePIDFile(fp); err != nil {
+		t.Fatal(err)
+	}
+}
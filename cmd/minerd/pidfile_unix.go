@@ -0,0 +1,16 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processRunning returns true if a process with the given PID exists.
+func processRunning(pid int) bool {
+	// signal 0 only checks whether the process exists. EPERM means it exists
+	// but belongs to another user.
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// applyProfile overrides the settings of c with the profile with the given
+// name. Settings whose flags are in set were passed explicitly and are kept.
+func applyProfile(c *Config, name string, set map[string]bool) error {
+	p, ok := c.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(c.Profiles))
+		for name := range c.Profiles {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		if len(names) == 0 {
+			return fmt.Errorf("profile %q does not exist, the config file doesn't define any profiles", name)
+		}
+		return fmt.Errorf("profile %q does not exist, must be one of %s", name, strings.Join(names, ", "))
+	}
+
+	override := func(flag string, dst *string, v string) {
+		if v != "" && !set[flag] {
+			*dst = v
+		}
+	}
+	override("dir", &c.Directory, p.Directory)
+	override("network", &c.Consensus.Network, p.Network)
+	override("http", &c.HTTP.Address, p.HTTPAddress)
+	override("addr", &c.Syncer.Address, p.SyncerAddress)
+	// the payout settings are mutually exclusive, so a profile replaces both
+	if (p.PayoutAddress != "" || p.PayoutWallet != "") && !set["mining.payoutAddress"] && !set["mining.payoutWallet"] {
+		c.Mining.PayoutAddress = p.PayoutAddress
+		c.Mining.PayoutWallet = p.PayoutWallet
+	}
+	return nil
+}
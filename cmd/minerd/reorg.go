@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.uber.org/zap"
+)
+
+// A reorgLimiter wraps a chain manager and rejects blocks that fork off the
+// best chain more than maxDepth blocks below the tip. This protects against
+// deep reorg attacks at the cost of possibly ending up on a different chain
+// than the rest of the network.
+type reorgLimiter struct {
+	*chain.Manager
+
+	maxDepth uint64
+	log      *zap.Logger
+}
+
+// forkDepth returns the number of blocks that would have to be reverted to
+// apply a block with the given parent. If the fork point is more than maxDepth
+// blocks below the tip, maxDepth+1 is returned.
+func (rl *reorgLimiter) forkDepth(parentID types.BlockID) uint64 {
+	tip := rl.Tip()
+	id := parentID
+	for depth := uint64(0); depth <= rl.maxDepth; depth++ {
+		cs, ok := rl.State(id)
+		if !ok {
+			return 0 // unknown parent, let the chain manager reject the block
+		} else if best, ok := rl.BestIndex(cs.Index.Height); ok && best.ID == id {
+			return tip.Height - cs.Index.Height
+		}
+		b, ok := rl.Block(id)
+		if !ok {
+			return 0
+		}
+		id = b.ParentID
+	}
+	return rl.maxDepth + 1
+}
+
+func (rl *reorgLimiter) checkDepth(blocks []types.Block) error {
+	if len(blocks) == 0 || blocks[0].ParentID == rl.Tip().ID {
+		return nil
+	} else if depth := rl.forkDepth(blocks[0].ParentID); depth > rl.maxDepth {
+		rl.log.Error("rejecting blocks forking off deeper than the max reorg depth", zap.Stringer("parentID", blocks[0].ParentID), zap.Uint64("maxDepth", rl.maxDepth), zap.Stringer("tip", rl.Tip()))
+		return fmt.Errorf("blocks fork off more than %d blocks below the tip", rl.maxDepth)
+	}
+	return nil
+}
+
+// AddBlocks implements syncer.ChainManager.
+func (rl *reorgLimiter) AddBlocks(blocks []types.Block) error {
+	if err := rl.checkDepth(blocks); err != nil {
+		return err
+	}
+	return rl.Manager.AddBlocks(blocks)
+}
+
+// AddValidatedV2Blocks implements syncer.ChainManager.
+func (rl *reorgLimiter) AddValidatedV2Blocks(blocks []types.Block, states []consensus.State) error {
+	if err := rl.checkDepth(blocks); err != nil {
+		return err
+	}
+	return rl.Manager.AddValidatedV2Blocks(blocks, states)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils"
+	"go.sia.tech/coreutils/chain"
+	coreutilsTestutil "go.sia.tech/coreutils/testutil"
+	"go.sia.tech/minerd/internal/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
+	"lukechampine.com/frand"
+)
+
+// mineFork mines n v2 blocks on top of parentID and returns them with their
+// child states.
+func mineFork(t *testing.T, cm *chain.Manager, parentID types.BlockID, n int) ([]types.Block, []consensus.State) {
+	t.Helper()
+	cs, ok := cm.State(parentID)
+	if !ok {
+		t.Fatal("missing parent state")
+	}
+	var blocks []types.Block
+	var states []consensus.State
+	for range n {
+		addr := types.Address(frand.Entropy256())
+		b := types.Block{
+			ParentID:     cs.Index.ID,
+			Timestamp:    cs.PrevTimestamps[0].Add(time.Second),
+			MinerPayouts: []types.SiacoinOutput{{Address: addr, Value: cs.BlockReward()}},
+			V2: &types.V2BlockData{
+				Height:     cs.Index.Height + 1,
+				Commitment: cs.Commitment(addr, nil, nil),
+			},
+		}
+		if !coreutils.FindBlockNonce(cs, &b, 10*time.Second) {
+			t.Fatal("failed to find nonce")
+		} else if err := consensus.ValidateBlock(cs, b, consensus.V1BlockSupplement{}); err != nil {
+			t.Fatal(err)
+		}
+		cs, _ = consensus.ApplyBlock(cs, b, consensus.V1BlockSupplement{}, time.Time{})
+		blocks = append(blocks, b)
+		states = append(states, cs)
+	}
+	return blocks, states
+}
+
+func TestReorgLimiter(t *testing.T) {
+	const maxDepth = 3
+
+	paths := map[string]func(rl *reorgLimiter, blocks []types.Block, states []consensus.State) error{
+		"AddBlocks": func(rl *reorgLimiter, blocks []types.Block, _ []consensus.State) error {
+			return rl.AddBlocks(blocks)
+		},
+		"AddValidatedV2Blocks": func(rl *reorgLimiter, blocks []types.Block, states []consensus.State) error {
+			return rl.AddValidatedV2Blocks(blocks, states)
+		},
+	}
+	for name, add := range paths {
+		t.Run(name, func(t *testing.T) {
+			log := zaptest.NewLogger(t)
+			network, genesisBlock := testutil.V2Network()
+			cn := testutil.NewConsensusNode(t, network, genesisBlock, log)
+			coreutilsTestutil.MineBlocks(t, cn.Chain, types.VoidAddress, 10)
+
+			core, logs := observer.New(zap.ErrorLevel)
+			rl := &reorgLimiter{Manager: cn.Chain, maxDepth: maxDepth, log: zap.New(core)}
+			tip := cn.Chain.Tip()
+			ancestor := func(depth uint64) types.BlockID {
+				t.Helper()
+				index, ok := cn.Chain.BestIndex(tip.Height - depth)
+				if !ok {
+					t.Fatalf("missing block %d below the tip", depth)
+				}
+				return index.ID
+			}
+
+			// a fork one block deeper than the limit is rejected without
+			// touching the chain
+			blocks, states := mineFork(t, cn.Chain, ancestor(maxDepth+1), maxDepth+3)
+			if err := add(rl, blocks, states); err == nil {
+				t.Fatal("expected fork beyond the max reorg depth to be rejected")
+			} else if cn.Chain.Tip() != tip {
+				t.Fatalf("expected tip %v to be kept, got %v", tip, cn.Chain.Tip())
+			} else if _, ok := cn.Chain.Block(blocks[0].ID()); ok {
+				t.Fatal("expected rejected blocks not to be stored")
+			} else if logs.FilterMessage("rejecting blocks forking off deeper than the max reorg depth").Len() != 1 {
+				t.Fatal("expected the rejection to be logged")
+			}
+
+			// a fork exactly at the limit is accepted and reorgs the chain
+			blocks, states = mineFork(t, cn.Chain, ancestor(maxDepth), maxDepth+2)
+			if err := add(rl, blocks, states); err != nil {
+				t.Fatal(err)
+			} else if last := blocks[len(blocks)-1]; cn.Chain.Tip().ID != last.ID() {
+				t.Fatalf("expected reorg to %v, got %v", last.ID(), cn.Chain.Tip())
+			}
+		})
+	}
+}
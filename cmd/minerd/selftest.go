@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.sia.tech/coreutils"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/minerd/api"
+	"go.uber.org/zap"
+)
+
+const (
+	// selfTestTimeout is the timeout for each request of a self-test.
+	selfTestTimeout = 30 * time.Second
+	// selfTestMiningTimeout is how long the self-test searches for a nonce.
+	// Self-tests run on devnets, where blocks are found almost instantly.
+	selfTestMiningTimeout = time.Minute
+)
+
+// runNodeSelfTest starts the node, mines a block from one of its templates
+// and submits it, then shuts the node down. It returns an error if the node
+// fails to start or the self-test fails. Self-tests are refused on mainnet
+// since mining a block at mainnet difficulty is infeasible.
+func runNodeSelfTest(ctx context.Context, cfg Config, configPath string, log *zap.Logger, enableDebug, reset, ephemeral bool) error {
+	if cfg.Consensus.Network == "mainnet" {
+		return errors.New("refusing to run a self-test on mainnet")
+	}
+	// a devnet node usually has no peers and a stale tip, which would delay
+	// templates and reject the submitted block
+	cfg.Mining.AllowUnsyncedSubmissions = true
+	cfg.Mining.StartupGracePeriod = 0
+
+	addr, err := apiURL(cfg.HTTP)
+	if err != nil {
+		return fmt.Errorf("failed to determine API address: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	started := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- runNode(ctx, cfg, configPath, log, enableDebug, reset, ephemeral, func() { close(started) })
+	}()
+	select {
+	case err := <-errCh:
+		if err == nil {
+			err = errors.New("node shut down before the self-test ran")
+		}
+		return err
+	case <-started:
+	}
+
+	testErr := runSelfTest(addr, cfg.HTTP.Password)
+	cancel()
+	if err := <-errCh; err != nil {
+		return err
+	} else if testErr != nil {
+		return fmt.Errorf("self-test failed: %w", testErr)
+	}
+	log.Info("self-test passed")
+	return nil
+}
+
+// runSelfTest fetches a block template from the node at addr, mines it and
+// submits the block. It returns an error if any step fails or the block does
+// not become the node's tip.
+func runSelfTest(addr, password string) error {
+	c := api.NewClient(addr, password)
+	// mining can take longer than a request, so every request gets its own
+	// timeout
+	request := func() (context.Context, context.CancelFunc) {
+		return context.WithTimeout(context.Background(), selfTestTimeout)
+	}
+
+	_, mainnetGenesis := chain.Mainnet()
+	ctx, cancel := request()
+	network, err := c.Network(ctx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to get network: %w", err)
+	} else if network.Name == "mainnet" || network.GenesisID == mainnetGenesis.ID() {
+		return errors.New("refusing to run a self-test on mainnet")
+	}
+
+	ctx, cancel = request()
+	b, _, err := c.MiningGetBlock(ctx, "")
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to get block template: %w", err)
+	}
+	cs, err := c.ConsensusTipState()
+	if err != nil {
+		return fmt.Errorf("failed to get tip state: %w", err)
+	} else if cs.Index.ID != b.ParentID {
+		return fmt.Errorf("template builds on %v instead of the tip %v", b.ParentID, cs.Index)
+	}
+	fmt.Printf("Mining block %v on %v...\n", cs.Index.Height+1, network.Name)
+	if !coreutils.FindBlockNonce(cs, &b, selfTestMiningTimeout) {
+		return fmt.Errorf("failed to find a nonce within %v, difficulty %v is too high", selfTestMiningTimeout, cs.Difficulty)
+	}
+	ctx, cancel = request()
+	err = c.MiningSubmitBlock(ctx, b)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to submit block: %w", err)
+	}
+
+	tip, err := c.ConsensusTip()
+	if err != nil {
+		return fmt.Errorf("failed to get tip: %w", err)
+	} else if tip.ID != b.ID() {
+		return fmt.Errorf("expected tip %v after submitting the block, got %v", b.ID(), tip)
+	}
+	fmt.Println("Block", b.ID(), "was added to the chain")
+	return nil
+}
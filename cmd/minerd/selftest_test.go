@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/minerd/internal/testutil"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestNodeSelfTest(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	dir := t.TempDir()
+
+	network, genesisBlock := testutil.V2Network()
+	buf, err := json.Marshal(map[string]any{"network": network, "genesis": genesisBlock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	networkFile := filepath.Join(dir, "devnet.json")
+	if err := os.WriteFile(networkFile, buf, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpAddr := l.Addr().String()
+	l.Close()
+
+	c := cfg
+	c.Directory = dir
+	c.HTTP.Address = httpAddr
+	c.HTTP.Password = "password"
+	c.Syncer.Address = "127.0.0.1:0"
+	c.Syncer.Bootstrap = false
+	c.Syncer.EnableUPnP = false
+	c.Consensus.Network = networkFile
+	c.Mining.PayoutAddress = types.StandardUnlockHash(types.GeneratePrivateKey().PublicKey()).String()
+	// the node has no peers and its tip is as old as the genesis block, so
+	// the self-test has to opt out of rejecting unsynced submissions
+	c.Mining.AllowUnsyncedSubmissions = false
+	if err := runNodeSelfTest(context.Background(), c, "", log, false, false, true); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Consensus.Network = "mainnet"
+	if err := runNodeSelfTest(context.Background(), c, "", log, false, false, true); err == nil {
+		t.Fatal("expected the self-test to be refused on mainnet")
+	}
+}
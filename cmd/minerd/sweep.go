@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.sia.tech/core/types"
+	cwallet "go.sia.tech/coreutils/wallet"
+	"go.sia.tech/minerd/api"
+	"go.sia.tech/walletd/v2/wallet"
+)
+
+// sweepFeeWeight is the weight walletd's construct endpoint multiplies the
+// recommended fee with to get the transaction fee.
+const sweepFeeWeight = 2000
+
+// sweepWallet returns the wallet with the given name.
+func sweepWallet(c *api.Client, name string) (wallet.Wallet, error) {
+	wallets, err := c.Wallets()
+	if err != nil {
+		return wallet.Wallet{}, fmt.Errorf("failed to get wallets: %w", err)
+	}
+	var matches []wallet.Wallet
+	for _, w := range wallets {
+		if w.Name == name {
+			matches = append(matches, w)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return wallet.Wallet{}, fmt.Errorf("wallet %q does not exist", name)
+	case 1:
+		return matches[0], nil
+	default:
+		return wallet.Wallet{}, fmt.Errorf("multiple wallets are named %q", name)
+	}
+}
+
+// signSweep signs the inputs of txn that spend seed-derived payout addresses.
+// It returns false if any input can't be signed with the seed.
+func signSweep(phrase string, addrs []wallet.Address, txn *types.V2Transaction, sigHash types.Hash256) (signed bool, _ error) {
+	var seed [32]byte
+	if err := cwallet.SeedFromPhrase(&seed, phrase); err != nil {
+		return false, fmt.Errorf("invalid recovery phrase: %w", err)
+	}
+	indices := make(map[types.Address]uint64)
+	for _, addr := range addrs {
+		var meta payoutAddressMetadata
+		if err := json.Unmarshal(addr.Metadata, &meta); err == nil {
+			indices[addr.Address] = meta.SeedIndex
+		}
+	}
+
+	signed = true
+	for i, sci := range txn.SiacoinInputs {
+		addr := sci.Parent.SiacoinOutput.Address
+		index, ok := indices[addr]
+		var key types.PrivateKey
+		if ok {
+			key = cwallet.KeyFromSeed(&seed, index)
+		}
+		// addresses with unrelated metadata or derived from another seed
+		// can't be signed
+		if !ok || types.StandardUnlockHash(key.PublicKey()) != addr {
+			signed = false
+			continue
+		}
+		txn.SiacoinInputs[i].SatisfiedPolicy.Signatures = []types.Signature{key.SignHash(sigHash)}
+	}
+	return signed, nil
+}
+
+// runSweep constructs a v2 transaction sending the mature siacoins of the
+// wallet with the given name to dest. Immature block rewards are left in the
+// wallet. If phrase is set, inputs spending seed-derived payout addresses are
+// signed. The transaction is written to stdout as JSON and only broadcast if
+// broadcast is set, which requires every input to be signed. Otherwise, the
+// outputs reserved for the transaction are released, so it can be signed
+// offline and broadcast later.
+func runSweep(addr, password, walletName string, dest types.Address, phrase string, broadcast bool) error {
+	c := api.NewClient(addr, password)
+
+	cs, err := c.ConsensusTipState()
+	if err != nil {
+		return fmt.Errorf("failed to get tip state: %w", err)
+	} else if cs.Index.Height < cs.Network.HardforkV2.AllowHeight {
+		return fmt.Errorf("sweeping requires v2 transactions, which are allowed from height %d", cs.Network.HardforkV2.AllowHeight)
+	}
+
+	w, err := sweepWallet(c, walletName)
+	if err != nil {
+		return err
+	}
+	wc := c.Wallet(w.ID)
+	balance, err := wc.Balance()
+	if err != nil {
+		return fmt.Errorf("failed to get balance: %w", err)
+	}
+	recommendedFee, err := c.TxpoolFee()
+	if err != nil {
+		return fmt.Errorf("failed to get recommended fee: %w", err)
+	}
+	fee := recommendedFee.Mul64(sweepFeeWeight)
+	if balance.Siacoins.Cmp(fee) <= 0 {
+		return fmt.Errorf("mature balance %v doesn't cover the fee %v, %v are still immature", balance.Siacoins, fee, balance.ImmatureSiacoins)
+	}
+	amount := balance.Siacoins.Sub(fee)
+
+	resp, err := wc.ConstructV2([]types.SiacoinOutput{{Address: dest, Value: amount}}, nil, dest)
+	if err != nil {
+		return fmt.Errorf("failed to construct transaction: %w", err)
+	}
+	release := func() {
+		ids := make([]types.SiacoinOutputID, 0, len(resp.Transaction.SiacoinInputs))
+		for _, sci := range resp.Transaction.SiacoinInputs {
+			ids = append(ids, sci.Parent.ID)
+		}
+		if err := wc.Release(ids, nil); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to release reserved outputs:", err)
+		}
+	}
+
+	var signed bool
+	if phrase != "" {
+		addrs, err := wc.Addresses()
+		if err != nil {
+			release()
+			return fmt.Errorf("failed to get wallet addresses: %w", err)
+		}
+		signed, err = signSweep(phrase, addrs, &resp.Transaction, resp.InputSigHash)
+		if err != nil {
+			release()
+			return err
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(resp); err != nil {
+		release()
+		return fmt.Errorf("failed to write transaction: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Sweeping %v from %d outputs to %v, paying a fee of %v\n", amount, len(resp.Transaction.SiacoinInputs), dest, resp.EstimatedFee)
+	if !balance.ImmatureSiacoins.IsZero() {
+		fmt.Fprintf(os.Stderr, "%v of block rewards are still immature and stay in the wallet\n", balance.ImmatureSiacoins)
+	}
+
+	if !broadcast {
+		release()
+		if !signed {
+			fmt.Fprintln(os.Stderr, "Not every input is signed. Sign the remaining inputs with the keys of their addresses over inputSigHash before broadcasting the transaction.")
+		}
+		return nil
+	} else if !signed {
+		release()
+		return errors.New("can't broadcast the transaction since not every input could be signed with the payout seed")
+	}
+	if _, err := c.TxpoolBroadcast(resp.Basis, nil, []types.V2Transaction{resp.Transaction}); err != nil {
+		release()
+		return fmt.Errorf("failed to broadcast transaction: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Broadcast transaction %v\n", resp.ID)
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"errors"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// newSyslogCore returns an error since syslog is not supported on this
+// platform.
+func newSyslogCore(network, addr, facility, tag string, enc zapcore.Encoder, level zapcore.LevelEnabler) (zapcore.Core, func() error, error) {
+	return nil, nil, errors.New("syslog is not supported on this platform")
+}
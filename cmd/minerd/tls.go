@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// A certReloader serves a TLS certificate loaded from disk. The certificate
+// can be reloaded while the server is running to support renewals.
+type certReloader struct {
+	certPath, keyPath string
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+}
+
+// Reload loads the certificate and key from disk. If loading fails, the
+// previous certificate continues to be served.
+func (cr *certReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(cr.certPath, cr.keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.mu.Unlock()
+	return nil
+}
+
+// GetCertificate implements the tls.Config.GetCertificate callback.
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return cr.cert, nil
+}
+
+// disableTLSVerification makes the default HTTP client accept any TLS
+// certificate, e.g. self-signed certificates of remote nodes.
+func disableTLSVerification() {
+	t, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		panic("default transport is not an *http.Transport") // should never happen
+	}
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = new(tls.Config)
+	}
+	t.TLSClientConfig.InsecureSkipVerify = true
+}
+
+// trustCertificate makes the default HTTP client accept the certificate at
+// path, which is the certificate served by the local node. The node is
+// usually reached at an address the certificate wasn't issued for, so only
+// the certificate itself is checked, not the host name.
+func trustCertificate(path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read TLS certificate: %w", err)
+	}
+	block, _ := pem.Decode(buf)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return fmt.Errorf("failed to read TLS certificate: no certificate found in %q", path)
+	}
+	leaf := block.Bytes
+
+	t, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		panic("default transport is not an *http.Transport") // should never happen
+	}
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = new(tls.Config)
+	}
+	t.TLSClientConfig.InsecureSkipVerify = true
+	t.TLSClientConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], leaf) {
+			return errors.New("node certificate does not match the configured TLS certificate")
+		}
+		return nil
+	}
+	return nil
+}
+
+func newCertReloader(certPath, keyPath string) (*certReloader, error) {
+	cr := &certReloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+	}
+	if err := cr.Reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.sia.tech/walletd/v2/config"
+)
+
+// writeTestCertificate writes a self-signed certificate for host and its key
+// to dir.
+func writeTestCertificate(t *testing.T, dir, host string) (certPath, keyPath string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath = filepath.Join(dir, host+".crt"), filepath.Join(dir, host+".key")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	} else if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func TestAPIURLTLS(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeTestCertificate(t, dir, "node.example.com")
+	otherCert, otherKey := writeTestCertificate(t, dir, "other.example.com")
+
+	serve := func(l net.Listener, certPath, keyPath string) {
+		t.Helper()
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := &http.Server{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(r.URL.Path))
+			}),
+			TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		}
+		t.Cleanup(func() { server.Close() })
+		go server.ServeTLS(l, "", "")
+	}
+	get := func(url string) error {
+		t.Helper()
+		resp, err := http.Get(url + "/state")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", resp.StatusCode)
+		}
+		return nil
+	}
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(tcp, certPath, keyPath)
+	unixPath := filepath.Join(dir, "minerd.sock")
+	unix, err := net.Listen("unix", unixPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(unix, certPath, keyPath)
+	other, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(other, otherCert, otherKey)
+
+	// the node's own certificate is trusted even though it was issued for
+	// another host name
+	for _, addr := range []string{tcp.Addr().String(), unixAddressPrefix + unixPath} {
+		url, err := apiURL(HTTP{HTTP: config.HTTP{Address: addr}, TLSCert: certPath, TLSKey: keyPath})
+		if err != nil {
+			t.Fatal(err)
+		} else if !strings.HasPrefix(url, "https") {
+			t.Fatalf("expected an HTTPS URL for %q, got %q", addr, url)
+		} else if err := get(url); err != nil {
+			t.Fatalf("failed to reach %q: %v", addr, err)
+		}
+	}
+
+	// other certificates are not
+	if err := get("https://" + other.Addr().String() + "/api"); err == nil {
+		t.Fatal("expected a certificate that isn't the node's to be rejected")
+	}
+
+	// without TLS the API is reached over plain HTTP
+	if url, err := apiURL(HTTP{HTTP: config.HTTP{Address: "localhost:9980"}}); err != nil {
+		t.Fatal(err)
+	} else if url != "http://localhost:9980/api" {
+		t.Fatalf("expected plain HTTP URL, got %q", url)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.sia.tech/minerd/api"
+)
+
+// unixAddressPrefix is the prefix of HTTP addresses that refer to a Unix
+// socket instead of a TCP address.
+const unixAddressPrefix = "unix:"
+
+// listenUnix listens on the Unix socket at path and sets the socket's file
+// permissions to mode. A stale socket left behind by a previous process is
+// removed.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to listen on %q: %w", path, errAddressInUse)
+		} else if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket %q: %w", path, err)
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %q: %w", path, err)
+	} else if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return l, nil
+}
+
+// listenHTTP listens on the configured HTTP address, which is either a TCP
+// address or a Unix socket prefixed with "unix:".
+func listenHTTP(cfg HTTP) (net.Listener, error) {
+	path, ok := strings.CutPrefix(cfg.Address, unixAddressPrefix)
+	if !ok {
+		return listen(cfg.Address)
+	} else if path == "" {
+		return nil, errors.New("unix socket path must not be empty")
+	}
+	mode, err := strconv.ParseUint(cfg.UnixSocketMode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid unix socket mode %q: %w", cfg.UnixSocketMode, err)
+	}
+	return listenUnix(path, os.FileMode(mode))
+}
+
+// apiURL returns the base URL of the API served with the given HTTP config.
+// If the API is served over HTTPS, the default HTTP client is set up to trust
+// the configured certificate.
+func apiURL(cfg HTTP) (string, error) {
+	useTLS := cfg.TLSCert != "" && cfg.TLSKey != ""
+	if useTLS {
+		if err := trustCertificate(cfg.TLSCert); err != nil {
+			return "", err
+		}
+	}
+	path, unix := strings.CutPrefix(cfg.Address, unixAddressPrefix)
+	switch {
+	case unix && useTLS:
+		return api.UnixSocketTLSURL(path) + "/api", nil
+	case unix:
+		return api.UnixSocketURL(path) + "/api", nil
+	case useTLS:
+		return "https://" + cfg.Address + "/api", nil
+	default:
+		return "http://" + cfg.Address + "/api", nil
+	}
+}
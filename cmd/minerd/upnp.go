@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"lukechampine.com/upnp"
+)
+
+const (
+	// upnpTimeout is the timeout for a single UPnP discovery and forwarding
+	// attempt.
+	upnpTimeout = 5 * time.Second
+	// upnpRetryInterval is the initial delay between failed UPnP attempts.
+	upnpRetryInterval = 30 * time.Second
+	// maxUPnPBackoff is the maximum delay between failed UPnP attempts.
+	maxUPnPBackoff = 10 * time.Minute
+	// upnpRefreshInterval is the interval at which an established port
+	// forward is checked and renewed if the router dropped it.
+	upnpRefreshInterval = 10 * time.Minute
+)
+
+func setupUPNP(ctx context.Context, port uint16, log *zap.Logger) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, upnpTimeout)
+	defer cancel()
+	d, err := upnp.Discover(ctx)
+	if err != nil {
+		return "", fmt.Errorf("couldn't discover UPnP router: %w", err)
+	} else if !d.IsForwarded(port, "TCP") {
+		if err := d.Forward(uint16(port), "TCP", "minerd"); err != nil {
+			return "", fmt.Errorf("couldn't forward port: %w", err)
+		}
+		log.Debug("forwarded p2p port", zap.Uint16("port", port))
+	}
+	return d.ExternalIP()
+}
+
+// An announcedListener is a syncer listener that reports the address the node
+// is announced at instead of the address it listens on. The address is updated
+// when UPnP discovers the external IP after the syncer has started.
+type announcedListener struct {
+	net.Listener
+	addr atomic.Value // string
+}
+
+type announcedAddr string
+
+func (a announcedAddr) Network() string { return "tcp" }
+func (a announcedAddr) String() string  { return string(a) }
+
+// Addr implements net.Listener.
+func (l *announcedListener) Addr() net.Addr {
+	return announcedAddr(l.addr.Load().(string))
+}
+
+// SetAddr updates the announced address.
+func (l *announcedListener) SetAddr(addr string) {
+	l.addr.Store(addr)
+}
+
+// maintainUPnP forwards the syncer port and discovers the external IP with
+// UPnP in the background. Failed attempts are retried with exponential
+// backoff and an established forward is periodically renewed, so a router
+// that is slow to respond at boot does not leave the node unreachable until
+// it is restarted. externalIP is the result of the initial attempt made
+// before the syncer started, if it succeeded. setExternalIP is called
+// whenever a later attempt discovers a different external IP. It blocks
+// until ctx is canceled.
+func maintainUPnP(ctx context.Context, port uint16, externalIP string, setExternalIP func(string), log *zap.Logger) {
+	available := externalIP != ""
+	var failures int
+	next := upnpRetryInterval
+	if available {
+		next = upnpRefreshInterval
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(next):
+		}
+
+		next = upnpRefreshInterval
+		ip, err := setupUPNP(ctx, port, log)
+		switch {
+		case err != nil:
+			failures++
+			next = min(upnpRetryInterval<<min(failures-1, 10), maxUPnPBackoff)
+			if available || failures == 1 {
+				log.Warn("failed to set up UPnP", zap.Duration("retry", next), zap.Error(err))
+			} else {
+				log.Debug("failed to set up UPnP", zap.Duration("retry", next), zap.Error(err))
+			}
+			available = false
+		case !available || ip != externalIP:
+			log.Info("UPnP port forwarding established", zap.String("externalIP", ip), zap.Uint16("port", port))
+			if ip != externalIP {
+				setExternalIP(ip)
+			}
+			available, externalIP, failures = true, ip, 0
+		}
+	}
+}
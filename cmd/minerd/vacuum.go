@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // import sqlite3 driver
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// databaseSize returns the combined size of the SQLite database at fp and its
+// write-ahead log.
+func databaseSize(fp string) (int64, error) {
+	stat, err := os.Stat(fp)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat database: %w", err)
+	}
+	size := stat.Size()
+	if stat, err := os.Stat(fp + "-wal"); err == nil {
+		size += stat.Size()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("failed to stat write-ahead log: %w", err)
+	}
+	return size, nil
+}
+
+// vacuumDatabase runs PRAGMA optimize and VACUUM on the SQLite database at fp
+// and returns its size before and after.
+func vacuumDatabase(ctx context.Context, fp string) (before, after int64, err error) {
+	before, err = databaseSize(fp)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_busy_timeout=%d", fp, time.Minute.Milliseconds()))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	if _, err := db.ExecContext(ctx, "PRAGMA optimize"); err != nil {
+		return 0, 0, fmt.Errorf("failed to optimize database: %w", err)
+	} else if _, err := db.ExecContext(ctx, "VACUUM"); err != nil {
+		return 0, 0, fmt.Errorf("failed to vacuum database: %w", err)
+	} else if _, err := db.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return 0, 0, fmt.Errorf("failed to checkpoint database: %w", err)
+	}
+
+	after, err = databaseSize(fp)
+	if err != nil {
+		return 0, 0, err
+	}
+	return before, after, nil
+}
+
+// runDatabaseMaintenance periodically vacuums the SQLite database at fp. Since
+// VACUUM blocks writes, it is skipped while the indexer is catching up with
+// the chain.
+func runDatabaseMaintenance(ctx context.Context, fp string, interval time.Duration, indexed func() (types.ChainIndex, error), tip func() types.ChainIndex, log *zap.Logger) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		if index, err := indexed(); err != nil {
+			log.Warn("failed to get last indexed block", zap.Error(err))
+			continue
+		} else if index != tip() {
+			log.Debug("skipping database maintenance while indexing", zap.Stringer("indexed", index), zap.Stringer("tip", tip()))
+			continue
+		}
+
+		log.Info("vacuuming database")
+		start := time.Now()
+		before, after, err := vacuumDatabase(ctx, fp)
+		if err != nil {
+			log.Warn("failed to vacuum database", zap.Error(err))
+			continue
+		}
+		log.Info("vacuumed database", zap.Int64("sizeBefore", before), zap.Int64("sizeAfter", after), zap.Duration("elapsed", time.Since(start)))
+	}
+}
@@ -3,6 +3,7 @@ module go.sia.tech/minerd // v0.3.4
 go 1.26.0
 
 require (
+	github.com/mattn/go-sqlite3 v1.14.33
 	go.sia.tech/core v0.21.1
 	go.sia.tech/coreutils v0.22.0
 	go.sia.tech/jape v0.14.1
@@ -19,7 +20,6 @@ require (
 require (
 	github.com/dunglas/httpsfv v1.1.0 // indirect
 	github.com/julienschmidt/httprouter v1.3.0 // indirect
-	github.com/mattn/go-sqlite3 v1.14.33 // indirect
 	github.com/quic-go/qpack v0.6.0 // indirect
 	github.com/quic-go/quic-go v0.59.1 // indirect
 	github.com/quic-go/webtransport-go v0.10.1-0.20260312060737-05fe5253a73c // indirect
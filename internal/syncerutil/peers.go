@@ -0,0 +1,104 @@
+// Package syncerutil contains helpers for working with the syncer's peers.
+package syncerutil
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"go.sia.tech/core/gateway"
+	"go.sia.tech/coreutils/syncer"
+)
+
+// A PeerMatcher finds the connected peer for a configured peer address. Peers
+// are reported by the address they were reached at, which is the resolved
+// remote address for outbound connections and an ephemeral port for inbound
+// ones, so comparing it with the configured address misses peers configured
+// by hostname and peers that connected to us. Instead, peers are identified
+// by the unique ID of the peer previously dialed at the address or, failing
+// that, by the address they announce and the resolved addresses of the
+// configured host.
+type PeerMatcher struct {
+	mu  sync.Mutex
+	ids map[string]gateway.UniqueID
+}
+
+// Connected records that the peer was connected to at addr, so it is found
+// even if it later announces a different address.
+func (m *PeerMatcher) Connected(addr string, p *syncer.Peer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ids == nil {
+		m.ids = make(map[string]gateway.UniqueID)
+	}
+	m.ids[addr] = p.UniqueID()
+}
+
+// Find returns the peer in peers that addr refers to.
+func (m *PeerMatcher) Find(ctx context.Context, peers []*syncer.Peer, addr string) (*syncer.Peer, bool) {
+	m.mu.Lock()
+	id, ok := m.ids[addr]
+	m.mu.Unlock()
+	if ok {
+		for _, p := range peers {
+			if p.UniqueID() == id {
+				return p, true
+			}
+		}
+	}
+	for _, p := range peers {
+		if p.ConnAddr == addr || p.Addr() == addr {
+			return p, true
+		}
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, false
+	}
+	ips, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, false
+	}
+	matches := func(peerAddr string) bool {
+		peerHost, peerPort, err := net.SplitHostPort(peerAddr)
+		if err != nil || peerPort != port {
+			return false
+		}
+		for _, ip := range ips {
+			if sameIP(ip, peerHost) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, p := range peers {
+		if matches(p.ConnAddr) || matches(announcedAddr(p)) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// announcedAddr returns the address the peer announces. Peers listening on
+// all interfaces announce an unspecified IP, which is replaced by the IP they
+// are connected from.
+func announcedAddr(p *syncer.Peer) string {
+	host, port, err := net.SplitHostPort(p.Addr())
+	if err != nil {
+		return p.Addr()
+	} else if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+		return p.Addr()
+	}
+	connHost, _, err := net.SplitHostPort(p.ConnAddr)
+	if err != nil {
+		return p.Addr()
+	}
+	return net.JoinHostPort(connHost, port)
+}
+
+// sameIP returns true if a and b are the same IP address.
+func sameIP(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	return ipA != nil && ipA.Equal(ipB)
+}
@@ -0,0 +1,76 @@
+package testutil
+
+import (
+	"os"
+	"testing"
+
+	"go.sia.tech/core/types"
+)
+
+// Snapshot writes the node's chain to a file so that it can be restored by
+// other nodes using Restore. Only the blocks are stored. The wallet database
+// is derived from the chain and rebuilt by the wallet manager after
+// restoring.
+func (cn *ConsensusNode) Snapshot(tb testing.TB, path string) {
+	tb.Helper()
+
+	tip := cn.Chain.Tip()
+	blocks := make([]types.Block, 0, tip.Height)
+	for height := uint64(1); height <= tip.Height; height++ {
+		index, ok := cn.Chain.BestIndex(height)
+		if !ok {
+			tb.Fatalf("missing index at height %d", height)
+		}
+		b, ok := cn.Chain.Block(index.ID)
+		if !ok {
+			tb.Fatalf("missing block %v", index)
+		}
+		blocks = append(blocks, b)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+	e := types.NewEncoder(f)
+	e.WriteUint64(uint64(len(blocks)))
+	for _, b := range blocks {
+		types.V2Block(b).EncodeTo(e)
+	}
+	if err := e.Flush(); err != nil {
+		tb.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// Restore applies the chain of a snapshot written by Snapshot to the node.
+// The node must use the same network and genesis block as the snapshotted
+// one. Restoring doesn't mine any blocks, so it is much faster than calling
+// MineBlocks. The store is updated by the wallet manager, so WaitForSync has
+// to be called after creating one to wait for it to catch up.
+func (cn *ConsensusNode) Restore(tb testing.TB, path string) {
+	tb.Helper()
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	d := types.NewBufDecoder(buf)
+	n := d.ReadUint64()
+	if n > uint64(len(buf)) {
+		tb.Fatal("invalid snapshot block count:", n)
+	}
+	blocks := make([]types.Block, n)
+	for i := range blocks {
+		(*types.V2Block)(&blocks[i]).DecodeFrom(d)
+	}
+	if err := d.Err(); err != nil {
+		tb.Fatal("failed to decode snapshot:", err)
+	} else if err := cn.Chain.AddBlocks(blocks); err != nil {
+		tb.Fatal("failed to restore snapshot:", err)
+	} else if len(blocks) > 0 && cn.Chain.Tip().ID != blocks[len(blocks)-1].ID() {
+		tb.Fatal("snapshot is not the best chain")
+	}
+}
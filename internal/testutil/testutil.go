@@ -4,6 +4,7 @@ import (
 	"context"
 	"net"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -23,6 +24,9 @@ type (
 		Store  *sqlite.Store
 		Chain  *chain.Manager
 		Syncer *MockSyncer
+		// PeerSyncer is a syncer connected to the network, for tests that
+		// exchange blocks between nodes.
+		PeerSyncer *syncer.Syncer
 	}
 )
 
@@ -86,9 +90,10 @@ func NewConsensusNode(tb testing.TB, n *consensus.Network, genesis types.Block,
 	go s.Run()
 
 	return &ConsensusNode{
-		Store:  store,
-		Chain:  cm,
-		Syncer: &MockSyncer{},
+		Store:      store,
+		Chain:      cm,
+		Syncer:     &MockSyncer{},
+		PeerSyncer: s,
 	}
 }
 
@@ -102,15 +107,33 @@ func V2Network() (*consensus.Network, types.Block) {
 	return testutil.V2Network()
 }
 
+// A MockSyncer is a syncer that records broadcast block outlines instead of
+// sending them to peers.
 type MockSyncer struct {
+	mu       sync.Mutex
+	outlines []gateway.V2BlockOutline
 }
 
 func (s *MockSyncer) Addr() string {
 	return ""
 }
 
-func (s *MockSyncer) BroadcastV2BlockOutline(bo gateway.V2BlockOutline) error { return nil }
-func (s *MockSyncer) BroadcastTransactionSet([]types.Transaction) error       { return nil }
+// BroadcastV2BlockOutline records the block outline.
+func (s *MockSyncer) BroadcastV2BlockOutline(bo gateway.V2BlockOutline) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outlines = append(s.outlines, bo)
+	return nil
+}
+
+// BlockOutlines returns the block outlines broadcast so far.
+func (s *MockSyncer) BlockOutlines() []gateway.V2BlockOutline {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]gateway.V2BlockOutline(nil), s.outlines...)
+}
+
+func (s *MockSyncer) BroadcastTransactionSet([]types.Transaction) error { return nil }
 func (s *MockSyncer) BroadcastV2TransactionSet(index types.ChainIndex, txns []types.V2Transaction) error {
 	return nil
 }